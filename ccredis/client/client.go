@@ -0,0 +1,131 @@
+// Package client is a Redis client built on the RESP codec of the server. It keeps
+// a goroutine-safe pool of connections, offers typed helpers for common commands,
+// pipelines and MULTI/EXEC transactions, and honours context deadlines and cancellation.
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+
+	redis "github.com/Kostaaa1/redis-clone/internal/resp"
+)
+
+// Value is a decoded RESP reply
+type Value = redis.Value
+
+type Options struct {
+	// "tcp" or "unix", defaults to tcp
+	Network string
+	// host:port of the server or the path of its unix socket, defaults to 127.0.0.1:6380
+	Addr string
+	// name set with CLIENT SETNAME on every new connection
+	ClientName string
+
+	// maximum number of open connections, defaults to 10 per CPU
+	PoolSize int
+	// how long to wait for a free connection when all are busy, defaults to ReadTimeout + 1s
+	PoolTimeout time.Duration
+	// idle connections older than this are closed instead of reused, 0 keeps them forever
+	IdleTimeout time.Duration
+
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// TLSConfig enables TLS for connections opened by the default Dialer
+	TLSConfig *tls.Config
+
+	// Dialer opens new connections. Defaults to net.Dialer, or tls.Dialer with TLSConfig.
+	Dialer func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+func (opt *Options) init() {
+	if opt.Network == "" {
+		opt.Network = "tcp"
+	}
+	if opt.Addr == "" {
+		opt.Addr = "127.0.0.1:6380"
+	}
+	if opt.PoolSize == 0 {
+		opt.PoolSize = 10 * runtimeNumCPU()
+	}
+	if opt.DialTimeout == 0 {
+		opt.DialTimeout = 5 * time.Second
+	}
+	if opt.ReadTimeout == 0 {
+		opt.ReadTimeout = 3 * time.Second
+	}
+	if opt.WriteTimeout == 0 {
+		opt.WriteTimeout = opt.ReadTimeout
+	}
+	if opt.PoolTimeout == 0 {
+		opt.PoolTimeout = opt.ReadTimeout + time.Second
+	}
+	if opt.Dialer == nil {
+		d := &net.Dialer{KeepAlive: 5 * time.Minute}
+		opt.Dialer = d.DialContext
+		if opt.TLSConfig != nil {
+			opt.Dialer = (&tls.Dialer{NetDialer: d, Config: opt.TLSConfig}).DialContext
+		}
+	}
+}
+
+// Client is safe for concurrent use by multiple goroutines
+type Client struct {
+	opt  Options
+	pool *pool
+}
+
+func New(opt Options) *Client {
+	opt.init()
+	return &Client{opt: opt, pool: newPool(&opt)}
+}
+
+func (c *Client) Close() error {
+	return c.pool.close()
+}
+
+// PoolStats reports the number of idle connections and of connections currently in use
+func (c *Client) PoolStats() (idle, inUse int) {
+	return c.pool.stats()
+}
+
+// Do sends an arbitrary command, args are converted to bulk strings
+func (c *Client) Do(ctx context.Context, args ...any) *Cmd {
+	cmd := NewCmd(args...)
+	c.process(ctx, []*Cmd{cmd})
+	return cmd
+}
+
+// process runs cmds on a single connection, setting the error of every cmd when the round trip fails
+func (c *Client) process(ctx context.Context, cmds []*Cmd) error {
+	for _, cmd := range cmds {
+		if err := cmd.checkArgs(); err != nil {
+			setErr(cmds, err)
+			return err
+		}
+	}
+
+	cn, err := c.pool.get(ctx)
+	if err != nil {
+		setErr(cmds, err)
+		return err
+	}
+
+	err = cn.roundTrip(ctx, &c.opt, cmds)
+	c.pool.put(cn, err)
+	if err != nil {
+		setErr(cmds, err)
+	}
+	return err
+}
+
+func setErr(cmds []*Cmd, err error) {
+	for _, cmd := range cmds {
+		if cmd.err == nil {
+			cmd.err = err
+		}
+	}
+}
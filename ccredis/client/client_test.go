@@ -0,0 +1,232 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	redis "github.com/Kostaaa1/redis-clone/internal/resp"
+	"github.com/stretchr/testify/require"
+)
+
+func startServer(t *testing.T) *Client {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	srv := redis.NewServer(redis.Config{Port: ln.Addr().(*net.TCPAddr).Port})
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+
+	c := New(Options{Addr: ln.Addr().String(), PoolSize: 4})
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestClient_Commands(t *testing.T) {
+	c := startServer(t)
+	ctx := context.Background()
+
+	require.NoError(t, c.Ping(ctx))
+
+	_, err := c.Get(ctx, "foo")
+	require.ErrorIs(t, err, Nil)
+
+	require.NoError(t, c.Set(ctx, "foo", "bar", nil).Err())
+	v, err := c.Get(ctx, "foo")
+	require.NoError(t, err)
+	require.Equal(t, "bar", v)
+
+	require.ErrorIs(t, c.Set(ctx, "foo", "baz", &SetOptions{NX: true}).Err(), Nil)
+	old, err := c.Set(ctx, "foo", 42, &SetOptions{XX: true, Get: true}).Text()
+	require.NoError(t, err)
+	require.Equal(t, "bar", old)
+
+	n, err := c.Get(ctx, "foo")
+	require.NoError(t, err)
+	require.Equal(t, "42", n)
+
+	ttl, err := c.TTL(ctx, "foo")
+	require.NoError(t, err)
+	require.Equal(t, time.Duration(-1), ttl)
+
+	ok, err := c.Expire(ctx, "foo", time.Minute)
+	require.NoError(t, err)
+	require.True(t, ok)
+	ttl, err = c.TTL(ctx, "foo")
+	require.NoError(t, err)
+	require.InDelta(t, time.Minute, ttl, float64(time.Second))
+
+	require.NoError(t, c.Set(ctx, "foo", "bar", &SetOptions{ExpireAt: time.Now().Add(time.Hour)}).Err())
+	ttl, err = c.TTL(ctx, "foo")
+	require.NoError(t, err)
+	require.InDelta(t, time.Hour, ttl, float64(time.Second))
+
+	// durations that aren't whole seconds go out in milliseconds
+	require.Equal(t, []any{"SET", "k", "v", "EX", int64(2)}, (&SetOptions{EX: 2 * time.Second}).args([]any{"SET", "k", "v"}))
+	require.Equal(t, []any{"SET", "k", "v", "PX", int64(1500)}, (&SetOptions{EX: 1500 * time.Millisecond}).args([]any{"SET", "k", "v"}))
+	require.NoError(t, c.Set(ctx, "short", "v", &SetOptions{EX: 200 * time.Millisecond}).Err())
+	require.Eventually(t, func() bool {
+		_, err := c.Get(ctx, "short")
+		return errors.Is(err, Nil)
+	}, time.Second, 10*time.Millisecond)
+
+	// a Duration has no unit the generic path could pick, nothing is sent
+	require.NoError(t, c.Set(ctx, "short", "v", nil).Err())
+	require.ErrorIs(t, c.Do(ctx, "EXPIRE", "short", 10*time.Second).Err(), errDurationArg)
+	ttl, err = c.TTL(ctx, "short")
+	require.NoError(t, err)
+	require.Equal(t, time.Duration(-1), ttl)
+
+	require.NoError(t, c.MSet(ctx, "a", 1, "b", 2))
+	deleted, err := c.Del(ctx, "a", "b", "missing")
+	require.NoError(t, err)
+	require.Equal(t, int64(2), deleted)
+
+	cnt, err := c.Incr(ctx, "counter")
+	require.NoError(t, err)
+	require.Equal(t, int64(1), cnt)
+	cnt, err = c.IncrBy(ctx, "counter", 41)
+	require.NoError(t, err)
+	require.Equal(t, int64(42), cnt)
+
+	added, err := c.HSet(ctx, "hash", "a", 1, "b", "two")
+	require.NoError(t, err)
+	require.Equal(t, int64(2), added)
+	h, err := c.HGetAll(ctx, "hash")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"a": "1", "b": "two"}, h)
+
+	var rerr Error
+	require.ErrorAs(t, c.Do(ctx, "NOSUCHCOMMAND").Err(), &rerr)
+	require.Contains(t, rerr.Error(), "ERR")
+}
+
+func TestClient_ConcurrentPool(t *testing.T) {
+	c := startServer(t)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := "key" + toString(i)
+			require.NoError(t, c.Set(ctx, key, i, nil).Err())
+			v, err := c.Get(ctx, key)
+			require.NoError(t, err)
+			require.Equal(t, toString(i), v)
+		}(i)
+	}
+	wg.Wait()
+
+	idle, inUse := c.PoolStats()
+	require.Equal(t, 0, inUse)
+	require.LessOrEqual(t, idle, 4)
+}
+
+func TestClient_Pipeline(t *testing.T) {
+	c := startServer(t)
+	ctx := context.Background()
+
+	p := c.Pipeline()
+	set := p.Do("SET", "foo", "bar")
+	get := p.Do("GET", "foo")
+	miss := p.Do("GET", "missing")
+	cmds, err := p.Exec(ctx)
+	require.NoError(t, err)
+	require.Len(t, cmds, 3)
+	require.Zero(t, p.Len())
+
+	require.NoError(t, set.Err())
+	v, err := get.Text()
+	require.NoError(t, err)
+	require.Equal(t, "bar", v)
+	require.ErrorIs(t, miss.Err(), Nil)
+}
+
+func TestClient_Transaction(t *testing.T) {
+	c := startServer(t)
+	ctx := context.Background()
+
+	tx := c.TxPipeline()
+	tx.Do("SET", "foo", "1")
+	get := tx.Do("GET", "foo")
+	del := tx.Do("DEL", "foo")
+	_, err := tx.Exec(ctx)
+	require.NoError(t, err)
+
+	v, err := get.Text()
+	require.NoError(t, err)
+	require.Equal(t, "1", v)
+	n, err := del.Int()
+	require.NoError(t, err)
+	require.Equal(t, int64(1), n)
+
+	// a command rejected while queueing aborts the whole transaction
+	tx.Do("SET", "foo", "1")
+	bad := tx.Do("GET")
+	_, err = tx.Exec(ctx)
+	require.Error(t, err)
+	require.ErrorContains(t, bad.Err(), "wrong number of arguments")
+
+	_, err = c.Get(ctx, "foo")
+	require.ErrorIs(t, err, Nil)
+
+	// the connection is still usable after the aborted transaction
+	require.NoError(t, c.Ping(ctx))
+}
+
+func TestClient_ContextTimeout(t *testing.T) {
+	// a server that accepts connections but never replies
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			defer conn.Close()
+			io.Copy(io.Discard, conn)
+		}
+	}()
+
+	c := New(Options{Addr: ln.Addr().String(), PoolSize: 1})
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = c.Ping(ctx)
+	require.Error(t, err)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), time.Second)
+
+	// the timed out connection was dropped and its pool slot released
+	idle, inUse := c.PoolStats()
+	require.Zero(t, idle)
+	require.Zero(t, inUse)
+}
+
+func TestClient_UnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "redis.sock")
+	ln, err := net.Listen("unix", path)
+	require.NoError(t, err)
+
+	srv := redis.NewServer(redis.Config{})
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+
+	c := New(Options{Network: "unix", Addr: path})
+	t.Cleanup(func() { c.Close() })
+
+	ctx := context.Background()
+	require.NoError(t, c.Set(ctx, "foo", "bar", nil).Err())
+	v, err := c.Get(ctx, "foo")
+	require.NoError(t, err)
+	require.Equal(t, "bar", v)
+}
@@ -0,0 +1,195 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strconv"
+	"time"
+
+	redis "github.com/Kostaaa1/redis-clone/internal/resp"
+)
+
+// Nil is returned when the server replies with a null, e.g. GET of a missing key
+var Nil = errors.New("redis: nil")
+
+// Error is an error reply sent by the server
+type Error string
+
+func (e Error) Error() string { return string(e) }
+
+func isRedisError(err error) bool {
+	var e Error
+	return errors.As(err, &e)
+}
+
+func runtimeNumCPU() int { return runtime.GOMAXPROCS(0) }
+
+// Cmd is a command together with its reply once it was executed
+type Cmd struct {
+	args []any
+	val  Value
+	err  error
+}
+
+func NewCmd(args ...any) *Cmd {
+	return &Cmd{args: args}
+}
+
+func (cmd *Cmd) Args() []any { return cmd.args }
+
+// errDurationArg is returned for a time.Duration argument, whose unit depends on the command
+var errDurationArg = errors.New("redis: time.Duration argument has no unit, pass seconds or milliseconds as an integer")
+
+// checkArgs refuses arguments toString can't send unambiguously
+func (cmd *Cmd) checkArgs() error {
+	for _, arg := range cmd.args {
+		if _, ok := arg.(time.Duration); ok {
+			return errDurationArg
+		}
+	}
+	return nil
+}
+
+func (cmd *Cmd) request() Value {
+	v := Value{Type: "array", Array: make([]Value, len(cmd.args))}
+	for i, arg := range cmd.args {
+		v.Array[i] = Value{Type: "bulk", Bulk: toString(arg)}
+	}
+	return v
+}
+
+func (cmd *Cmd) setReply(v Value) {
+	cmd.val = v
+	switch v.Type {
+	case "error":
+		cmd.err = Error(v.String)
+	case "null":
+		cmd.err = Nil
+	}
+}
+
+func toString(arg any) string {
+	switch v := arg.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func (cmd *Cmd) Err() error { return cmd.err }
+
+// Result returns the raw reply
+func (cmd *Cmd) Result() (Value, error) { return cmd.val, cmd.err }
+
+// Text returns a bulk or simple string reply
+func (cmd *Cmd) Text() (string, error) {
+	if cmd.err != nil {
+		return "", cmd.err
+	}
+	switch cmd.val.Type {
+	case "bulk":
+		return cmd.val.Bulk, nil
+	case "string":
+		return cmd.val.String, nil
+	case "integer":
+		return strconv.Itoa(cmd.val.Int), nil
+	default:
+		return "", fmt.Errorf("redis: unexpected reply type %s, expected a string", cmd.val.Type)
+	}
+}
+
+// Int returns an integer reply, or a string reply holding an integer
+func (cmd *Cmd) Int() (int64, error) {
+	if cmd.err != nil {
+		return 0, cmd.err
+	}
+	switch cmd.val.Type {
+	case "integer":
+		return int64(cmd.val.Int), nil
+	case "bulk", "string":
+		s, _ := cmd.Text()
+		return strconv.ParseInt(s, 10, 64)
+	default:
+		return 0, fmt.Errorf("redis: unexpected reply type %s, expected an integer", cmd.val.Type)
+	}
+}
+
+func (cmd *Cmd) Bool() (bool, error) {
+	n, err := cmd.Int()
+	return n != 0, err
+}
+
+// Strings returns an array reply of strings, nulls inside the array become empty strings
+func (cmd *Cmd) Strings() ([]string, error) {
+	if cmd.err != nil {
+		return nil, cmd.err
+	}
+	if cmd.val.Type != "array" && cmd.val.Type != "push" {
+		return nil, fmt.Errorf("redis: unexpected reply type %s, expected an array", cmd.val.Type)
+	}
+	s := make([]string, len(cmd.val.Array))
+	for i, v := range cmd.val.Array {
+		s[i] = valueString(v)
+	}
+	return s, nil
+}
+
+// StringMap returns a RESP3 map reply or a RESP2 array of alternating fields and values
+func (cmd *Cmd) StringMap() (map[string]string, error) {
+	if cmd.err != nil {
+		return nil, cmd.err
+	}
+	if cmd.val.Type != "array" && cmd.val.Type != "map" {
+		return nil, fmt.Errorf("redis: unexpected reply type %s, expected a map", cmd.val.Type)
+	}
+	if len(cmd.val.Array)%2 != 0 {
+		return nil, errors.New("redis: map reply with an odd number of elements")
+	}
+	m := make(map[string]string, len(cmd.val.Array)/2)
+	for i := 0; i < len(cmd.val.Array); i += 2 {
+		m[valueString(cmd.val.Array[i])] = valueString(cmd.val.Array[i+1])
+	}
+	return m, nil
+}
+
+// Duration converts an integer reply counted in unit. Negative replies (-1 no ttl, -2 no key) are kept as is.
+func (cmd *Cmd) Duration(unit time.Duration) (time.Duration, error) {
+	n, err := cmd.Int()
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return time.Duration(n), nil
+	}
+	return time.Duration(n) * unit, nil
+}
+
+func valueString(v redis.Value) string {
+	switch v.Type {
+	case "bulk":
+		return v.Bulk
+	case "string", "error":
+		return v.String
+	case "integer":
+		return strconv.Itoa(v.Int)
+	default:
+		return ""
+	}
+}
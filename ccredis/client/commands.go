@@ -0,0 +1,130 @@
+package client
+
+import (
+	"context"
+	"time"
+)
+
+func (c *Client) Ping(ctx context.Context) error {
+	return c.Do(ctx, "PING").Err()
+}
+
+// Get returns Nil when key does not exist
+func (c *Client) Get(ctx context.Context, key string) (string, error) {
+	return c.Do(ctx, "GET", key).Text()
+}
+
+// SetOptions are the optional arguments of SET. At most one of EX, PX, ExpireAt and KeepTTL may be set.
+type SetOptions struct {
+	// sent as EX when a whole number of seconds, as PX otherwise
+	EX time.Duration
+	PX time.Duration
+	// absolute expire time, sent with millisecond precision as PXAT
+	ExpireAt time.Time
+	KeepTTL  bool
+	// only set the key if it does not exist
+	NX bool
+	// only set the key if it already exists
+	XX bool
+	// return the previous value instead of OK
+	Get bool
+}
+
+func (opt *SetOptions) args(args []any) []any {
+	if opt == nil {
+		return args
+	}
+	switch {
+	case opt.EX > 0 && opt.EX%time.Second == 0:
+		args = append(args, "EX", int64(opt.EX/time.Second))
+	case opt.EX > 0:
+		args = append(args, "PX", opt.EX.Milliseconds())
+	case opt.PX > 0:
+		args = append(args, "PX", int64(opt.PX/time.Millisecond))
+	case !opt.ExpireAt.IsZero():
+		args = append(args, "PXAT", opt.ExpireAt.UnixMilli())
+	case opt.KeepTTL:
+		args = append(args, "KEEPTTL")
+	}
+	if opt.NX {
+		args = append(args, "NX")
+	}
+	if opt.XX {
+		args = append(args, "XX")
+	}
+	if opt.Get {
+		args = append(args, "GET")
+	}
+	return args
+}
+
+// Set stores value under key. With NX or XX a key that was not set returns Nil, with Get
+// the reply holds the previous value.
+func (c *Client) Set(ctx context.Context, key string, value any, opt *SetOptions) *Cmd {
+	return c.Do(ctx, opt.args([]any{"SET", key, value})...)
+}
+
+func (c *Client) MSet(ctx context.Context, pairs ...any) error {
+	return c.Do(ctx, append([]any{"MSET"}, pairs...)...).Err()
+}
+
+// Del returns the number of keys that were removed
+func (c *Client) Del(ctx context.Context, keys ...string) (int64, error) {
+	return c.Do(ctx, append([]any{"DEL"}, strArgs(keys)...)...).Int()
+}
+
+// Expire returns false when key does not exist or the condition was not met
+func (c *Client) Expire(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return c.Do(ctx, "EXPIRE", key, int64(ttl/time.Second)).Bool()
+}
+
+// TTL returns -1 when key has no ttl and -2 when it does not exist
+func (c *Client) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return c.Do(ctx, "TTL", key).Duration(time.Second)
+}
+
+func (c *Client) Persist(ctx context.Context, key string) (bool, error) {
+	return c.Do(ctx, "PERSIST", key).Bool()
+}
+
+func (c *Client) Keys(ctx context.Context, pattern string) ([]string, error) {
+	return c.Do(ctx, "KEYS", pattern).Strings()
+}
+
+func (c *Client) Incr(ctx context.Context, key string) (int64, error) {
+	return c.Do(ctx, "INCR", key).Int()
+}
+
+func (c *Client) IncrBy(ctx context.Context, key string, n int64) (int64, error) {
+	return c.Do(ctx, "INCRBY", key, n).Int()
+}
+
+func (c *Client) Decr(ctx context.Context, key string) (int64, error) {
+	return c.Do(ctx, "DECR", key).Int()
+}
+
+// HSet takes field value pairs and returns the number of fields that were added
+func (c *Client) HSet(ctx context.Context, key string, pairs ...any) (int64, error) {
+	return c.Do(ctx, append([]any{"HSET", key}, pairs...)...).Int()
+}
+
+func (c *Client) HGet(ctx context.Context, key, field string) (string, error) {
+	return c.Do(ctx, "HGET", key, field).Text()
+}
+
+func (c *Client) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	return c.Do(ctx, "HGETALL", key).StringMap()
+}
+
+// Publish returns the number of clients that received the message
+func (c *Client) Publish(ctx context.Context, channel string, message any) (int64, error) {
+	return c.Do(ctx, "PUBLISH", channel, message).Int()
+}
+
+func strArgs(s []string) []any {
+	args := make([]any, len(s))
+	for i, v := range s {
+		args[i] = v
+	}
+	return args
+}
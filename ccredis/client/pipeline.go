@@ -0,0 +1,110 @@
+package client
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrTxFailed is returned by a transaction the server aborted, e.g. because a queued command was rejected
+var ErrTxFailed = errors.New("redis: transaction failed")
+
+// Pipeline queues commands and sends them in a single round trip on Exec. It is not
+// safe for concurrent use.
+type Pipeline struct {
+	c    *Client
+	tx   bool
+	cmds []*Cmd
+}
+
+func (c *Client) Pipeline() *Pipeline {
+	return &Pipeline{c: c}
+}
+
+// TxPipeline is a pipeline wrapped in MULTI/EXEC, its commands run atomically
+func (c *Client) TxPipeline() *Pipeline {
+	return &Pipeline{c: c, tx: true}
+}
+
+// Do queues a command, its reply is available once Exec returned
+func (p *Pipeline) Do(args ...any) *Cmd {
+	cmd := NewCmd(args...)
+	p.cmds = append(p.cmds, cmd)
+	return cmd
+}
+
+func (p *Pipeline) Len() int { return len(p.cmds) }
+
+// Exec sends the queued commands and empties the pipeline. The returned error is the
+// first failed command's error, the commands themselves carry their own results.
+func (p *Pipeline) Exec(ctx context.Context) ([]*Cmd, error) {
+	cmds := p.cmds
+	p.cmds = nil
+	if len(cmds) == 0 {
+		return nil, nil
+	}
+
+	if p.tx {
+		return cmds, p.execTx(ctx, cmds)
+	}
+
+	if err := p.c.process(ctx, cmds); err != nil {
+		return cmds, err
+	}
+	return cmds, firstErr(cmds)
+}
+
+// execTx sends MULTI, the commands and EXEC, then hands the entries of the EXEC reply to the commands
+func (p *Pipeline) execTx(ctx context.Context, cmds []*Cmd) error {
+	wrapped := make([]*Cmd, 0, len(cmds)+2)
+	wrapped = append(wrapped, NewCmd("MULTI"))
+	wrapped = append(wrapped, cmds...)
+	exec := NewCmd("EXEC")
+	wrapped = append(wrapped, exec)
+
+	if err := p.c.process(ctx, wrapped); err != nil {
+		return err
+	}
+	if err := wrapped[0].Err(); err != nil {
+		return err
+	}
+
+	// queued commands reply QUEUED, anything else is a queueing error which aborts EXEC
+	var queueErr error
+	for _, cmd := range cmds {
+		if err := cmd.Err(); err != nil && queueErr == nil {
+			queueErr = err
+		}
+		cmd.val, cmd.err = Value{}, nil
+	}
+
+	if errors.Is(exec.Err(), Nil) {
+		setErr(cmds, ErrTxFailed)
+		return ErrTxFailed
+	}
+	if err := exec.Err(); err != nil {
+		if queueErr != nil {
+			err = queueErr
+		}
+		setErr(cmds, err)
+		return err
+	}
+
+	replies := exec.val.Array
+	if len(replies) != len(cmds) {
+		setErr(cmds, ErrTxFailed)
+		return ErrTxFailed
+	}
+	for i, cmd := range cmds {
+		cmd.setReply(replies[i])
+	}
+	return firstErr(cmds)
+}
+
+func firstErr(cmds []*Cmd) error {
+	for _, cmd := range cmds {
+		if err := cmd.Err(); err != nil && !errors.Is(err, Nil) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	redis "github.com/Kostaaa1/redis-clone/internal/resp"
+)
+
+var (
+	ErrClosed      = errors.New("redis: client is closed")
+	ErrPoolTimeout = errors.New("redis: connection pool timeout")
+)
+
+type conn struct {
+	netConn net.Conn
+	r       *redis.Resp
+	bw      *bufio.Writer
+	w       *redis.Writer
+	usedAt  time.Time
+}
+
+func newConn(nc net.Conn) *conn {
+	bw := bufio.NewWriter(nc)
+	return &conn{
+		netConn: nc,
+		r:       redis.NewReader(nc),
+		bw:      bw,
+		w:       redis.NewWriter(bw),
+		usedAt:  time.Now(),
+	}
+}
+
+// roundTrip writes every command before reading any reply, a single command is just a pipeline of one
+func (cn *conn) roundTrip(ctx context.Context, opt *Options, cmds []*Cmd) error {
+	// cancelling ctx interrupts blocked reads and writes. Once stop fails the deadline is
+	// being set, wait for it so it can't hit the next round trip of the connection.
+	interrupted := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		cn.netConn.SetDeadline(time.Now())
+		close(interrupted)
+	})
+	defer func() {
+		if !stop() {
+			<-interrupted
+		}
+	}()
+
+	if err := cn.writeRead(ctx, opt, cmds); err != nil {
+		// the read deadline may fire just before ctx reports it is done
+		if d, ok := ctx.Deadline(); ok && !time.Now().Before(d) {
+			return context.DeadlineExceeded
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	// every reply was read, a ctx done by now came too late to matter
+	return nil
+}
+
+func (cn *conn) writeRead(ctx context.Context, opt *Options, cmds []*Cmd) error {
+	cn.netConn.SetWriteDeadline(deadline(ctx, opt.WriteTimeout))
+	for _, cmd := range cmds {
+		if _, err := cn.w.Write(cmd.request()); err != nil {
+			return err
+		}
+	}
+	if err := cn.bw.Flush(); err != nil {
+		return err
+	}
+
+	cn.netConn.SetReadDeadline(deadline(ctx, opt.ReadTimeout))
+	for _, cmd := range cmds {
+		v, err := cn.r.Read()
+		if err != nil {
+			return err
+		}
+		cmd.setReply(v)
+	}
+	return nil
+}
+
+func deadline(ctx context.Context, timeout time.Duration) time.Time {
+	var t time.Time
+	if timeout > 0 {
+		t = time.Now().Add(timeout)
+	}
+	if d, ok := ctx.Deadline(); ok && (t.IsZero() || d.Before(t)) {
+		t = d
+	}
+	return t
+}
+
+// pool hands out at most PoolSize connections and keeps the returned ones for reuse
+type pool struct {
+	opt *Options
+	sem chan struct{}
+
+	mu     sync.Mutex
+	idle   []*conn
+	closed bool
+}
+
+func newPool(opt *Options) *pool {
+	return &pool{
+		opt: opt,
+		sem: make(chan struct{}, opt.PoolSize),
+	}
+}
+
+func (p *pool) get(ctx context.Context) (*conn, error) {
+	timer := time.NewTimer(p.opt.PoolTimeout)
+	defer timer.Stop()
+
+	select {
+	case p.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timer.C:
+		return nil, ErrPoolTimeout
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		<-p.sem
+		return nil, ErrClosed
+	}
+	for len(p.idle) > 0 {
+		cn := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		if p.opt.IdleTimeout > 0 && time.Since(cn.usedAt) > p.opt.IdleTimeout {
+			cn.netConn.Close()
+			continue
+		}
+		p.mu.Unlock()
+		return cn, nil
+	}
+	p.mu.Unlock()
+
+	dialCtx, cancel := context.WithTimeout(ctx, p.opt.DialTimeout)
+	defer cancel()
+
+	nc, err := p.opt.Dialer(dialCtx, p.opt.Network, p.opt.Addr)
+	if err != nil {
+		<-p.sem
+		return nil, err
+	}
+
+	cn := newConn(nc)
+	if p.opt.ClientName != "" {
+		cmd := NewCmd("CLIENT", "SETNAME", p.opt.ClientName)
+		if err := cn.roundTrip(ctx, p.opt, []*Cmd{cmd}); err == nil {
+			err = cmd.Err()
+		}
+		if err != nil {
+			nc.Close()
+			<-p.sem
+			return nil, err
+		}
+	}
+	return cn, nil
+}
+
+// put returns cn to the pool, connections that failed mid command are in an unknown state and get closed
+func (p *pool) put(cn *conn, err error) {
+	defer func() { <-p.sem }()
+
+	if err != nil && !isRedisError(err) {
+		cn.netConn.Close()
+		return
+	}
+
+	cn.usedAt = time.Now()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		cn.netConn.Close()
+		return
+	}
+	p.idle = append(p.idle, cn)
+}
+
+func (p *pool) close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return ErrClosed
+	}
+	p.closed = true
+	for _, cn := range p.idle {
+		cn.netConn.Close()
+	}
+	p.idle = nil
+	return nil
+}
+
+// stats returns the number of idle connections and of connections handed out
+func (p *pool) stats() (idle, inUse int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.idle), len(p.sem)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// histogram counts latencies in log-linear buckets: values below 128ns are exact, above
+// that every power of two is split into 64 buckets. The relative error stays under 2% at
+// a fixed memory cost no matter how many requests are recorded.
+type histogram struct {
+	counts [linearBuckets + 64*subBuckets]uint64
+	total  uint64
+	sum    time.Duration
+	min    time.Duration
+	max    time.Duration
+}
+
+const (
+	linearBuckets = 128
+	subBuckets    = 64
+)
+
+func newHistogram() *histogram {
+	return &histogram{min: math.MaxInt64}
+}
+
+// bucketOf maps a duration in nanoseconds to its bucket
+func bucketOf(v uint64) int {
+	if v < linearBuckets {
+		return int(v)
+	}
+	// keep the 7 most significant bits, the top one is always set
+	shift := bits.Len64(v) - 7
+	top := v >> shift
+	return linearBuckets + (shift-1)*subBuckets + int(top-subBuckets)
+}
+
+// bucketUpper returns the largest value that falls into bucket i
+func bucketUpper(i int) uint64 {
+	if i < linearBuckets {
+		return uint64(i)
+	}
+	i -= linearBuckets
+	shift := i/subBuckets + 1
+	top := uint64(i%subBuckets + subBuckets)
+	return (top+1)<<shift - 1
+}
+
+func (h *histogram) record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	h.counts[bucketOf(uint64(d))]++
+	h.total++
+	h.sum += d
+	h.min = min(h.min, d)
+	h.max = max(h.max, d)
+}
+
+func (h *histogram) merge(o *histogram) {
+	for i, n := range o.counts {
+		h.counts[i] += n
+	}
+	h.total += o.total
+	h.sum += o.sum
+	h.min = min(h.min, o.min)
+	h.max = max(h.max, o.max)
+}
+
+func (h *histogram) mean() time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.total)
+}
+
+// quantile returns the upper bound of the bucket holding the q-th fraction of the samples
+func (h *histogram) quantile(q float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(h.total)))
+	if rank == 0 {
+		rank = 1
+	}
+	var seen uint64
+	for i, n := range h.counts {
+		seen += n
+		if seen >= rank {
+			return min(time.Duration(bucketUpper(i)), h.max)
+		}
+	}
+	return h.max
+}
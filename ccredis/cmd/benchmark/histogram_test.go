@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogram_Buckets(t *testing.T) {
+	t.Parallel()
+
+	for _, v := range []uint64{0, 1, 127, 128, 129, 130, 255, 256, 1000, 123456789, 1 << 62} {
+		i := bucketOf(v)
+		require.GreaterOrEqual(t, bucketUpper(i), v, v)
+		if i > 0 {
+			require.Less(t, bucketUpper(i-1), v, v)
+		}
+		// the bucket is at most 1/64 wide relative to its values
+		require.LessOrEqual(t, float64(bucketUpper(i)-v), float64(v)/64, v)
+	}
+}
+
+func TestHistogram_Quantile(t *testing.T) {
+	t.Parallel()
+
+	h := newHistogram()
+	for i := 1; i <= 1000; i++ {
+		h.record(time.Duration(i) * time.Microsecond)
+	}
+	require.Equal(t, uint64(1000), h.total)
+	require.Equal(t, time.Microsecond, h.min)
+	require.Equal(t, time.Millisecond, h.max)
+	require.InEpsilon(t, 500*time.Microsecond, h.quantile(0.5), 0.02)
+	require.InEpsilon(t, 990*time.Microsecond, h.quantile(0.99), 0.02)
+	require.Equal(t, time.Millisecond, h.quantile(1))
+
+	other := newHistogram()
+	other.record(time.Second)
+	h.merge(other)
+	require.Equal(t, time.Second, h.max)
+	require.Equal(t, time.Second, h.quantile(1))
+}
@@ -0,0 +1,347 @@
+// Command benchmark is a redis-benchmark style load generator. It works against this
+// server as well as a real Redis.
+//
+//	benchmark [-h host] [-p port] [-c clients] [-n requests] [-d size|min-max] [-r keyspacelen] [-P pipeline] [-t tests] [-q]
+//	benchmark [options] cmd [arg..]
+//
+// A custom command replaces the built in tests. __rand_int__ in its arguments is replaced
+// by a random number in [0, keyspacelen) when -r is set, and __data__ by a random payload.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	redis "github.com/Kostaaa1/redis-clone/internal/resp"
+)
+
+type config struct {
+	addr     string
+	clients  int
+	requests int
+	dataMin  int
+	dataMax  int
+	keyspace int
+	pipeline int
+	quiet    bool
+}
+
+// test is a named command template, every request is built from it by expanding placeholders
+type test struct {
+	name string
+	args []string
+}
+
+var tests = []test{
+	{"PING", []string{"PING"}},
+	{"SET", []string{"SET", "key:__rand_int__", "__data__"}},
+	{"GET", []string{"GET", "key:__rand_int__"}},
+	{"INCR", []string{"INCR", "counter:__rand_int__"}},
+	{"LPUSH", []string{"LPUSH", "mylist", "__data__"}},
+	{"RPUSH", []string{"RPUSH", "mylist", "__data__"}},
+	{"LPOP", []string{"LPOP", "mylist"}},
+	{"RPOP", []string{"RPOP", "mylist"}},
+	{"SADD", []string{"SADD", "myset", "element:__rand_int__"}},
+	{"HSET", []string{"HSET", "myhash", "element:__rand_int__", "__data__"}},
+	{"SPOP", []string{"SPOP", "myset"}},
+	{"MSET", msetArgs(10)},
+}
+
+func msetArgs(n int) []string {
+	args := []string{"MSET"}
+	for i := 0; i < n; i++ {
+		args = append(args, "key:__rand_int__", "__data__")
+	}
+	return args
+}
+
+func main() {
+	host := flag.String("h", "127.0.0.1", "server hostname")
+	port := flag.Int("p", 6380, "server port")
+	clients := flag.Int("c", 50, "number of parallel connections")
+	requests := flag.Int("n", 100000, "total number of requests")
+	data := flag.String("d", "3", "payload size in bytes of SET/GET values, MIN-MAX picks a random size per request")
+	keyspace := flag.Int("r", 0, "use random keys in [0, keyspacelen) for SET/GET/INCR, random members for SADD/HSET")
+	pipeline := flag.Int("P", 1, "pipeline <numreq> requests")
+	only := flag.String("t", "", "comma separated list of tests to run, e.g. set,get,incr")
+	quiet := flag.Bool("q", false, "quiet, just show query/sec values and the p50 latency")
+	flag.Parse()
+
+	cfg := config{
+		addr:     net.JoinHostPort(*host, strconv.Itoa(*port)),
+		clients:  max(*clients, 1),
+		requests: *requests,
+		keyspace: *keyspace,
+		pipeline: max(*pipeline, 1),
+		quiet:    *quiet,
+	}
+	var err error
+	if cfg.dataMin, cfg.dataMax, err = parseRange(*data); err != nil {
+		fmt.Fprintln(os.Stderr, "invalid -d:", err)
+		os.Exit(1)
+	}
+
+	run := tests
+	if flag.NArg() > 0 {
+		run = []test{{strings.Join(flag.Args(), " "), flag.Args()}}
+	} else if *only != "" {
+		run = nil
+		for _, name := range strings.Split(*only, ",") {
+			t, ok := findTest(strings.TrimSpace(name))
+			if !ok {
+				fmt.Fprintf(os.Stderr, "unknown test %q\n", name)
+				os.Exit(1)
+			}
+			run = append(run, t)
+		}
+	}
+
+	for _, t := range run {
+		res, err := benchmark(cfg, t)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		report(cfg, t, res)
+	}
+}
+
+func findTest(name string) (test, bool) {
+	for _, t := range tests {
+		if strings.EqualFold(t.name, name) {
+			return t, true
+		}
+	}
+	return test{}, false
+}
+
+func parseRange(s string) (lo, hi int, err error) {
+	a, b, isRange := strings.Cut(s, "-")
+	if lo, err = strconv.Atoi(a); err != nil {
+		return 0, 0, err
+	}
+	hi = lo
+	if isRange {
+		if hi, err = strconv.Atoi(b); err != nil {
+			return 0, 0, err
+		}
+	}
+	if lo < 0 || hi < lo {
+		return 0, 0, fmt.Errorf("bad size range %q", s)
+	}
+	return lo, hi, nil
+}
+
+type result struct {
+	elapsed time.Duration
+	hist    *histogram
+	errors  uint64
+	// first error reply, shown so that unsupported commands are easy to spot
+	firstErr string
+}
+
+// benchmark runs cfg.requests requests of t over cfg.clients connections. Requests are
+// handed out in batches of cfg.pipeline, every request of a batch is recorded with the
+// latency of the whole batch, which is what redis-benchmark reports as well.
+func benchmark(cfg config, t test) (*result, error) {
+	conns := make([]*worker, cfg.clients)
+	for i := range conns {
+		w, err := newWorker(cfg, t)
+		if err != nil {
+			for _, w := range conns[:i] {
+				w.conn.Close()
+			}
+			return nil, err
+		}
+		conns[i] = w
+	}
+
+	var (
+		issued atomic.Int64
+		wg     sync.WaitGroup
+		errsMu sync.Mutex
+		errs   []error
+	)
+	start := time.Now()
+	for _, w := range conns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer w.conn.Close()
+			for {
+				end := issued.Add(int64(cfg.pipeline))
+				n := cfg.pipeline - int(max(0, end-int64(cfg.requests)))
+				if n <= 0 {
+					return
+				}
+				if err := w.batch(n); err != nil {
+					errsMu.Lock()
+					errs = append(errs, err)
+					errsMu.Unlock()
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	res := &result{elapsed: time.Since(start), hist: newHistogram()}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%s: %w", t.name, errs[0])
+	}
+	for _, w := range conns {
+		res.hist.merge(w.hist)
+		res.errors += w.errors
+		if res.firstErr == "" {
+			res.firstErr = w.firstErr
+		}
+	}
+	return res, nil
+}
+
+type worker struct {
+	cfg  config
+	test test
+	rnd  *rand.Rand
+	// random payload, requests use a prefix of it
+	data string
+
+	conn net.Conn
+	bw   *bufio.Writer
+	w    *redis.Writer
+	r    *redis.Resp
+
+	hist     *histogram
+	errors   uint64
+	firstErr string
+}
+
+func newWorker(cfg config, t test) (*worker, error) {
+	conn, err := net.DialTimeout("tcp", cfg.addr, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	bw := bufio.NewWriter(conn)
+
+	rnd := rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+	data := make([]byte, cfg.dataMax)
+	for i := range data {
+		data[i] = 'a' + byte(rnd.IntN(26))
+	}
+
+	return &worker{
+		cfg:  cfg,
+		test: t,
+		rnd:  rnd,
+		data: string(data),
+		conn: conn,
+		bw:   bw,
+		w:    redis.NewWriter(bw),
+		r:    redis.NewReader(conn),
+		hist: newHistogram(),
+	}, nil
+}
+
+func (w *worker) request() redis.Value {
+	v := redis.Value{Type: "array", Array: make([]redis.Value, len(w.test.args))}
+	for i, arg := range w.test.args {
+		v.Array[i] = redis.Value{Type: "bulk", Bulk: w.expand(arg)}
+	}
+	return v
+}
+
+func (w *worker) expand(arg string) string {
+	if arg == "__data__" {
+		n := w.cfg.dataMin
+		if w.cfg.dataMax > n {
+			n += w.rnd.IntN(w.cfg.dataMax - n + 1)
+		}
+		return w.data[:n]
+	}
+	if w.cfg.keyspace > 0 && strings.Contains(arg, "__rand_int__") {
+		return strings.ReplaceAll(arg, "__rand_int__", fmt.Sprintf("%012d", w.rnd.IntN(w.cfg.keyspace)))
+	}
+	return arg
+}
+
+func (w *worker) batch(n int) error {
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		if _, err := w.w.Write(w.request()); err != nil {
+			return err
+		}
+	}
+	if err := w.bw.Flush(); err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		v, err := w.r.Read()
+		if err != nil {
+			return err
+		}
+		if v.Type == "error" {
+			w.errors++
+			if w.firstErr == "" {
+				w.firstErr = v.String
+			}
+		}
+	}
+	latency := time.Since(start)
+	for i := 0; i < n; i++ {
+		w.hist.record(latency)
+	}
+	return nil
+}
+
+func msec(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+}
+
+func report(cfg config, t test, res *result) {
+	h := res.hist
+	rps := float64(h.total) / res.elapsed.Seconds()
+
+	if cfg.quiet {
+		fmt.Printf("%s: %.2f requests per second, p50=%s msec", t.name, rps, msec(h.quantile(0.5)))
+		if res.errors > 0 {
+			fmt.Printf(" (%d errors: %s)", res.errors, res.firstErr)
+		}
+		fmt.Println()
+		return
+	}
+
+	fmt.Printf("====== %s ======\n", t.name)
+	fmt.Printf("  %d requests completed in %.2f seconds\n", h.total, res.elapsed.Seconds())
+	fmt.Printf("  %d parallel clients\n", cfg.clients)
+	if cfg.dataMin == cfg.dataMax {
+		fmt.Printf("  %d bytes payload\n", cfg.dataMin)
+	} else {
+		fmt.Printf("  %d-%d bytes payload\n", cfg.dataMin, cfg.dataMax)
+	}
+	fmt.Printf("  pipeline: %d\n", cfg.pipeline)
+	if res.errors > 0 {
+		fmt.Printf("  %d errors, first: %s\n", res.errors, res.firstErr)
+	}
+
+	fmt.Println("\nLatency by percentile distribution:")
+	for _, q := range []float64{0, 0.5, 0.75, 0.875, 0.9375, 0.96875, 0.984375, 0.9921875, 0.99609375, 0.998046875, 1} {
+		fmt.Printf("%.3f%% <= %s milliseconds\n", q*100, msec(h.quantile(q)))
+	}
+
+	fmt.Println("\nSummary:")
+	fmt.Printf("  throughput summary: %.2f requests per second\n", rps)
+	fmt.Println("  latency summary (msec):")
+	fmt.Printf("  %9s %9s %9s %9s %9s %9s %9s\n", "avg", "min", "p50", "p95", "p99", "p999", "max")
+	fmt.Printf("  %9s %9s %9s %9s %9s %9s %9s\n\n",
+		msec(h.mean()), msec(h.min), msec(h.quantile(0.5)), msec(h.quantile(0.95)),
+		msec(h.quantile(0.99)), msec(h.quantile(0.999)), msec(h.max))
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var errUnbalancedQuotes = errors.New("Invalid argument(s)")
+
+// splitArgs splits a command line the way redis-cli does. Double quoted arguments
+// understand \n \r \t \b \a \\ \" and \xHH escapes, single quoted ones only \'.
+// A closing quote must be followed by a space or the end of the line.
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+
+		var (
+			cur      strings.Builder
+			inDouble bool
+			inSingle bool
+			done     bool
+		)
+		for !done {
+			if i == len(line) {
+				if inDouble || inSingle {
+					return nil, errUnbalancedQuotes
+				}
+				break
+			}
+			ch := line[i]
+			switch {
+			case inDouble:
+				switch {
+				case ch == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHex(line[i+2]) && isHex(line[i+3]):
+					b, _ := strconv.ParseUint(line[i+2:i+4], 16, 8)
+					cur.WriteByte(byte(b))
+					i += 3
+				case ch == '\\' && i+1 < len(line):
+					i++
+					cur.WriteByte(unescape(line[i]))
+				case ch == '"':
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, errUnbalancedQuotes
+					}
+					done = true
+				default:
+					cur.WriteByte(ch)
+				}
+			case inSingle:
+				switch {
+				case ch == '\\' && i+1 < len(line) && line[i+1] == '\'':
+					i++
+					cur.WriteByte('\'')
+				case ch == '\'':
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, errUnbalancedQuotes
+					}
+					done = true
+				default:
+					cur.WriteByte(ch)
+				}
+			default:
+				switch {
+				case isSpace(ch):
+					done = true
+				case ch == '"':
+					inDouble = true
+				case ch == '\'':
+					inSingle = true
+				default:
+					cur.WriteByte(ch)
+				}
+			}
+			i++
+		}
+		args = append(args, cur.String())
+	}
+}
+
+func unescape(ch byte) byte {
+	switch ch {
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case 'b':
+		return '\b'
+	case 'a':
+		return '\a'
+	default:
+		return ch
+	}
+}
+
+func isSpace(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r'
+}
+
+func isHex(ch byte) bool {
+	return '0' <= ch && ch <= '9' || 'a' <= ch && ch <= 'f' || 'A' <= ch && ch <= 'F'
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Kostaaa1/redis-clone/client"
+	"github.com/Kostaaa1/redis-clone/internal/harness"
+	redis "github.com/Kostaaa1/redis-clone/internal/resp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitArgs(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		line string
+		args []string
+	}{
+		{`set foo bar`, []string{"set", "foo", "bar"}},
+		{`  set   foo  bar  `, []string{"set", "foo", "bar"}},
+		{`set "hello world" 'it''`, nil},
+		{`set "a\nb\x41" 'it\'s'`, []string{"set", "a\nbA", "it's"}},
+		{`set "" ''`, []string{"set", "", ""}},
+		{`set 'a\nb' x`, []string{"set", `a\nb`, "x"}},
+		{`set "unterminated`, nil},
+		{`set "a"b`, nil},
+		{``, nil},
+	} {
+		args, err := splitArgs(tc.line)
+		if tc.args == nil && tc.line != "" {
+			require.ErrorIs(t, err, errUnbalancedQuotes, tc.line)
+			continue
+		}
+		require.NoError(t, err, tc.line)
+		require.Equal(t, tc.args, args, tc.line)
+	}
+}
+
+func TestFormatTTY(t *testing.T) {
+	t.Parallel()
+
+	bulk := func(s string) redis.Value { return redis.Value{Type: "bulk", Bulk: s} }
+
+	require.Equal(t, "OK\n", formatTTY(redis.Value{Type: "string", String: "OK"}, ""))
+	require.Equal(t, "(nil)\n", formatTTY(redis.Value{Type: "null"}, ""))
+	require.Equal(t, "(integer) 42\n", formatTTY(redis.Value{Type: "integer", Int: 42}, ""))
+	require.Equal(t, "(error) ERR boom\n", formatTTY(redis.Value{Type: "error", String: "ERR boom"}, ""))
+	require.Equal(t, `"a\"b\n\x00"`+"\n", formatTTY(bulk("a\"b\n\x00"), ""))
+	require.Equal(t, "(empty array)\n", formatTTY(redis.Value{Type: "array"}, ""))
+
+	elems := []redis.Value{bulk("a"), {Type: "array", Array: []redis.Value{bulk("b"), {Type: "null"}}}}
+	for i := 0; i < 9; i++ {
+		elems = append(elems, redis.Value{Type: "integer", Int: i})
+	}
+	require.Equal(t, ` 1) "a"
+ 2) 1) "b"
+    2) (nil)
+ 3) (integer) 0
+ 4) (integer) 1
+ 5) (integer) 2
+ 6) (integer) 3
+ 7) (integer) 4
+ 8) (integer) 5
+ 9) (integer) 6
+10) (integer) 7
+11) (integer) 8
+`, formatTTY(redis.Value{Type: "array", Array: elems}, ""))
+
+	m := redis.Value{Type: "map", Array: []redis.Value{
+		bulk("flags"), {Type: "array", Array: []redis.Value{bulk("on"), bulk("bcast")}},
+		bulk("redirect"), {Type: "integer", Int: -1},
+	}}
+	require.Equal(t, `1# flags => 1) "on"
+            2) "bcast"
+2# redirect => (integer) -1
+`, formatTTY(m, ""))
+}
+
+func TestKeyStats(t *testing.T) {
+	ctx := context.Background()
+	h := harness.Start(t, 1, redis.Config{})
+	rdb := h.Clients(t, client.Options{})[0]
+	for i := 0; i < 300; i++ {
+		require.NoError(t, rdb.Do(ctx, "SET", fmt.Sprint("key:", i), "v").Err())
+	}
+	require.NoError(t, rdb.Do(ctx, "SET", "big", strings.Repeat("x", 100)).Err())
+	require.NoError(t, rdb.Do(ctx, "RPUSH", "list", "a", "b", "c").Err())
+	require.NoError(t, rdb.Do(ctx, "HSET", "hash", "f", "v").Err())
+
+	c := &cli{network: "tcp", addr: h.Instances[0].Addr()}
+	defer c.disconnect()
+
+	var out strings.Builder
+	require.NoError(t, c.bigKeys(&out, 0))
+	require.Contains(t, out.String(), "Sampled 303 keys in the keyspace!\n")
+	require.Contains(t, out.String(), "Biggest string found \"big\" has 100 bytes\n")
+	require.Contains(t, out.String(), "Biggest   list found \"list\" has 3 items\n")
+	require.Contains(t, out.String(), "301 strings with 400 bytes (99.34% of keys, avg size 1.33)\n")
+	require.Contains(t, out.String(), "0 sets with 0 members (00.00% of keys, avg size 0.00)\n")
+
+	// OBJECT FREQ is only tracked with an LFU policy
+	out.Reset()
+	require.ErrorContains(t, c.hotKeys(&out, 0), "An LFU maxmemory policy is not selected")
+
+	require.NoError(t, rdb.Do(ctx, "CONFIG", "SET", "maxmemory-policy", "allkeys-lfu").Err())
+	for i := 0; i < 300; i++ {
+		require.NoError(t, rdb.Do(ctx, "GET", "big").Err())
+	}
+	out.Reset()
+	require.NoError(t, c.hotKeys(&out, 0))
+	summary := out.String()[strings.Index(out.String(), "-------- summary"):]
+	lines := strings.Split(strings.TrimSpace(summary), "\n")
+	require.Equal(t, "Sampled 303 keys in the keyspace!", lines[2])
+	require.Len(t, lines, 3+hotKeysTop)
+	require.Regexp(t, `^hot key found with counter: \d+\tkeyname: "big"$`, lines[3])
+}
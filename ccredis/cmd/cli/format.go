@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	redis "github.com/Kostaaa1/redis-clone/internal/resp"
+)
+
+// formatTTY renders a reply like redis-cli does on a terminal. prefix is the indentation
+// of nested aggregates, every element after the first line of an aggregate starts with it.
+func formatTTY(v redis.Value, prefix string) string {
+	switch v.Type {
+	case "string":
+		return v.String + "\n"
+	case "error":
+		return "(error) " + v.String + "\n"
+	case "integer":
+		return "(integer) " + strconv.Itoa(v.Int) + "\n"
+	case "bulk":
+		return repr(v.Bulk) + "\n"
+	case "null":
+		return "(nil)\n"
+	case "array", "push":
+		if len(v.Array) == 0 {
+			return "(empty array)\n"
+		}
+		var b strings.Builder
+		width := len(strconv.Itoa(len(v.Array)))
+		for i, elem := range v.Array {
+			label := fmt.Sprintf("%*d) ", width, i+1)
+			if i > 0 {
+				b.WriteString(prefix)
+			}
+			b.WriteString(label)
+			b.WriteString(formatTTY(elem, prefix+strings.Repeat(" ", len(label))))
+		}
+		return b.String()
+	case "map":
+		if len(v.Array) == 0 {
+			return "(empty hash)\n"
+		}
+		var b strings.Builder
+		n := len(v.Array) / 2
+		width := len(strconv.Itoa(n))
+		for i := 0; i < n; i++ {
+			label := fmt.Sprintf("%*d# ", width, i+1)
+			if i > 0 {
+				b.WriteString(prefix)
+			}
+			b.WriteString(label)
+			key := strings.TrimSuffix(formatRaw(v.Array[2*i]), "\n") + " => "
+			b.WriteString(key)
+			b.WriteString(formatTTY(v.Array[2*i+1], prefix+strings.Repeat(" ", len(label)+len(key))))
+		}
+		return b.String()
+	default:
+		return "(unknown reply type " + v.Type + ")\n"
+	}
+}
+
+// formatRaw renders a reply without type annotations and quoting, used with --raw and
+// when the output is not a terminal
+func formatRaw(v redis.Value) string {
+	switch v.Type {
+	case "string":
+		return v.String + "\n"
+	case "error":
+		return v.String + "\n"
+	case "integer":
+		return strconv.Itoa(v.Int) + "\n"
+	case "bulk":
+		return v.Bulk + "\n"
+	case "null":
+		return "\n"
+	case "array", "push", "map":
+		var b strings.Builder
+		for _, elem := range v.Array {
+			b.WriteString(formatRaw(elem))
+		}
+		return b.String()
+	default:
+		return "\n"
+	}
+}
+
+// repr quotes s escaping everything that isn't printable ASCII
+func repr(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; ch {
+		case '\\', '"':
+			b.WriteByte('\\')
+			b.WriteByte(ch)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\a':
+			b.WriteString(`\a`)
+		case '\b':
+			b.WriteString(`\b`)
+		default:
+			if ch < 0x20 || ch > 0x7e {
+				fmt.Fprintf(&b, `\x%02x`, ch)
+			} else {
+				b.WriteByte(ch)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	redis "github.com/Kostaaa1/redis-clone/internal/resp"
+)
+
+// keyType is a type --bigkeys reports on, with the command giving the size of a key
+type keyType struct {
+	name, sizeCmd, unit string
+}
+
+var keyTypes = []keyType{
+	{"string", "STRLEN", "bytes"},
+	{"list", "LLEN", "items"},
+	{"set", "SCARD", "members"},
+	{"hash", "HLEN", "fields"},
+	{"zset", "ZCARD", "members"},
+}
+
+// hotKeysTop is the number of keys --hotkeys reports
+const hotKeysTop = 16
+
+const scanBanner = `# Scanning the entire keyspace to find %s as well as
+# average sizes per key type.  You can use -i 0.1 to sleep 0.1 sec
+# per 100 SCAN commands (not usually needed).
+
+`
+
+// bigKeys walks the keyspace with SCAN and reports the biggest key of every type and the
+// average sizes, like redis-cli --bigkeys
+func (c *cli) bigKeys(w io.Writer, interval time.Duration) error {
+	type stats struct {
+		keys, total, biggest int
+		biggestKey           string
+	}
+	byType := make(map[string]*stats)
+	for _, kt := range keyTypes {
+		byType[kt.name] = &stats{biggest: -1}
+	}
+
+	fmt.Fprintf(w, scanBanner, "biggest keys")
+	sampled, keyBytes := 0, 0
+	err := c.scanKeys(interval, func(keys []string, progress float64) error {
+		cmds := make([][]string, len(keys))
+		for i, key := range keys {
+			cmds[i] = []string{"TYPE", key}
+		}
+		types, err := c.pipeline(cmds)
+		if err != nil {
+			return err
+		}
+		var sized []string
+		var kts []keyType
+		cmds = cmds[:0]
+		for i, key := range keys {
+			t := slices.IndexFunc(keyTypes, func(kt keyType) bool { return kt.name == types[i].String })
+			if t < 0 {
+				// gone since SCAN returned it, or a type without a size command
+				continue
+			}
+			sized = append(sized, key)
+			kts = append(kts, keyTypes[t])
+			cmds = append(cmds, []string{keyTypes[t].sizeCmd, key})
+		}
+		sizes, err := c.pipeline(cmds)
+		if err != nil {
+			return err
+		}
+
+		for i, key := range sized {
+			if sizes[i].Type != "integer" {
+				continue
+			}
+			kt, size := kts[i], sizes[i].Int
+			st := byType[kt.name]
+			sampled++
+			keyBytes += len(key)
+			st.keys++
+			st.total += size
+			if size > st.biggest {
+				st.biggest, st.biggestKey = size, key
+				fmt.Fprintf(w, "[%05.2f%%] Biggest %-6s found so far %s with %d %s\n", progress, kt.name, repr(key), size, kt.unit)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "\n-------- summary -------\n\nSampled %d keys in the keyspace!\n", sampled)
+	fmt.Fprintf(w, "Total key length in bytes is %d (avg len %.2f)\n\n", keyBytes, avg(keyBytes, sampled))
+	for _, kt := range keyTypes {
+		if st := byType[kt.name]; st.biggest >= 0 {
+			fmt.Fprintf(w, "Biggest %6s found %s has %d %s\n", kt.name, repr(st.biggestKey), st.biggest, kt.unit)
+		}
+	}
+	fmt.Fprintln(w)
+	for _, kt := range keyTypes {
+		st := byType[kt.name]
+		fmt.Fprintf(w, "%d %ss with %d %s (%05.2f%% of keys, avg size %.2f)\n",
+			st.keys, kt.name, st.total, kt.unit, 100*avg(st.keys, sampled), avg(st.total, st.keys))
+	}
+	return nil
+}
+
+// hotKeys walks the keyspace with SCAN and reports the keys with the highest access
+// frequency, like redis-cli --hotkeys. OBJECT FREQ needs an LFU maxmemory-policy.
+func (c *cli) hotKeys(w io.Writer, interval time.Duration) error {
+	type hot struct {
+		key  string
+		freq int
+	}
+	var top []hot
+
+	fmt.Fprintf(w, scanBanner, "hot keys")
+	sampled := 0
+	err := c.scanKeys(interval, func(keys []string, progress float64) error {
+		cmds := make([][]string, len(keys))
+		for i, key := range keys {
+			cmds[i] = []string{"OBJECT", "FREQ", key}
+		}
+		freqs, err := c.pipeline(cmds)
+		if err != nil {
+			return err
+		}
+		for i, key := range keys {
+			v := freqs[i]
+			if v.Type == "null" {
+				// gone since SCAN returned it
+				continue
+			}
+			if v.Type == "error" {
+				return fmt.Errorf("Error: %s", v.String)
+			}
+			sampled++
+			if len(top) == hotKeysTop && v.Int <= top[len(top)-1].freq {
+				continue
+			}
+			at, _ := slices.BinarySearchFunc(top, v.Int, func(h hot, freq int) int { return freq - h.freq })
+			top = slices.Insert(top, at, hot{key, v.Int})
+			if len(top) > hotKeysTop {
+				top = top[:hotKeysTop]
+			}
+			fmt.Fprintf(w, "[%05.2f%%] Hot key %s found so far with counter %d\n", progress, repr(key), v.Int)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "\n-------- summary -------\n\nSampled %d keys in the keyspace!\n", sampled)
+	for _, h := range top {
+		fmt.Fprintf(w, "hot key found with counter: %d\tkeyname: %s\n", h.freq, repr(h.key))
+	}
+	return nil
+}
+
+// scanKeys calls fn with every batch of keys SCAN returns and the share of the keyspace
+// scanned so far in percent, sleeping interval after every 100 SCAN calls
+func (c *cli) scanKeys(interval time.Duration, fn func(keys []string, progress float64) error) error {
+	total, err := c.dbsize()
+	if err != nil {
+		return err
+	}
+	cursor, seen := "0", 0
+	for calls := 1; ; calls++ {
+		v, err := c.send([]string{"SCAN", cursor, "COUNT", "100"})
+		if err != nil {
+			return err
+		}
+		if v.Type == "error" {
+			return fmt.Errorf("Error: %s", v.String)
+		}
+		if v.Type != "array" || len(v.Array) != 2 {
+			return fmt.Errorf("Error: unexpected SCAN reply")
+		}
+		keys := make([]string, len(v.Array[1].Array))
+		for i, k := range v.Array[1].Array {
+			keys[i] = k.Bulk
+		}
+		seen += len(keys)
+		if err := fn(keys, 100*avg(seen, max(total, seen))); err != nil {
+			return err
+		}
+
+		cursor = v.Array[0].Bulk
+		if cursor == "0" {
+			return nil
+		}
+		if interval > 0 && calls%100 == 0 {
+			time.Sleep(interval)
+		}
+	}
+}
+
+// dbsize reads the number of keys from INFO keyspace
+func (c *cli) dbsize() (int, error) {
+	v, err := c.send([]string{"INFO", "keyspace"})
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(v.Bulk, "\r\n") {
+		if rest, ok := strings.CutPrefix(line, "db0:keys="); ok {
+			n, _, _ := strings.Cut(rest, ",")
+			return strconv.Atoi(n)
+		}
+	}
+	return 0, nil
+}
+
+// pipeline sends all cmds before reading their replies, one round trip per SCAN batch
+// instead of one per key
+func (c *cli) pipeline(cmds [][]string) ([]redis.Value, error) {
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+	for _, args := range cmds {
+		req := redis.Value{Type: "array", Array: make([]redis.Value, len(args))}
+		for i, arg := range args {
+			req.Array[i] = redis.Value{Type: "bulk", Bulk: arg}
+		}
+		if _, err := c.w.Write(req); err != nil {
+			c.disconnect()
+			return nil, err
+		}
+	}
+	replies := make([]redis.Value, len(cmds))
+	for i := range replies {
+		v, err := c.r.Read()
+		if err != nil {
+			c.disconnect()
+			return nil, err
+		}
+		replies[i] = v
+	}
+	return replies, nil
+}
+
+func avg(n, of int) float64 {
+	if of == 0 {
+		return 0
+	}
+	return float64(n) / float64(of)
+}
@@ -0,0 +1,381 @@
+// Command cli is a small redis-cli compatible client for poking at the server.
+//
+//	cli [-h host] [-p port] [--raw]          interactive REPL with history
+//	cli [-h host] [-p port] [-x] cmd [arg..] run a single command, -x reads the last argument from stdin
+//	cli [-h host] [-p port] --pipe < file    send raw RESP commands from stdin
+//	cli [-h host] [-p port] --bigkeys [-i s] report the biggest key of every type
+//	cli [-h host] [-p port] --hotkeys [-i s] report the most accessed keys, needs an LFU maxmemory-policy
+//
+// -s connects to a unix socket instead, --tls with --cacert, --cert and --key to a TLS port.
+package main
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chzyer/readline"
+
+	redis "github.com/Kostaaa1/redis-clone/internal/resp"
+)
+
+type cli struct {
+	network string
+	addr    string
+	// nil for plain connections
+	tlsConf *tls.Config
+	raw     bool
+
+	conn net.Conn
+	r    *redis.Resp
+	w    *redis.Writer
+
+	// set between MULTI and EXEC/DISCARD, shown in the prompt
+	inTx bool
+}
+
+func main() {
+	host := flag.String("h", "127.0.0.1", "server hostname")
+	port := flag.Int("p", 6380, "server port")
+	raw := flag.Bool("raw", false, "use raw formatting for replies (default when stdout is not a tty)")
+	stdinArg := flag.Bool("x", false, "read the last argument from stdin")
+	pipe := flag.Bool("pipe", false, "transfer raw RESP commands from stdin to the server")
+	socket := flag.String("s", "", "server socket (overrides hostname and port)")
+	useTLS := flag.Bool("tls", false, "establish a secure TLS connection")
+	cacert := flag.String("cacert", "", "CA certificate file to verify the server with")
+	cert := flag.String("cert", "", "client certificate to authenticate with")
+	key := flag.String("key", "", "private key file to authenticate with")
+	insecure := flag.Bool("insecure", false, "allow insecure TLS connection by skipping cert validation")
+	bigkeys := flag.Bool("bigkeys", false, "sample keys looking for keys with many elements (complexity)")
+	hotkeys := flag.Bool("hotkeys", false, "sample keys looking for hot keys, only works when maxmemory-policy is *lfu")
+	interval := flag.Float64("i", 0, "with --bigkeys and --hotkeys, seconds to wait every 100 SCAN commands")
+	flag.Parse()
+
+	c := &cli{
+		network: "tcp",
+		addr:    net.JoinHostPort(*host, strconv.Itoa(*port)),
+		raw:     *raw || !readline.IsTerminal(int(os.Stdout.Fd())),
+	}
+	if *socket != "" {
+		c.network, c.addr = "unix", *socket
+	}
+
+	var err error
+	if *useTLS {
+		c.tlsConf, err = clientTLSConfig(*host, *cacert, *cert, *key, *insecure)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	switch {
+	case *pipe:
+		err = c.pipe(os.Stdin)
+	case *bigkeys:
+		err = c.bigKeys(os.Stdout, time.Duration(*interval*float64(time.Second)))
+	case *hotkeys:
+		err = c.hotKeys(os.Stdout, time.Duration(*interval*float64(time.Second)))
+	case flag.NArg() > 0 || *stdinArg:
+		args := flag.Args()
+		if *stdinArg {
+			var b []byte
+			if b, err = io.ReadAll(os.Stdin); err != nil {
+				break
+			}
+			args = append(args, string(b))
+		}
+		err = c.runOnce(args)
+	default:
+		c.raw = *raw
+		err = c.repl()
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func (c *cli) connect() error {
+	if c.conn != nil {
+		return nil
+	}
+	d := &net.Dialer{Timeout: 5 * time.Second}
+	var conn net.Conn
+	var err error
+	if c.tlsConf != nil {
+		conn, err = tls.DialWithDialer(d, c.network, c.addr, c.tlsConf)
+	} else {
+		conn, err = d.Dial(c.network, c.addr)
+	}
+	if err != nil {
+		return fmt.Errorf("Could not connect to Redis at %s: %w", c.addr, err)
+	}
+	c.conn = conn
+	c.r = redis.NewReader(conn)
+	c.w = redis.NewWriter(conn)
+	c.inTx = false
+	return nil
+}
+
+// clientTLSConfig verifies the server against cacert, or the system roots without it,
+// and presents cert when given
+func clientTLSConfig(host, cacert, cert, key string, insecure bool) (*tls.Config, error) {
+	conf := &tls.Config{ServerName: host, InsecureSkipVerify: insecure}
+	if cacert != "" {
+		pem, err := os.ReadFile(cacert)
+		if err != nil {
+			return nil, err
+		}
+		conf.RootCAs = x509.NewCertPool()
+		if !conf.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cacert)
+		}
+	}
+	if cert != "" || key != "" {
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{pair}
+	}
+	return conf, nil
+}
+
+func (c *cli) disconnect() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+func (c *cli) format(v redis.Value) string {
+	if c.raw {
+		return formatRaw(v)
+	}
+	return formatTTY(v, "")
+}
+
+// send writes one command and returns its reply, push messages that arrive first are printed
+func (c *cli) send(args []string) (redis.Value, error) {
+	if err := c.connect(); err != nil {
+		return redis.Value{}, err
+	}
+
+	req := redis.Value{Type: "array", Array: make([]redis.Value, len(args))}
+	for i, arg := range args {
+		req.Array[i] = redis.Value{Type: "bulk", Bulk: arg}
+	}
+	if _, err := c.w.Write(req); err != nil {
+		c.disconnect()
+		return redis.Value{}, err
+	}
+
+	for {
+		v, err := c.r.Read()
+		if err != nil {
+			c.disconnect()
+			return redis.Value{}, err
+		}
+		if v.Type == "push" && !isSubscribe(args[0]) {
+			fmt.Print(c.format(v))
+			continue
+		}
+		return v, nil
+	}
+}
+
+func isSubscribe(name string) bool {
+	name = strings.ToUpper(name)
+	return name == "SUBSCRIBE" || name == "PSUBSCRIBE"
+}
+
+// isStreaming reports whether the server keeps sending after the reply to name
+func isStreaming(name string) bool {
+	return isSubscribe(name) || strings.EqualFold(name, "MONITOR")
+}
+
+// subscribed prints pub/sub messages and monitored commands until the connection breaks
+func (c *cli) subscribed() error {
+	for {
+		v, err := c.r.Read()
+		if err != nil {
+			c.disconnect()
+			return err
+		}
+		fmt.Print(c.format(v))
+	}
+}
+
+func (c *cli) runOnce(args []string) error {
+	v, err := c.send(args)
+	if err != nil {
+		return err
+	}
+	fmt.Print(c.format(v))
+	if isStreaming(args[0]) && v.Type != "error" {
+		return c.subscribed()
+	}
+	if v.Type == "error" {
+		os.Exit(1)
+	}
+	return nil
+}
+
+func (c *cli) prompt() string {
+	if c.conn == nil {
+		return "not connected> "
+	}
+	if c.inTx {
+		return c.addr + "(TX)> "
+	}
+	return c.addr + "> "
+}
+
+func (c *cli) repl() error {
+	home, _ := os.UserHomeDir()
+	rl, err := readline.NewEx(&readline.Config{
+		HistoryFile:     filepath.Join(home, ".ccredis_cli_history"),
+		InterruptPrompt: "^C",
+	})
+	if err != nil {
+		return err
+	}
+	defer rl.Close()
+
+	if err := c.connect(); err != nil {
+		fmt.Println(err)
+	}
+
+	for {
+		rl.SetPrompt(c.prompt())
+		line, err := rl.Readline()
+		if errors.Is(err, readline.ErrInterrupt) {
+			continue
+		}
+		if err != nil {
+			return nil
+		}
+
+		args, err := splitArgs(line)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		switch strings.ToLower(args[0]) {
+		case "quit", "exit":
+			return nil
+		case "clear":
+			readline.ClearScreen(rl)
+			continue
+		}
+
+		v, err := c.send(args)
+		if err != nil {
+			fmt.Println("Error:", err)
+			continue
+		}
+		fmt.Print(c.format(v))
+
+		if v.Type != "error" {
+			switch strings.ToUpper(args[0]) {
+			case "MULTI":
+				c.inTx = true
+			case "EXEC", "DISCARD":
+				c.inTx = false
+			}
+		}
+
+		if isStreaming(args[0]) && v.Type != "error" {
+			fmt.Println("Reading messages... (press Ctrl-C to quit)")
+			if err := c.subscribed(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// pipe streams the RESP commands in r to the server while a second goroutine counts the
+// replies, so large imports are not slowed down by round trips. Like redis-cli a final
+// command echoing a random marker tells the reader that the last reply arrived.
+func (c *cli) pipe(r io.Reader) error {
+	if err := c.connect(); err != nil {
+		return err
+	}
+
+	marker := make([]byte, 20)
+	rand.Read(marker)
+	magic := hex.EncodeToString(marker)
+
+	type result struct {
+		replies, errors int
+		err             error
+	}
+	done := make(chan result, 1)
+
+	go func() {
+		var res result
+		for {
+			v, err := c.r.Read()
+			if err != nil {
+				res.err = err
+				break
+			}
+			if v.Type == "bulk" && v.Bulk == magic {
+				break
+			}
+			res.replies++
+			if v.Type == "error" {
+				res.errors++
+				fmt.Fprintln(os.Stderr, v.String)
+			}
+		}
+		done <- res
+	}()
+
+	in := redis.NewReader(r)
+	for {
+		v, err := in.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("invalid input: %w", err)
+		}
+		if _, err := c.w.Write(v); err != nil {
+			return err
+		}
+	}
+	ping := redis.Value{Type: "array", Array: []redis.Value{{Type: "bulk", Bulk: "PING"}, {Type: "bulk", Bulk: magic}}}
+	if _, err := c.w.Write(ping); err != nil {
+		return err
+	}
+	fmt.Println("All data transferred. Waiting for the last reply...")
+
+	res := <-done
+	if res.err != nil {
+		return res.err
+	}
+	fmt.Println("Last reply received from server.")
+	fmt.Printf("errors: %d, replies: %d\n", res.errors, res.replies)
+	if res.errors > 0 {
+		os.Exit(1)
+	}
+	return nil
+}
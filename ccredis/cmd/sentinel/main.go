@@ -0,0 +1,85 @@
+// Command sentinel monitors primaries and their replicas and fails them over, like
+// redis-sentinel. Run several of them, e.g. three with a quorum of 2, against the same primaries.
+//
+//	sentinel [-bind addr] [-port port] [-down-after-milliseconds ms] [-failover-timeout ms] -monitor "<name> <host> <port> <quorum>" [-monitor ...]
+//
+// Clients find the current primary with SENTINEL GET-MASTER-ADDR-BY-NAME <name>.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Kostaaa1/redis-clone/sentinel"
+)
+
+// monitorFlags collects the repeated -monitor flags
+type monitorFlags []string
+
+func (m *monitorFlags) String() string { return strings.Join(*m, ", ") }
+
+func (m *monitorFlags) Set(v string) error {
+	*m = append(*m, v)
+	return nil
+}
+
+func main() {
+	bind := flag.String("bind", "127.0.0.1", "address to listen on, also announced to the other sentinels")
+	port := flag.Int("port", 26380, "sentinel port")
+	downAfter := flag.Int("down-after-milliseconds", 30000, "how long a primary may not reply before it is considered down")
+	failoverTimeout := flag.Int("failover-timeout", 180000, "how long a failover may take, in milliseconds")
+	var monitors monitorFlags
+	flag.Var(&monitors, "monitor", `primary to monitor as "<name> <host> <port> <quorum>", can be repeated`)
+	flag.Parse()
+
+	cfg := sentinel.Config{Addr: net.JoinHostPort(*bind, strconv.Itoa(*port))}
+	for _, m := range monitors {
+		mc, err := parseMonitor(m)
+		if err != nil {
+			log.Fatal(err)
+		}
+		mc.DownAfter = time.Duration(*downAfter) * time.Millisecond
+		mc.FailoverTimeout = time.Duration(*failoverTimeout) * time.Millisecond
+		cfg.Masters = append(cfg.Masters, mc)
+	}
+	if len(cfg.Masters) == 0 {
+		log.Fatal("nothing to monitor, add a -monitor flag")
+	}
+
+	s, err := sentinel.New(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		s.Close()
+	}()
+
+	log.Printf("sentinel %s listening on %s", s.RunID(), cfg.Addr)
+	if err := s.ListenAndServe(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func parseMonitor(v string) (sentinel.MasterConfig, error) {
+	f := strings.Fields(v)
+	if len(f) != 4 {
+		return sentinel.MasterConfig{}, fmt.Errorf(`invalid -monitor %q, expected "<name> <host> <port> <quorum>"`, v)
+	}
+	quorum, err := strconv.Atoi(f[3])
+	if err != nil {
+		return sentinel.MasterConfig{}, fmt.Errorf("invalid quorum in -monitor %q", v)
+	}
+	return sentinel.MasterConfig{Name: f[0], Addr: net.JoinHostPort(f[1], f[2]), Quorum: quorum}, nil
+}
@@ -0,0 +1,213 @@
+// Package harness runs several independent servers in one process, for tests of code that
+// talks to more than one instance, e.g. distributed locks. Every instance is reached through
+// a proxy, so a test can cut it off the network without losing its data, or crash it.
+package harness
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/Kostaaa1/redis-clone/client"
+	redis "github.com/Kostaaa1/redis-clone/internal/resp"
+)
+
+type Harness struct {
+	Instances []*Instance
+}
+
+// Start serves n servers configured by cfg on free ports until the test ends
+func Start(t testing.TB, n int, cfg redis.Config) *Harness {
+	t.Helper()
+
+	h := &Harness{Instances: make([]*Instance, n)}
+	for i := range h.Instances {
+		inst := &Instance{cfg: cfg, conns: make(map[net.Conn]struct{})}
+		inst.start(t)
+
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		inst.proxy = ln
+		go inst.serveProxy()
+
+		t.Cleanup(inst.close)
+		h.Instances[i] = inst
+	}
+	return h
+}
+
+// Clients returns a client for every instance, closed when the test ends
+func (h *Harness) Clients(t testing.TB, opt client.Options) []*client.Client {
+	clients := make([]*client.Client, len(h.Instances))
+	for i, inst := range h.Instances {
+		clients[i] = inst.Client(t, opt)
+	}
+	return clients
+}
+
+// Instance is a single server of a Harness
+type Instance struct {
+	cfg   redis.Config
+	proxy net.Listener
+
+	mu          sync.Mutex
+	srv         *redis.Server
+	partitioned bool
+	// proxied connections, both the client and the server side
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+func (inst *Instance) start(t testing.TB) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := inst.cfg
+	cfg.Bind = "127.0.0.1"
+	cfg.Port = ln.Addr().(*net.TCPAddr).Port
+
+	inst.srv = redis.NewServer(cfg)
+	go inst.srv.Serve(ln)
+}
+
+// Addr is the address clients connect to, it stays the same across restarts
+func (inst *Instance) Addr() string {
+	return inst.proxy.Addr().String()
+}
+
+// Server returns the server currently running, nil while the instance is stopped
+func (inst *Instance) Server() *redis.Server {
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	return inst.srv
+}
+
+// Client returns a client of the instance, closed when the test ends
+func (inst *Instance) Client(t testing.TB, opt client.Options) *client.Client {
+	opt.Addr = inst.Addr()
+	c := client.New(opt)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// Stop crashes the instance, its keyspace is lost. Clients can't connect until Restart.
+func (inst *Instance) Stop() {
+	inst.mu.Lock()
+	srv := inst.srv
+	inst.srv = nil
+	inst.mu.Unlock()
+
+	if srv != nil {
+		srv.Shutdown(false)
+	}
+	inst.dropConns()
+}
+
+// Restart starts a new, empty server behind the instance address after Stop
+func (inst *Instance) Restart(t testing.TB) {
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	if inst.srv == nil {
+		inst.start(t)
+	}
+}
+
+// Partition cuts the instance off, open connections break and new ones are closed right
+// away. Unlike Stop the server keeps running with its data.
+func (inst *Instance) Partition() {
+	inst.mu.Lock()
+	inst.partitioned = true
+	inst.mu.Unlock()
+	inst.dropConns()
+}
+
+// Heal undoes Partition
+func (inst *Instance) Heal() {
+	inst.mu.Lock()
+	inst.partitioned = false
+	inst.mu.Unlock()
+}
+
+func (inst *Instance) dropConns() {
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	for conn := range inst.conns {
+		conn.Close()
+	}
+	clear(inst.conns)
+}
+
+func (inst *Instance) close() {
+	inst.mu.Lock()
+	inst.closed = true
+	srv := inst.srv
+	inst.mu.Unlock()
+
+	inst.proxy.Close()
+	inst.dropConns()
+	if srv != nil {
+		srv.Close()
+	}
+}
+
+func (inst *Instance) serveProxy() {
+	for {
+		conn, err := inst.proxy.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			continue
+		}
+		go inst.forward(conn)
+	}
+}
+
+// forward copies between conn and the server until either side closes
+func (inst *Instance) forward(conn net.Conn) {
+	inst.mu.Lock()
+	srv, unreachable := inst.srv, inst.partitioned || inst.closed
+	inst.mu.Unlock()
+	if srv == nil || unreachable {
+		conn.Close()
+		return
+	}
+
+	upstream, err := net.Dial("tcp", srv.Addr())
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	inst.mu.Lock()
+	if inst.srv != srv || inst.partitioned || inst.closed {
+		inst.mu.Unlock()
+		conn.Close()
+		upstream.Close()
+		return
+	}
+	inst.conns[conn] = struct{}{}
+	inst.conns[upstream] = struct{}{}
+	inst.mu.Unlock()
+
+	done := make(chan struct{}, 2)
+	pipe := func(dst, src net.Conn) {
+		io.Copy(dst, src)
+		done <- struct{}{}
+	}
+	go pipe(upstream, conn)
+	go pipe(conn, upstream)
+	<-done
+
+	conn.Close()
+	upstream.Close()
+	inst.mu.Lock()
+	delete(inst.conns, conn)
+	delete(inst.conns, upstream)
+	inst.mu.Unlock()
+}
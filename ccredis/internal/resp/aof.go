@@ -0,0 +1,406 @@
+package redis
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The append only file logs the replication stream without its pings and acks, every write
+// command as RESP, and is replayed on startup instead of loading the snapshot. A rewrite
+// replaces it with a snapshot of the keyspace, the same format SAVE writes, and the commands
+// executed after the rewrite are appended to it. Like Redis a truncated last command, e.g. of
+// a crash in the middle of a write, is dropped when loading.
+
+// appendfsync policies
+var appendFsyncPolicies = []string{"always", "everysec", "no"}
+
+const (
+	fsyncAlways = iota
+	fsyncEverysec
+	fsyncNo
+)
+
+// aof appends the stream to an open file. A goroutine writes the buffered commands and
+// fsyncs the file as appendfsync says, commands never wait on the disk while holding locks.
+type aof struct {
+	srv *Server
+	f   *os.File
+
+	mu  sync.Mutex
+	buf []byte
+	// replication offset at the end of buf
+	bufOff int64
+
+	wake          chan struct{}
+	syncRequested atomic.Bool
+	done          chan struct{}
+	stopped       chan struct{}
+}
+
+func (s *Server) aofPath() string {
+	return filepath.Join(s.cfg.Dir, s.cfg.AppendFilename)
+}
+
+// newAOF appends to f, which holds the stream up to offset off
+func (s *Server) newAOF(f *os.File, off int64) *aof {
+	a := &aof{
+		srv:     s,
+		f:       f,
+		bufOff:  off,
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+// append buffers a command ending at the replication offset off, called under replication.mu
+func (a *aof) append(v Value, off int64) {
+	a.mu.Lock()
+	a.buf = v.appendTo(a.buf)
+	a.bufOff = off
+	a.mu.Unlock()
+	a.notify()
+}
+
+func (a *aof) notify() {
+	select {
+	case a.wake <- struct{}{}:
+	default:
+	}
+}
+
+// close writes and fsyncs what is buffered and closes the file
+func (a *aof) close() {
+	close(a.done)
+	<-a.stopped
+}
+
+func (a *aof) run() {
+	defer close(a.stopped)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	var (
+		buf             []byte
+		written, synced int64
+		lastSync        = time.Now()
+		failed          bool
+	)
+	a.mu.Lock()
+	written, synced = a.bufOff, a.bufOff
+	a.mu.Unlock()
+
+	for {
+		closing := false
+		select {
+		case <-a.wake:
+		case <-ticker.C:
+		case <-a.done:
+			closing = true
+		}
+
+		// swap buffers so appends don't wait for the write
+		a.mu.Lock()
+		buf, a.buf = a.buf, buf[:0]
+		off := a.bufOff
+		a.mu.Unlock()
+
+		if len(buf) > 0 && !failed {
+			if _, err := a.f.Write(buf); err != nil {
+				// the file misses commands from now on, it is never reported fsynced again
+				log.Printf("error writing the AOF, WAITAOF won't be acknowledged until it is rewritten: %v", err)
+				a.srv.aofLastWriteOK.Store(false)
+				failed = true
+			} else {
+				written = off
+			}
+		}
+
+		policy := a.srv.appendFsync.Load()
+		if !failed && written > synced {
+			requested := a.syncRequested.Swap(false)
+			switch {
+			case policy == fsyncNo:
+				// the kernel flushes when it sees fit, a written command is as good as it gets
+				synced = written
+			case policy == fsyncAlways || closing || requested || time.Since(lastSync) >= time.Second:
+				if err := a.f.Sync(); err != nil {
+					log.Printf("error fsyncing the AOF: %v", err)
+					a.srv.aofLastWriteOK.Store(false)
+					break
+				}
+				lastSync = time.Now()
+				synced = written
+			}
+			a.srv.repl.setAOFSynced(synced)
+		}
+
+		if closing {
+			a.f.Close()
+			return
+		}
+	}
+}
+
+func (r *replication) aofEnabled() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.aof != nil
+}
+
+// setAOFSynced records that the AOF is fsynced up to off
+func (r *replication) setAOFSynced(off int64) {
+	for {
+		cur := r.aofSynced.Load()
+		if off <= cur {
+			return
+		}
+		if r.aofSynced.CompareAndSwap(cur, off) {
+			r.acked.broadcast()
+			return
+		}
+	}
+}
+
+// requestAOFSync makes the AOF fsync right away instead of at the next second
+func (r *replication) requestAOFSync() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.aof != nil {
+		r.aof.syncRequested.Store(true)
+		r.aof.notify()
+	}
+}
+
+// waitAOFSynced blocks until the AOF is fsynced up to off, for appendfsync always
+func (r *replication) waitAOFSynced(off int64) {
+	for {
+		changed := r.acked.wait()
+		if r.aofSynced.Load() >= off || !r.aofEnabled() {
+			return
+		}
+		select {
+		case <-changed:
+		case <-r.srv.done:
+			return
+		}
+	}
+}
+
+// disableAOF stops appending to the AOF, after writing and fsyncing what is buffered
+func (r *replication) disableAOF() {
+	r.mu.Lock()
+	a := r.aof
+	r.aof = nil
+	r.updateListening()
+	r.mu.Unlock()
+	if a != nil {
+		a.close()
+		r.acked.broadcast()
+	}
+}
+
+// rewriteAOF writes a snapshot of the keyspace to a new AOF and, with attach, appends the
+// commands that follow to it. Writers are blocked for the duration of the rewrite.
+func (s *Server) rewriteAOF(attach bool) error {
+	s.aofRewriteMu.Lock()
+	defer s.aofRewriteMu.Unlock()
+	defer s.store.rlockAll()()
+	return s.rewriteAOFLocked(attach)
+}
+
+// rewriteAOFLocked is rewriteAOF for callers holding aofRewriteMu and rlockAll or lockAll
+func (s *Server) rewriteAOFLocked(attach bool) error {
+	start := time.Now()
+	path := s.aofPath()
+	tmp := filepath.Join(filepath.Dir(path), fmt.Sprintf("temp-rewriteaof-%d-%d.aof", os.Getpid(), time.Now().UnixNano()))
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		f.Close()
+		os.Remove(tmp)
+		log.Printf("error rewriting the AOF: %v", err)
+		return err
+	}
+	if err := s.writeSnapshot(f, snapshotLocked); err != nil {
+		return fail(err)
+	}
+	if err := f.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fail(err)
+	}
+	s.recordLatency(latencyAOFRewrite, time.Since(start))
+
+	r := s.repl
+	r.mu.Lock()
+	if !attach && r.aof == nil {
+		r.mu.Unlock()
+		return f.Close()
+	}
+	// nothing was fed since the keyspace is locked, the snapshot holds the whole stream
+	old := r.aof
+	off := r.offset.Load()
+	r.aof = s.newAOF(f, off)
+	r.updateListening()
+	r.mu.Unlock()
+
+	s.aofLastWriteOK.Store(true)
+	if old != nil {
+		old.close()
+	}
+	r.setAOFSynced(off)
+	return nil
+}
+
+// loadAOF replays the AOF and keeps appending to it. Without an AOF the snapshot is loaded
+// and written as the first AOF.
+func (s *Server) loadAOF() error {
+	path := s.aofPath()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		if err := s.loadSnapshot(); err != nil {
+			return fmt.Errorf("loading %s: %w", s.snapshotPath(), err)
+		}
+		return s.rewriteAOF(true)
+	}
+	if err != nil {
+		return err
+	}
+
+	body := data
+	keys := 0
+	if hasSnapshotMagic(data) {
+		records, rest, err := decodeSnapshot(data)
+		if err != nil {
+			return fmt.Errorf("loading %s: %w", path, err)
+		}
+		unlock := s.store.lockAll()
+		keys, err = s.restoreSnapshot(records)
+		unlock()
+		if err != nil {
+			return fmt.Errorf("loading %s: %w", path, err)
+		}
+		body = rest
+	}
+
+	n, valid, err := s.replayAOF(body)
+	if err != nil {
+		return fmt.Errorf("loading %s: %w", path, err)
+	}
+	log.Printf("loaded %d keys and %d commands from %s", keys, n, path)
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if valid < len(body) {
+		log.Printf("!!! the AOF ends with an incomplete command, truncating %d bytes", len(body)-valid)
+		if err := f.Truncate(int64(len(data) - len(body) + valid)); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	r := s.repl
+	r.mu.Lock()
+	r.aof = s.newAOF(f, r.offset.Load())
+	r.updateListening()
+	r.mu.Unlock()
+	r.setAOFSynced(r.offset.Load())
+	return nil
+}
+
+// replayAOF runs the commands of an AOF and returns how many ran and the length of the
+// complete commands, an incomplete last command is ignored
+func (s *Server) replayAOF(body []byte) (n, valid int, err error) {
+	c := s.newClient(nil)
+	c.replicated = true
+	defer c.close()
+
+	r := NewReader(bytes.NewReader(body))
+	for valid < len(body) {
+		v, err := r.Read()
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return n, valid, err
+		}
+		if v.Type != "array" || len(v.Array) == 0 {
+			return n, valid, errors.New("the AOF is corrupted")
+		}
+		c.dispatch(v.Array)
+		valid += v.encodedLen()
+		n++
+	}
+	// a transaction without its EXEC was cut short, like Redis it isn't applied
+	return n, valid, nil
+}
+
+// appendfsync config parameter
+var appendFsyncParam = &configParam{
+	name: "appendfsync",
+	get:  func(s *Server) string { return appendFsyncPolicies[s.appendFsync.Load()] },
+	set: func(s *Server, v string) error {
+		i := slices.Index(appendFsyncPolicies, strings.ToLower(v))
+		if i < 0 {
+			return errors.New("argument(s) must be one of the following: " + strings.Join(appendFsyncPolicies, ", "))
+		}
+		s.appendFsync.Store(int32(i))
+		return nil
+	},
+}
+
+// appendonly config parameter, turning it on writes the AOF from the current keyspace
+var appendOnlyParam = &configParam{
+	name: "appendonly",
+	get:  func(s *Server) string { return yesno(s.repl.aofEnabled()) },
+	load: func(cfg *Config, v string) error {
+		b, err := parseYesNo(v)
+		cfg.AppendOnly = b
+		return err
+	},
+	set: func(s *Server, v string) error {
+		switch strings.ToLower(v) {
+		case "yes":
+			if s.repl.aofEnabled() {
+				return nil
+			}
+			return s.rewriteAOF(true)
+		case "no":
+			s.repl.disableAOF()
+			return nil
+		default:
+			return errors.New("argument must be 'yes' or 'no'")
+		}
+	},
+}
+
+// BGREWRITEAOF
+func BGREWRITEAOF(c *Client, args []Value) Value {
+	s := c.srv
+	if !s.aofRewriteInProgress.CompareAndSwap(false, true) {
+		return errVal("Background append only file rewriting already in progress")
+	}
+	go func() {
+		defer s.aofRewriteInProgress.Store(false)
+		s.rewriteAOF(false)
+	}()
+	return strVal("Background append only file rewriting started")
+}
@@ -0,0 +1,134 @@
+package redis
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// startAOFServer serves a server that loads and appends to the AOF in dir
+func startAOFServer(t *testing.T, dir string) *testNode {
+	srv := NewServer(Config{Port: freePort(t), Dir: dir, AppendOnly: true})
+	listenAndServe(t, srv)
+	waitListening(t, "tcp", srv.Addr())
+	return dialTestServer(t, srv)
+}
+
+func TestAOF(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "appendonly.aof")
+
+	n := startAOFServer(t, dir)
+	n.do(t, "SET", "k", "v")
+	n.do(t, "RPUSH", "list", "a", "b", "c")
+	n.do(t, "SADD", "set", "x", "y", "z")
+	n.do(t, "SPOP", "set")
+	n.do(t, "MULTI")
+	n.do(t, "INCR", "counter")
+	n.do(t, "INCR", "counter")
+	n.do(t, "EXEC")
+	n.do(t, "SET", "gone", "v")
+	n.do(t, "DEL", "gone")
+	members := sorted(n.do(t, "SMEMBERS", "set"))
+	n.srv.Close()
+
+	requireData := func(n *testNode) {
+		t.Helper()
+		require.Equal(t, "v", n.do(t, "GET", "k").Bulk)
+		require.Equal(t, []string{"a", "b", "c"}, bulks(n.do(t, "LRANGE", "list", "0", "-1")))
+		require.Equal(t, members, sorted(n.do(t, "SMEMBERS", "set")))
+		require.Equal(t, "2", n.do(t, "GET", "counter").Bulk)
+		require.Equal(t, "null", n.do(t, "GET", "gone").Type)
+	}
+
+	n = startAOFServer(t, dir)
+	requireData(n)
+
+	// a rewrite keeps the data and the commands that follow are appended to it
+	require.Equal(t, "Background append only file rewriting started", n.do(t, "BGREWRITEAOF").String)
+	require.Eventually(t, func() bool { return !n.srv.aofRewriteInProgress.Load() }, 5*time.Second, 10*time.Millisecond)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.True(t, hasSnapshotMagic(data))
+	n.do(t, "SET", "after", "rewrite")
+	n.srv.Close()
+
+	n = startAOFServer(t, dir)
+	requireData(n)
+	require.Equal(t, "rewrite", n.do(t, "GET", "after").Bulk)
+
+	// with appendfsync always the command is in the file by the time it is acknowledged
+	n.do(t, "CONFIG", "SET", "appendfsync", "always")
+	n.do(t, "SET", "durable", "yes")
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	require.Contains(t, string(data), "$7\r\ndurable\r\n$3\r\nyes\r\n")
+	n.srv.Close()
+
+	// a command cut short by a crash is dropped and the file truncated
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString("*3\r\n$3\r\nSET\r\n$4\r\nhalf\r\n$5\r\nwri")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	n = startAOFServer(t, dir)
+	requireData(n)
+	require.Equal(t, "yes", n.do(t, "GET", "durable").Bulk)
+	require.Equal(t, "null", n.do(t, "GET", "half").Type)
+	n.do(t, "SET", "half", "whole")
+	n.srv.Close()
+
+	n = startAOFServer(t, dir)
+	require.Equal(t, "whole", n.do(t, "GET", "half").Bulk)
+}
+
+func TestAOF_EnabledAtRuntime(t *testing.T) {
+	dir := t.TempDir()
+	n := startTestServer(t, Config{Dir: dir})
+	n.do(t, "SET", "before", "1")
+	require.Equal(t, "OK", n.do(t, "CONFIG", "SET", "appendonly", "yes").String)
+	require.Equal(t, "yes", n.do(t, "CONFIG", "GET", "appendonly").Array[1].Bulk)
+	n.do(t, "SET", "after", "2")
+	require.Contains(t, n.do(t, "INFO", "persistence").Bulk, "aof_enabled:1\r\n")
+	require.Equal(t, "OK", n.do(t, "CONFIG", "SET", "appendonly", "no").String)
+	n.do(t, "SET", "lost", "3")
+
+	n = startAOFServer(t, dir)
+	require.Equal(t, "1", n.do(t, "GET", "before").Bulk)
+	require.Equal(t, "2", n.do(t, "GET", "after").Bulk)
+	require.Equal(t, "null", n.do(t, "GET", "lost").Type)
+}
+
+func TestAOF_RelativeTTLsDontRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	n := startAOFServer(t, dir)
+	n.do(t, "SET", "ex", "v", "EX", "1")
+	n.do(t, "SET", "px", "v", "PX", "1000")
+	n.do(t, "SET", "expire", "v")
+	require.Equal(t, 1, n.do(t, "EXPIRE", "expire", "1").Int)
+	n.do(t, "SET", "long", "v", "EX", "100")
+	payload := n.do(t, "DUMP", "long").Bulk
+	require.Equal(t, "OK", n.do(t, "RESTORE", "restored", "1000", payload).String)
+	n.srv.Close()
+
+	// replaying the AOF after the ttls passed must not bring the keys back
+	time.Sleep(1100 * time.Millisecond)
+	n = startAOFServer(t, dir)
+	for _, key := range []string{"ex", "px", "expire", "restored"} {
+		require.Equal(t, "null", n.do(t, "GET", key).Type, key)
+	}
+	require.Equal(t, "v", n.do(t, "GET", "long").Bulk)
+	require.InDelta(t, 98, n.do(t, "TTL", "long").Int, 1)
+
+	at := time.Now().Add(time.Hour).UnixMilli()
+	require.Equal(t, 1, n.do(t, "PEXPIREAT", "long", strconv.FormatInt(at, 10), "GT").Int)
+	require.Equal(t, 0, n.do(t, "PEXPIREAT", "long", strconv.FormatInt(at+1, 10), "NX").Int)
+	require.InDelta(t, 3600, n.do(t, "TTL", "long").Int, 1)
+	require.Equal(t, "ERR syntax error", n.do(t, "PEXPIREAT", "long", "1", "BOGUS").String)
+}
@@ -0,0 +1,96 @@
+package redis
+
+import (
+	"bytes"
+	"io"
+	"math/rand/v2"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// keys and values that would break a text protocol, the store and its persistence
+var binaryStrings = []string{
+	"crlf\r\nkey",
+	"nul\x00key\x00",
+	"utf8\xff\xfe\xc3",
+	"*1\r\n$4\r\nPING\r\n",
+	"",
+}
+
+func TestBinarySafety(t *testing.T) {
+	dir := t.TempDir()
+	n := startAOFServer(t, dir)
+
+	big := make([]byte, 3<<20)
+	for i := range big {
+		big[i] = byte(rand.N(256))
+	}
+	copy(big[1000:], "\r\n$5\r\n")
+
+	for i, s := range binaryStrings {
+		n.do(t, "SET", s, s+"value")
+		n.do(t, "APPEND", s, "\x00")
+		n.do(t, "RPUSH", "list", s)
+		n.do(t, "HSET", "hash", s, s)
+		n.do(t, "SADD", "set", s)
+		n.do(t, "ZADD", "zset", string(rune('0'+i)), s)
+	}
+	n.do(t, "SET", "big", string(big))
+	n.do(t, "RENAME", "crlf\r\nkey", "renamed\r\n")
+
+	requireData := func(n *testNode) {
+		t.Helper()
+		for _, s := range binaryStrings[1:] {
+			require.Equal(t, s+"value\x00", n.do(t, "GET", s).Bulk)
+			require.Equal(t, s, n.do(t, "HGET", "hash", s).Bulk)
+			require.Equal(t, 1, n.do(t, "SISMEMBER", "set", s).Int)
+		}
+		require.Equal(t, "crlf\r\nkeyvalue\x00", n.do(t, "GET", "renamed\r\n").Bulk)
+		require.Equal(t, binaryStrings, bulks(n.do(t, "LRANGE", "list", "0", "-1")))
+		require.Equal(t, binaryStrings, bulks(n.do(t, "ZRANGE", "zset", "0", "-1")))
+		require.Equal(t, []string{"nul\x00key\x00"}, bulks(n.do(t, "KEYS", "nul\x00*")))
+		require.True(t, bytes.Equal(big, []byte(n.do(t, "GET", "big").Bulk)))
+
+		dump := n.do(t, "DUMP", "utf8\xff\xfe\xc3").Bulk
+		require.Equal(t, "OK", n.do(t, "RESTORE", "copy\xff", "0", dump).String)
+		require.Equal(t, "utf8\xff\xfe\xc3value\x00", n.do(t, "GET", "copy\xff").Bulk)
+		n.do(t, "DEL", "copy\xff")
+	}
+	requireData(n)
+
+	// the AOF replays the commands, after a rewrite it starts with a snapshot
+	n.srv.Close()
+	n = startAOFServer(t, dir)
+	requireData(n)
+	n.do(t, "BGREWRITEAOF")
+	require.Eventually(t, func() bool { return !n.srv.aofRewriteInProgress.Load() }, 5*time.Second, 10*time.Millisecond)
+	n.srv.Close()
+	n = startAOFServer(t, dir)
+	requireData(n)
+}
+
+func TestWriter_BigBulk(t *testing.T) {
+	big := string(bytes.Repeat([]byte("a\r\n\x00"), bigBulk))
+	v := Value{Type: "array", Array: []Value{
+		bulkVal("GET"),
+		{Type: "map", Array: []Value{bulkVal(big), intVal(1), bulkVal("small"), {Type: "null"}}},
+		bulkVal(big[:bigBulk]),
+		strVal("OK"),
+	}}
+
+	var buf bytes.Buffer
+	n, err := NewWriter(&buf).Write(v)
+	require.NoError(t, err)
+	require.Equal(t, v.encodedLen(), n)
+	require.Equal(t, v.Marshal(), buf.Bytes())
+
+	read, err := NewReader(&buf).Read()
+	require.NoError(t, err)
+	require.Equal(t, v, read)
+
+	// the payload of a big bulk goes out without being copied
+	w := NewWriter(io.Discard)
+	require.Zero(t, testing.AllocsPerRun(10, func() { w.Write(bulkVal(big)) }))
+}
@@ -0,0 +1,617 @@
+package redis
+
+import (
+	"math/bits"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// Bit commands work on string values. The first write turns the value into a []byte
+// that later writes modify in place, reads look at the bytes of whatever encoding the
+// value has without copying it.
+
+// bit offsets are limited to the 512MB maximum size of a string
+const maxBitOffset = 512*1024*1024*8 - 1
+
+// bytesView returns the value of a string item as bytes without copying. The slice may
+// alias an immutable string and must not be modified.
+func (item *RedisItem) bytesView() []byte {
+	switch v := item.value.(type) {
+	case []byte:
+		return v
+	case string:
+		return unsafe.Slice(unsafe.StringData(v), len(v))
+	default:
+		return []byte(item.str())
+	}
+}
+
+// mutableBytes converts the value of a string item to a []byte owned by the item,
+// grown with zero bytes to at least n bytes. Requires the write lock.
+func (item *RedisItem) mutableBytes(n int) []byte {
+	b, ok := item.value.([]byte)
+	if !ok {
+		s := item.str()
+		b = make([]byte, len(s), max(len(s), n))
+		copy(b, s)
+	}
+	if len(b) < n {
+		b = append(b, make([]byte, n-len(b))...)
+	}
+	item.value = b
+	return b
+}
+
+func parseBitOffset(s string) (uint64, bool) {
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || n > maxBitOffset {
+		return 0, false
+	}
+	return n, true
+}
+
+func errBitOffset() Value { return errVal("bit offset is not an integer or out of range") }
+
+// lookupBitmap returns the string item at key for a bit write, creating an empty one.
+// Requires the write lock.
+func lookupBitmap(c *Client, key string) (*RedisItem, Value, bool) {
+	item, ok := c.store.lookup(key)
+	if ok {
+		if item.itemType != REDIS_STRING {
+			return nil, errWrongType(), false
+		}
+		return item, Value{}, true
+	}
+	item = newItem(REDIS_STRING, []byte{})
+	c.store.set(key, item)
+	c.srv.notify(notifyNew, "new", key)
+	return item, Value{}, true
+}
+
+// SETBIT key offset value
+func SETBIT(c *Client, args []Value) Value {
+	key := args[0].Bulk
+	offset, ok := parseBitOffset(args[1].Bulk)
+	if !ok {
+		return errBitOffset()
+	}
+	if args[2].Bulk != "0" && args[2].Bulk != "1" {
+		return errVal("bit is not an integer or out of range")
+	}
+
+	defer c.store.lock(key)()
+
+	item, errReply, ok := lookupBitmap(c, key)
+	if !ok {
+		return errReply
+	}
+	b := item.mutableBytes(int(offset/8) + 1)
+
+	mask := byte(1) << (7 - offset%8)
+	old := 0
+	if b[offset/8]&mask != 0 {
+		old = 1
+	}
+	if args[2].Bulk == "1" {
+		b[offset/8] |= mask
+	} else {
+		b[offset/8] &^= mask
+	}
+
+	c.signalModifiedKey(key)
+	c.srv.notify(notifyString, "setbit", key)
+	return intVal(old)
+}
+
+// GETBIT key offset
+func GETBIT(c *Client, args []Value) Value {
+	key := args[0].Bulk
+	offset, ok := parseBitOffset(args[1].Bulk)
+	if !ok {
+		return errBitOffset()
+	}
+
+	defer c.store.rlock(key)()
+
+	item, ok := c.store.lookupRead(key)
+	if !ok {
+		return intVal(0)
+	}
+	if item.itemType != REDIS_STRING {
+		return errWrongType()
+	}
+	b := item.bytesView()
+	if offset/8 >= uint64(len(b)) {
+		return intVal(0)
+	}
+	return intVal(int(b[offset/8]>>(7-offset%8)) & 1)
+}
+
+// parseBitRange parses the optional start end [BYTE|BIT] arguments of BITCOUNT and BITPOS
+// into an inclusive range of bits within a value of n bytes. ok is false for an empty range.
+func parseBitRange(args []Value, n int, endGiven bool) (start, end int64, ok bool, errReply Value) {
+	var s, e int64
+	var err error
+	if s, err = strconv.ParseInt(args[0].Bulk, 10, 64); err != nil {
+		return 0, 0, false, errVal("value is not an integer or out of range")
+	}
+	isBit := false
+	if endGiven {
+		if e, err = strconv.ParseInt(args[1].Bulk, 10, 64); err != nil {
+			return 0, 0, false, errVal("value is not an integer or out of range")
+		}
+		if len(args) == 3 {
+			switch strings.ToUpper(args[2].Bulk) {
+			case "BIT":
+				isBit = true
+			case "BYTE":
+			default:
+				return 0, 0, false, syntaxErr()
+			}
+		}
+	}
+
+	total := int64(n)
+	if isBit {
+		total *= 8
+	}
+	if !endGiven {
+		e = total - 1
+	}
+	if s < 0 {
+		s += total
+	}
+	if e < 0 {
+		e += total
+	}
+	s = max(s, 0)
+	e = min(max(e, 0), total-1)
+	if s > e || total == 0 {
+		return 0, 0, false, Value{}
+	}
+	if !isBit {
+		s, e = s*8, e*8+7
+	}
+	return s, e, true, Value{}
+}
+
+// BITCOUNT key [start end [BYTE|BIT]]
+func BITCOUNT(c *Client, args []Value) Value {
+	if len(args) != 1 && len(args) != 3 && len(args) != 4 {
+		return syntaxErr()
+	}
+	key := args[0].Bulk
+	defer c.store.rlock(key)()
+
+	item, found := c.store.lookupRead(key)
+	if found && item.itemType != REDIS_STRING {
+		return errWrongType()
+	}
+	var b []byte
+	if found {
+		b = item.bytesView()
+	}
+
+	start, end := int64(0), int64(len(b))*8-1
+	if len(args) > 1 {
+		var ok bool
+		var errReply Value
+		if start, end, ok, errReply = parseBitRange(args[1:], len(b), true); !ok {
+			if errReply.Type != "" {
+				return errReply
+			}
+			return intVal(0)
+		}
+	}
+	if len(b) == 0 {
+		return intVal(0)
+	}
+	return intVal(countBits(b, start, end))
+}
+
+// countBits counts the set bits from bit start to bit end inclusive
+func countBits(b []byte, start, end int64) int {
+	first, last := start/8, end/8
+	if first == last {
+		return bits.OnesCount8(b[first] & (0xff >> (start % 8)) & (0xff << (7 - end%8)))
+	}
+	n := bits.OnesCount8(b[first] & (0xff >> (start % 8)))
+	n += bits.OnesCount8(b[last] & (0xff << (7 - end%8)))
+
+	mid := b[first+1 : last]
+	for len(mid) >= 8 {
+		n += bits.OnesCount64(uint64(mid[0]) | uint64(mid[1])<<8 | uint64(mid[2])<<16 | uint64(mid[3])<<24 |
+			uint64(mid[4])<<32 | uint64(mid[5])<<40 | uint64(mid[6])<<48 | uint64(mid[7])<<56)
+		mid = mid[8:]
+	}
+	for _, x := range mid {
+		n += bits.OnesCount8(x)
+	}
+	return n
+}
+
+// BITPOS key bit [start [end [BYTE|BIT]]]
+func BITPOS(c *Client, args []Value) Value {
+	if len(args) > 5 {
+		return syntaxErr()
+	}
+	key := args[0].Bulk
+	var bit byte
+	switch args[1].Bulk {
+	case "0":
+	case "1":
+		bit = 1
+	default:
+		return errVal("The bit argument must be 1 or 0.")
+	}
+
+	defer c.store.rlock(key)()
+
+	item, found := c.store.lookupRead(key)
+	if !found {
+		// a missing key is an empty string, which is all zeros
+		if bit == 1 {
+			return intVal(-1)
+		}
+		return intVal(0)
+	}
+	if item.itemType != REDIS_STRING {
+		return errWrongType()
+	}
+	b := item.bytesView()
+
+	start, end := int64(0), int64(len(b))*8-1
+	endGiven := len(args) > 3
+	if len(args) > 2 {
+		var ok bool
+		var errReply Value
+		if start, end, ok, errReply = parseBitRange(args[2:], len(b), endGiven); !ok {
+			if errReply.Type != "" {
+				return errReply
+			}
+			return intVal(-1)
+		}
+	}
+	if len(b) == 0 {
+		return intVal(-1)
+	}
+
+	for i := start; i <= end; {
+		// skip whole bytes that can't contain the bit
+		if i%8 == 0 && i+7 <= end && (bit == 1 && b[i/8] == 0 || bit == 0 && b[i/8] == 0xff) {
+			i += 8
+			continue
+		}
+		if (b[i/8]>>(7-i%8))&1 == bit {
+			return intVal(int(i))
+		}
+		i++
+	}
+
+	// looking for a clear bit without an explicit end, the string is as if padded with zeros
+	if bit == 0 && !endGiven {
+		return intVal(int(end + 1))
+	}
+	return intVal(-1)
+}
+
+// BITOP AND|OR|XOR|NOT destkey key [key ...]
+func BITOP(c *Client, args []Value) Value {
+	op := strings.ToUpper(args[0].Bulk)
+	switch op {
+	case "AND", "OR", "XOR":
+	case "NOT":
+		if len(args) != 3 {
+			return errVal("BITOP NOT must be called with a single source key.")
+		}
+	default:
+		return syntaxErr()
+	}
+
+	dest := args[1].Bulk
+	keys := []string{dest}
+	for _, arg := range args[2:] {
+		keys = append(keys, arg.Bulk)
+	}
+	defer c.store.lock(keys...)()
+
+	srcs := make([][]byte, len(keys)-1)
+	n := 0
+	for i, key := range keys[1:] {
+		item, ok := c.store.lookup(key)
+		if !ok {
+			continue
+		}
+		if item.itemType != REDIS_STRING {
+			return errWrongType()
+		}
+		srcs[i] = item.bytesView()
+		n = max(n, len(srcs[i]))
+	}
+
+	res := make([]byte, n)
+	if op == "NOT" {
+		for i, x := range srcs[0] {
+			res[i] = ^x
+		}
+	} else {
+		copy(res, srcs[0])
+		for _, src := range srcs[1:] {
+			for i := range res {
+				var x byte
+				if i < len(src) {
+					x = src[i]
+				}
+				switch op {
+				case "AND":
+					res[i] &= x
+				case "OR":
+					res[i] |= x
+				case "XOR":
+					res[i] ^= x
+				}
+			}
+		}
+	}
+
+	_, exists := c.store.getLive(dest)
+	if n == 0 {
+		if exists {
+			c.store.del(dest)
+			c.signalModifiedKey(dest)
+			c.srv.notify(notifyGeneric, "del", dest)
+		}
+		return intVal(0)
+	}
+
+	c.store.set(dest, newItem(REDIS_STRING, res))
+	if !exists {
+		c.srv.notify(notifyNew, "new", dest)
+	}
+	c.signalModifiedKey(dest)
+	c.srv.notify(notifyString, "set", dest)
+	return intVal(n)
+}
+
+const (
+	overflowWrap = iota
+	overflowSat
+	overflowFail
+)
+
+type bitfieldOp struct {
+	op       string // GET, SET or INCRBY
+	signed   bool
+	width    uint
+	offset   uint64
+	value    int64
+	overflow int
+}
+
+// BITFIELD key [GET type offset] [SET type offset value] [INCRBY type offset increment] [OVERFLOW WRAP|SAT|FAIL]
+func BITFIELD(c *Client, args []Value) Value {
+	return bitfield(c, args, false)
+}
+
+// BITFIELD_RO key [GET type offset ...]
+func BITFIELD_RO(c *Client, args []Value) Value {
+	return bitfield(c, args, true)
+}
+
+func bitfield(c *Client, args []Value, readonly bool) Value {
+	key := args[0].Bulk
+
+	var ops []bitfieldOp
+	overflow := overflowWrap
+	write := false
+	var highest uint64
+	for i := 1; i < len(args); i++ {
+		sub := strings.ToUpper(args[i].Bulk)
+		if sub == "OVERFLOW" {
+			if i+1 >= len(args) {
+				return syntaxErr()
+			}
+			switch strings.ToUpper(args[i+1].Bulk) {
+			case "WRAP":
+				overflow = overflowWrap
+			case "SAT":
+				overflow = overflowSat
+			case "FAIL":
+				overflow = overflowFail
+			default:
+				return errVal("Invalid OVERFLOW type specified")
+			}
+			i++
+			continue
+		}
+
+		nargs := 2
+		switch sub {
+		case "GET":
+		case "SET", "INCRBY":
+			if readonly {
+				return errVal("BITFIELD_RO only supports the GET subcommand")
+			}
+			nargs = 3
+			write = true
+		default:
+			return syntaxErr()
+		}
+		if i+nargs >= len(args) {
+			return syntaxErr()
+		}
+
+		op := bitfieldOp{op: sub, overflow: overflow}
+		t := args[i+1].Bulk
+		if len(t) < 2 || (t[0] != 'i' && t[0] != 'u') {
+			return errVal("Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+		}
+		width, err := strconv.Atoi(t[1:])
+		op.signed = t[0] == 'i'
+		if err != nil || width < 1 || op.signed && width > 64 || !op.signed && width > 63 {
+			return errVal("Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+		}
+		op.width = uint(width)
+
+		off := args[i+2].Bulk
+		mul := uint64(1)
+		if strings.HasPrefix(off, "#") {
+			off, mul = off[1:], uint64(width)
+		}
+		n, ok := parseBitOffset(off)
+		if !ok || n*mul+uint64(width)-1 > maxBitOffset {
+			return errBitOffset()
+		}
+		op.offset = n * mul
+
+		if nargs == 3 {
+			if op.value, err = strconv.ParseInt(args[i+3].Bulk, 10, 64); err != nil {
+				return errVal("value is not an integer or out of range")
+			}
+			highest = max(highest, op.offset+uint64(op.width))
+		}
+		ops = append(ops, op)
+		i += nargs
+	}
+
+	res := Value{Type: "array", Array: make([]Value, 0, len(ops))}
+
+	if !write {
+		defer c.store.rlock(key)()
+		item, ok := c.store.lookupRead(key)
+		if ok && item.itemType != REDIS_STRING {
+			return errWrongType()
+		}
+		var b []byte
+		if ok {
+			b = item.bytesView()
+		}
+		for _, op := range ops {
+			res.Array = append(res.Array, intVal(int(op.get(b))))
+		}
+		return res
+	}
+
+	defer c.store.lock(key)()
+	item, errReply, ok := lookupBitmap(c, key)
+	if !ok {
+		return errReply
+	}
+	b := item.mutableBytes(int((highest + 7) / 8))
+
+	changed := false
+	for _, op := range ops {
+		old := op.get(b)
+		if op.op == "GET" {
+			res.Array = append(res.Array, intVal(int(old)))
+			continue
+		}
+
+		var v int64
+		var overflowed bool
+		if op.op == "SET" {
+			if op.signed {
+				v, overflowed = op.limit(0, op.value)
+			} else {
+				v, overflowed = op.limitUnsigned(uint64(op.value), 0)
+			}
+		} else if op.signed {
+			v, overflowed = op.limit(old, op.value)
+		} else {
+			v, overflowed = op.limitUnsigned(uint64(old), op.value)
+		}
+
+		if overflowed && op.overflow == overflowFail {
+			res.Array = append(res.Array, nullVal())
+			continue
+		}
+		setBits(b, op.offset, op.width, uint64(v))
+		changed = true
+		if op.op == "SET" {
+			res.Array = append(res.Array, intVal(int(old)))
+		} else {
+			res.Array = append(res.Array, intVal(int(v)))
+		}
+	}
+
+	if changed {
+		c.signalModifiedKey(key)
+		c.srv.notify(notifyString, "setbit", key)
+	}
+	return res
+}
+
+// get reads the field from b, bits past the end of b are zero
+func (op *bitfieldOp) get(b []byte) int64 {
+	var v uint64
+	for i := uint64(0); i < uint64(op.width); i++ {
+		pos := op.offset + i
+		v <<= 1
+		if pos/8 < uint64(len(b)) {
+			v |= uint64(b[pos/8]>>(7-pos%8)) & 1
+		}
+	}
+	if op.signed && op.width < 64 && v&(1<<(op.width-1)) != 0 {
+		v |= ^uint64(0) << op.width
+	}
+	return int64(v)
+}
+
+func setBits(b []byte, offset uint64, width uint, v uint64) {
+	for i := uint64(0); i < uint64(width); i++ {
+		pos := offset + i
+		mask := byte(1) << (7 - pos%8)
+		if v>>(uint64(width)-1-i)&1 != 0 {
+			b[pos/8] |= mask
+		} else {
+			b[pos/8] &^= mask
+		}
+	}
+}
+
+// limit adds incr to the signed field value v and applies the overflow mode
+func (op *bitfieldOp) limit(v, incr int64) (int64, bool) {
+	maxVal := int64(1)<<(op.width-1) - 1
+	minVal := -maxVal - 1
+	switch {
+	case incr > 0 && v > maxVal-incr:
+		if op.overflow == overflowSat {
+			return maxVal, true
+		}
+	case incr < 0 && v < minVal-incr:
+		if op.overflow == overflowSat {
+			return minVal, true
+		}
+	default:
+		return v + incr, false
+	}
+
+	// wrap around, keep the low bits and sign extend them
+	res := uint64(v + incr)
+	if op.width < 64 {
+		res &= 1<<op.width - 1
+		if res&(1<<(op.width-1)) != 0 {
+			res |= ^uint64(0) << op.width
+		}
+	}
+	return int64(res), true
+}
+
+// limitUnsigned adds incr to the unsigned field value v and applies the overflow mode
+func (op *bitfieldOp) limitUnsigned(v uint64, incr int64) (int64, bool) {
+	maxVal := uint64(1)<<op.width - 1
+	switch {
+	case v > maxVal || incr > 0 && uint64(incr) > maxVal-v:
+		if op.overflow == overflowSat {
+			return int64(maxVal), true
+		}
+	case incr < 0 && uint64(-incr) > v:
+		if op.overflow == overflowSat {
+			return 0, true
+		}
+	default:
+		return int64(v + uint64(incr)), false
+	}
+	return int64((v + uint64(incr)) & maxVal), true
+}
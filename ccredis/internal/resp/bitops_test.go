@@ -0,0 +1,118 @@
+package redis
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBitops_SetBitAndCount(t *testing.T) {
+	n := startTestServer(t, Config{})
+
+	// daily active users, one bit per user id
+	for _, id := range []int{0, 5, 100, 1000} {
+		require.Equal(t, 0, n.do(t, "SETBIT", "dau", fmt.Sprint(id), "1").Int)
+	}
+	require.Equal(t, 1, n.do(t, "SETBIT", "dau", "5", "1").Int)
+	require.Equal(t, 1, n.do(t, "GETBIT", "dau", "100").Int)
+	require.Equal(t, 0, n.do(t, "GETBIT", "dau", "101").Int)
+	require.Equal(t, 0, n.do(t, "GETBIT", "dau", "1000000").Int)
+	require.Equal(t, 4, n.do(t, "BITCOUNT", "dau").Int)
+	require.Equal(t, 126, n.do(t, "STRLEN", "dau").Int)
+	require.Equal(t, "raw", n.do(t, "OBJECT", "ENCODING", "dau").Bulk)
+	require.Equal(t, "ERR bit offset is not an integer or out of range", n.do(t, "SETBIT", "dau", "4294967296", "1").String)
+	require.Equal(t, "ERR bit is not an integer or out of range", n.do(t, "SETBIT", "dau", "1", "2").String)
+
+	n.do(t, "SET", "foo", "foobar")
+	require.Equal(t, 26, n.do(t, "BITCOUNT", "foo").Int)
+	require.Equal(t, 4, n.do(t, "BITCOUNT", "foo", "0", "0").Int)
+	require.Equal(t, 6, n.do(t, "BITCOUNT", "foo", "1", "1", "BYTE").Int)
+	require.Equal(t, 17, n.do(t, "BITCOUNT", "foo", "5", "30", "BIT").Int)
+	require.Equal(t, 0, n.do(t, "BITCOUNT", "foo", "4", "2").Int)
+	require.Equal(t, 0, n.do(t, "BITCOUNT", "missing").Int)
+
+	// writes modify the value in place and reads see it
+	require.Equal(t, 0, n.do(t, "SETBIT", "foo", "7", "1").Int)
+	require.Equal(t, "goobar", n.do(t, "GET", "foo").Bulk)
+	require.Equal(t, 8, n.do(t, "APPEND", "foo", "!!").Int)
+	require.Equal(t, "goobar!!", n.do(t, "GET", "foo").Bulk)
+
+	n.do(t, "HSET", "h", "f", "v")
+	require.Equal(t, "WRONGTYPE Operation against a key holding the wrong kind of value", n.do(t, "SETBIT", "h", "1", "1").String)
+}
+
+func TestBitops_BitPos(t *testing.T) {
+	n := startTestServer(t, Config{})
+
+	n.do(t, "SET", "k", "\xff\xf0\x00")
+	require.Equal(t, 12, n.do(t, "BITPOS", "k", "0").Int)
+
+	n.do(t, "SET", "k", "\x00\xff\xf0")
+	require.Equal(t, 8, n.do(t, "BITPOS", "k", "1", "0").Int)
+	require.Equal(t, 16, n.do(t, "BITPOS", "k", "1", "2").Int)
+	require.Equal(t, 16, n.do(t, "BITPOS", "k", "1", "2", "-1", "BYTE").Int)
+	require.Equal(t, 8, n.do(t, "BITPOS", "k", "1", "7", "15", "BIT").Int)
+
+	n.do(t, "SET", "k", "\x00\x00\x00")
+	require.Equal(t, -1, n.do(t, "BITPOS", "k", "1").Int)
+
+	// clear bits past the end only count without an explicit end
+	n.do(t, "SET", "k", "\xff\xff\xff")
+	require.Equal(t, 24, n.do(t, "BITPOS", "k", "0").Int)
+	require.Equal(t, -1, n.do(t, "BITPOS", "k", "0", "0", "-1").Int)
+
+	require.Equal(t, 0, n.do(t, "BITPOS", "missing", "0").Int)
+	require.Equal(t, -1, n.do(t, "BITPOS", "missing", "1").Int)
+	require.Equal(t, "ERR The bit argument must be 1 or 0.", n.do(t, "BITPOS", "k", "2").String)
+}
+
+func TestBitops_BitOp(t *testing.T) {
+	n := startTestServer(t, Config{})
+
+	n.do(t, "SET", "key1", "foobar")
+	n.do(t, "SET", "key2", "abcdef")
+	require.Equal(t, 6, n.do(t, "BITOP", "AND", "dest", "key1", "key2").Int)
+	require.Equal(t, "`bc`ab", n.do(t, "GET", "dest").Bulk)
+
+	n.do(t, "SET", "short", "\x0f")
+	require.Equal(t, 6, n.do(t, "BITOP", "OR", "dest", "short", "key1").Int)
+	require.Equal(t, "ooobar", n.do(t, "GET", "dest").Bulk)
+	require.Equal(t, 6, n.do(t, "BITOP", "XOR", "dest", "key1", "key1", "missing").Int)
+	require.Equal(t, "\x00\x00\x00\x00\x00\x00", n.do(t, "GET", "dest").Bulk)
+	require.Equal(t, 1, n.do(t, "BITOP", "NOT", "dest", "short").Int)
+	require.Equal(t, "\xf0", n.do(t, "GET", "dest").Bulk)
+
+	require.Equal(t, 0, n.do(t, "BITOP", "AND", "dest", "missing").Int)
+	require.Equal(t, 0, n.do(t, "EXISTS", "dest").Int)
+	require.Equal(t, "ERR BITOP NOT must be called with a single source key.", n.do(t, "BITOP", "NOT", "dest", "key1", "key2").String)
+}
+
+func TestBitops_BitField(t *testing.T) {
+	n := startTestServer(t, Config{})
+
+	res := n.do(t, "BITFIELD", "k", "INCRBY", "i5", "100", "1", "GET", "u4", "0")
+	require.Equal(t, []Value{intVal(1), intVal(0)}, res.Array)
+
+	// u2 wraps from 3 to 0 while the saturating one sticks at 3
+	for _, want := range [][2]int{{1, 1}, {2, 2}, {3, 3}, {0, 3}} {
+		res := n.do(t, "BITFIELD", "k", "INCRBY", "u2", "100", "1", "OVERFLOW", "SAT", "INCRBY", "u2", "102", "1")
+		require.Equal(t, []Value{intVal(want[0]), intVal(want[1])}, res.Array)
+	}
+	res = n.do(t, "BITFIELD", "k", "OVERFLOW", "FAIL", "INCRBY", "u2", "102", "1")
+	require.Equal(t, []Value{nullVal()}, res.Array)
+
+	res = n.do(t, "BITFIELD", "s", "SET", "i8", "0", "127", "INCRBY", "i8", "0", "1", "OVERFLOW", "SAT", "INCRBY", "i8", "0", "-1000")
+	require.Equal(t, []Value{intVal(0), intVal(-128), intVal(-128)}, res.Array)
+	res = n.do(t, "BITFIELD", "s", "OVERFLOW", "SAT", "SET", "u8", "#1", "-1", "GET", "u8", "8", "SET", "i64", "64", "-1", "GET", "u63", "65")
+	require.Equal(t, []Value{intVal(0), intVal(255), intVal(0), intVal(1<<63 - 1)}, res.Array)
+	require.Equal(t, -1, n.do(t, "BITFIELD_RO", "s", "GET", "i64", "64").Array[0].Int)
+
+	require.Equal(t, []Value{intVal(0)}, n.do(t, "BITFIELD", "missing", "GET", "u8", "0").Array)
+	require.Equal(t, 0, n.do(t, "EXISTS", "missing").Int)
+
+	require.Contains(t, n.do(t, "BITFIELD", "k", "GET", "u64", "0").String, "Invalid bitfield type")
+	require.Equal(t, "ERR Invalid OVERFLOW type specified", n.do(t, "BITFIELD", "k", "OVERFLOW", "NOPE").String)
+	require.Equal(t, "ERR BITFIELD_RO only supports the GET subcommand", n.do(t, "BITFIELD_RO", "k", "SET", "u8", "0", "1").String)
+	require.Equal(t, "ERR bit offset is not an integer or out of range", n.do(t, "BITFIELD", "k", "GET", "u8", "-1").String)
+}
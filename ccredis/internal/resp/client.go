@@ -0,0 +1,337 @@
+package redis
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// replies and pushed messages queued for a client before it is considered too slow and dropped
+const clientOutputBuffer = 1024
+
+// Client holds the state of a single connection
+type Client struct {
+	srv   *Server
+	store *keyspace
+	conn  net.Conn
+	id    int64
+
+	// protocol version selected with HELLO
+	resp atomic.Int32
+
+	createdAt       time.Time
+	lastInteraction atomic.Int64
+	// guarded by srv.clientsMu
+	name string
+
+	// every reply and pushed message goes through out, so replies to the client's
+	// own commands and messages published by other clients never interleave
+	out       chan Value
+	quit      chan struct{}
+	closeOnce sync.Once
+
+	// set by ASKING, allows the next command to run against an importing slot
+	asking bool
+
+	// commands queued between MULTI and EXEC, nil outside of a transaction
+	multi *multiState
+
+	// guarded by srv.pubsub.mu
+	subs  map[string]struct{}
+	psubs map[string]struct{}
+
+	// written by the client itself under srv.tracking.mu
+	tracking *trackingOpts
+	// keys the client is registered under in srv.tracking.keys, guarded by srv.tracking.mu
+	trackedKeys map[string]struct{}
+	// CLIENT CACHING yes/no, applies to the command following it
+	caching      int
+	cachingArmed bool
+
+	// set for the clients running the commands of the primary or of the AOF, their replies
+	// are discarded and they may write to a replica
+	replicated bool
+	// args of the running command as they are propagated, see propagate
+	propagating []Value
+	propagated  bool
+	// replication offset after the last write of the client, what WAIT waits for
+	woff int64
+	// set while EXEC runs the queued commands, and once it propagated MULTI
+	inExec         bool
+	execPropagated bool
+
+	// keys of the shard the client's SCAN is in, see scanShard
+	scan *scanSnapshot
+
+	// the port sent with REPLCONF listening-port, and the replica state after PSYNC,
+	// guarded by srv.clientsMu
+	listeningPort int
+	replica       *replica
+}
+
+func (s *Server) newClient(conn net.Conn) *Client {
+	c := &Client{
+		srv:       s,
+		store:     s.store,
+		conn:      conn,
+		id:        s.nextClientID.Add(1),
+		createdAt: time.Now(),
+		out:       make(chan Value, clientOutputBuffer),
+		quit:      make(chan struct{}),
+		subs:      make(map[string]struct{}),
+		psubs:     make(map[string]struct{}),
+	}
+	c.resp.Store(2)
+	c.lastInteraction.Store(c.createdAt.UnixNano())
+	return c
+}
+
+// registerClient adds c to the clients, it reports false once maxclients are connected
+func (s *Server) registerClient(c *Client) bool {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+	if int64(len(s.clients)) >= s.maxClients.Load() {
+		return false
+	}
+	s.clients[c.id] = c
+	return true
+}
+
+func (s *Server) unregisterClient(c *Client) {
+	s.clientsMu.Lock()
+	delete(s.clients, c.id)
+	s.clientsMu.Unlock()
+}
+
+func (s *Server) clientByID(id int64) (*Client, bool) {
+	s.clientsMu.RLock()
+	defer s.clientsMu.RUnlock()
+	c, ok := s.clients[id]
+	return c, ok
+}
+
+// mapVal returns a map reply for RESP3 clients and a flat array for RESP2 clients
+func (c *Client) mapVal(kv []Value) Value {
+	if c.resp.Load() == 3 {
+		return Value{Type: "map", Array: kv}
+	}
+	return Value{Type: "array", Array: kv}
+}
+
+func (c *Client) addr() string {
+	if c.conn == nil {
+		return ""
+	}
+	if c.unixSocket() {
+		// like Redis show the socket path, the peer of a Unix socket has no address
+		return c.conn.LocalAddr().String() + ":0"
+	}
+	return c.conn.RemoteAddr().String()
+}
+
+func (c *Client) unixSocket() bool {
+	_, ok := c.conn.(*net.UnixConn)
+	return ok
+}
+
+// info formats c the way CLIENT LIST and CLIENT INFO show clients. Requires srv.clientsMu.
+func (c *Client) info() string {
+	var laddr string
+	flags := ""
+	if c.conn != nil {
+		laddr = c.conn.LocalAddr().String()
+		if c.unixSocket() {
+			laddr += ":0"
+			flags += "U"
+		}
+	}
+
+	if c.subscribed() {
+		flags += "P"
+	}
+	if c.tracking != nil {
+		flags += "t"
+	}
+	if c.replica != nil {
+		flags += "S"
+	}
+	if flags == "" {
+		flags = "N"
+	}
+
+	c.srv.pubsub.mu.RLock()
+	sub, psub := len(c.subs), len(c.psubs)
+	c.srv.pubsub.mu.RUnlock()
+
+	idle := time.Since(time.Unix(0, c.lastInteraction.Load()))
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s age=%d idle=%d flags=%s db=0 sub=%d psub=%d resp=%d",
+		c.id, c.addr(), laddr, c.name, int(time.Since(c.createdAt).Seconds()), int(idle.Seconds()), flags, sub, psub, c.resp.Load())
+}
+
+// writeLoop writes queued values to the connection, flushing whenever the queue is drained.
+// Once the client is closed it flushes what is left and closes the connection.
+func (c *Client) writeLoop() {
+	bw := bufio.NewWriter(c.conn)
+	w := NewWriter(bw)
+
+	for {
+		select {
+		case v := <-c.out:
+			if _, err := w.Write(v); err != nil {
+				c.conn.Close()
+				return
+			}
+			if len(c.out) == 0 {
+				if err := bw.Flush(); err != nil {
+					c.conn.Close()
+					return
+				}
+			}
+		case <-c.quit:
+			for len(c.out) > 0 {
+				w.Write(<-c.out)
+			}
+			bw.Flush()
+			c.conn.Close()
+			return
+		}
+	}
+}
+
+// write queues the reply to a command of this client
+func (c *Client) write(v Value) {
+	if v.Type == "" || c.replicated {
+		return
+	}
+	select {
+	case c.out <- v:
+	case <-c.quit:
+	}
+}
+
+// push queues a message from another client, e.g. a published message. It never blocks,
+// a client that doesn't keep up with its messages is disconnected.
+func (c *Client) push(v Value) {
+	select {
+	case c.out <- v:
+	case <-c.quit:
+	default:
+		c.close()
+		c.conn.Close()
+	}
+}
+
+func (c *Client) close() {
+	c.closeOnce.Do(func() {
+		close(c.quit)
+	})
+}
+
+func CLIENT(c *Client, args []Value) Value {
+	sub := strings.ToUpper(args[0].Bulk)
+	args = args[1:]
+
+	switch sub {
+	case "ID":
+		return intVal(int(c.id))
+	case "SETNAME":
+		if len(args) != 1 {
+			return errWrongArgs("client|setname")
+		}
+		if strings.ContainsAny(args[0].Bulk, " \n") {
+			return errVal("Client names cannot contain spaces, newlines or special characters.")
+		}
+		c.srv.clientsMu.Lock()
+		c.name = args[0].Bulk
+		c.srv.clientsMu.Unlock()
+		return ok()
+	case "GETNAME":
+		c.srv.clientsMu.RLock()
+		defer c.srv.clientsMu.RUnlock()
+		if c.name == "" {
+			return nullVal()
+		}
+		return bulkVal(c.name)
+	case "INFO":
+		c.srv.clientsMu.RLock()
+		defer c.srv.clientsMu.RUnlock()
+		return bulkVal(c.info() + "\n")
+	case "LIST":
+		c.srv.clientsMu.RLock()
+		defer c.srv.clientsMu.RUnlock()
+		clients := make([]*Client, 0, len(c.srv.clients))
+		for _, cl := range c.srv.clients {
+			clients = append(clients, cl)
+		}
+		slices.SortFunc(clients, func(a, b *Client) int { return int(a.id - b.id) })
+		var b strings.Builder
+		for _, cl := range clients {
+			b.WriteString(cl.info())
+			b.WriteByte('\n')
+		}
+		return bulkVal(b.String())
+	case "TRACKING":
+		return clientTracking(c, args)
+	case "CACHING":
+		return clientCaching(c, args)
+	case "GETREDIR":
+		if c.tracking == nil {
+			return intVal(-1)
+		}
+		return intVal(int(c.tracking.redirect))
+	case "TRACKINGINFO":
+		return clientTrackingInfo(c)
+	default:
+		return errVal(fmt.Sprintf("unknown subcommand '%s'. Try CLIENT HELP.", strings.ToLower(sub)))
+	}
+}
+
+// HELLO [protover [SETNAME clientname]]
+func HELLO(c *Client, args []Value) Value {
+	if len(args) > 0 {
+		ver, err := strconv.Atoi(args[0].Bulk)
+		if err != nil {
+			return errVal("Protocol version is not an integer or out of range")
+		}
+		if ver != 2 && ver != 3 {
+			return errCode("NOPROTO", "unsupported protocol version")
+		}
+
+		for i := 1; i < len(args); i++ {
+			if strings.ToUpper(args[i].Bulk) == "SETNAME" && i+1 < len(args) {
+				c.srv.clientsMu.Lock()
+				c.name = args[i+1].Bulk
+				c.srv.clientsMu.Unlock()
+				i++
+				continue
+			}
+			return errVal(fmt.Sprintf("Syntax error in HELLO option '%s'", args[i].Bulk))
+		}
+		c.resp.Store(int32(ver))
+	}
+
+	mode := "standalone"
+	if c.srv.cluster != nil {
+		mode = "cluster"
+	}
+	role := "master"
+	if c.srv.repl.link.Load() != nil {
+		role = "replica"
+	}
+
+	return c.mapVal([]Value{
+		bulkVal("server"), bulkVal("redis"),
+		bulkVal("version"), bulkVal("7.2.0"),
+		bulkVal("proto"), intVal(int(c.resp.Load())),
+		bulkVal("id"), intVal(int(c.id)),
+		bulkVal("mode"), bulkVal(mode),
+		bulkVal("role"), bulkVal(role),
+		bulkVal("modules"), {Type: "array", Array: []Value{}},
+	})
+}
@@ -0,0 +1,675 @@
+package redis
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type clusterNode struct {
+	id      string
+	ip      string
+	port    int
+	busPort int
+	myself  bool
+
+	configEpoch uint64
+	pingSent    time.Time
+	pongRecv    time.Time
+	linked      bool
+}
+
+func (n *clusterNode) addr() string {
+	return net.JoinHostPort(n.ip, strconv.Itoa(n.port))
+}
+
+func (n *clusterNode) busAddr() string {
+	return net.JoinHostPort(n.ip, strconv.Itoa(n.busPort))
+}
+
+// failing reports whether the node stopped answering pings (PFAIL in Redis terms)
+func (n *clusterNode) failing() bool {
+	return !n.myself && !n.pingSent.IsZero() && time.Since(n.pingSent) > clusterNodeTimeout && n.pongRecv.Before(n.pingSent)
+}
+
+type clusterState struct {
+	srv *Server
+
+	mu           sync.RWMutex
+	myself       *clusterNode
+	nodes        map[string]*clusterNode
+	currentEpoch uint64
+	slots        [clusterSlots]*clusterNode
+	migrating    [clusterSlots]*clusterNode
+	importing    [clusterSlots]*clusterNode
+
+	ln   net.Listener
+	done chan struct{}
+}
+
+func newClusterState(srv *Server) *clusterState {
+	myself := &clusterNode{
+		id:      newNodeID(),
+		ip:      srv.cfg.Bind,
+		port:    srv.cfg.Port,
+		busPort: srv.cfg.ClusterPort,
+		myself:  true,
+	}
+	return &clusterState{
+		srv:    srv,
+		myself: myself,
+		nodes:  map[string]*clusterNode{myself.id: myself},
+		done:   make(chan struct{}),
+	}
+}
+
+func newNodeID() string {
+	b := make([]byte, 20)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// route decides whether the command can be served by this node. When it can't,
+// the returned value is the MOVED/ASK/CROSSSLOT/CLUSTERDOWN error for the client.
+func (cs *clusterState) route(c *Client, cmd *command, args []Value) (Value, bool) {
+	keys := cmd.keys(args)
+	if len(keys) == 0 {
+		return Value{}, true
+	}
+
+	slot := keyHashSlot(keys[0])
+	for _, key := range keys[1:] {
+		if keyHashSlot(key) != slot {
+			return errCode("CROSSSLOT", "Keys in request don't hash to the same slot"), false
+		}
+	}
+
+	cs.mu.RLock()
+	owner := cs.slots[slot]
+	importing := cs.importing[slot]
+	var ownerAddr, migratingAddr string
+	if owner != nil {
+		ownerAddr = owner.addr()
+	}
+	if migrating := cs.migrating[slot]; migrating != nil {
+		migratingAddr = migrating.addr()
+	}
+	cs.mu.RUnlock()
+
+	if owner == nil {
+		return errCode("CLUSTERDOWN", "Hash slot not served"), false
+	}
+
+	if owner == cs.myself {
+		if migratingAddr == "" {
+			return Value{}, true
+		}
+		// keys that were already moved to the target are served there
+		missing := 0
+		unlock := c.store.rlock(keys...)
+		for _, key := range keys {
+			if item, ok := c.store.get(key); !ok || isExpired(item.ttl) {
+				missing++
+			}
+		}
+		unlock()
+
+		switch {
+		case missing == 0:
+			return Value{}, true
+		case missing == len(keys):
+			return errCode("ASK", fmt.Sprintf("%d %s", slot, migratingAddr)), false
+		default:
+			return errCode("TRYAGAIN", "Multiple keys request during rehashing of slot"), false
+		}
+	}
+
+	if importing != nil && c.asking {
+		return Value{}, true
+	}
+
+	return errCode("MOVED", fmt.Sprintf("%d %s", slot, ownerAddr)), false
+}
+
+func (cs *clusterState) nodeByID(id string) (*clusterNode, bool) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	n, ok := cs.nodes[id]
+	return n, ok
+}
+
+// slotRanges groups the slots owned by n into contiguous [start, end] ranges. Requires cs.mu.
+func (cs *clusterState) slotRanges(n *clusterNode) [][2]int {
+	var ranges [][2]int
+	for slot := 0; slot < clusterSlots; slot++ {
+		if cs.slots[slot] != n {
+			continue
+		}
+		if l := len(ranges); l > 0 && ranges[l-1][1] == slot-1 {
+			ranges[l-1][1] = slot
+		} else {
+			ranges = append(ranges, [2]int{slot, slot})
+		}
+	}
+	return ranges
+}
+
+// sortedNodes returns the known nodes ordered by id. Requires cs.mu.
+func (cs *clusterState) sortedNodes() []*clusterNode {
+	nodes := make([]*clusterNode, 0, len(cs.nodes))
+	for _, n := range cs.nodes {
+		nodes = append(nodes, n)
+	}
+	slices.SortFunc(nodes, func(a, b *clusterNode) int { return strings.Compare(a.id, b.id) })
+	return nodes
+}
+
+func (cs *clusterState) countKeysInSlot(slot int) int {
+	n := 0
+	defer cs.srv.store.rlockAll()()
+	cs.srv.store.each(func(key string, item *RedisItem) {
+		if !isExpired(item.ttl) && keyHashSlot(key) == slot {
+			n++
+		}
+	})
+	return n
+}
+
+func ASKING(c *Client, args []Value) Value {
+	if c.srv.cluster == nil {
+		return errVal("This instance has cluster support disabled")
+	}
+	c.asking = true
+	return ok()
+}
+
+func CLUSTER(c *Client, args []Value) Value {
+	cs := c.srv.cluster
+	if cs == nil {
+		return errVal("This instance has cluster support disabled")
+	}
+
+	sub := strings.ToUpper(args[0].Bulk)
+	args = args[1:]
+
+	switch sub {
+	case "INFO":
+		return cs.info()
+	case "MYID":
+		return bulkVal(cs.myself.id)
+	case "NODES":
+		return cs.nodesInfo()
+	case "SLOTS":
+		return cs.slotsInfo()
+	case "SHARDS":
+		return cs.shardsInfo()
+	case "KEYSLOT":
+		if len(args) != 1 {
+			return errWrongArgs("cluster|keyslot")
+		}
+		return intVal(keyHashSlot(args[0].Bulk))
+	case "COUNTKEYSINSLOT":
+		if len(args) != 1 {
+			return errWrongArgs("cluster|countkeysinslot")
+		}
+		slot, err := parseSlot(args[0].Bulk)
+		if err != nil {
+			return errVal(err.Error())
+		}
+		return intVal(cs.countKeysInSlot(slot))
+	case "GETKEYSINSLOT":
+		if len(args) != 2 {
+			return errWrongArgs("cluster|getkeysinslot")
+		}
+		return cs.getKeysInSlot(args[0].Bulk, args[1].Bulk)
+	case "ADDSLOTS", "DELSLOTS":
+		if len(args) == 0 {
+			return errWrongArgs("cluster|" + strings.ToLower(sub))
+		}
+		slots := make([]int, len(args))
+		for i, arg := range args {
+			slot, err := parseSlot(arg.Bulk)
+			if err != nil {
+				return errVal(err.Error())
+			}
+			slots[i] = slot
+		}
+		return cs.assignSlots(slots, sub == "ADDSLOTS")
+	case "ADDSLOTSRANGE", "DELSLOTSRANGE":
+		if len(args) == 0 || len(args)%2 != 0 {
+			return errWrongArgs("cluster|" + strings.ToLower(sub))
+		}
+		var slots []int
+		for i := 0; i < len(args); i += 2 {
+			start, err := parseSlot(args[i].Bulk)
+			if err != nil {
+				return errVal(err.Error())
+			}
+			end, err := parseSlot(args[i+1].Bulk)
+			if err != nil {
+				return errVal(err.Error())
+			}
+			if start > end {
+				return errVal(fmt.Sprintf("start slot number %d is greater than end slot number %d", start, end))
+			}
+			for slot := start; slot <= end; slot++ {
+				slots = append(slots, slot)
+			}
+		}
+		return cs.assignSlots(slots, sub == "ADDSLOTSRANGE")
+	case "MEET":
+		if len(args) != 2 && len(args) != 3 {
+			return errWrongArgs("cluster|meet")
+		}
+		port, err := strconv.Atoi(args[1].Bulk)
+		if err != nil {
+			return errVal("Invalid base port specified: " + args[1].Bulk)
+		}
+		busPort := port + 10000
+		if len(args) == 3 {
+			if busPort, err = strconv.Atoi(args[2].Bulk); err != nil {
+				return errVal("Invalid bus port specified: " + args[2].Bulk)
+			}
+		}
+		if net.ParseIP(args[0].Bulk) == nil {
+			return errVal(fmt.Sprintf("Invalid node address specified: %s:%d", args[0].Bulk, port))
+		}
+		go cs.meet(args[0].Bulk, busPort)
+		return ok()
+	case "SETSLOT":
+		if len(args) < 2 {
+			return errWrongArgs("cluster|setslot")
+		}
+		return cs.setSlot(args)
+	default:
+		return errVal(fmt.Sprintf("unknown subcommand '%s'. Try CLUSTER HELP.", strings.ToLower(sub)))
+	}
+}
+
+func parseSlot(s string) (int, error) {
+	slot, err := strconv.Atoi(s)
+	if err != nil || slot < 0 || slot >= clusterSlots {
+		return 0, fmt.Errorf("Invalid or out of range slot")
+	}
+	return slot, nil
+}
+
+func (cs *clusterState) assignSlots(slots []int, add bool) Value {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	seen := make(map[int]bool, len(slots))
+	for _, slot := range slots {
+		if seen[slot] {
+			return errVal(fmt.Sprintf("Slot %d specified multiple times", slot))
+		}
+		seen[slot] = true
+		if add && cs.slots[slot] != nil {
+			return errVal(fmt.Sprintf("Slot %d is already busy", slot))
+		}
+		if !add && cs.slots[slot] == nil {
+			return errVal(fmt.Sprintf("Slot %d is already unassigned", slot))
+		}
+	}
+
+	for _, slot := range slots {
+		if add {
+			cs.slots[slot] = cs.myself
+			cs.importing[slot] = nil
+		} else {
+			cs.slots[slot] = nil
+		}
+	}
+	return ok()
+}
+
+// setSlot implements CLUSTER SETSLOT <slot> IMPORTING|MIGRATING|NODE <node-id> and STABLE
+func (cs *clusterState) setSlot(args []Value) Value {
+	slot, err := parseSlot(args[0].Bulk)
+	if err != nil {
+		return errVal(err.Error())
+	}
+
+	action := strings.ToUpper(args[1].Bulk)
+
+	if action == "STABLE" {
+		cs.mu.Lock()
+		cs.migrating[slot] = nil
+		cs.importing[slot] = nil
+		cs.mu.Unlock()
+		return ok()
+	}
+
+	if len(args) != 3 {
+		return syntaxErr()
+	}
+
+	n, found := cs.nodeByID(args[2].Bulk)
+	if !found {
+		return errVal("I don't know about node " + args[2].Bulk)
+	}
+
+	// checked before taking cs.mu, counting keys needs the keyspace locks
+	var keysInSlot int
+	if action == "NODE" && n != cs.myself {
+		keysInSlot = cs.countKeysInSlot(slot)
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	switch action {
+	case "MIGRATING":
+		if cs.slots[slot] != cs.myself {
+			return errVal(fmt.Sprintf("I'm not the owner of hash slot %d", slot))
+		}
+		if n == cs.myself {
+			return errVal("Target node is myself")
+		}
+		cs.migrating[slot] = n
+	case "IMPORTING":
+		if cs.slots[slot] == cs.myself {
+			return errVal(fmt.Sprintf("I'm already the owner of hash slot %d", slot))
+		}
+		if n == cs.myself {
+			return errVal("Source node is myself")
+		}
+		cs.importing[slot] = n
+	case "NODE":
+		if cs.slots[slot] == cs.myself && n != cs.myself && keysInSlot > 0 {
+			return errVal(fmt.Sprintf("Can't assign hashslot %d to a different node while I still hold keys for this hash slot.", slot))
+		}
+		if cs.migrating[slot] != nil && n != cs.myself {
+			cs.migrating[slot] = nil
+		}
+		if n == cs.myself && cs.importing[slot] != nil {
+			cs.importing[slot] = nil
+			// claim the slot with a new config epoch so the rest of the cluster prefers our claim
+			cs.currentEpoch++
+			cs.myself.configEpoch = cs.currentEpoch
+		}
+		cs.slots[slot] = n
+	default:
+		return errVal("Invalid CLUSTER SETSLOT action or number of arguments. Try CLUSTER HELP")
+	}
+	return ok()
+}
+
+func (cs *clusterState) getKeysInSlot(slotArg, countArg string) Value {
+	slot, err := parseSlot(slotArg)
+	if err != nil {
+		return errVal(err.Error())
+	}
+	count, err := strconv.Atoi(countArg)
+	if err != nil || count < 0 {
+		return errVal("Invalid number of keys")
+	}
+
+	v := Value{Type: "array", Array: []Value{}}
+
+	defer cs.srv.store.rlockAll()()
+	cs.srv.store.each(func(key string, item *RedisItem) {
+		if len(v.Array) < count && !isExpired(item.ttl) && keyHashSlot(key) == slot {
+			v.Array = append(v.Array, bulkVal(key))
+		}
+	})
+	return v
+}
+
+func (cs *clusterState) info() Value {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	assigned, okSlots, pfail := 0, 0, 0
+	size := make(map[*clusterNode]bool)
+	for _, n := range cs.slots {
+		if n == nil {
+			continue
+		}
+		assigned++
+		size[n] = true
+		if n.failing() {
+			pfail++
+		} else {
+			okSlots++
+		}
+	}
+
+	state := "ok"
+	if assigned < clusterSlots {
+		state = "fail"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "cluster_enabled:1\r\n")
+	fmt.Fprintf(&b, "cluster_state:%s\r\n", state)
+	fmt.Fprintf(&b, "cluster_slots_assigned:%d\r\n", assigned)
+	fmt.Fprintf(&b, "cluster_slots_ok:%d\r\n", okSlots)
+	fmt.Fprintf(&b, "cluster_slots_pfail:%d\r\n", pfail)
+	fmt.Fprintf(&b, "cluster_slots_fail:0\r\n")
+	fmt.Fprintf(&b, "cluster_known_nodes:%d\r\n", len(cs.nodes))
+	fmt.Fprintf(&b, "cluster_size:%d\r\n", len(size))
+	fmt.Fprintf(&b, "cluster_current_epoch:%d\r\n", cs.currentEpoch)
+	fmt.Fprintf(&b, "cluster_my_epoch:%d\r\n", cs.myself.configEpoch)
+	return bulkVal(b.String())
+}
+
+func (cs *clusterState) nodesInfo() Value {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	var b strings.Builder
+	for _, n := range cs.sortedNodes() {
+		flags := "master"
+		if n.myself {
+			flags = "myself,master"
+		}
+		if n.failing() {
+			flags += ",fail?"
+		}
+
+		var pingSent, pongRecv int64
+		if n.pingSent.After(n.pongRecv) {
+			pingSent = n.pingSent.UnixMilli()
+		}
+		if !n.pongRecv.IsZero() {
+			pongRecv = n.pongRecv.UnixMilli()
+		}
+
+		link := "disconnected"
+		if n.myself || n.linked {
+			link = "connected"
+		}
+
+		fmt.Fprintf(&b, "%s %s@%d %s - %d %d %d %s", n.id, n.addr(), n.busPort, flags, pingSent, pongRecv, n.configEpoch, link)
+
+		for _, r := range cs.slotRanges(n) {
+			if r[0] == r[1] {
+				fmt.Fprintf(&b, " %d", r[0])
+			} else {
+				fmt.Fprintf(&b, " %d-%d", r[0], r[1])
+			}
+		}
+		if n.myself {
+			for slot := 0; slot < clusterSlots; slot++ {
+				if target := cs.migrating[slot]; target != nil {
+					fmt.Fprintf(&b, " [%d->-%s]", slot, target.id)
+				}
+				if source := cs.importing[slot]; source != nil {
+					fmt.Fprintf(&b, " [%d-<-%s]", slot, source.id)
+				}
+			}
+		}
+		b.WriteByte('\n')
+	}
+	return bulkVal(b.String())
+}
+
+func (cs *clusterState) slotsInfo() Value {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	v := Value{Type: "array", Array: []Value{}}
+	for _, n := range cs.sortedNodes() {
+		for _, r := range cs.slotRanges(n) {
+			v.Array = append(v.Array, Value{Type: "array", Array: []Value{
+				intVal(r[0]),
+				intVal(r[1]),
+				{Type: "array", Array: []Value{bulkVal(n.ip), intVal(n.port), bulkVal(n.id)}},
+			}})
+		}
+	}
+	slices.SortFunc(v.Array, func(a, b Value) int { return a.Array[0].Int - b.Array[0].Int })
+	return v
+}
+
+func (cs *clusterState) shardsInfo() Value {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	v := Value{Type: "array", Array: []Value{}}
+	for _, n := range cs.sortedNodes() {
+		slots := Value{Type: "array", Array: []Value{}}
+		for _, r := range cs.slotRanges(n) {
+			slots.Array = append(slots.Array, intVal(r[0]), intVal(r[1]))
+		}
+
+		health := "online"
+		if n.failing() {
+			health = "fail"
+		}
+
+		node := Value{Type: "array", Array: []Value{
+			bulkVal("id"), bulkVal(n.id),
+			bulkVal("port"), intVal(n.port),
+			bulkVal("ip"), bulkVal(n.ip),
+			bulkVal("endpoint"), bulkVal(n.ip),
+			bulkVal("role"), bulkVal("master"),
+			bulkVal("replication-offset"), intVal(0),
+			bulkVal("health"), bulkVal(health),
+		}}
+
+		v.Array = append(v.Array, Value{Type: "array", Array: []Value{
+			bulkVal("slots"), slots,
+			bulkVal("nodes"), {Type: "array", Array: []Value{node}},
+		}})
+	}
+	return v
+}
+
+// MIGRATE host port key|"" destination-db timeout [COPY] [REPLACE] [KEYS key [key ...]]
+func MIGRATE(c *Client, args []Value) Value {
+	host, port := args[0].Bulk, args[1].Bulk
+	timeout, err := strconv.Atoi(args[4].Bulk)
+	if err != nil || timeout < 0 {
+		return errVal("value is not an integer or out of range")
+	}
+	if timeout == 0 {
+		timeout = 1000
+	}
+
+	var copyKeys, replace bool
+	var keys []string
+	if args[2].Bulk != "" {
+		keys = []string{args[2].Bulk}
+	}
+
+	for i := 5; i < len(args); i++ {
+		switch strings.ToUpper(args[i].Bulk) {
+		case "COPY":
+			copyKeys = true
+		case "REPLACE":
+			replace = true
+		case "KEYS":
+			if args[2].Bulk != "" {
+				return errVal("When using MIGRATE KEYS option, the key argument must be set to the empty string")
+			}
+			for _, k := range args[i+1:] {
+				keys = append(keys, k.Bulk)
+			}
+			i = len(args)
+		default:
+			return syntaxErr()
+		}
+	}
+
+	type migrated struct {
+		key     string
+		payload string
+		ttl     time.Time
+	}
+	var items []migrated
+
+	unlock := c.store.rlock(keys...)
+	for _, key := range keys {
+		if item, ok := c.store.get(key); ok && !isExpired(item.ttl) {
+			items = append(items, migrated{key: key, payload: string(dumpValue(item)), ttl: item.ttl})
+		}
+	}
+	unlock()
+
+	if len(items) == 0 {
+		return strVal("NOKEY")
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, port), time.Duration(timeout)*time.Millisecond)
+	if err != nil {
+		return errCode("IOERR", "error or timeout connecting to the client")
+	}
+	defer conn.Close()
+
+	w := NewWriter(conn)
+	r := NewReader(conn)
+
+	for _, it := range items {
+		conn.SetDeadline(time.Now().Add(time.Duration(timeout) * time.Millisecond))
+
+		var ttl int64
+		if !it.ttl.IsZero() {
+			ttl = max(time.Until(it.ttl).Milliseconds(), 1)
+		}
+		restore := []Value{bulkVal("RESTORE"), bulkVal(it.key), bulkVal(strconv.FormatInt(ttl, 10)), bulkVal(it.payload)}
+		if replace {
+			restore = append(restore, bulkVal("REPLACE"))
+		}
+
+		// ASKING lets the RESTORE through while the target is still importing the slot
+		for _, req := range [][]Value{{bulkVal("ASKING")}, restore} {
+			if _, err := w.Write(Value{Type: "array", Array: req}); err != nil {
+				return errCode("IOERR", "error or timeout writing to target instance")
+			}
+			reply, err := r.Read()
+			if err != nil {
+				return errCode("IOERR", "error or timeout reading to target instance")
+			}
+			if reply.Type == "error" && req[0].Bulk != "ASKING" {
+				return errVal("Target instance replied with error: " + reply.String)
+			}
+		}
+	}
+
+	if !copyKeys {
+		migratedKeys := make([]string, len(items))
+		for i, it := range items {
+			migratedKeys[i] = it.key
+		}
+		unlock := c.store.lock(migratedKeys...)
+		// the target has the keys now, replicas only delete them
+		c.replicateAs(append([]string{"DEL"}, migratedKeys...)...)
+		for _, key := range migratedKeys {
+			if c.store.del(key) {
+				c.signalModifiedKey(key)
+				c.srv.notify(notifyGeneric, "del", key)
+			}
+		}
+		unlock()
+	}
+
+	return ok()
+}
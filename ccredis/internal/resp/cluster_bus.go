@@ -0,0 +1,270 @@
+package redis
+
+import (
+	"encoding/gob"
+	"log"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	clusterNodeTimeout  = 15 * time.Second
+	clusterPingInterval = time.Second
+	// number of random known nodes piggybacked on every ping
+	clusterGossipCount = 3
+)
+
+type clusterMsgType int
+
+const (
+	clusterMsgPing clusterMsgType = iota
+	clusterMsgPong
+	clusterMsgMeet
+)
+
+type clusterMsgNode struct {
+	ID          string
+	IP          string
+	Port        int
+	BusPort     int
+	ConfigEpoch uint64
+}
+
+// clusterMsg is exchanged between nodes over the cluster bus, every ping is answered with a pong
+type clusterMsg struct {
+	Type         clusterMsgType
+	Sender       clusterMsgNode
+	CurrentEpoch uint64
+	// bitmap of the slots the sender serves
+	Slots  []byte
+	Gossip []clusterMsgNode
+}
+
+func msgNode(n *clusterNode) clusterMsgNode {
+	return clusterMsgNode{ID: n.id, IP: n.ip, Port: n.port, BusPort: n.busPort, ConfigEpoch: n.configEpoch}
+}
+
+func (cs *clusterState) listen() error {
+	ln, err := net.Listen("tcp", cs.myself.busAddr())
+	if err != nil {
+		return err
+	}
+	cs.ln = ln
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go cs.handleBusConn(conn)
+		}
+	}()
+	return nil
+}
+
+func (cs *clusterState) close() {
+	close(cs.done)
+	if cs.ln != nil {
+		cs.ln.Close()
+	}
+}
+
+func (cs *clusterState) closed() bool {
+	select {
+	case <-cs.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (cs *clusterState) handleBusConn(conn net.Conn) {
+	defer conn.Close()
+
+	enc := gob.NewEncoder(conn)
+	dec := gob.NewDecoder(conn)
+
+	for !cs.closed() {
+		conn.SetReadDeadline(time.Now().Add(clusterNodeTimeout))
+
+		var msg clusterMsg
+		if err := dec.Decode(&msg); err != nil {
+			return
+		}
+		cs.process(&msg)
+
+		if err := enc.Encode(cs.buildMsg(clusterMsgPong)); err != nil {
+			return
+		}
+	}
+}
+
+func (cs *clusterState) buildMsg(typ clusterMsgType) *clusterMsg {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	msg := &clusterMsg{
+		Type:         typ,
+		Sender:       msgNode(cs.myself),
+		CurrentEpoch: cs.currentEpoch,
+		Slots:        make([]byte, clusterSlots/8),
+	}
+	for slot, n := range cs.slots {
+		if n == cs.myself {
+			msg.Slots[slot/8] |= 1 << (slot % 8)
+		}
+	}
+
+	for _, n := range cs.nodes {
+		if len(msg.Gossip) == clusterGossipCount {
+			break
+		}
+		if !n.myself && !n.failing() && rand.IntN(2) == 0 {
+			msg.Gossip = append(msg.Gossip, msgNode(n))
+		}
+	}
+	return msg
+}
+
+// process updates the cluster view with what the sender reports about itself and the nodes it knows
+func (cs *clusterState) process(msg *clusterMsg) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if msg.Sender.ID == cs.myself.id {
+		return
+	}
+
+	if msg.CurrentEpoch > cs.currentEpoch {
+		cs.currentEpoch = msg.CurrentEpoch
+	}
+
+	sender := cs.addNode(msg.Sender)
+	sender.ip = msg.Sender.IP
+	sender.port = msg.Sender.Port
+	sender.busPort = msg.Sender.BusPort
+	sender.configEpoch = msg.Sender.ConfigEpoch
+	if msg.Type == clusterMsgPong {
+		sender.pongRecv = time.Now()
+	}
+
+	for slot := 0; slot < clusterSlots; slot++ {
+		claimed := len(msg.Slots) == clusterSlots/8 && msg.Slots[slot/8]&(1<<(slot%8)) != 0
+		owner := cs.slots[slot]
+
+		if !claimed {
+			// the sender is authoritative about the slots it gave up
+			if owner == sender {
+				cs.slots[slot] = nil
+			}
+			continue
+		}
+		if owner == sender {
+			continue
+		}
+		// the claim with the greater config epoch wins
+		if owner == nil || owner.configEpoch < sender.configEpoch {
+			cs.slots[slot] = sender
+			if cs.migrating[slot] == sender {
+				cs.migrating[slot] = nil
+			}
+		}
+	}
+
+	for _, g := range msg.Gossip {
+		if g.ID != cs.myself.id {
+			cs.addNode(g)
+		}
+	}
+}
+
+// addNode returns the known node with the id of m, or adds it and starts pinging it. Requires cs.mu.
+func (cs *clusterState) addNode(m clusterMsgNode) *clusterNode {
+	if n, ok := cs.nodes[m.ID]; ok {
+		return n
+	}
+	n := &clusterNode{id: m.ID, ip: m.IP, port: m.Port, busPort: m.BusPort, configEpoch: m.ConfigEpoch}
+	cs.nodes[n.id] = n
+	go cs.link(n)
+	return n
+}
+
+// meet introduces this node to the node listening on ip:busPort. The node is added
+// to the cluster once it answers, under the id it reports.
+func (cs *clusterState) meet(ip string, busPort int) {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip, strconv.Itoa(busPort)), clusterNodeTimeout)
+	if err != nil {
+		log.Printf("cluster meet %s:%d: %v", ip, busPort, err)
+		return
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(clusterNodeTimeout))
+	if err := gob.NewEncoder(conn).Encode(cs.buildMsg(clusterMsgMeet)); err != nil {
+		log.Printf("cluster meet %s:%d: %v", ip, busPort, err)
+		return
+	}
+
+	var reply clusterMsg
+	if err := gob.NewDecoder(conn).Decode(&reply); err != nil {
+		log.Printf("cluster meet %s:%d: %v", ip, busPort, err)
+		return
+	}
+	cs.process(&reply)
+}
+
+// link keeps pinging n over the cluster bus until the server shuts down
+func (cs *clusterState) link(n *clusterNode) {
+	for !cs.closed() {
+		cs.mu.RLock()
+		addr := n.busAddr()
+		cs.mu.RUnlock()
+
+		conn, err := net.DialTimeout("tcp", addr, clusterPingInterval)
+		if err != nil {
+			cs.setLinked(n, false)
+			time.Sleep(clusterPingInterval)
+			continue
+		}
+
+		cs.setLinked(n, true)
+		enc := gob.NewEncoder(conn)
+		dec := gob.NewDecoder(conn)
+
+		for !cs.closed() {
+			conn.SetDeadline(time.Now().Add(clusterNodeTimeout))
+
+			cs.mu.Lock()
+			if n.pongRecv.After(n.pingSent) || n.pingSent.IsZero() {
+				n.pingSent = time.Now()
+			}
+			cs.mu.Unlock()
+
+			if err := enc.Encode(cs.buildMsg(clusterMsgPing)); err != nil {
+				break
+			}
+			var reply clusterMsg
+			if err := dec.Decode(&reply); err != nil {
+				break
+			}
+			cs.process(&reply)
+
+			select {
+			case <-cs.done:
+			case <-time.After(clusterPingInterval):
+			}
+		}
+
+		conn.Close()
+		cs.setLinked(n, false)
+	}
+}
+
+func (cs *clusterState) setLinked(n *clusterNode, linked bool) {
+	cs.mu.Lock()
+	n.linked = linked
+	cs.mu.Unlock()
+}
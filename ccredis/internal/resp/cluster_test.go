@@ -0,0 +1,100 @@
+package redis
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyHashSlot(t *testing.T) {
+	t.Parallel()
+
+	// values from the Redis Cluster specification
+	require.Equal(t, 12739, keyHashSlot("123456789"))
+	require.Equal(t, keyHashSlot("user1000"), keyHashSlot("{user1000}.following"))
+	require.Equal(t, keyHashSlot("user1000"), keyHashSlot("foo{user1000}{bar}"))
+	require.Equal(t, keyHashSlot("{}.following"), keyHashSlot("{}.following"))
+	require.NotEqual(t, keyHashSlot("{}a"), keyHashSlot("{}b"))
+}
+
+func TestCluster_RedirectsAndMigration(t *testing.T) {
+	a := startTestServer(t, Config{ClusterEnabled: true})
+	b := startTestServer(t, Config{ClusterEnabled: true})
+
+	require.Equal(t, "OK", a.do(t, "CLUSTER", "ADDSLOTSRANGE", "0", "8191").String)
+	require.Equal(t, "OK", b.do(t, "CLUSTER", "ADDSLOTSRANGE", "8192", "16383").String)
+	require.Equal(t, "OK", a.do(t, "CLUSTER", "MEET", "127.0.0.1", fmt.Sprint(b.srv.cfg.Port), fmt.Sprint(b.srv.cfg.ClusterPort)).String)
+
+	require.Eventually(t, func() bool {
+		info := b.do(t, "CLUSTER", "INFO").Bulk
+		return strings.Contains(info, "cluster_state:ok") && strings.Contains(info, "cluster_known_nodes:2")
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// "foo" hashes to 12182 which is served by b
+	slot := keyHashSlot("foo")
+	require.Equal(t, 12182, slot)
+
+	v := a.do(t, "SET", "foo", "bar")
+	require.Equal(t, "error", v.Type)
+	require.Equal(t, fmt.Sprintf("MOVED %d %s", slot, b.srv.Addr()), v.String)
+	require.Equal(t, "OK", b.do(t, "SET", "foo", "bar").String)
+
+	require.Equal(t, "CROSSSLOT Keys in request don't hash to the same slot", b.do(t, "MSET", "foo", "1", "bar", "2").String)
+	require.Equal(t, "OK", b.do(t, "MSET", "{foo}a", "1", "{foo}b", "2").String)
+	require.Equal(t, 1, b.do(t, "HSET", "{foo}h", "field", "value").Int)
+
+	slots := a.do(t, "CLUSTER", "SLOTS")
+	require.Len(t, slots.Array, 2)
+	require.Equal(t, 0, slots.Array[0].Array[0].Int)
+	require.Equal(t, 8191, slots.Array[0].Array[1].Int)
+	require.Equal(t, b.srv.cfg.Port, slots.Array[1].Array[2].Array[1].Int)
+
+	// migrate the slot of "foo" from b to a
+	aID := a.do(t, "CLUSTER", "MYID").Bulk
+	bID := b.do(t, "CLUSTER", "MYID").Bulk
+	require.Equal(t, "OK", a.do(t, "CLUSTER", "SETSLOT", fmt.Sprint(slot), "IMPORTING", bID).String)
+	require.Equal(t, "OK", b.do(t, "CLUSTER", "SETSLOT", fmt.Sprint(slot), "MIGRATING", aID).String)
+	require.Contains(t, b.do(t, "CLUSTER", "NODES").Bulk, fmt.Sprintf("[%d->-%s]", slot, aID))
+
+	require.Equal(t, "OK", b.do(t, "MIGRATE", "127.0.0.1", fmt.Sprint(a.srv.cfg.Port), "foo", "0", "1000").String)
+
+	// the key is gone from the source, which now redirects with ASK
+	require.Equal(t, fmt.Sprintf("ASK %d %s", slot, a.srv.Addr()), b.do(t, "GET", "foo").String)
+	require.Equal(t, "TRYAGAIN Multiple keys request during rehashing of slot", b.do(t, "MSET", "{foo}a", "1", "foo", "2").String)
+
+	// the target only serves it after ASKING
+	require.Equal(t, "MOVED", strings.Fields(a.do(t, "GET", "foo").String)[0])
+	require.Equal(t, "OK", a.do(t, "ASKING").String)
+	require.Equal(t, "bar", a.do(t, "GET", "foo").Bulk)
+
+	require.Equal(t, "OK", b.do(t, "MIGRATE", "127.0.0.1", fmt.Sprint(a.srv.cfg.Port), "", "0", "1000", "KEYS", "{foo}a", "{foo}b", "{foo}h").String)
+	require.Equal(t, 0, b.do(t, "CLUSTER", "COUNTKEYSINSLOT", fmt.Sprint(slot)).Int)
+
+	require.Equal(t, "OK", a.do(t, "CLUSTER", "SETSLOT", fmt.Sprint(slot), "NODE", aID).String)
+	require.Equal(t, "OK", b.do(t, "CLUSTER", "SETSLOT", fmt.Sprint(slot), "NODE", aID).String)
+
+	require.Equal(t, "bar", a.do(t, "GET", "foo").Bulk)
+	require.Equal(t, "value", a.do(t, "HGET", "{foo}h", "field").Bulk)
+	require.Equal(t, fmt.Sprintf("MOVED %d %s", slot, a.srv.Addr()), b.do(t, "GET", "foo").String)
+
+	// the new owner wins the slot through its bumped config epoch
+	require.Eventually(t, func() bool {
+		shards := b.do(t, "CLUSTER", "SHARDS")
+		for _, shard := range shards.Array {
+			node := shard.Array[3].Array[0]
+			ranges := shard.Array[1].Array
+			if node.Array[1].Bulk != aID {
+				continue
+			}
+			for i := 0; i < len(ranges); i += 2 {
+				if ranges[i].Int <= slot && slot <= ranges[i+1].Int {
+					return true
+				}
+			}
+		}
+		return false
+	}, 5*time.Second, 50*time.Millisecond)
+}
@@ -0,0 +1,306 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// configParam is a parameter exposed through CONFIG GET/SET and read from the config file
+// and the command line. Parameters without set are read only, those with load are stored in
+// the Config the server is created with, the others are applied with set once it exists.
+type configParam struct {
+	name string
+	get  func(s *Server) string
+	set  func(s *Server, v string) error
+	load func(cfg *Config, v string) error
+	// the value is several arguments in the config file, e.g. "save 3600 1 300 100"
+	args bool
+}
+
+var configParams = []*configParam{
+	stringConst("bind", func(cfg *Config) *string { return &cfg.Bind }),
+	intConst("port", func(cfg *Config) *int { return &cfg.Port }),
+	intConst("tls-port", func(cfg *Config) *int { return &cfg.TLSPort }),
+	stringConst("tls-cert-file", func(cfg *Config) *string { return &cfg.TLSCertFile }),
+	stringConst("tls-key-file", func(cfg *Config) *string { return &cfg.TLSKeyFile }),
+	stringConst("tls-ca-cert-file", func(cfg *Config) *string { return &cfg.TLSCACertFile }),
+	stringConst("tls-auth-clients", func(cfg *Config) *string { return &cfg.TLSAuthClients }),
+	stringConst("unixsocket", func(cfg *Config) *string { return &cfg.UnixSocket }),
+	{
+		name: "unixsocketperm",
+		get:  func(s *Server) string { return fmt.Sprintf("%o", s.cfg.UnixSocketPerm) },
+		load: func(cfg *Config, v string) error {
+			perm, err := strconv.ParseUint(v, 8, 32)
+			if err != nil {
+				return errors.New("argument must be an octal number")
+			}
+			cfg.UnixSocketPerm = os.FileMode(perm)
+			return nil
+		},
+	},
+	boolConst("cluster-enabled", func(cfg *Config) *bool { return &cfg.ClusterEnabled }),
+	intConst("cluster-port", func(cfg *Config) *int { return &cfg.ClusterPort }),
+	{
+		name: "notify-keyspace-events",
+		get:  func(s *Server) string { return formatNotifyFlags(int(s.notifyFlags.Load())) },
+		set: func(s *Server, v string) error {
+			flags, err := parseNotifyFlags(v)
+			if err != nil {
+				return err
+			}
+			s.notifyFlags.Store(int32(flags))
+			return nil
+		},
+	},
+	intParam("maxclients", func(s *Server) *atomic.Int64 { return &s.maxClients }),
+	intParam("timeout", func(s *Server) *atomic.Int64 { return &s.timeout }),
+	intParam("busy-reply-threshold", func(s *Server) *atomic.Int64 { return &s.busyReplyThreshold }),
+	intParam("tcp-keepalive", func(s *Server) *atomic.Int64 { return &s.tcpKeepalive }),
+	stringConst("dir", func(cfg *Config) *string { return &cfg.Dir }),
+	stringConst("dbfilename", func(cfg *Config) *string { return &cfg.DBFilename }),
+	{
+		name: "save",
+		args: true,
+		get:  func(s *Server) string { return formatSavePoints(*s.savePoints.Load()) },
+		set: func(s *Server, v string) error {
+			points, err := parseSavePoints(v)
+			if err != nil {
+				return err
+			}
+			s.savePoints.Store(&points)
+			return nil
+		},
+	},
+	appendOnlyParam,
+	stringConst("appendfilename", func(cfg *Config) *string { return &cfg.AppendFilename }),
+	appendFsyncParam,
+	{
+		name: "replicaof",
+		args: true,
+		get: func(s *Server) string {
+			if l := s.repl.link.Load(); l != nil {
+				return fmt.Sprintf("%s %d", l.host, l.port)
+			}
+			return ""
+		},
+		load: func(cfg *Config, v string) error {
+			if strings.EqualFold(v, "no one") {
+				v = ""
+			}
+			if fields := strings.Fields(v); v != "" && len(fields) != 2 {
+				return errors.New(`argument must be "<host> <port>" or "no one"`)
+			} else if v != "" {
+				if _, err := strconv.Atoi(fields[1]); err != nil {
+					return errors.New("Invalid master port")
+				}
+			}
+			cfg.ReplicaOf = v
+			return nil
+		},
+	},
+	intParam("replica-priority", func(s *Server) *atomic.Int64 { return &s.replicaPriority }),
+	stringConst("metrics-addr", func(cfg *Config) *string { return &cfg.MetricsAddr }),
+	{
+		name: "slowlog-log-slower-than",
+		get:  func(s *Server) string { return strconv.FormatInt(s.slowlogSlowerThan.Load(), 10) },
+		set: func(s *Server, v string) error {
+			// negative disables the slowlog, 0 logs every command
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return errors.New("argument couldn't be parsed into an integer")
+			}
+			s.slowlogSlowerThan.Store(n)
+			return nil
+		},
+	},
+	intParam("slowlog-max-len", func(s *Server) *atomic.Int64 { return &s.slowlogMaxLen }),
+	intParam("latency-monitor-threshold", func(s *Server) *atomic.Int64 { return &s.latencyThreshold }),
+	shutdownModeParam("shutdown-on-sigterm", func(s *Server) *atomic.Int32 { return &s.shutdownOnSigterm }),
+	shutdownModeParam("shutdown-on-sigint", func(s *Server) *atomic.Int32 { return &s.shutdownOnSigint }),
+	intParam("hash-max-listpack-entries", func(s *Server) *atomic.Int64 { return &s.encLimits.hashMaxListpackEntries }),
+	bytesParam("hash-max-listpack-value", func(s *Server) *atomic.Int64 { return &s.encLimits.hashMaxListpackValue }),
+	intParam("set-max-intset-entries", func(s *Server) *atomic.Int64 { return &s.encLimits.setMaxIntsetEntries }),
+	intParam("set-max-listpack-entries", func(s *Server) *atomic.Int64 { return &s.encLimits.setMaxListpackEntries }),
+	bytesParam("set-max-listpack-value", func(s *Server) *atomic.Int64 { return &s.encLimits.setMaxListpackValue }),
+	intParam("list-max-listpack-size", func(s *Server) *atomic.Int64 { return &s.encLimits.listMaxListpackSize }),
+	intParam("zset-max-listpack-entries", func(s *Server) *atomic.Int64 { return &s.encLimits.zsetMaxListpackEntries }),
+	bytesParam("zset-max-listpack-value", func(s *Server) *atomic.Int64 { return &s.encLimits.zsetMaxListpackValue }),
+	bytesParam("hll-sparse-max-bytes", func(s *Server) *atomic.Int64 { return &s.encLimits.hllSparseMaxBytes }),
+	{
+		name: "maxmemory-policy",
+		get:  func(s *Server) string { return maxmemoryPolicies[s.maxmemoryPolicy.Load()] },
+		set: func(s *Server, v string) error {
+			i := slices.Index(maxmemoryPolicies, strings.ToLower(v))
+			if i < 0 {
+				return errors.New("argument(s) must be one of the following: " + strings.Join(maxmemoryPolicies, ", "))
+			}
+			s.maxmemoryPolicy.Store(int32(i))
+			return nil
+		},
+	},
+}
+
+var maxmemoryPolicies = []string{
+	"noeviction", "allkeys-lru", "volatile-lru", "allkeys-lfu", "volatile-lfu",
+	"allkeys-random", "volatile-random", "volatile-ttl",
+}
+
+func (s *Server) lfuPolicy() bool {
+	return strings.HasSuffix(maxmemoryPolicies[s.maxmemoryPolicy.Load()], "-lfu")
+}
+
+// intParam is a non negative integer parameter backed by an atomic
+func intParam(name string, field func(s *Server) *atomic.Int64) *configParam {
+	return &configParam{
+		name: name,
+		get:  func(s *Server) string { return strconv.FormatInt(field(s).Load(), 10) },
+		set: func(s *Server, v string) error {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				return errors.New("argument must be a non negative integer")
+			}
+			field(s).Store(n)
+			return nil
+		},
+	}
+}
+
+// bytesParam is intParam for a size, which also takes units like 64kb
+func bytesParam(name string, field func(s *Server) *atomic.Int64) *configParam {
+	p := intParam(name, field)
+	p.set = func(s *Server, v string) error {
+		n, err := parseMemory(v)
+		if err != nil {
+			return err
+		}
+		field(s).Store(n)
+		return nil
+	}
+	return p
+}
+
+// stringConst is a read only string parameter of the Config
+func stringConst(name string, field func(cfg *Config) *string) *configParam {
+	return &configParam{
+		name: name,
+		get:  func(s *Server) string { return *field(&s.cfg) },
+		load: func(cfg *Config, v string) error {
+			*field(cfg) = v
+			return nil
+		},
+	}
+}
+
+// intConst is a read only non negative integer parameter of the Config
+func intConst(name string, field func(cfg *Config) *int) *configParam {
+	return &configParam{
+		name: name,
+		get:  func(s *Server) string { return strconv.Itoa(*field(&s.cfg)) },
+		load: func(cfg *Config, v string) error {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return errors.New("argument must be a non negative integer")
+			}
+			*field(cfg) = n
+			return nil
+		},
+	}
+}
+
+// boolConst is a read only yes/no parameter of the Config
+func boolConst(name string, field func(cfg *Config) *bool) *configParam {
+	return &configParam{
+		name: name,
+		get:  func(s *Server) string { return yesno(*field(&s.cfg)) },
+		load: func(cfg *Config, v string) error {
+			b, err := parseYesNo(v)
+			if err != nil {
+				return err
+			}
+			*field(cfg) = b
+			return nil
+		},
+	}
+}
+
+func lookupConfigParam(name string) *configParam {
+	for _, p := range configParams {
+		if p.name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+func parseYesNo(v string) (bool, error) {
+	switch strings.ToLower(v) {
+	case "yes":
+		return true, nil
+	case "no":
+		return false, nil
+	default:
+		return false, errors.New("argument must be 'yes' or 'no'")
+	}
+}
+
+func yesno(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+func CONFIG(c *Client, args []Value) Value {
+	switch strings.ToUpper(args[0].Bulk) {
+	case "GET":
+		if len(args) < 2 {
+			return errWrongArgs("config|get")
+		}
+		v := Value{Type: "array", Array: []Value{}}
+		for _, p := range configParams {
+			for _, arg := range args[1:] {
+				if matchPattern(strings.ToLower(arg.Bulk), p.name) {
+					v.Array = append(v.Array, bulkVal(p.name), bulkVal(p.get(c.srv)))
+					break
+				}
+			}
+		}
+		return v
+	case "SET":
+		if len(args) < 3 || len(args)%2 != 1 {
+			return errWrongArgs("config|set")
+		}
+		for i := 1; i < len(args); i += 2 {
+			name := strings.ToLower(args[i].Bulk)
+			p := lookupConfigParam(name)
+			if p == nil {
+				return errVal(fmt.Sprintf("Unknown option or number of arguments for CONFIG SET - '%s'", name))
+			}
+			if p.set == nil {
+				return errVal(fmt.Sprintf("CONFIG SET failed (possibly related to argument '%s') - can't set immutable config", name))
+			}
+			if err := p.set(c.srv, args[i+1].Bulk); err != nil {
+				return errVal(fmt.Sprintf("CONFIG SET failed (possibly related to argument '%s') - %s", name, err))
+			}
+		}
+		return ok()
+	case "REWRITE":
+		if len(args) != 1 {
+			return errWrongArgs("config|rewrite")
+		}
+		if c.srv.cfg.File == "" {
+			return errVal("The server is running without a config file")
+		}
+		if err := c.srv.rewriteConfig(); err != nil {
+			return errVal(fmt.Sprintf("Rewriting config file: %s", err))
+		}
+		return ok()
+	default:
+		return errVal(fmt.Sprintf("unknown subcommand '%s'. Try CONFIG HELP.", args[0].Bulk))
+	}
+}
@@ -0,0 +1,404 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config files use the format of redis.conf, a directive and its arguments per line:
+//
+//	# comments and blank lines are ignored
+//	port 6380
+//	save 3600 1 300 100
+//	notify-keyspace-events "Ex"
+//	hll-sparse-max-bytes 3kb
+//	include /etc/ccredis/common.conf
+//
+// Arguments are split like redis-cli splits them: double quoted ones take escapes like \n
+// and \x41, single quoted ones only \'. Sizes take the units of Redis, 1k is 1000 bytes and
+// 1kb 1024. An included path is relative to the directory of the file including it. Later
+// directives override earlier ones, except that save lines add up, and command line flags
+// come after the file.
+
+// maxConfigIncludeDepth stops include cycles
+const maxConfigIncludeDepth = 16
+
+// configRewriteMarker precedes the lines CONFIG REWRITE appends, like in Redis
+const configRewriteMarker = "# Generated by CONFIG REWRITE"
+
+// configDirective is a line of a config file or a command line flag
+type configDirective struct {
+	name string
+	args []string
+	// where the directive was read, for errors
+	file string
+	line int
+	raw  string
+}
+
+func (d configDirective) errorf(format string, args ...any) error {
+	msg := fmt.Sprintf(format, args...)
+	if d.file == "" {
+		return fmt.Errorf("--%s: %s", d.name, msg)
+	}
+	return fmt.Errorf("%s, line %d: >>> '%s': %s", d.file, d.line, d.raw, msg)
+}
+
+// DefaultConfig is the configuration of a server started without config file and flags
+func DefaultConfig() Config {
+	return Config{
+		Bind:           "127.0.0.1",
+		Port:           6380,
+		TLSAuthClients: tlsAuthYes,
+		MaxClients:     10000,
+		TCPKeepalive:   300,
+		Dir:            ".",
+		DBFilename:     "dump.ccdb",
+		Save:           "3600 1 300 100 60 10000",
+		AppendFilename: "appendonly.aof",
+		AppendFsync:    "everysec",
+	}
+}
+
+// NewConfiguredServer creates a server from DefaultConfig, the config file at path, if not
+// empty, and then overrides, which are "<name> <value>" pairs like the command line options
+// of redis-server. CONFIG REWRITE writes the configuration back to path.
+func NewConfiguredServer(path string, overrides [][2]string) (*Server, error) {
+	var directives []configDirective
+	if path != "" {
+		var err error
+		if directives, err = readConfigFile(path, 0); err != nil {
+			return nil, err
+		}
+	}
+	for _, o := range overrides {
+		directives = append(directives, configDirective{name: o[0], args: []string{o[1]}})
+	}
+	cfg := DefaultConfig()
+	cfg.File = path
+	return configure(cfg, directives)
+}
+
+// configure creates a server from cfg and directives, later directives override earlier ones
+func configure(cfg Config, directives []configDirective) (*Server, error) {
+	// parameters the server applies itself, in the order they were given
+	var later []configDirective
+	var values []string
+	var save []string
+	for _, d := range directives {
+		p := lookupConfigParam(d.name)
+		if p == nil || !p.args && len(d.args) != 1 || p.set == nil && p.load == nil {
+			return nil, d.errorf("Bad directive or wrong number of arguments")
+		}
+		v := strings.Join(d.args, " ")
+		if p.name == "save" {
+			// every save line adds save points, an empty one removes them
+			if v == "" {
+				save = save[:0]
+			} else {
+				save = append(save, v)
+			}
+			v = strings.Join(save, " ")
+		}
+		if p.load != nil {
+			if err := p.load(&cfg, v); err != nil {
+				return nil, d.errorf("%s", err)
+			}
+			continue
+		}
+		later = append(later, d)
+		values = append(values, v)
+	}
+
+	s := NewServer(cfg)
+	for i, d := range later {
+		if err := lookupConfigParam(d.name).set(s, values[i]); err != nil {
+			return nil, d.errorf("%s", err)
+		}
+	}
+	return s, nil
+}
+
+// readConfigFile returns the directives of the file at path and the files it includes
+func readConfigFile(path string, depth int) ([]configDirective, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var directives []configDirective
+	for i, raw := range strings.Split(string(data), "\n") {
+		line := strings.TrimSpace(raw)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		d := configDirective{file: path, line: i + 1, raw: line}
+		args, err := splitConfigArgs(line)
+		if err != nil {
+			return nil, d.errorf("%s", err)
+		}
+		d.name, d.args = strings.ToLower(args[0]), args[1:]
+
+		if d.name != "include" {
+			directives = append(directives, d)
+			continue
+		}
+		if len(d.args) != 1 {
+			return nil, d.errorf("Bad directive or wrong number of arguments")
+		}
+		if depth == maxConfigIncludeDepth {
+			return nil, d.errorf("too many nested includes")
+		}
+		include := d.args[0]
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(path), include)
+		}
+		included, err := readConfigFile(include, depth+1)
+		if err != nil {
+			return nil, d.errorf("%s", err)
+		}
+		directives = append(directives, included...)
+	}
+	return directives, nil
+}
+
+// splitConfigArgs splits a line into arguments the way sdssplitargs of Redis does
+func splitConfigArgs(line string) ([]string, error) {
+	var args []string
+	i := 0
+	for {
+		for i < len(line) && isConfigSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+
+		var arg []byte
+		switch line[i] {
+		case '"':
+			for i++; ; i++ {
+				if i == len(line) {
+					return nil, errors.New("unbalanced quotes in configuration line")
+				}
+				c := line[i]
+				if c == '"' {
+					break
+				}
+				if c == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHexDigit(line[i+2]) && isHexDigit(line[i+3]) {
+					n, _ := strconv.ParseUint(line[i+2:i+4], 16, 8)
+					arg = append(arg, byte(n))
+					i += 3
+					continue
+				}
+				if c == '\\' && i+1 < len(line) {
+					i++
+					c = line[i]
+					switch c {
+					case 'n':
+						c = '\n'
+					case 'r':
+						c = '\r'
+					case 't':
+						c = '\t'
+					case 'b':
+						c = '\b'
+					case 'a':
+						c = '\a'
+					}
+				}
+				arg = append(arg, c)
+			}
+			i++
+		case '\'':
+			for i++; ; i++ {
+				if i == len(line) {
+					return nil, errors.New("unbalanced quotes in configuration line")
+				}
+				c := line[i]
+				if c == '\'' {
+					break
+				}
+				if c == '\\' && i+1 < len(line) && line[i+1] == '\'' {
+					i++
+					c = '\''
+				}
+				arg = append(arg, c)
+			}
+			i++
+		default:
+			for ; i < len(line) && !isConfigSpace(line[i]) && line[i] != '"' && line[i] != '\''; i++ {
+				arg = append(arg, line[i])
+			}
+			args = append(args, string(arg))
+			continue
+		}
+		// a closing quote must end the argument
+		if i < len(line) && !isConfigSpace(line[i]) {
+			return nil, errors.New("closing quote must be followed by a space or nothing at all")
+		}
+		args = append(args, string(arg))
+	}
+}
+
+func isConfigSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\v' || c == '\f'
+}
+
+func isHexDigit(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+// quoteConfigArg quotes s when splitConfigArgs wouldn't return it as a single argument
+func quoteConfigArg(s string) string {
+	plain := s != ""
+	for i := 0; i < len(s) && plain; i++ {
+		c := s[i]
+		plain = c > ' ' && c < 0x7f && c != '"' && c != '\'' && c != '\\'
+	}
+	if plain {
+		return s
+	}
+	b := []byte{'"'}
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\', '"':
+			b = append(b, '\\', c)
+		case '\n':
+			b = append(b, '\\', 'n')
+		case '\r':
+			b = append(b, '\\', 'r')
+		case '\t':
+			b = append(b, '\\', 't')
+		default:
+			if c < ' ' || c >= 0x7f {
+				b = fmt.Appendf(b, "\\x%02x", c)
+			} else {
+				b = append(b, c)
+			}
+		}
+	}
+	return string(append(b, '"'))
+}
+
+// parseMemory parses a size with an optional unit like memtoll of Redis: b, k, kb, m, mb,
+// g or gb, where k is 1000 and kb 1024
+func parseMemory(v string) (int64, error) {
+	lower := strings.ToLower(v)
+	num := strings.TrimRight(lower, "bkmg")
+	mul, ok := map[string]int64{
+		"": 1, "b": 1,
+		"k": 1000, "kb": 1024,
+		"m": 1000 * 1000, "mb": 1024 * 1024,
+		"g": 1000 * 1000 * 1000, "gb": 1024 * 1024 * 1024,
+	}[lower[len(num):]]
+	n, err := strconv.ParseInt(num, 10, 64)
+	if !ok || err != nil || n < 0 {
+		return 0, errors.New("argument must be a memory value")
+	}
+	return n * mul, nil
+}
+
+// formatConfigLine is the line of the config file setting p to v
+func formatConfigLine(p *configParam, v string) string {
+	if p.args && v != "" {
+		return p.name + " " + v
+	}
+	return p.name + " " + quoteConfigArg(v)
+}
+
+// rewriteConfig writes the current configuration to the config file. Lines of parameters
+// keep their place, with the current value, and comments, includes and unknown lines are
+// kept as they are. Parameters the file doesn't set are appended when they differ from
+// the default, or from the value of the included files, which are never written to.
+func (s *Server) rewriteConfig() error {
+	path := s.cfg.File
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	out := make([]string, 0, len(lines))
+	written := make(map[string]bool)
+	var included []configDirective
+	marker := false
+	for _, raw := range lines {
+		line := strings.TrimSpace(raw)
+		marker = marker || line == configRewriteMarker
+		args, err := splitConfigArgs(line)
+		if line == "" || line[0] == '#' || err != nil {
+			out = append(out, raw)
+			continue
+		}
+		name := strings.ToLower(args[0])
+		if name == "include" && len(args) == 2 {
+			include := args[1]
+			if !filepath.IsAbs(include) {
+				include = filepath.Join(filepath.Dir(path), include)
+			}
+			if directives, err := readConfigFile(include, 1); err == nil {
+				included = append(included, directives...)
+			}
+		}
+		p := lookupConfigParam(name)
+		if p == nil {
+			out = append(out, raw)
+			continue
+		}
+		// the first line of a parameter takes its value, the others go
+		if !written[p.name] {
+			written[p.name] = true
+			out = append(out, formatConfigLine(p, p.get(s)))
+		}
+	}
+
+	def := DefaultConfig()
+	// the cluster port follows the port unless it is set
+	def.ClusterPort = s.cfg.Port + 10000
+	defaults, err := configure(def, included)
+	if err != nil {
+		defaults = NewServer(def)
+	}
+	for _, p := range configParams {
+		if written[p.name] {
+			continue
+		}
+		v := p.get(s)
+		if v == p.get(defaults) {
+			continue
+		}
+		if !marker {
+			out = append(out, configRewriteMarker)
+			marker = true
+		}
+		out = append(out, formatConfigLine(p, v))
+	}
+
+	tmp := filepath.Join(filepath.Dir(path), fmt.Sprintf("temp-config-%d-%d.conf", os.Getpid(), time.Now().UnixNano()))
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	if _, err := f.WriteString(strings.Join(out, "\n") + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
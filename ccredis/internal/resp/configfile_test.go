@@ -0,0 +1,199 @@
+package redis
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitConfigArgs(t *testing.T) {
+	for line, want := range map[string][]string{
+		"port 6380":                       {"port", "6380"},
+		"  save   3600 1\t300 100  ":      {"save", "3600", "1", "300", "100"},
+		`notify-keyspace-events "Ex"`:     {"notify-keyspace-events", "Ex"},
+		`dir "/data/my dir"`:              {"dir", "/data/my dir"},
+		`x "a\tb\n\x41\"\\"`:              {"x", "a\tb\nA\"\\"},
+		`x 'it\'s \n'`:                    {"x", `it's \n`},
+		`save ""`:                         {"save", ""},
+		`replicaof "10.0.0.1" '6379' end`: {"replicaof", "10.0.0.1", "6379", "end"},
+	} {
+		args, err := splitConfigArgs(line)
+		require.NoError(t, err, line)
+		require.Equal(t, want, args, line)
+	}
+	for _, line := range []string{`dir "/data`, `dir '/data`, `dir "a"b`} {
+		_, err := splitConfigArgs(line)
+		require.Error(t, err, line)
+	}
+
+	for _, v := range []string{"", "plain", "two words", "tab\there", `q"uote`, "\x00\xff", `back\slash`} {
+		args, err := splitConfigArgs("x " + quoteConfigArg(v))
+		require.NoError(t, err, v)
+		require.Equal(t, []string{"x", v}, args, v)
+	}
+}
+
+func TestParseMemory(t *testing.T) {
+	for v, want := range map[string]int64{
+		"100": 100, "100b": 100, "1k": 1000, "1kb": 1024, "64KB": 64 << 10,
+		"2m": 2000000, "2mb": 2 << 20, "1g": 1000000000, "1Gb": 1 << 30,
+	} {
+		n, err := parseMemory(v)
+		require.NoError(t, err, v)
+		require.Equal(t, want, n, v)
+	}
+	for _, v := range []string{"", "mb", "-1kb", "1kbb", "1tb", "1.5mb", "k1"} {
+		_, err := parseMemory(v)
+		require.Error(t, err, v)
+	}
+}
+
+func writeConfigFile(t *testing.T, path, content string) {
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+}
+
+func TestNewConfiguredServer(t *testing.T) {
+	dir := t.TempDir()
+	port := freePort(t)
+	writeConfigFile(t, filepath.Join(dir, "common.conf"), "tcp-keepalive 60\nhash-max-listpack-value 1kb\n")
+	path := filepath.Join(dir, "redis.conf")
+	writeConfigFile(t, path, `# the test server
+PORT `+strconv.Itoa(port)+`
+include common.conf
+dir "`+dir+`"
+save 900 1
+save 300 10
+notify-keyspace-events "Ex"
+maxclients 100
+appendonly yes
+`)
+
+	srv, err := NewConfiguredServer(path, [][2]string{{"maxclients", "50"}, {"save", "60 10000"}})
+	require.NoError(t, err)
+	require.Equal(t, path, srv.cfg.File)
+	require.Equal(t, port, srv.cfg.Port)
+	require.Equal(t, port+10000, srv.cfg.ClusterPort)
+	require.Equal(t, dir, srv.cfg.Dir)
+	require.True(t, srv.cfg.AppendOnly)
+
+	n := dialTestServer(t, serveTestServer(t, srv))
+	config := func(name string) string { return n.do(t, "CONFIG", "GET", name).Array[1].Bulk }
+	require.Equal(t, "60", config("tcp-keepalive"))
+	require.Equal(t, "1024", config("hash-max-listpack-value"))
+	require.Equal(t, "900 1 300 10 60 10000", config("save"), "save lines add up")
+	require.Equal(t, "xE", config("notify-keyspace-events"))
+	require.Equal(t, "50", config("maxclients"), "flags override the file")
+	require.Equal(t, "everysec", config("appendfsync"), "parameters not given keep their default")
+	require.Contains(t, n.do(t, "INFO", "server").Bulk, "config_file:"+path+"\r\n")
+
+	srv, err = NewConfiguredServer("", nil)
+	require.NoError(t, err)
+	require.Equal(t, DefaultConfig().Port, srv.cfg.Port)
+	require.Equal(t, "3600 1 300 100 60 10000", lookupConfigParam("save").get(srv))
+}
+
+func TestNewConfiguredServer_Errors(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "redis.conf")
+	for content, msg := range map[string]string{
+		"port 6380\n\nbogus-directive 1\n": path + ", line 3: >>> 'bogus-directive 1': Bad directive or wrong number of arguments",
+		"port 6380 6381\n":                 path + ", line 1: >>> 'port 6380 6381': Bad directive or wrong number of arguments",
+		"port abc\n":                       path + ", line 1: >>> 'port abc': argument must be a non negative integer",
+		"maxclients -1\n":                  path + ", line 1: >>> 'maxclients -1': argument must be a non negative integer",
+		"appendonly maybe\n":               path + ", line 1: >>> 'appendonly maybe': argument must be 'yes' or 'no'",
+		"# comment\ndir \"/data\n":         path + ", line 2: >>> 'dir \"/data': unbalanced quotes in configuration line",
+		"include missing.conf\n":           path + ", line 1: >>> 'include missing.conf': open " + filepath.Join(dir, "missing.conf") + ": no such file or directory",
+		"include redis.conf\n":             "too many nested includes",
+	} {
+		writeConfigFile(t, path, content)
+		_, err := NewConfiguredServer(path, nil)
+		require.ErrorContains(t, err, msg, content)
+	}
+
+	_, err := NewConfiguredServer("", [][2]string{{"hll-sparse-max-bytes", "1tb"}})
+	require.EqualError(t, err, "--hll-sparse-max-bytes: argument must be a memory value")
+}
+
+func TestConfigRewrite(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "redis.conf")
+	port := freePort(t)
+	writeConfigFile(t, filepath.Join(dir, "common.conf"), "tcp-keepalive 60\n")
+	original := `# ccredis configuration
+
+# network
+port ` + strconv.Itoa(port) + `
+include common.conf
+
+maxclients 100
+# timeout is set twice, the second one goes
+timeout 10
+timeout 20
+save 900 1
+save 300 10
+`
+	writeConfigFile(t, path, original)
+	srv, err := NewConfiguredServer(path, nil)
+	require.NoError(t, err)
+	n := dialTestServer(t, serveTestServer(t, srv))
+
+	require.Equal(t, "OK", n.do(t, "CONFIG", "REWRITE").String)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, `# ccredis configuration
+
+# network
+port `+strconv.Itoa(port)+`
+include common.conf
+
+maxclients 100
+# timeout is set twice, the second one goes
+timeout 20
+save 900 1 300 10
+`, string(data), "an unchanged configuration keeps the file as it is, but for duplicates")
+
+	n.do(t, "CONFIG", "SET", "maxclients", "200")
+	n.do(t, "CONFIG", "SET", "notify-keyspace-events", "KEA")
+	n.do(t, "CONFIG", "SET", "save", "")
+	n.do(t, "CONFIG", "SET", "slowlog-max-len", "1000")
+	n.do(t, "CONFIG", "SET", "tcp-keepalive", "30")
+	require.Equal(t, "OK", n.do(t, "CONFIG", "REWRITE").String)
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, `# ccredis configuration
+
+# network
+port `+strconv.Itoa(port)+`
+include common.conf
+
+maxclients 200
+# timeout is set twice, the second one goes
+timeout 20
+save ""
+# Generated by CONFIG REWRITE
+notify-keyspace-events AKE
+tcp-keepalive 30
+slowlog-max-len 1000
+`, string(data), "parameters of included files are appended only when they changed")
+
+	// rewriting again doesn't repeat the generated lines
+	require.Equal(t, "OK", n.do(t, "CONFIG", "REWRITE").String)
+	again, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, string(data), string(again))
+
+	srv.Close()
+	srv, err = NewConfiguredServer(path, nil)
+	require.NoError(t, err)
+	require.Equal(t, "200", lookupConfigParam("maxclients").get(srv))
+	require.Equal(t, "", lookupConfigParam("save").get(srv))
+	require.Equal(t, "AKE", lookupConfigParam("notify-keyspace-events").get(srv))
+	require.Equal(t, "1000", lookupConfigParam("slowlog-max-len").get(srv))
+	require.Equal(t, "30", lookupConfigParam("tcp-keepalive").get(srv))
+
+	m := startTestServer(t, Config{})
+	require.Equal(t, "ERR The server is running without a config file", m.do(t, "CONFIG", "REWRITE").String)
+}
@@ -0,0 +1,40 @@
+package redis
+
+import "strings"
+
+const clusterSlots = 16384
+
+// CRC16-CCITT (XMODEM), the variant Redis Cluster uses to map keys to hash slots
+var crc16tab = func() (tab [256]uint16) {
+	for i := range tab {
+		crc := uint16(i) << 8
+		for range 8 {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		tab[i] = crc
+	}
+	return tab
+}()
+
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc = crc<<8 ^ crc16tab[byte(crc>>8)^s[i]]
+	}
+	return crc
+}
+
+// keyHashSlot returns the slot of key. If the key contains a non empty {hashtag}
+// only the hashtag is hashed, so related keys can be forced into the same slot.
+func keyHashSlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start != -1 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key) % clusterSlots)
+}
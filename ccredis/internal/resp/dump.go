@@ -0,0 +1,197 @@
+package redis
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc64"
+	"math"
+)
+
+// DUMP payloads follow the layout Redis uses, but not its RDB encoding of the value:
+//
+//	type byte | value | version (uint16 LE) | CRC-64 of everything before (uint64 LE)
+//
+// Strings are a uvarint length and the bytes, aggregates a uvarint count followed by
+// their strings (field and value for hashes, member and the float64 bits of the score
+// for sorted sets). RESTORE rebuilds aggregates through the
+// regular insert paths, so the restored value gets the encoding its size calls for.
+const dumpVersion = 1
+
+// type bytes, the RDB type ids of the same types
+const (
+	dumpTypeString = 0
+	dumpTypeList   = 1
+	dumpTypeSet    = 2
+	dumpTypeHash   = 4
+	dumpTypeZset   = 5
+)
+
+var crcTable = crc64.MakeTable(crc64.ECMA)
+
+var (
+	errDumpChecksum = errors.New("DUMP payload version or checksum are wrong")
+	errDumpFormat   = errors.New("Bad data format")
+)
+
+func dumpValue(item *RedisItem) []byte {
+	var b []byte
+	appendStr := func(s string) {
+		b = binary.AppendUvarint(b, uint64(len(s)))
+		b = append(b, s...)
+	}
+
+	switch item.itemType {
+	case REDIS_STRING:
+		b = append(b, dumpTypeString)
+		appendStr(item.str())
+	case REDIS_LIST:
+		b = append(b, dumpTypeList)
+		b = binary.AppendUvarint(b, uint64(listLen(item)))
+		switch v := item.value.(type) {
+		case *listpack:
+			for _, e := range v.entries {
+				appendStr(e)
+			}
+		case *quicklist:
+			v.each(appendStr)
+		}
+	case REDIS_SET:
+		b = append(b, dumpTypeSet)
+		members := setMembers(item)
+		b = binary.AppendUvarint(b, uint64(len(members)))
+		for _, m := range members {
+			appendStr(m)
+		}
+	case REDIS_ZSET:
+		b = append(b, dumpTypeZset)
+		b = binary.AppendUvarint(b, uint64(zsetLen(item)))
+		zsetEach(item, func(m string, score float64) {
+			appendStr(m)
+			b = binary.LittleEndian.AppendUint64(b, math.Float64bits(score))
+		})
+	case REDIS_HASH:
+		b = append(b, dumpTypeHash)
+		b = binary.AppendUvarint(b, uint64(hashLen(item)))
+		hashEach(item, func(f, v string) {
+			appendStr(f)
+			appendStr(v)
+		})
+	}
+
+	return appendDumpFooter(b)
+}
+
+// appendDumpFooter appends the version and checksum that end every payload
+func appendDumpFooter(b []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, dumpVersion)
+	return binary.LittleEndian.AppendUint64(b, crc64.Checksum(b, crcTable))
+}
+
+// checkDumpFooter verifies the version and checksum of payload and returns what they cover
+func checkDumpFooter(payload []byte) ([]byte, error) {
+	if len(payload) < 10 {
+		return nil, errDumpChecksum
+	}
+	body, footer := payload[:len(payload)-10], payload[len(payload)-10:]
+	version := binary.LittleEndian.Uint16(footer)
+	sum := binary.LittleEndian.Uint64(footer[2:])
+	if version > dumpVersion || crc64.Checksum(payload[:len(payload)-8], crcTable) != sum {
+		return nil, errDumpChecksum
+	}
+	return body, nil
+}
+
+// dumpReader decodes the body of a payload, the first error sticks
+type dumpReader struct {
+	b   []byte
+	err error
+}
+
+func (r *dumpReader) uvarint() int {
+	if r.err != nil {
+		return 0
+	}
+	n, size := binary.Uvarint(r.b)
+	if size <= 0 || n > uint64(len(r.b)) {
+		r.err = errDumpFormat
+		return 0
+	}
+	r.b = r.b[size:]
+	return int(n)
+}
+
+func (r *dumpReader) str() string {
+	n := r.uvarint()
+	if r.err != nil || n > len(r.b) {
+		r.err = errDumpFormat
+		return ""
+	}
+	s := string(r.b[:n])
+	r.b = r.b[n:]
+	return s
+}
+
+func (r *dumpReader) float() float64 {
+	if r.err != nil || len(r.b) < 8 {
+		r.err = errDumpFormat
+		return 0
+	}
+	f := math.Float64frombits(binary.LittleEndian.Uint64(r.b))
+	r.b = r.b[8:]
+	return f
+}
+
+// restoreValue verifies the version and checksum of payload and decodes it into a new item
+func restoreValue(l *encodingLimits, payload []byte) (*RedisItem, error) {
+	body, err := checkDumpFooter(payload)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) == 0 {
+		return nil, errDumpChecksum
+	}
+
+	r := &dumpReader{b: body[1:]}
+	var item *RedisItem
+	switch body[0] {
+	case dumpTypeString:
+		item = newStringItem(r.str())
+	case dumpTypeList:
+		item = newItem(REDIS_LIST, &listpack{})
+		for n := r.uvarint(); n > 0 && r.err == nil; n-- {
+			listPush(l, item, r.str(), false)
+		}
+	case dumpTypeSet:
+		n := r.uvarint()
+		for i := 0; i < n && r.err == nil; i++ {
+			m := r.str()
+			if item == nil {
+				item = newSetItem(m)
+			}
+			setAdd(l, item, m)
+		}
+	case dumpTypeHash:
+		item = newItem(REDIS_HASH, &listpack{})
+		for n := r.uvarint(); n > 0 && r.err == nil; n-- {
+			f := r.str()
+			hashSet(l, item, f, r.str())
+		}
+	case dumpTypeZset:
+		item = newItem(REDIS_ZSET, &listpack{})
+		for n := r.uvarint(); n > 0 && r.err == nil; n-- {
+			m := r.str()
+			score := r.float()
+			if math.IsNaN(score) {
+				return nil, errDumpFormat
+			}
+			zsetAdd(l, item, m, score)
+		}
+	default:
+		return nil, errDumpFormat
+	}
+
+	if r.err != nil || len(r.b) != 0 || item == nil {
+		return nil, errDumpFormat
+	}
+	return item, nil
+}
@@ -0,0 +1,178 @@
+package redis
+
+import (
+	"slices"
+	"strconv"
+	"sync/atomic"
+)
+
+// encodingLimits decide when a small aggregate converts to its full structure, they
+// are CONFIG SET-able and read by every write to an aggregate
+type encodingLimits struct {
+	hashMaxListpackEntries atomic.Int64
+	hashMaxListpackValue   atomic.Int64
+	setMaxIntsetEntries    atomic.Int64
+	setMaxListpackEntries  atomic.Int64
+	setMaxListpackValue    atomic.Int64
+	listMaxListpackSize    atomic.Int64
+	zsetMaxListpackEntries atomic.Int64
+	zsetMaxListpackValue   atomic.Int64
+	hllSparseMaxBytes      atomic.Int64
+}
+
+func newEncodingLimits() *encodingLimits {
+	l := &encodingLimits{}
+	l.hashMaxListpackEntries.Store(128)
+	l.hashMaxListpackValue.Store(64)
+	l.setMaxIntsetEntries.Store(512)
+	l.setMaxListpackEntries.Store(128)
+	l.setMaxListpackValue.Store(64)
+	l.listMaxListpackSize.Store(128)
+	l.zsetMaxListpackEntries.Store(128)
+	l.zsetMaxListpackValue.Store(64)
+	l.hllSparseMaxBytes.Store(3000)
+	return l
+}
+
+// listpack is a flat sequence of strings. Hashes store field value pairs, sets their
+// members and lists their elements. Lookups are linear, which is fast for the small
+// sizes it is used for and saves the per entry overhead of a map.
+type listpack struct {
+	entries []string
+}
+
+func (lp *listpack) index(s string) int {
+	return slices.Index(lp.entries, s)
+}
+
+// intset is a sorted set of integers, used by sets whose members are all integers
+type intset struct {
+	members []int64
+}
+
+func (is *intset) find(n int64) (int, bool) {
+	return slices.BinarySearch(is.members, n)
+}
+
+func (is *intset) add(n int64) bool {
+	i, found := is.find(n)
+	if found {
+		return false
+	}
+	is.members = slices.Insert(is.members, i, n)
+	return true
+}
+
+func (is *intset) remove(n int64) bool {
+	i, found := is.find(n)
+	if !found {
+		return false
+	}
+	is.members = slices.Delete(is.members, i, i+1)
+	return true
+}
+
+// width is the bytes per member, Redis upgrades the whole intset to the widest member
+func (is *intset) width() int {
+	w := 2
+	for _, n := range is.members {
+		switch {
+		case n < -1<<31 || n > 1<<31-1:
+			return 8
+		case n < -1<<15 || n > 1<<15-1:
+			w = 4
+		}
+	}
+	return w
+}
+
+func (is *intset) strings() []string {
+	s := make([]string, len(is.members))
+	for i, n := range is.members {
+		s[i] = strconv.FormatInt(n, 10)
+	}
+	return s
+}
+
+// quicklist is a list of listpack nodes holding at most nodeSize elements each, so
+// pushes and pops at both ends stay cheap while the list keeps a compact layout
+type quicklist struct {
+	nodes    [][]string
+	n        int
+	nodeSize int
+}
+
+func newQuicklist(nodeSize int, elems []string) *quicklist {
+	ql := &quicklist{nodeSize: max(nodeSize, 1)}
+	for _, e := range elems {
+		ql.pushBack(e)
+	}
+	return ql
+}
+
+func (ql *quicklist) pushFront(s string) {
+	if len(ql.nodes) == 0 || len(ql.nodes[0]) >= ql.nodeSize {
+		ql.nodes = slices.Insert(ql.nodes, 0, make([]string, 0, ql.nodeSize))
+	}
+	ql.nodes[0] = slices.Insert(ql.nodes[0], 0, s)
+	ql.n++
+}
+
+func (ql *quicklist) pushBack(s string) {
+	last := len(ql.nodes) - 1
+	if last < 0 || len(ql.nodes[last]) >= ql.nodeSize {
+		ql.nodes = append(ql.nodes, make([]string, 0, ql.nodeSize))
+		last++
+	}
+	ql.nodes[last] = append(ql.nodes[last], s)
+	ql.n++
+}
+
+func (ql *quicklist) popFront() string {
+	node := ql.nodes[0]
+	s := node[0]
+	if len(node) == 1 {
+		ql.nodes = ql.nodes[1:]
+	} else {
+		ql.nodes[0] = node[1:]
+	}
+	ql.n--
+	return s
+}
+
+func (ql *quicklist) popBack() string {
+	last := len(ql.nodes) - 1
+	node := ql.nodes[last]
+	s := node[len(node)-1]
+	if len(node) == 1 {
+		ql.nodes = ql.nodes[:last]
+	} else {
+		ql.nodes[last] = node[:len(node)-1]
+	}
+	ql.n--
+	return s
+}
+
+func (ql *quicklist) index(i int) string {
+	for _, node := range ql.nodes {
+		if i < len(node) {
+			return node[i]
+		}
+		i -= len(node)
+	}
+	panic("quicklist: index out of range")
+}
+
+func (ql *quicklist) each(fn func(string)) {
+	for _, node := range ql.nodes {
+		for _, s := range node {
+			fn(s)
+		}
+	}
+}
+
+func (ql *quicklist) slice() []string {
+	s := make([]string, 0, ql.n)
+	ql.each(func(e string) { s = append(s, e) })
+	return s
+}
@@ -0,0 +1,846 @@
+package redis
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Functions are Lua libraries installed with FUNCTION LOAD and run with FCALL, like in Redis 7:
+//
+//	#!lua name=mylib
+//	redis.register_function('incr_get', function(keys, args)
+//		redis.call('INCR', keys[1])
+//		return redis.call('GET', keys[1])
+//	end)
+//
+// Every library has a Lua state of its own with only the base, table, string and math
+// libraries. FCALL holds the server exclusively like EXEC, so a function is atomic and a
+// state is never used by two goroutines at once. A function reaches the replicas and the AOF
+// as the write commands it ran, wrapped in MULTI/EXEC, and libraries are saved in snapshots.
+//
+// Once a function has run for busy-reply-threshold other clients get a BUSY error instead of
+// waiting for it, and FUNCTION KILL stops it as long as it wrote nothing. SHUTDOWN NOSAVE
+// stops it in any case.
+
+// functionLoadTimeout bounds running the code of a library on FUNCTION LOAD
+const functionLoadTimeout = 500 * time.Millisecond
+
+// states of a runningFunction
+const (
+	functionRunning = iota
+	functionWrote
+	functionKilled
+)
+
+// runningFunction is the function FCALL is running, FUNCTION KILL cancels its context
+type runningFunction struct {
+	start  time.Time
+	cancel context.CancelFunc
+	state  atomic.Int32
+}
+
+var (
+	errFunctionNotBusy    = errors.New("NOTBUSY No scripts in execution right now.")
+	errFunctionUnkillable = errors.New("UNKILLABLE Sorry the script already executed write commands against the dataset. You can either wait the script termination or kill the server in a hard way using the SHUTDOWN NOSAVE command.")
+)
+
+// functionBusy reports whether a function has been running for busy-reply-threshold
+func (s *Server) functionBusy() bool {
+	run := s.runningFunction.Load()
+	return run != nil && time.Since(run.start) >= time.Duration(s.busyReplyThreshold.Load())*time.Millisecond
+}
+
+// killFunction stops the running function, unless it wrote something and force is false
+func (s *Server) killFunction(force bool) error {
+	run := s.runningFunction.Load()
+	if run == nil {
+		return errFunctionNotBusy
+	}
+	if !run.state.CompareAndSwap(functionRunning, functionKilled) {
+		if !force {
+			return errFunctionUnkillable
+		}
+		run.state.Store(functionKilled)
+	}
+	run.cancel()
+	return nil
+}
+
+// flags a function may be registered with, only no-writes changes what it can do here
+var functionFlags = []string{"no-writes", "allow-oom", "allow-stale", "no-cluster", "allow-cross-slot-keys"}
+
+// dumpTypeFunction starts a library in a FUNCTION DUMP payload, the RDB opcode of functions
+const dumpTypeFunction = 245
+
+type functions struct {
+	mu        sync.Mutex
+	libraries map[string]*library
+	// the functions of every library, names are unique across libraries
+	byName map[string]*function
+}
+
+type library struct {
+	name  string
+	code  string
+	state *lua.LState
+	// in registration order
+	funcs []*function
+
+	// the client of the running FCALL and whether the call may write
+	caller   *Client
+	readOnly bool
+}
+
+type function struct {
+	name        string
+	description string
+	flags       []string
+	lib         *library
+	callback    *lua.LFunction
+}
+
+func newFunctions() *functions {
+	return &functions{libraries: make(map[string]*library), byName: make(map[string]*function)}
+}
+
+// add registers libs, with replace a library replaces the one of the same name. Nothing is
+// registered when a library or function name is taken.
+func (f *functions) add(libs []*library, replace bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	libraries := maps.Clone(f.libraries)
+	for _, lib := range libs {
+		if _, ok := libraries[lib.name]; ok && !replace {
+			return fmt.Errorf("Library '%s' already exists", lib.name)
+		}
+		libraries[lib.name] = lib
+	}
+	byName := make(map[string]*function)
+	for _, lib := range libraries {
+		for _, fn := range lib.funcs {
+			if _, ok := byName[fn.name]; ok {
+				return fmt.Errorf("Function %s already exists", fn.name)
+			}
+			byName[fn.name] = fn
+		}
+	}
+	f.libraries, f.byName = libraries, byName
+	return nil
+}
+
+// delete removes a library and its functions, it reports whether the library existed
+func (f *functions) delete(name string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	lib, ok := f.libraries[name]
+	if !ok {
+		return false
+	}
+	delete(f.libraries, name)
+	for _, fn := range lib.funcs {
+		delete(f.byName, fn.name)
+	}
+	return true
+}
+
+func (f *functions) flush() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.libraries = make(map[string]*library)
+	f.byName = make(map[string]*function)
+}
+
+func (f *functions) lookup(name string) *function {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.byName[name]
+}
+
+// list returns the libraries sorted by name
+func (f *functions) list() []*library {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.SortedFunc(maps.Values(f.libraries), func(a, b *library) int { return strings.Compare(a.name, b.name) })
+}
+
+// codes returns the code of every library, what snapshots and FUNCTION DUMP save
+func (f *functions) codes() []string {
+	libs := f.list()
+	codes := make([]string, len(libs))
+	for i, lib := range libs {
+		codes[i] = lib.code
+	}
+	return codes
+}
+
+// dump encodes every library like a DUMP payload, see dump.go
+func (f *functions) dump() []byte {
+	var b []byte
+	for _, code := range f.codes() {
+		b = append(b, dumpTypeFunction)
+		b = binary.AppendUvarint(b, uint64(len(code)))
+		b = append(b, code...)
+	}
+	return appendDumpFooter(b)
+}
+
+// restoreFunctions decodes a FUNCTION DUMP payload and loads its libraries
+func restoreFunctions(payload []byte) ([]*library, error) {
+	body, err := checkDumpFooter(payload)
+	if err != nil {
+		return nil, errors.New("payload version or checksum are wrong")
+	}
+	var libs []*library
+	r := &dumpReader{b: body}
+	for len(r.b) > 0 {
+		if r.b[0] != dumpTypeFunction {
+			return nil, errors.New("given type is not a function")
+		}
+		r.b = r.b[1:]
+		code := r.str()
+		if r.err != nil {
+			return nil, errors.New("payload version or checksum are wrong")
+		}
+		lib, err := loadLibrary(code)
+		if err != nil {
+			return nil, err
+		}
+		libs = append(libs, lib)
+	}
+	return libs, nil
+}
+
+// parseLibraryMetadata reads the name of a library from its shebang line, "#!lua name=<name>",
+// and returns the code with that line blanked so line numbers in errors stay right
+func parseLibraryMetadata(code string) (name, body string, err error) {
+	if !strings.HasPrefix(code, "#!") {
+		return "", "", errors.New("Missing library metadata")
+	}
+	shebang, rest, _ := strings.Cut(code, "\n")
+	fields := strings.Fields(shebang[2:])
+	if len(fields) == 0 {
+		return "", "", errors.New("Missing library metadata")
+	}
+	if fields[0] != "lua" {
+		return "", "", fmt.Errorf("Engine '%s' not found", fields[0])
+	}
+	for _, field := range fields[1:] {
+		v, ok := strings.CutPrefix(field, "name=")
+		if !ok {
+			return "", "", fmt.Errorf("Invalid metadata value given: %s", field)
+		}
+		name = v
+	}
+	if name == "" {
+		return "", "", errors.New("Library name was not given")
+	}
+	if !validFunctionName(name) {
+		return "", "", errors.New("Library names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+	}
+	return name, "\n" + rest, nil
+}
+
+func validFunctionName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// newLuaState returns a state with the libraries functions may use, nothing that reaches the
+// file system or the process
+func newLuaState() *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range []string{"dofile", "loadfile", "require", "module"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+	return L
+}
+
+// loadLibrary compiles code and runs it to collect the functions it registers
+func loadLibrary(code string) (*library, error) {
+	name, body, err := parseLibraryMetadata(code)
+	if err != nil {
+		return nil, err
+	}
+	lib := &library{name: name, code: code, state: newLuaState()}
+	L := lib.state
+
+	chunk, err := L.LoadString(body)
+	if err != nil {
+		return nil, fmt.Errorf("Error compiling function: %s", luaErrorMessage(err))
+	}
+
+	L.SetGlobal("redis", lib.api(true))
+	ctx, cancel := context.WithTimeout(context.Background(), functionLoadTimeout)
+	defer cancel()
+	L.SetContext(ctx)
+	L.Push(chunk)
+	err = L.PCall(0, 0, nil)
+	L.RemoveContext()
+	if ctx.Err() != nil {
+		return nil, errors.New("FUNCTION LOAD timeout")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error registering functions: %s", luaErrorMessage(err))
+	}
+	if len(lib.funcs) == 0 {
+		return nil, errors.New("No functions registered")
+	}
+
+	L.SetGlobal("redis", lib.api(false))
+	return lib, nil
+}
+
+// api builds the redis table of the library. While loading it registers functions, once
+// loaded it runs commands.
+func (lib *library) api(loading bool) *lua.LTable {
+	L := lib.state
+	api := L.NewTable()
+	L.SetFuncs(api, map[string]lua.LGFunction{
+		"error_reply": func(L *lua.LState) int {
+			L.Push(replyTable(L, "err", L.CheckString(1)))
+			return 1
+		},
+		"status_reply": func(L *lua.LState) int {
+			L.Push(replyTable(L, "ok", L.CheckString(1)))
+			return 1
+		},
+		"log": func(L *lua.LState) int {
+			L.CheckInt(1)
+			parts := make([]string, 0, L.GetTop()-1)
+			for i := 2; i <= L.GetTop(); i++ {
+				parts = append(parts, L.ToStringMeta(L.Get(i)).String())
+			}
+			log.Printf("function library %s: %s", lib.name, strings.Join(parts, " "))
+			return 0
+		},
+	})
+	for i, level := range []string{"LOG_DEBUG", "LOG_VERBOSE", "LOG_NOTICE", "LOG_WARNING"} {
+		api.RawSetString(level, lua.LNumber(i))
+	}
+
+	if loading {
+		api.RawSetString("register_function", L.NewFunction(lib.registerFunction))
+		return api
+	}
+	L.SetFuncs(api, map[string]lua.LGFunction{
+		"call":  func(L *lua.LState) int { return lib.redisCall(L, true) },
+		"pcall": func(L *lua.LState) int { return lib.redisCall(L, false) },
+		"register_function": func(L *lua.LState) int {
+			L.RaiseError("redis.register_function can only be called on FUNCTION LOAD command")
+			return 0
+		},
+	})
+	return api
+}
+
+// registerFunction is redis.register_function(name, callback), or the table form
+// redis.register_function{function_name=..., callback=..., flags={...}, description=...}
+func (lib *library) registerFunction(L *lua.LState) int {
+	f := &function{lib: lib, flags: []string{}}
+	switch L.GetTop() {
+	case 1:
+		L.CheckTable(1).ForEach(func(k, v lua.LValue) {
+			switch lua.LVAsString(k) {
+			case "function_name":
+				s, ok := v.(lua.LString)
+				if !ok {
+					L.RaiseError("function_name argument given to redis.register_function must be a string")
+				}
+				f.name = string(s)
+			case "callback":
+				fn, ok := v.(*lua.LFunction)
+				if !ok {
+					L.RaiseError("callback argument given to redis.register_function must be a function")
+				}
+				f.callback = fn
+			case "description":
+				s, ok := v.(lua.LString)
+				if !ok {
+					L.RaiseError("description argument given to redis.register_function must be a string")
+				}
+				f.description = string(s)
+			case "flags":
+				t, ok := v.(*lua.LTable)
+				if !ok {
+					L.RaiseError("flags argument to redis.register_function must be a table representing function flags")
+				}
+				for i := 1; i <= t.Len(); i++ {
+					flag := lua.LVAsString(t.RawGetInt(i))
+					if !slices.Contains(functionFlags, flag) {
+						L.RaiseError("unknown flag given")
+					}
+					f.flags = append(f.flags, flag)
+				}
+			default:
+				L.RaiseError("unknown argument given to redis.register_function")
+			}
+		})
+		if f.name == "" {
+			L.RaiseError("redis.register_function must get a function name argument")
+		}
+		if f.callback == nil {
+			L.RaiseError("redis.register_function must get a callback argument")
+		}
+	case 2:
+		f.name = L.CheckString(1)
+		f.callback = L.CheckFunction(2)
+	default:
+		L.RaiseError("wrong number of arguments to redis.register_function")
+	}
+
+	if !validFunctionName(f.name) {
+		L.RaiseError("Function names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+	}
+	if slices.ContainsFunc(lib.funcs, func(g *function) bool { return g.name == f.name }) {
+		L.RaiseError("Function already exists in the library")
+	}
+	lib.funcs = append(lib.funcs, f)
+	return 0
+}
+
+// redisCall runs a command for redis.call, or with raise unset for redis.pcall, which
+// returns errors as a table instead of raising them
+func (lib *library) redisCall(L *lua.LState, raise bool) int {
+	n := L.GetTop()
+	if n == 0 {
+		L.RaiseError("Please specify at least one argument for this redis lib call")
+	}
+	args := make([]Value, n)
+	for i := range n {
+		switch v := L.Get(i + 1).(type) {
+		case lua.LString, lua.LNumber:
+			args[i] = bulkVal(v.String())
+		default:
+			L.RaiseError("Lua redis lib command arguments must be strings or integers")
+		}
+	}
+
+	res := lib.caller.scriptCall(args, lib.readOnly)
+	if res.Type == "error" && raise {
+		L.Error(replyTable(L, "err", res.String), 1)
+	}
+	L.Push(toLua(L, res))
+	return 1
+}
+
+// scriptCall runs a command on behalf of a function with the checks dispatch does for a
+// client, and those a function is subject to
+func (c *Client) scriptCall(args []Value, readOnly bool) Value {
+	name := strings.ToUpper(args[0].Bulk)
+	cmd, ok := commands[name]
+	if !ok {
+		return errVal("Unknown Redis command called from script")
+	}
+	if cmd.flags&(cmdNoScript|cmdNoMulti|cmdBlocking) != 0 {
+		return errVal("This Redis command is not allowed from script")
+	}
+	if cmd.flags&cmdWrite != 0 {
+		if readOnly {
+			return errVal("Write commands are not allowed from read-only scripts.")
+		}
+		if !c.replicated && c.srv.repl.link.Load() != nil {
+			return errCode("READONLY", "You can't write against a read only replica.")
+		}
+		// from here on FUNCTION KILL leaves the function alone, it would leave half its writes
+		if run := c.srv.runningFunction.Load(); run != nil && run.state.Swap(functionWrote) == functionKilled {
+			run.state.Store(functionKilled)
+			return errVal("Script killed by user with FUNCTION KILL...")
+		}
+	}
+	return c.call(name, Handlers[name], args)
+}
+
+// call runs the function with the server held exclusively by the caller
+func (f *function) call(c *Client, keys, args []Value, readOnly bool) Value {
+	lib := f.lib
+	L := lib.state
+	lib.caller, lib.readOnly = c, readOnly
+	defer func() { lib.caller = nil }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	run := &runningFunction{start: time.Now(), cancel: cancel}
+	c.srv.runningFunction.Store(run)
+	defer c.srv.runningFunction.Store(nil)
+	L.SetContext(ctx)
+	defer L.RemoveContext()
+
+	err := L.CallByParam(lua.P{Fn: f.callback, NRet: 1, Protect: true}, bulkTable(L, keys), bulkTable(L, args))
+	if run.state.Load() == functionKilled {
+		L.SetTop(0)
+		return errVal("Script killed by user with FUNCTION KILL...")
+	}
+	if err != nil {
+		var apiErr *lua.ApiError
+		if errors.As(err, &apiErr) {
+			if t, ok := apiErr.Object.(*lua.LTable); ok {
+				if e, ok := t.RawGetString("err").(lua.LString); ok {
+					return Value{Type: "error", String: errorLine(string(e))}
+				}
+			}
+		}
+		return errVal(luaErrorMessage(err))
+	}
+	ret := L.Get(-1)
+	L.Pop(1)
+	return fromLua(ret, 0)
+}
+
+func (f *function) hasFlag(flag string) bool {
+	return slices.Contains(f.flags, flag)
+}
+
+// luaErrorMessage is the error without the Lua stack trace, on a single line
+func luaErrorMessage(err error) string {
+	var apiErr *lua.ApiError
+	if errors.As(err, &apiErr) {
+		return errorLine(apiErr.Object.String())
+	}
+	return errorLine(err.Error())
+}
+
+// errorLine makes msg fit a status or error reply, which ends at the first CRLF
+func errorLine(msg string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(msg)
+}
+
+func replyTable(L *lua.LState, field, msg string) *lua.LTable {
+	t := L.NewTable()
+	t.RawSetString(field, lua.LString(msg))
+	return t
+}
+
+func bulkTable(L *lua.LState, vals []Value) *lua.LTable {
+	t := L.CreateTable(len(vals), 0)
+	for _, v := range vals {
+		t.Append(lua.LString(v.Bulk))
+	}
+	return t
+}
+
+// toLua converts a reply the way Redis hands it to Lua: status and error replies become
+// tables with an ok or err field and a null becomes false
+func toLua(L *lua.LState, v Value) lua.LValue {
+	switch v.Type {
+	case "integer":
+		return lua.LNumber(v.Int)
+	case "bulk":
+		return lua.LString(v.Bulk)
+	case "string":
+		return replyTable(L, "ok", v.String)
+	case "error":
+		return replyTable(L, "err", v.String)
+	case "array", "push", "map":
+		t := L.CreateTable(len(v.Array), 0)
+		for _, e := range v.Array {
+			t.Append(toLua(L, e))
+		}
+		return t
+	default:
+		return lua.LFalse
+	}
+}
+
+// maxLuaReplyDepth bounds the nesting of a returned table, which may also refer to itself
+const maxLuaReplyDepth = 512
+
+// fromLua converts what a function returned to a reply the way Redis does: numbers are
+// truncated to integers, true is 1, false and nil are null and a table is an array up to
+// its first nil, unless it has an err or ok field
+func fromLua(lv lua.LValue, depth int) Value {
+	switch v := lv.(type) {
+	case lua.LNumber:
+		return intVal(int(v))
+	case lua.LString:
+		return bulkVal(string(v))
+	case lua.LBool:
+		if v {
+			return intVal(1)
+		}
+		return nullVal()
+	case *lua.LTable:
+		if e, ok := v.RawGetString("err").(lua.LString); ok {
+			return Value{Type: "error", String: errorLine(string(e))}
+		}
+		if s, ok := v.RawGetString("ok").(lua.LString); ok {
+			return strVal(errorLine(string(s)))
+		}
+		if depth == maxLuaReplyDepth {
+			return errVal("reached lua stack limit")
+		}
+		arr := Value{Type: "array", Array: []Value{}}
+		for i := 1; ; i++ {
+			e := v.RawGetInt(i)
+			if e == lua.LNil {
+				break
+			}
+			arr.Array = append(arr.Array, fromLua(e, depth+1))
+		}
+		return arr
+	default:
+		return nullVal()
+	}
+}
+
+func isFunctionKill(args []Value) bool {
+	return len(args) == 2 && strings.EqualFold(args[0].Bulk, "FUNCTION") && strings.EqualFold(args[1].Bulk, "KILL")
+}
+
+// FCALL function numkeys [key [key ...]] [arg [arg ...]]
+func FCALL(c *Client, args []Value) Value {
+	return fcall(c, args, false)
+}
+
+// FCALL_RO function numkeys [key [key ...]] [arg [arg ...]], only for no-writes functions
+func FCALL_RO(c *Client, args []Value) Value {
+	return fcall(c, args, true)
+}
+
+func fcall(c *Client, args []Value, readOnly bool) Value {
+	numkeys, err := strconv.Atoi(args[1].Bulk)
+	if err != nil {
+		return errVal("value is not an integer or out of range")
+	}
+	if numkeys < 0 {
+		return errVal("Number of keys can't be negative")
+	}
+	if numkeys > len(args)-2 {
+		return errVal("Number of keys can't be greater than number of args")
+	}
+	keys, rest := args[2:2+numkeys], args[2+numkeys:]
+
+	return c.atomically(func() Value {
+		f := c.srv.functions.lookup(args[0].Bulk)
+		if f == nil {
+			return errVal("Function not found")
+		}
+		noWrites := f.hasFlag("no-writes")
+		if readOnly && !noWrites {
+			return errVal("Can not execute a script with write flag using *_ro command.")
+		}
+		return f.call(c, keys, rest, readOnly || noWrites)
+	})
+}
+
+// FUNCTION LOAD [REPLACE] code | LIST [WITHCODE] [LIBRARYNAME pattern] | DELETE library |
+// DUMP | RESTORE payload [FLUSH|APPEND|REPLACE] | FLUSH [ASYNC|SYNC]
+func FUNCTION(c *Client, args []Value) Value {
+	sub := strings.ToUpper(args[0].Bulk)
+	args = args[1:]
+	switch sub {
+	case "HELP":
+		return helpVal("FUNCTION",
+			"LOAD [REPLACE] <FUNCTION CODE>",
+			"    Create a new library with the given library name and code.",
+			"DELETE <LIBRARY NAME>",
+			"    Delete the given library.",
+			"LIST [LIBRARYNAME PATTERN] [WITHCODE]",
+			"    Return general information on all the libraries:",
+			"    * Library name",
+			"    * The engine used to run the Library",
+			"    * Set of functions",
+			"    * Library code (if WITHCODE is given)",
+			"    It also possible to get only function that matches a pattern using LIBRARYNAME argument.",
+			"DUMP",
+			"    Return a serialized payload representing the current libraries, can be restored using FUNCTION RESTORE command",
+			"RESTORE <PAYLOAD> [FLUSH|APPEND|REPLACE]",
+			"    Restore the libraries represented by the given payload, it is possible to give a restore policy to",
+			"    control how to handle existing libraries (default APPEND):",
+			"    * FLUSH: delete all existing libraries.",
+			"    * APPEND: appends the restored libraries to the existing libraries. On collision, abort.",
+			"    * REPLACE: appends the restored libraries to the existing libraries, On collision, replace the old",
+			"      libraries with the new libraries (notice that even on this option there is a chance of failure",
+			"      in case of functions name collision with another library).",
+			"FLUSH [ASYNC|SYNC]",
+			"    Delete all the libraries.",
+			"KILL",
+			"    Kill a function that is currently executing.",
+		)
+	case "LIST":
+		return functionList(c, args)
+	case "KILL":
+		if len(args) != 0 {
+			return errWrongArgs("function|kill")
+		}
+		if err := c.srv.killFunction(false); err != nil {
+			code, msg, _ := strings.Cut(err.Error(), " ")
+			return errCode(code, msg)
+		}
+		return ok()
+	case "DUMP":
+		if len(args) != 0 {
+			return errWrongArgs("function|dump")
+		}
+		return bulkVal(string(c.srv.functions.dump()))
+	case "LOAD", "DELETE", "RESTORE", "FLUSH":
+		if !c.replicated && c.srv.repl.link.Load() != nil {
+			return errCode("READONLY", "You can't write against a read only replica.")
+		}
+		return functionWrite(c, sub, args)
+	default:
+		return errVal(fmt.Sprintf("unknown subcommand '%s'. Try FUNCTION HELP.", strings.ToLower(sub)))
+	}
+}
+
+// functionWrite runs the subcommands that change the libraries
+func functionWrite(c *Client, sub string, args []Value) Value {
+	fns := c.srv.functions
+	// a change is made like a write to every key, so a snapshot holds exactly the libraries
+	// fed to the replication stream before it. Code is compiled before, without the lock.
+	change := func(fn func() error) Value {
+		defer c.store.lockAll()()
+		if err := fn(); err != nil {
+			return errVal(err.Error())
+		}
+		c.propagate()
+		c.srv.dirty.Add(1)
+		return Value{}
+	}
+
+	switch sub {
+	case "LOAD":
+		replace := false
+		if len(args) == 2 && strings.EqualFold(args[0].Bulk, "REPLACE") {
+			replace, args = true, args[1:]
+		}
+		if len(args) != 1 {
+			return errWrongArgs("function|load")
+		}
+		lib, err := loadLibrary(args[0].Bulk)
+		if err != nil {
+			return errVal(err.Error())
+		}
+		if res := change(func() error { return fns.add([]*library{lib}, replace) }); res.Type == "error" {
+			return res
+		}
+		return bulkVal(lib.name)
+	case "DELETE":
+		if len(args) != 1 {
+			return errWrongArgs("function|delete")
+		}
+		if res := change(func() error {
+			if !fns.delete(args[0].Bulk) {
+				return errors.New("Library not found")
+			}
+			return nil
+		}); res.Type == "error" {
+			return res
+		}
+		return ok()
+	case "RESTORE":
+		if len(args) < 1 || len(args) > 2 {
+			return errWrongArgs("function|restore")
+		}
+		policy := "APPEND"
+		if len(args) == 2 {
+			policy = strings.ToUpper(args[1].Bulk)
+			if policy != "FLUSH" && policy != "APPEND" && policy != "REPLACE" {
+				return errVal("Wrong restore policy given, value should be either FLUSH, APPEND or REPLACE.")
+			}
+		}
+		libs, err := restoreFunctions([]byte(args[0].Bulk))
+		if err != nil {
+			return errVal(err.Error())
+		}
+		if res := change(func() error {
+			if policy == "FLUSH" {
+				fns.flush()
+			}
+			return fns.add(libs, policy != "APPEND")
+		}); res.Type == "error" {
+			return res
+		}
+		return ok()
+	default:
+		if len(args) > 1 || len(args) == 1 && !strings.EqualFold(args[0].Bulk, "ASYNC") && !strings.EqualFold(args[0].Bulk, "SYNC") {
+			return syntaxErr()
+		}
+		change(func() error {
+			fns.flush()
+			return nil
+		})
+		return ok()
+	}
+}
+
+// functionList is FUNCTION LIST [WITHCODE] [LIBRARYNAME pattern]
+func functionList(c *Client, args []Value) Value {
+	withCode, pattern := false, ""
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(args[i].Bulk) {
+		case "WITHCODE":
+			withCode = true
+		case "LIBRARYNAME":
+			if i+1 == len(args) {
+				return errVal("library name argument was not given")
+			}
+			pattern = args[i+1].Bulk
+			i++
+		default:
+			return errVal(fmt.Sprintf("Unknown argument %s", args[i].Bulk))
+		}
+	}
+
+	res := Value{Type: "array", Array: []Value{}}
+	for _, lib := range c.srv.functions.list() {
+		if pattern != "" && !matchPattern(pattern, lib.name) {
+			continue
+		}
+		funcs := Value{Type: "array", Array: make([]Value, len(lib.funcs))}
+		for i, fn := range lib.funcs {
+			description := nullVal()
+			if fn.description != "" {
+				description = bulkVal(fn.description)
+			}
+			funcs.Array[i] = c.mapVal([]Value{
+				bulkVal("name"), bulkVal(fn.name),
+				bulkVal("description"), description,
+				bulkVal("flags"), {Type: "array", Array: bulkVals(fn.flags)},
+			})
+		}
+		kv := []Value{
+			bulkVal("library_name"), bulkVal(lib.name),
+			bulkVal("engine"), bulkVal("LUA"),
+			bulkVal("functions"), funcs,
+		}
+		if withCode {
+			kv = append(kv, bulkVal("library_code"), bulkVal(lib.code))
+		}
+		res.Array = append(res.Array, c.mapVal(kv))
+	}
+	return res
+}
@@ -0,0 +1,187 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testLibrary = `#!lua name=mylib
+local function incr_get(keys, args)
+	redis.call('INCRBY', keys[1], args[1])
+	return redis.call('GET', keys[1])
+end
+
+redis.register_function('incr_get', incr_get)
+redis.register_function{
+	function_name = 'peek',
+	callback = function(keys) return {redis.call('GET', keys[1]), 1.9, true, false, redis.status_reply('FINE')} end,
+	flags = {'no-writes'},
+	description = 'reads a key',
+}
+redis.register_function{
+	function_name = 'sneaky_write',
+	callback = function(keys) return redis.pcall('SET', keys[1], 'x') end,
+	flags = {'no-writes'},
+}
+redis.register_function('fail', function() return redis.call('NOPE') end)
+redis.register_function('custom_error', function() return redis.error_reply('MYERR went wrong') end)
+`
+
+func TestFunction(t *testing.T) {
+	n := startTestServer(t, Config{})
+
+	require.Equal(t, "mylib", n.do(t, "FUNCTION", "LOAD", testLibrary).Bulk)
+	require.Equal(t, "ERR Library 'mylib' already exists", n.do(t, "FUNCTION", "LOAD", testLibrary).String)
+	require.Equal(t, "mylib", n.do(t, "FUNCTION", "LOAD", "REPLACE", testLibrary).Bulk)
+
+	require.Equal(t, "5", n.do(t, "FCALL", "incr_get", "1", "counter", "5").Bulk)
+	require.Equal(t, "7", n.do(t, "FCALL", "incr_get", "1", "counter", "2").Bulk)
+	peek := n.do(t, "FCALL_RO", "peek", "1", "counter")
+	require.Equal(t, []Value{bulkVal("7"), intVal(1), intVal(1), nullVal(), strVal("FINE")}, peek.Array)
+
+	require.Equal(t, "ERR Can not execute a script with write flag using *_ro command.", n.do(t, "FCALL_RO", "incr_get", "1", "counter", "1").String)
+	require.Equal(t, "ERR Write commands are not allowed from read-only scripts.", n.do(t, "FCALL_RO", "sneaky_write", "1", "k").String)
+	require.Equal(t, "ERR Unknown Redis command called from script", n.do(t, "FCALL", "fail", "0").String)
+	require.Equal(t, "MYERR went wrong", n.do(t, "FCALL", "custom_error", "0").String)
+	require.Equal(t, "ERR Function not found", n.do(t, "FCALL", "missing", "0").String)
+	require.Equal(t, "ERR Number of keys can't be greater than number of args", n.do(t, "FCALL", "incr_get", "2", "k").String)
+	require.Equal(t, "ERR Number of keys can't be negative", n.do(t, "FCALL", "incr_get", "-1").String)
+	require.Equal(t, "null", n.do(t, "GET", "k").Type)
+
+	list := n.do(t, "FUNCTION", "LIST", "LIBRARYNAME", "my*", "WITHCODE")
+	require.Len(t, list.Array, 1)
+	lib := list.Array[0].Array
+	require.Equal(t, []string{"library_name", "mylib", "engine", "LUA"}, bulks(Value{Array: lib[:4]}))
+	require.Len(t, lib[5].Array, 5)
+	require.Equal(t, "peek", lib[5].Array[1].Array[1].Bulk)
+	require.Equal(t, "reads a key", lib[5].Array[1].Array[3].Bulk)
+	require.Equal(t, []string{"no-writes"}, bulks(lib[5].Array[1].Array[5]))
+	require.Equal(t, testLibrary, lib[7].Bulk)
+	require.Empty(t, n.do(t, "FUNCTION", "LIST", "LIBRARYNAME", "other*").Array)
+
+	// the payload of FUNCTION DUMP brings the libraries back
+	dump := n.do(t, "FUNCTION", "DUMP").Bulk
+	require.Equal(t, "ERR Library 'mylib' already exists", n.do(t, "FUNCTION", "RESTORE", dump).String)
+	require.Equal(t, "OK", n.do(t, "FUNCTION", "DELETE", "mylib").String)
+	require.Equal(t, "ERR Library not found", n.do(t, "FUNCTION", "DELETE", "mylib").String)
+	require.Equal(t, "ERR Function not found", n.do(t, "FCALL", "incr_get", "1", "counter", "1").String)
+	require.Equal(t, "OK", n.do(t, "FUNCTION", "RESTORE", dump).String)
+	require.Equal(t, "OK", n.do(t, "FUNCTION", "RESTORE", dump, "REPLACE").String)
+	require.Equal(t, "8", n.do(t, "FCALL", "incr_get", "1", "counter", "1").Bulk)
+	require.Equal(t, "ERR payload version or checksum are wrong", n.do(t, "FUNCTION", "RESTORE", dump[1:]).String)
+
+	require.Equal(t, "OK", n.do(t, "FUNCTION", "FLUSH").String)
+	require.Empty(t, n.do(t, "FUNCTION", "LIST").Array)
+	require.Equal(t, "OK", n.do(t, "FUNCTION", "RESTORE", n.do(t, "FUNCTION", "DUMP").Bulk).String, "an empty dump restores nothing")
+
+	// a function inside a transaction is part of it
+	n.do(t, "FUNCTION", "LOAD", testLibrary)
+	n.do(t, "MULTI")
+	n.do(t, "FCALL", "incr_get", "1", "counter", "2")
+	n.do(t, "INCR", "counter")
+	require.Equal(t, []Value{bulkVal("10"), intVal(11)}, n.do(t, "EXEC").Array)
+}
+
+func TestFunction_LoadErrors(t *testing.T) {
+	n := startTestServer(t, Config{})
+
+	for code, msg := range map[string]string{
+		"return 1":                           "ERR Missing library metadata",
+		"#!js name=lib\nreturn 1":            "ERR Engine 'js' not found",
+		"#!lua\nreturn 1":                    "ERR Library name was not given",
+		"#!lua name=lib foo=bar\n":           "ERR Invalid metadata value given: foo=bar",
+		"#!lua name=my-lib\n":                "ERR Library names can only contain letters, numbers, or underscores(_) and must be at least one character long",
+		"#!lua name=lib\nlocal x = 1":        "ERR No functions registered",
+		"#!lua name=lib\nredis.call('PING')": "ERR Error registering functions: <string>:2: attempt to call a non-function object",
+		"#!lua name=lib\nwhile true do end":  "ERR FUNCTION LOAD timeout",
+		"#!lua name=lib\nredis.register_function('f', function() end)\nredis.register_function('f', function() end)": "ERR Error registering functions: <string>:3: Function already exists in the library",
+		"#!lua name=lib\nredis.register_function{function_name='f', callback=function() end, flags={'bogus'}}":       "ERR Error registering functions: <string>:2: unknown flag given",
+		"#!lua name=lib\nos.exit(1)": "ERR Error registering functions: <string>:2: attempt to index a non-table object(nil) with key 'exit'",
+	} {
+		require.Equal(t, msg, n.do(t, "FUNCTION", "LOAD", code).String, code)
+	}
+	require.Contains(t, n.do(t, "FUNCTION", "LOAD", "#!lua name=lib\nend").String, "ERR Error compiling function: ")
+
+	// function names are unique across libraries
+	n.do(t, "FUNCTION", "LOAD", "#!lua name=a\nredis.register_function('f', function() return 1 end)")
+	require.Equal(t, "ERR Function f already exists", n.do(t, "FUNCTION", "LOAD", "#!lua name=b\nredis.register_function('f', function() return 2 end)").String)
+
+	n.do(t, "FUNCTION", "LOAD", "#!lua name=c\nredis.register_function('nested', function() return redis.call('FCALL', 'f', '0') end)")
+	require.Equal(t, "ERR This Redis command is not allowed from script", n.do(t, "FCALL", "nested", "0").String)
+}
+
+func TestFunction_Persistence(t *testing.T) {
+	dir := t.TempDir()
+	n := startAOFServer(t, dir)
+	n.do(t, "FUNCTION", "LOAD", testLibrary)
+	n.do(t, "FCALL", "incr_get", "1", "counter", "3")
+	n.do(t, "FLUSHALL")
+	n.do(t, "FCALL", "incr_get", "1", "counter", "4")
+
+	// the AOF replays the load, after a rewrite the snapshot preamble holds the library
+	n.srv.Close()
+	n = startAOFServer(t, dir)
+	require.Equal(t, "5", n.do(t, "FCALL", "incr_get", "1", "counter", "1").Bulk)
+	n.do(t, "BGREWRITEAOF")
+	require.Eventually(t, func() bool { return !n.srv.aofRewriteInProgress.Load() }, 5*time.Second, 10*time.Millisecond)
+	n.srv.Close()
+	n = startAOFServer(t, dir)
+	require.Equal(t, "6", n.do(t, "FCALL", "incr_get", "1", "counter", "1").Bulk)
+
+	require.Equal(t, "OK", n.do(t, "SAVE").String)
+	restored := NewServer(Config{Dir: dir, Port: freePort(t)})
+	require.NoError(t, restored.loadSnapshot())
+	m := dialTestServer(t, serveTestServer(t, restored))
+	require.Equal(t, "mylib", m.do(t, "FUNCTION", "LIST").Array[0].Array[1].Bulk)
+	require.Equal(t, "7", m.do(t, "FCALL", "incr_get", "1", "counter", "1").Bulk)
+}
+
+func TestFunction_Replication(t *testing.T) {
+	p := startTestServer(t, Config{})
+	p.do(t, "FUNCTION", "LOAD", testLibrary)
+
+	r := startReplica(t, p.srv, Config{})
+	p.do(t, "FUNCTION", "LOAD", "#!lua name=other\nredis.register_function('push', function(keys, args) redis.call('RPUSH', keys[1], args[1]); return redis.call('RPUSH', keys[1], args[2]) end)")
+	require.Equal(t, 2, p.do(t, "FCALL", "push", "1", "list", "a", "b").Int)
+	p.do(t, "FCALL", "incr_get", "1", "counter", "5")
+	require.Equal(t, 1, p.do(t, "WAIT", "1", "5000").Int)
+
+	require.Equal(t, []string{"a", "b"}, bulks(r.do(t, "LRANGE", "list", "0", "-1")))
+	require.Equal(t, "5", r.do(t, "FCALL_RO", "peek", "1", "counter").Array[0].Bulk)
+	require.Len(t, r.do(t, "FUNCTION", "LIST").Array, 2)
+	require.Equal(t, "READONLY You can't write against a read only replica.", r.do(t, "FCALL", "incr_get", "1", "counter", "1").String)
+	require.Equal(t, "READONLY You can't write against a read only replica.", r.do(t, "FUNCTION", "DELETE", "mylib").String)
+
+	p.do(t, "FUNCTION", "DELETE", "other")
+	require.Equal(t, 1, p.do(t, "WAIT", "1", "5000").Int)
+	require.Len(t, r.do(t, "FUNCTION", "LIST").Array, 1)
+}
+
+func TestFunction_Kill(t *testing.T) {
+	n := startTestServer(t, Config{})
+	caller := dialTestServer(t, n.srv)
+
+	require.Equal(t, "OK", n.do(t, "CONFIG", "SET", "busy-reply-threshold", "100").String)
+	require.Equal(t, "loops", n.do(t, "FUNCTION", "LOAD", `#!lua name=loops
+redis.register_function('spin', function() while true do end end)
+redis.register_function('write_spin', function(keys) redis.call('SET', keys[1], 'v') while true do end end)`).Bulk)
+	require.Equal(t, "NOTBUSY No scripts in execution right now.", n.do(t, "FUNCTION", "KILL").String)
+
+	// a looping function gets other clients BUSY replies until it is killed
+	caller.w.Write(Value{Type: "array", Array: cmdArgs("FCALL", "spin", "0")})
+	require.Eventually(t, func() bool { return n.srv.functionBusy() }, time.Second, time.Millisecond)
+	require.Equal(t, "BUSY", n.do(t, "GET", "k").String[:4])
+	require.Equal(t, "OK", n.do(t, "FUNCTION", "KILL").String)
+	require.Equal(t, "ERR Script killed by user with FUNCTION KILL...", caller.read(t).String)
+	require.Equal(t, "PONG", n.do(t, "PING").String)
+
+	// once it wrote it can only be stopped by shutting down without saving
+	caller.w.Write(Value{Type: "array", Array: cmdArgs("FCALL", "write_spin", "1", "k")})
+	require.Eventually(t, func() bool { return n.srv.functionBusy() }, time.Second, time.Millisecond)
+	require.Equal(t, "UNKILLABLE", n.do(t, "FUNCTION", "KILL").String[:10])
+	require.Equal(t, "BUSY", n.do(t, "SHUTDOWN").String[:4])
+	n.w.Write(Value{Type: "array", Array: cmdArgs("SHUTDOWN", "NOSAVE")})
+	requireClosed(t, n.conn)
+}
@@ -0,0 +1,521 @@
+package redis
+
+import (
+	"cmp"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EXISTS key [key ...], a key given twice is counted twice
+func EXISTS(c *Client, args []Value) Value {
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		keys[i] = arg.Bulk
+	}
+	defer c.store.rlock(keys...)()
+
+	n := 0
+	for _, key := range keys {
+		if item, ok := c.store.get(key); ok && !isExpired(item.ttl) {
+			n++
+		}
+	}
+	return intVal(n)
+}
+
+// TOUCH key [key ...] updates the access time of the keys and returns how many exist
+func TOUCH(c *Client, args []Value) Value {
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		keys[i] = arg.Bulk
+	}
+	defer c.store.rlock(keys...)()
+
+	n := 0
+	for _, key := range keys {
+		if _, ok := c.store.lookupRead(key); ok {
+			n++
+		}
+	}
+	return intVal(n)
+}
+
+// UNLINK key [key ...] is DEL that frees large values in the background
+func UNLINK(c *Client, args []Value) Value {
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		keys[i] = arg.Bulk
+	}
+
+	unlock := c.store.lock(keys...)
+	var unlinked []*RedisItem
+	for _, key := range keys {
+		if item, ok := c.store.getLive(key); ok {
+			c.store.del(key)
+			c.signalModifiedKey(key)
+			c.srv.notify(notifyGeneric, "del", key)
+			unlinked = append(unlinked, item)
+		}
+	}
+	unlock()
+
+	for _, item := range unlinked {
+		c.srv.freeAsync(item)
+	}
+	return intVal(len(unlinked))
+}
+
+const (
+	// aggregates with more elements than this are freed by the lazyfree worker
+	lazyfreeThreshold = 64
+	// values waiting for the lazyfree worker, past that they are left to the collector
+	lazyfreeQueueLen = 1024
+)
+
+// freeAsync hands a large value that is no longer reachable from the keyspace to the
+// lazyfree worker, like the lazyfree thread of Redis: the command only drops its reference
+// and the worker tears the value down, INFO reports the backlog as lazyfree_pending_objects.
+// A single worker with a bounded queue keeps a burst of UNLINKs from starting a goroutine
+// each, a value that doesn't fit in the queue is left to the collector.
+func (s *Server) freeAsync(item *RedisItem) {
+	if freeEffort(item) <= lazyfreeThreshold {
+		return
+	}
+	s.lazyfreeOnce.Do(func() {
+		s.lazyfree = make(chan *RedisItem, lazyfreeQueueLen)
+		go s.lazyfreeWorker()
+	})
+	s.lazyfreePending.Add(1)
+	select {
+	case s.lazyfree <- item:
+	default:
+		s.lazyfreePending.Add(-1)
+	}
+}
+
+func (s *Server) lazyfreeWorker() {
+	for {
+		select {
+		case item := <-s.lazyfree:
+			freeValue(item)
+			s.lazyfreePending.Add(-1)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// freeValue takes a detached value apart, unlinking the nodes of a skiplist one by one
+func freeValue(item *RedisItem) {
+	switch v := item.value.(type) {
+	case map[string]string:
+		clear(v)
+	case map[string]struct{}:
+		clear(v)
+	case *quicklist:
+		clear(v.nodes)
+		v.nodes, v.n = nil, 0
+	case *zset:
+		clear(v.dict)
+		for x := v.zsl.header; x != nil; {
+			next := x.level[0].forward
+			clear(x.level)
+			x.backward = nil
+			x = next
+		}
+	}
+	item.value = nil
+}
+
+// freeEffort is the number of allocations making up the value
+func freeEffort(item *RedisItem) int {
+	switch v := item.value.(type) {
+	case map[string]string:
+		return len(v)
+	case map[string]struct{}:
+		return len(v)
+	case *quicklist:
+		return len(v.nodes)
+	case *zset:
+		return len(v.dict)
+	default:
+		return 1
+	}
+}
+
+// RANDOMKEY
+func RANDOMKEY(c *Client, args []Value) Value {
+	shards := c.store.shards
+	start := rand.IntN(len(shards))
+
+	for i := range shards {
+		s := shards[(start+i)%len(shards)]
+		s.mu.Lock()
+		// map iteration starts at a random element, expired keys met on the way are removed
+		for key, item := range s.items {
+			if isExpired(item.ttl) {
+				c.store.expire(s, key)
+				continue
+			}
+			s.mu.Unlock()
+			return bulkVal(key)
+		}
+		s.mu.Unlock()
+	}
+	return nullVal()
+}
+
+// SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+//
+// The cursor is the index of the shard to visit in its upper 32 bits and a position in the
+// shard in the lower ones. Keys are visited in the order of their scanPos, so a call
+// examines about count keys, and a key present for the whole iteration is returned exactly
+// once. Like Redis expired keys are skipped and the scan doesn't count as an access.
+func SCAN(c *Client, args []Value) Value {
+	cursor, err := strconv.ParseUint(args[0].Bulk, 10, 64)
+	if err != nil {
+		return errVal("invalid cursor")
+	}
+	pattern, count, typ := "*", 10, ""
+	for i := 1; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			return syntaxErr()
+		}
+		switch opt := args[i+1].Bulk; strings.ToUpper(args[i].Bulk) {
+		case "MATCH":
+			pattern = opt
+		case "COUNT":
+			n, err := strconv.Atoi(opt)
+			if err != nil {
+				return errVal("value is not an integer or out of range")
+			}
+			if n < 1 {
+				return syntaxErr()
+			}
+			count = n
+		case "TYPE":
+			typ = strings.ToLower(opt)
+		default:
+			return syntaxErr()
+		}
+	}
+
+	shards := c.store.shards
+	idx, pos := cursor>>32, uint32(cursor)
+	keys := []Value{}
+	examined := 0
+	for idx < uint64(len(shards)) && examined < count {
+		snap := c.scanShard(int(idx), pos)
+		i, _ := slices.BinarySearchFunc(snap.keys, pos, func(k scanKey, pos uint32) int {
+			return cmp.Compare(k.pos, pos)
+		})
+		s := shards[idx]
+		s.mu.RLock()
+		// keys sharing a position are never split between calls, the cursor can't tell them apart
+		for ; i < len(snap.keys) && (examined < count || snap.keys[i].pos == snap.keys[i-1].pos); i++ {
+			examined++
+			key := snap.keys[i].key
+			item, ok := s.items[key]
+			if !ok || isExpired(item.ttl) || (typ != "" && item.itemType.String() != typ) || !matchPattern(pattern, key) {
+				continue
+			}
+			keys = append(keys, bulkVal(key))
+		}
+		s.mu.RUnlock()
+		if i < len(snap.keys) {
+			pos = snap.keys[i].pos
+			break
+		}
+		idx, pos = idx+1, 0
+		c.scan = nil
+	}
+	cursor = idx<<32 | uint64(pos)
+	if idx >= uint64(len(shards)) {
+		cursor = 0
+	}
+	return Value{Type: "array", Array: []Value{
+		bulkVal(strconv.FormatUint(cursor, 10)),
+		{Type: "array", Array: keys},
+	}}
+}
+
+// scanSnapshot holds the keys a shard had when a SCAN entered it, ordered by scanPos
+type scanSnapshot struct {
+	shard int
+	keys  []scanKey
+}
+
+type scanKey struct {
+	pos uint32
+	key string
+}
+
+// scanShard returns the snapshot of shard for a SCAN at pos. It is taken again whenever the
+// scan enters the shard, or continues in a shard the client has no snapshot of, e.g. with a
+// cursor of another connection. The new snapshot holds every key that was present all along,
+// so the position still tells which of them were returned already.
+func (c *Client) scanShard(shard int, pos uint32) *scanSnapshot {
+	if c.scan != nil && c.scan.shard == shard && pos != 0 {
+		return c.scan
+	}
+	s := c.store.shards[shard]
+	s.mu.RLock()
+	snap := &scanSnapshot{shard: shard, keys: make([]scanKey, 0, len(s.items))}
+	for key := range s.items {
+		snap.keys = append(snap.keys, scanKey{pos: c.store.scanPos(key), key: key})
+	}
+	s.mu.RUnlock()
+	slices.SortFunc(snap.keys, func(a, b scanKey) int {
+		return cmp.Or(cmp.Compare(a.pos, b.pos), strings.Compare(a.key, b.key))
+	})
+	c.scan = snap
+	return snap
+}
+
+// RENAME key newkey
+func RENAME(c *Client, args []Value) Value {
+	return rename(c, args[0].Bulk, args[1].Bulk, false)
+}
+
+// RENAMENX key newkey
+func RENAMENX(c *Client, args []Value) Value {
+	return rename(c, args[0].Bulk, args[1].Bulk, true)
+}
+
+// rename moves the item itself, the value and its ttl carry over to newkey
+func rename(c *Client, key, newkey string, nx bool) Value {
+	defer c.store.lock(key, newkey)()
+
+	item, found := c.store.lookup(key)
+	if !found {
+		return errVal("no such key")
+	}
+	if key == newkey {
+		if nx {
+			return intVal(0)
+		}
+		return ok()
+	}
+
+	old, exists := c.store.getLive(newkey)
+	if exists && nx {
+		return intVal(0)
+	}
+
+	c.store.del(key)
+	c.store.set(newkey, item)
+	if exists {
+		c.srv.freeAsync(old)
+	}
+
+	c.signalModifiedKey(key)
+	c.signalModifiedKey(newkey)
+	c.srv.notify(notifyGeneric, "rename_from", key)
+	c.srv.notify(notifyGeneric, "rename_to", newkey)
+
+	if nx {
+		return intVal(1)
+	}
+	return ok()
+}
+
+// COPY source destination [DB destination-db] [REPLACE]
+func COPY(c *Client, args []Value) Value {
+	src, dst := args[0].Bulk, args[1].Bulk
+	var replace bool
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i].Bulk) {
+		case "REPLACE":
+			replace = true
+		case "DB":
+			if i+1 >= len(args) {
+				return syntaxErr()
+			}
+			db, err := strconv.Atoi(args[i+1].Bulk)
+			if err != nil {
+				return errVal("value is not an integer or out of range")
+			}
+			if db != 0 {
+				return errVal("DB index is out of range")
+			}
+			i++
+		default:
+			return syntaxErr()
+		}
+	}
+	if src == dst {
+		return errVal("source and destination objects are the same")
+	}
+
+	defer c.store.lock(src, dst)()
+
+	item, ok := c.store.lookup(src)
+	if !ok {
+		return intVal(0)
+	}
+	old, exists := c.store.getLive(dst)
+	if exists && !replace {
+		return intVal(0)
+	}
+
+	dup := newItem(item.itemType, copyValue(item.value))
+	dup.ttl = item.ttl
+	c.store.set(dst, dup)
+	if exists {
+		c.srv.freeAsync(old)
+	} else {
+		c.srv.notify(notifyNew, "new", dst)
+	}
+
+	c.signalModifiedKey(dst)
+	c.srv.notify(notifyGeneric, "copy_to", dst)
+	return intVal(1)
+}
+
+// copyValue deep copies a value of any encoding
+func copyValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case []byte:
+		return slices.Clone(v)
+	case *listpack:
+		return &listpack{entries: slices.Clone(v.entries)}
+	case *intset:
+		return &intset{members: slices.Clone(v.members)}
+	case map[string]string:
+		return maps.Clone(v)
+	case map[string]struct{}:
+		return maps.Clone(v)
+	case *quicklist:
+		ql := &quicklist{nodeSize: v.nodeSize, n: v.n, nodes: make([][]string, len(v.nodes))}
+		for i, node := range v.nodes {
+			ql.nodes[i] = slices.Clone(node)
+		}
+		return ql
+	case *zset:
+		z := newZset()
+		for x := v.zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+			z.zsl.insert(x.score, x.member)
+			z.dict[x.member] = x.score
+		}
+		return z
+	default:
+		// strings and integers are immutable
+		return v
+	}
+}
+
+// DUMP key
+func DUMP(c *Client, args []Value) Value {
+	key := args[0].Bulk
+	defer c.store.rlock(key)()
+
+	item, ok := c.store.lookupRead(key)
+	if !ok {
+		return nullVal()
+	}
+	return bulkVal(string(dumpValue(item)))
+}
+
+// RESTORE key ttl serialized-value [REPLACE] [ABSTTL] [IDLETIME seconds] [FREQ frequency]
+func RESTORE(c *Client, args []Value) Value {
+	key := args[0].Bulk
+	ttlMs, err := strconv.ParseInt(args[1].Bulk, 10, 64)
+	if err != nil {
+		return errVal("value is not an integer or out of range")
+	}
+	if ttlMs < 0 {
+		return errVal("Invalid TTL value, must be >= 0")
+	}
+
+	var replace, absttl bool
+	idle, freq := int64(-1), int64(-1)
+	for i := 3; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i].Bulk); opt {
+		case "REPLACE":
+			replace = true
+		case "ABSTTL":
+			absttl = true
+		case "IDLETIME", "FREQ":
+			if i+1 >= len(args) {
+				return syntaxErr()
+			}
+			n, err := strconv.ParseInt(args[i+1].Bulk, 10, 64)
+			if err != nil {
+				return errVal("value is not an integer or out of range")
+			}
+			if opt == "IDLETIME" {
+				if n < 0 {
+					return errVal("Invalid IDLETIME value, must be >= 0")
+				}
+				idle = n
+			} else {
+				if n < 0 || n > 255 {
+					return errVal("Invalid FREQ value, must be >= 0 and <= 255")
+				}
+				freq = n
+			}
+			i++
+		default:
+			return syntaxErr()
+		}
+	}
+	if idle >= 0 && freq >= 0 {
+		return syntaxErr()
+	}
+
+	item, err := restoreValue(c.srv.encLimits, []byte(args[2].Bulk))
+	if err != nil {
+		return errVal(err.Error())
+	}
+	if ttlMs > 0 {
+		if absttl {
+			item.ttl = time.UnixMilli(ttlMs)
+		} else {
+			item.ttl = time.Now().Add(time.Duration(ttlMs) * time.Millisecond)
+			// replayed with the absolute ttl, a relative one would start over
+			rewritten := []string{"RESTORE", key, strconv.FormatInt(item.ttl.UnixMilli(), 10)}
+			for _, arg := range args[2:] {
+				rewritten = append(rewritten, arg.Bulk)
+			}
+			c.replicateAs(append(rewritten, "ABSTTL")...)
+		}
+	}
+	if idle >= 0 {
+		item.accessed.Store(time.Now().Add(-time.Duration(idle) * time.Second).UnixNano())
+	}
+	if freq >= 0 {
+		item.freq.Store(uint32(freq))
+	}
+
+	defer c.store.lock(key)()
+
+	old, exists := c.store.getLive(key)
+	if exists && !replace {
+		return errCode("BUSYKEY", "Target key name already exists.")
+	}
+
+	// an absolute ttl in the past restores nothing, the key is only removed
+	if isExpired(item.ttl) {
+		if exists {
+			c.store.del(key)
+			c.srv.freeAsync(old)
+			c.signalModifiedKey(key)
+			c.srv.notify(notifyGeneric, "del", key)
+		}
+		return ok()
+	}
+
+	c.store.set(key, item)
+	if exists {
+		c.srv.freeAsync(old)
+	} else {
+		c.srv.notify(notifyNew, "new", key)
+	}
+	c.signalModifiedKey(key)
+	c.srv.notify(notifyGeneric, "restore", key)
+	return ok()
+}
@@ -0,0 +1,215 @@
+package redis
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeneric_RenameAndCopy(t *testing.T) {
+	n := startTestServer(t, Config{})
+
+	require.Equal(t, "ERR no such key", n.do(t, "RENAME", "missing", "x").String)
+
+	n.do(t, "SET", "a", "1")
+	require.Equal(t, 1, n.do(t, "EXPIRE", "a", "100").Int)
+	require.Equal(t, "OK", n.do(t, "RENAME", "a", "b").String)
+	require.Equal(t, 0, n.do(t, "EXISTS", "a").Int)
+	require.Equal(t, "1", n.do(t, "GET", "b").Bulk)
+	require.Greater(t, n.do(t, "TTL", "b").Int, 90)
+
+	n.do(t, "SET", "c", "2")
+	require.Equal(t, 0, n.do(t, "RENAMENX", "b", "c").Int)
+	require.Equal(t, 1, n.do(t, "RENAMENX", "b", "d").Int)
+	require.Equal(t, "OK", n.do(t, "RENAME", "d", "c").String)
+	require.Equal(t, "1", n.do(t, "GET", "c").Bulk)
+	require.Greater(t, n.do(t, "TTL", "c").Int, 90)
+
+	n.do(t, "RPUSH", "list", "x", "y")
+	require.Equal(t, 1, n.do(t, "COPY", "list", "copy").Int)
+	require.Equal(t, 0, n.do(t, "COPY", "list", "copy").Int)
+	n.do(t, "RPUSH", "copy", "z")
+	require.Equal(t, 2, n.do(t, "LLEN", "list").Int)
+	require.Equal(t, 3, n.do(t, "LLEN", "copy").Int)
+	require.Equal(t, 1, n.do(t, "COPY", "c", "copy", "DB", "0", "REPLACE").Int)
+	require.Equal(t, "1", n.do(t, "GET", "copy").Bulk)
+	require.Greater(t, n.do(t, "TTL", "copy").Int, 90)
+	require.Equal(t, "ERR DB index is out of range", n.do(t, "COPY", "c", "x", "DB", "1").String)
+	require.Equal(t, "ERR source and destination objects are the same", n.do(t, "COPY", "c", "c").String)
+
+	require.Equal(t, 3, n.do(t, "EXISTS", "c", "c", "list", "missing").Int)
+	require.Equal(t, 2, n.do(t, "TOUCH", "c", "list", "missing").Int)
+}
+
+func TestGeneric_UnlinkAndRandomKey(t *testing.T) {
+	n := startTestServer(t, Config{})
+
+	require.Equal(t, "null", n.do(t, "RANDOMKEY").Type)
+
+	args := []string{"SADD", "big"}
+	for i := 0; i < 1000; i++ {
+		args = append(args, fmt.Sprint("member", i))
+	}
+	require.Equal(t, 1000, n.do(t, args...).Int)
+	n.do(t, "SET", "small", "x")
+
+	require.Contains(t, []string{"big", "small"}, n.do(t, "RANDOMKEY").Bulk)
+
+	require.Equal(t, 2, n.do(t, "UNLINK", "big", "small", "missing").Int)
+	require.Equal(t, 0, n.do(t, "EXISTS", "big", "small").Int)
+
+	// a value replaced by RENAME goes to the worker as well
+	args = []string{"ZADD", "zbig"}
+	for i := 0; i < 200; i++ {
+		args = append(args, strconv.Itoa(i), fmt.Sprint("member", i))
+	}
+	require.Equal(t, 200, n.do(t, args...).Int)
+	n.do(t, "SET", "replacement", "x")
+	require.Equal(t, "OK", n.do(t, "RENAME", "replacement", "zbig").String)
+	require.Equal(t, "x", n.do(t, "GET", "zbig").Bulk)
+	n.do(t, "DEL", "zbig")
+	require.Eventually(t, func() bool { return n.srv.lazyfreePending.Load() == 0 }, time.Second, 10*time.Millisecond)
+	require.Contains(t, n.do(t, "INFO", "memory").Bulk, "lazyfree_pending_objects:0\r\n")
+
+	// expired keys are never returned
+	n.do(t, "SET", "gone", "x")
+	n.do(t, "EXPIRE", "gone", "1")
+	time.Sleep(1100 * time.Millisecond)
+	require.Equal(t, "null", n.do(t, "RANDOMKEY").Type)
+}
+
+func TestGeneric_Keys(t *testing.T) {
+	n := startTestServer(t, Config{})
+
+	require.Empty(t, n.do(t, "KEYS", "*").Array)
+	n.do(t, "SET", "user:1", "a")
+	n.do(t, "HSET", "user:2", "f", "v")
+	n.do(t, "RPUSH", "users", "x")
+	n.do(t, "SET", "session", "s", "PX", "1")
+	time.Sleep(5 * time.Millisecond)
+
+	keys := func(pattern string) []string {
+		k := bulks(n.do(t, "KEYS", pattern))
+		slices.Sort(k)
+		return k
+	}
+	require.Equal(t, []string{"user:1", "user:2", "users"}, keys("*"), "every type, expired keys left out")
+	require.Equal(t, []string{"user:1", "user:2"}, keys("user:?"))
+	require.Equal(t, []string{"user:2"}, keys("user:[2-9]"))
+	require.Equal(t, []string{"users"}, keys("*s"))
+	require.Empty(t, keys("session"))
+}
+
+func TestGeneric_DumpRestore(t *testing.T) {
+	n := startTestServer(t, Config{})
+
+	n.do(t, "SET", "str", "hello")
+	n.do(t, "SET", "int", "42")
+	n.do(t, "RPUSH", "list", "a", "b", "c")
+	n.do(t, "SADD", "intset", "1", "2", "3")
+	n.do(t, "SADD", "set", "x", "y")
+	n.do(t, "HSET", "hash", "f1", "v1", "f2", "v2")
+
+	for _, key := range []string{"str", "int", "list", "intset", "set", "hash"} {
+		payload := n.do(t, "DUMP", key)
+		require.Equal(t, "bulk", payload.Type, key)
+		encoding := n.do(t, "OBJECT", "ENCODING", key).Bulk
+
+		require.Equal(t, "BUSYKEY Target key name already exists.", n.do(t, "RESTORE", key, "0", payload.Bulk).String)
+		require.Equal(t, "OK", n.do(t, "RESTORE", key+":copy", "0", payload.Bulk).String, key)
+		require.Equal(t, encoding, n.do(t, "OBJECT", "ENCODING", key+":copy").Bulk, key)
+		require.Equal(t, payload.Bulk, n.do(t, "DUMP", key+":copy").Bulk, key)
+	}
+	require.Equal(t, []Value{bulkVal("a"), bulkVal("b"), bulkVal("c")}, n.do(t, "LRANGE", "list:copy", "0", "-1").Array)
+	require.Equal(t, "v2", n.do(t, "HGET", "hash:copy", "f2").Bulk)
+
+	payload := n.do(t, "DUMP", "str").Bulk
+	require.Equal(t, "OK", n.do(t, "RESTORE", "str", "5000", payload, "REPLACE", "IDLETIME", "100").String)
+	require.InDelta(t, 5, n.do(t, "TTL", "str").Int, 1)
+	require.GreaterOrEqual(t, n.do(t, "OBJECT", "IDLETIME", "str").Int, 100)
+
+	past := strconv.FormatInt(time.Now().Add(-time.Minute).UnixMilli(), 10)
+	require.Equal(t, "OK", n.do(t, "RESTORE", "str", past, payload, "REPLACE", "ABSTTL").String)
+	require.Equal(t, 0, n.do(t, "EXISTS", "str").Int)
+
+	corrupt := []byte(payload)
+	corrupt[2] ^= 0xff
+	require.Equal(t, "ERR DUMP payload version or checksum are wrong", n.do(t, "RESTORE", "x", "0", string(corrupt)).String)
+	require.Equal(t, "ERR DUMP payload version or checksum are wrong", n.do(t, "RESTORE", "x", "0", "short").String)
+	require.Equal(t, "ERR Invalid TTL value, must be >= 0", n.do(t, "RESTORE", "x", "-1", payload).String)
+	require.Equal(t, "null", n.do(t, "DUMP", "missing").Type)
+}
+
+func TestGeneric_Scan(t *testing.T) {
+	n := startTestServer(t, Config{})
+
+	for i := 0; i < 500; i++ {
+		n.do(t, "SET", fmt.Sprint("key:", i), "v")
+	}
+	n.do(t, "RPUSH", "list", "a")
+	n.do(t, "SADD", "set", "a")
+
+	// keys are added and removed during the scan, those present all along come back exactly once
+	scan := func(opts ...string) map[string]int {
+		seen := make(map[string]int)
+		cursor, calls := "0", 0
+		for {
+			v := n.do(t, append([]string{"SCAN", cursor}, opts...)...)
+			require.Len(t, v.Array, 2)
+			for _, key := range bulks(v.Array[1]) {
+				seen[key]++
+			}
+			cursor = v.Array[0].Bulk
+			calls++
+			n.do(t, "SET", fmt.Sprint("new:", calls), "v")
+			n.do(t, "DEL", fmt.Sprint("key:", 499-calls))
+			if cursor == "0" {
+				return seen
+			}
+		}
+	}
+	seen := scan("COUNT", "20")
+	for i := 0; i < 400; i++ {
+		require.Equal(t, 1, seen[fmt.Sprint("key:", i)], "key:%d", i)
+	}
+	require.Equal(t, 1, seen["list"])
+
+	seen = scan("MATCH", "key:1?", "COUNT", "1000")
+	require.Len(t, seen, 10)
+	require.Equal(t, map[string]int{"list": 1}, scan("TYPE", "list"))
+
+	n.do(t, "SET", "gone", "x", "PX", "1")
+	time.Sleep(10 * time.Millisecond)
+	require.Zero(t, scan("MATCH", "gone")["gone"])
+
+	// COUNT bounds the keys a call looks at, and a cursor carries over to another connection
+	other := dialTestServer(t, n.srv)
+	seen = make(map[string]int)
+	cursor := "0"
+	for i := 0; ; i++ {
+		conn := n
+		if i%2 == 1 {
+			conn = other
+		}
+		v := conn.do(t, "SCAN", cursor, "COUNT", "3")
+		require.LessOrEqual(t, len(v.Array[1].Array), 3)
+		for _, key := range bulks(v.Array[1]) {
+			seen[key]++
+		}
+		if cursor = v.Array[0].Bulk; cursor == "0" {
+			break
+		}
+	}
+	require.Len(t, seen, len(bulks(n.do(t, "KEYS", "*"))))
+	for key, times := range seen {
+		require.Equal(t, 1, times, key)
+	}
+
+	require.Equal(t, "ERR invalid cursor", n.do(t, "SCAN", "x").String)
+	require.Equal(t, "ERR syntax error", n.do(t, "SCAN", "0", "COUNT", "0").String)
+	require.Equal(t, "ERR syntax error", n.do(t, "SCAN", "0", "MATCH").String)
+}
@@ -0,0 +1,559 @@
+package redis
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Geo indexes are sorted sets whose scores are 52 bit geohashes: the longitude and latitude
+// are each quantized to 26 bits and interleaved, latitude in the even bits. Members close
+// to each other get close scores, so an area is a handful of score ranges, one per geohash
+// cell covering it, whose members are then filtered by their exact distance.
+const (
+	geoStepMax   = 26
+	geoLatMin    = -85.05112878
+	geoLatMax    = 85.05112878
+	geoLonMin    = -180.0
+	geoLonMax    = 180.0
+	earthRadiusM = 6372797.560856
+	mercatorMax  = 20037726.37
+)
+
+// geoHash is the hash of a cell, 2*step bits
+type geoHash struct {
+	bits uint64
+	step uint
+}
+
+// geoArea is the extent of a cell
+type geoArea struct {
+	lonMin, lonMax, latMin, latMax float64
+}
+
+func interleave64(x, y uint32) uint64 {
+	var b uint64
+	for i := 0; i < 32; i++ {
+		b |= uint64(x>>i&1) << (2 * i)
+		b |= uint64(y>>i&1) << (2*i + 1)
+	}
+	return b
+}
+
+func deinterleave64(b uint64) (x, y uint32) {
+	for i := 0; i < 32; i++ {
+		x |= uint32(b>>(2*i)&1) << i
+		y |= uint32(b>>(2*i+1)&1) << i
+	}
+	return x, y
+}
+
+func geoEncodeRange(lon, lat float64, step uint, latMin, latMax float64) geoHash {
+	latOffset := (lat - latMin) / (latMax - latMin) * float64(uint64(1)<<step)
+	lonOffset := (lon - geoLonMin) / (geoLonMax - geoLonMin) * float64(uint64(1)<<step)
+	return geoHash{bits: interleave64(uint32(latOffset), uint32(lonOffset)), step: step}
+}
+
+func geoEncode(lon, lat float64, step uint) geoHash {
+	return geoEncodeRange(lon, lat, step, geoLatMin, geoLatMax)
+}
+
+func geoDecode(h geoHash) geoArea {
+	ilat, ilon := deinterleave64(h.bits)
+	cells := float64(uint64(1) << h.step)
+	latScale, lonScale := geoLatMax-geoLatMin, geoLonMax-geoLonMin
+	return geoArea{
+		latMin: geoLatMin + float64(ilat)/cells*latScale,
+		latMax: geoLatMin + float64(ilat+1)/cells*latScale,
+		lonMin: geoLonMin + float64(ilon)/cells*lonScale,
+		lonMax: geoLonMin + float64(ilon+1)/cells*lonScale,
+	}
+}
+
+// center returns the coordinates a member of the cell is reported at
+func (a geoArea) center() (lon, lat float64) {
+	lon = min(max((a.lonMin+a.lonMax)/2, geoLonMin), geoLonMax)
+	lat = min(max((a.latMin+a.latMax)/2, geoLatMin), geoLatMax)
+	return lon, lat
+}
+
+// geoScoreCoords decodes the score of a member back into its coordinates
+func geoScoreCoords(score float64) (lon, lat float64) {
+	return geoDecode(geoHash{bits: uint64(score), step: geoStepMax}).center()
+}
+
+// neighbor returns the cell dlon cells east and dlat cells north of h, wrapping around
+func (h geoHash) neighbor(dlon, dlat int) geoHash {
+	ilat, ilon := deinterleave64(h.bits)
+	mask := uint32(1)<<h.step - 1
+	ilat = uint32(int(ilat)+dlat) & mask
+	ilon = uint32(int(ilon)+dlon) & mask
+	return geoHash{bits: interleave64(ilat, ilon), step: h.step}
+}
+
+// scoreRange is the range of 52 bit scores inside the cell, max exclusive
+func (h geoHash) scoreRange() *zrangeSpec {
+	shift := 2 * (geoStepMax - h.step)
+	return &zrangeSpec{min: float64(h.bits << shift), max: float64((h.bits + 1) << shift), maxex: true}
+}
+
+func degRad(d float64) float64 { return d * math.Pi / 180 }
+func radDeg(r float64) float64 { return r * 180 / math.Pi }
+
+// geoDistance is the haversine distance in meters
+func geoDistance(lon1, lat1, lon2, lat2 float64) float64 {
+	lat1r, lon1r := degRad(lat1), degRad(lon1)
+	lat2r, lon2r := degRad(lat2), degRad(lon2)
+	u := math.Sin((lat2r - lat1r) / 2)
+	v := math.Sin((lon2r - lon1r) / 2)
+	a := u*u + math.Cos(lat1r)*math.Cos(lat2r)*v*v
+	return 2 * earthRadiusM * math.Asin(math.Sqrt(a))
+}
+
+// geoShape is the area of a GEOSEARCH, a circle or a box around a center
+type geoShape struct {
+	lon, lat float64
+	// radius of a circle, or the width and height of a box, in meters
+	radius, width, height float64
+	box                   bool
+	unit                  float64
+}
+
+// contains returns the distance of the point from the center when it is inside the shape
+func (s *geoShape) contains(lon, lat float64) (float64, bool) {
+	if !s.box {
+		d := geoDistance(s.lon, s.lat, lon, lat)
+		return d, d <= s.radius
+	}
+	if earthRadiusM*math.Abs(degRad(lat)-degRad(s.lat)) > s.height/2 {
+		return 0, false
+	}
+	if geoDistance(lon, lat, s.lon, lat) > s.width/2 {
+		return 0, false
+	}
+	return geoDistance(s.lon, s.lat, lon, lat), true
+}
+
+// boundingBox returns the coordinates enclosing the shape
+func (s *geoShape) boundingBox() geoArea {
+	height, width := s.radius, s.radius
+	if s.box {
+		height, width = s.height/2, s.width/2
+	}
+	latDelta := radDeg(height / earthRadiusM)
+	lonDeltaTop := radDeg(width / earthRadiusM / math.Cos(degRad(s.lat+latDelta)))
+	lonDeltaBottom := radDeg(width / earthRadiusM / math.Cos(degRad(s.lat-latDelta)))
+	// the edge further from the equator is the wider one
+	lonDelta := lonDeltaTop
+	if s.lat < 0 {
+		lonDelta = lonDeltaBottom
+	}
+	return geoArea{lonMin: s.lon - lonDelta, lonMax: s.lon + lonDelta, latMin: s.lat - latDelta, latMax: s.lat + latDelta}
+}
+
+// geoEstimateSteps returns the precision at which a cell is about as large as the radius
+func geoEstimateSteps(radius, lat float64) uint {
+	if radius == 0 {
+		return geoStepMax
+	}
+	step := 1
+	for radius < mercatorMax {
+		radius *= 2
+		step++
+	}
+	step -= 2
+	// cells are narrower near the poles
+	if lat > 66 || lat < -66 {
+		step--
+		if lat > 80 || lat < -80 {
+			step--
+		}
+	}
+	return uint(min(max(step, 1), geoStepMax))
+}
+
+// cells returns the cell of the center and its eight neighbors at a precision where they
+// cover the whole shape, without duplicates
+func (s *geoShape) cells() []geoHash {
+	radius := s.radius
+	if s.box {
+		radius = math.Hypot(s.width/2, s.height/2)
+	}
+	bounds := s.boundingBox()
+	step := geoEstimateSteps(radius, s.lat)
+
+	center := geoEncode(s.lon, s.lat, step)
+	north, south := geoDecode(center.neighbor(0, 1)), geoDecode(center.neighbor(0, -1))
+	east, west := geoDecode(center.neighbor(1, 0)), geoDecode(center.neighbor(-1, 0))
+	if step > 1 && (north.latMax < bounds.latMax || south.latMin > bounds.latMin ||
+		east.lonMax < bounds.lonMax || west.lonMin > bounds.lonMin) {
+		center = geoEncode(s.lon, s.lat, step-1)
+	}
+
+	cells := make([]geoHash, 0, 9)
+	for dlat := -1; dlat <= 1; dlat++ {
+		for dlon := -1; dlon <= 1; dlon++ {
+			h := center.neighbor(dlon, dlat)
+			if !slices.Contains(cells, h) {
+				cells = append(cells, h)
+			}
+		}
+	}
+	return cells
+}
+
+type geoPoint struct {
+	member   string
+	score    float64
+	lon, lat float64
+	dist     float64
+}
+
+// search returns the members of the geo index inside the shape, cell by cell. With
+// limit > 0 it stops once it found that many.
+func (s *geoShape) search(item *RedisItem, limit int) []geoPoint {
+	var points []geoPoint
+	for _, cell := range s.cells() {
+		for _, e := range zsetRangeByScore(item, cell.scoreRange()) {
+			lon, lat := geoScoreCoords(e.score)
+			dist, ok := s.contains(lon, lat)
+			if !ok {
+				continue
+			}
+			points = append(points, geoPoint{member: e.member, score: e.score, lon: lon, lat: lat, dist: dist})
+			if limit > 0 && len(points) == limit {
+				return points
+			}
+		}
+	}
+	return points
+}
+
+// parseGeoUnit returns the meters in unit
+func parseGeoUnit(unit string) (float64, bool) {
+	switch strings.ToLower(unit) {
+	case "m":
+		return 1, true
+	case "km":
+		return 1000, true
+	case "ft":
+		return 0.3048, true
+	case "mi":
+		return 1609.34, true
+	}
+	return 0, false
+}
+
+func errGeoUnit() Value {
+	return errVal("unsupported unit provided. please use M, KM, FT, MI")
+}
+
+func parseGeoCoords(lonArg, latArg string) (float64, float64, Value, bool) {
+	lon, err1 := strconv.ParseFloat(lonArg, 64)
+	lat, err2 := strconv.ParseFloat(latArg, 64)
+	if err1 != nil || err2 != nil || math.IsNaN(lon) || math.IsNaN(lat) {
+		return 0, 0, errVal("value is not a valid float"), false
+	}
+	if lon < geoLonMin || lon > geoLonMax || lat < geoLatMin || lat > geoLatMax {
+		return 0, 0, errVal(fmt.Sprintf("invalid longitude,latitude pair %f,%f", lon, lat)), false
+	}
+	return lon, lat, Value{}, true
+}
+
+func coordVal(lon, lat float64) Value {
+	return Value{Type: "array", Array: []Value{
+		bulkVal(strconv.FormatFloat(lon, 'f', -1, 64)),
+		bulkVal(strconv.FormatFloat(lat, 'f', -1, 64)),
+	}}
+}
+
+// GEOADD key [NX|XX] [CH] longitude latitude member [longitude latitude member ...]
+func GEOADD(c *Client, args []Value) Value {
+	zadd := []Value{args[0]}
+	i := 1
+opts:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i].Bulk) {
+		case "NX", "XX", "CH":
+			zadd = append(zadd, args[i])
+		default:
+			break opts
+		}
+	}
+
+	triples := args[i:]
+	if len(triples) == 0 || len(triples)%3 != 0 {
+		return syntaxErr()
+	}
+	for j := 0; j < len(triples); j += 3 {
+		lon, lat, errReply, ok := parseGeoCoords(triples[j].Bulk, triples[j+1].Bulk)
+		if !ok {
+			return errReply
+		}
+		score := float64(geoEncode(lon, lat, geoStepMax).bits)
+		zadd = append(zadd, bulkVal(formatScore(score)), triples[j+2])
+	}
+	return ZADD(c, zadd)
+}
+
+// GEODIST key member1 member2 [M|KM|FT|MI]
+func GEODIST(c *Client, args []Value) Value {
+	unit := 1.0
+	if len(args) == 4 {
+		var ok bool
+		if unit, ok = parseGeoUnit(args[3].Bulk); !ok {
+			return errGeoUnit()
+		}
+	} else if len(args) != 3 {
+		return syntaxErr()
+	}
+
+	key := args[0].Bulk
+	defer c.store.rlock(key)()
+
+	item, errReply, ok := c.zsetRead(key)
+	if !ok {
+		return errReply
+	}
+	if item == nil {
+		return nullVal()
+	}
+	s1, ok1 := zsetScore(item, args[1].Bulk)
+	s2, ok2 := zsetScore(item, args[2].Bulk)
+	if !ok1 || !ok2 {
+		return nullVal()
+	}
+	lon1, lat1 := geoScoreCoords(s1)
+	lon2, lat2 := geoScoreCoords(s2)
+	return bulkVal(fmt.Sprintf("%.4f", geoDistance(lon1, lat1, lon2, lat2)/unit))
+}
+
+// GEOPOS key [member ...]
+func GEOPOS(c *Client, args []Value) Value {
+	key := args[0].Bulk
+	defer c.store.rlock(key)()
+
+	item, errReply, ok := c.zsetRead(key)
+	if !ok {
+		return errReply
+	}
+	res := Value{Type: "array", Array: make([]Value, 0, len(args)-1)}
+	for _, m := range args[1:] {
+		if item != nil {
+			if score, ok := zsetScore(item, m.Bulk); ok {
+				res.Array = append(res.Array, coordVal(geoScoreCoords(score)))
+				continue
+			}
+		}
+		res.Array = append(res.Array, nullVal())
+	}
+	return res
+}
+
+const geoAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// GEOHASH key [member ...], the standard 11 character geohash, which unlike the scores
+// covers latitudes from -90 to 90
+func GEOHASH(c *Client, args []Value) Value {
+	key := args[0].Bulk
+	defer c.store.rlock(key)()
+
+	item, errReply, ok := c.zsetRead(key)
+	if !ok {
+		return errReply
+	}
+	res := Value{Type: "array", Array: make([]Value, 0, len(args)-1)}
+	for _, m := range args[1:] {
+		var score float64
+		found := false
+		if item != nil {
+			score, found = zsetScore(item, m.Bulk)
+		}
+		if !found {
+			res.Array = append(res.Array, nullVal())
+			continue
+		}
+		lon, lat := geoScoreCoords(score)
+		bits := geoEncodeRange(lon, lat, geoStepMax, -90, 90).bits
+		buf := make([]byte, 11)
+		for i := range buf {
+			idx := 0
+			if i < 10 {
+				idx = int(bits >> (52 - (i+1)*5) & 0x1f)
+			}
+			buf[i] = geoAlphabet[idx]
+		}
+		res.Array = append(res.Array, bulkVal(string(buf)))
+	}
+	return res
+}
+
+// GEOSEARCH key FROMMEMBER member|FROMLONLAT longitude latitude
+// BYRADIUS radius M|KM|FT|MI|BYBOX width height M|KM|FT|MI
+// [ASC|DESC] [COUNT count [ANY]] [WITHCOORD] [WITHDIST] [WITHHASH]
+func GEOSEARCH(c *Client, args []Value) Value {
+	key := args[0].Bulk
+
+	shape := &geoShape{}
+	var fromMember string
+	var fromMemberSet, fromLonLat, byRadius, byBox bool
+	var withCoord, withDist, withHash, anyOpt bool
+	var asc, desc bool
+	count := 0
+
+	for i := 1; i < len(args); i++ {
+		left := len(args) - i - 1
+		switch strings.ToUpper(args[i].Bulk) {
+		case "FROMMEMBER":
+			if left < 1 {
+				return syntaxErr()
+			}
+			fromMember, fromMemberSet = args[i+1].Bulk, true
+			i++
+		case "FROMLONLAT":
+			if left < 2 {
+				return syntaxErr()
+			}
+			lon, lat, errReply, ok := parseGeoCoords(args[i+1].Bulk, args[i+2].Bulk)
+			if !ok {
+				return errReply
+			}
+			shape.lon, shape.lat, fromLonLat = lon, lat, true
+			i += 2
+		case "BYRADIUS":
+			if left < 2 {
+				return syntaxErr()
+			}
+			r, err := strconv.ParseFloat(args[i+1].Bulk, 64)
+			if err != nil {
+				return errVal("need numeric radius")
+			}
+			if r < 0 {
+				return errVal("radius cannot be negative")
+			}
+			unit, ok := parseGeoUnit(args[i+2].Bulk)
+			if !ok {
+				return errGeoUnit()
+			}
+			shape.radius, shape.unit, byRadius = r*unit, unit, true
+			i += 2
+		case "BYBOX":
+			if left < 3 {
+				return syntaxErr()
+			}
+			w, err1 := strconv.ParseFloat(args[i+1].Bulk, 64)
+			h, err2 := strconv.ParseFloat(args[i+2].Bulk, 64)
+			if err1 != nil || err2 != nil {
+				return errVal("need numeric width and height")
+			}
+			if w < 0 || h < 0 {
+				return errVal("height or width cannot be negative")
+			}
+			unit, ok := parseGeoUnit(args[i+3].Bulk)
+			if !ok {
+				return errGeoUnit()
+			}
+			shape.width, shape.height, shape.unit, shape.box, byBox = w*unit, h*unit, unit, true, true
+			i += 3
+		case "ASC":
+			asc = true
+		case "DESC":
+			desc = true
+		case "COUNT":
+			if left < 1 {
+				return syntaxErr()
+			}
+			n, err := strconv.Atoi(args[i+1].Bulk)
+			if err != nil {
+				return errVal("value is not an integer or out of range")
+			}
+			if n <= 0 {
+				return errVal("COUNT must be > 0")
+			}
+			count = n
+			i++
+		case "ANY":
+			anyOpt = true
+		case "WITHCOORD":
+			withCoord = true
+		case "WITHDIST":
+			withDist = true
+		case "WITHHASH":
+			withHash = true
+		default:
+			return syntaxErr()
+		}
+	}
+
+	if fromMemberSet == fromLonLat {
+		return errVal("exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH")
+	}
+	if byRadius == byBox {
+		return errVal("exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH")
+	}
+	if anyOpt && count == 0 {
+		return errVal("the ANY argument requires COUNT argument")
+	}
+	if asc && desc {
+		return syntaxErr()
+	}
+	// without ANY the closest count members are returned
+	if count > 0 && !anyOpt && !desc {
+		asc = true
+	}
+
+	defer c.store.rlock(key)()
+
+	res := Value{Type: "array", Array: []Value{}}
+	item, errReply, ok := c.zsetRead(key)
+	if !ok {
+		return errReply
+	}
+	if item == nil {
+		return res
+	}
+	if fromMemberSet {
+		score, ok := zsetScore(item, fromMember)
+		if !ok {
+			return errVal("could not decode requested zset member")
+		}
+		shape.lon, shape.lat = geoScoreCoords(score)
+	}
+
+	limit := 0
+	if anyOpt {
+		limit = count
+	}
+	points := shape.search(item, limit)
+	switch {
+	case asc:
+		slices.SortStableFunc(points, func(a, b geoPoint) int { return cmp.Compare(a.dist, b.dist) })
+	case desc:
+		slices.SortStableFunc(points, func(a, b geoPoint) int { return cmp.Compare(b.dist, a.dist) })
+	}
+	if count > 0 && len(points) > count {
+		points = points[:count]
+	}
+
+	for _, p := range points {
+		if !withCoord && !withDist && !withHash {
+			res.Array = append(res.Array, bulkVal(p.member))
+			continue
+		}
+		entry := Value{Type: "array", Array: []Value{bulkVal(p.member)}}
+		if withDist {
+			entry.Array = append(entry.Array, bulkVal(fmt.Sprintf("%.4f", p.dist/shape.unit)))
+		}
+		if withHash {
+			entry.Array = append(entry.Array, intVal(int(p.score)))
+		}
+		if withCoord {
+			entry.Array = append(entry.Array, coordVal(p.lon, p.lat))
+		}
+		res.Array = append(res.Array, entry)
+	}
+	return res
+}
@@ -0,0 +1,135 @@
+package redis
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func sicily(t *testing.T, n *testNode) {
+	require.Equal(t, 2, n.do(t, "GEOADD", "Sicily", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania").Int)
+}
+
+func TestGeo_AddPosDistHash(t *testing.T) {
+	n := startTestServer(t, Config{})
+	sicily(t, n)
+
+	// values from the Redis documentation
+	require.Equal(t, "3479099956230698", n.do(t, "ZSCORE", "Sicily", "Palermo").Bulk)
+	require.Equal(t, "3479447370796909", n.do(t, "ZSCORE", "Sicily", "Catania").Bulk)
+	require.Equal(t, "166274.1516", n.do(t, "GEODIST", "Sicily", "Palermo", "Catania").Bulk)
+	require.Equal(t, "166.2742", n.do(t, "GEODIST", "Sicily", "Palermo", "Catania", "km").Bulk)
+	require.Equal(t, "103.3182", n.do(t, "GEODIST", "Sicily", "Palermo", "Catania", "MI").Bulk)
+	require.Equal(t, "null", n.do(t, "GEODIST", "Sicily", "Palermo", "missing").Type)
+	require.Equal(t, []string{"sqc8b49rny0", "sqdtr74hyu0", ""}, bulks(n.do(t, "GEOHASH", "Sicily", "Palermo", "Catania", "missing")))
+
+	pos := n.do(t, "GEOPOS", "Sicily", "Palermo", "missing")
+	lon, _ := strconv.ParseFloat(pos.Array[0].Array[0].Bulk, 64)
+	lat, _ := strconv.ParseFloat(pos.Array[0].Array[1].Bulk, 64)
+	require.InDelta(t, 13.36138933897018433, lon, 1e-12)
+	require.InDelta(t, 38.11555639549629859, lat, 1e-12)
+	require.Equal(t, "null", pos.Array[1].Type)
+
+	// geo indexes are plain sorted sets
+	require.Equal(t, "zset", n.do(t, "TYPE", "Sicily").String)
+	require.Equal(t, []string{"Palermo", "Catania"}, bulks(n.do(t, "ZRANGE", "Sicily", "0", "-1")))
+	require.Equal(t, 0, n.do(t, "GEOADD", "Sicily", "NX", "0", "0", "Palermo").Int)
+	require.Equal(t, 1, n.do(t, "GEOADD", "Sicily", "XX", "CH", "13.361389", "38.115556", "Catania").Int)
+	require.Equal(t, "0.0000", n.do(t, "GEODIST", "Sicily", "Palermo", "Catania").Bulk)
+
+	require.Equal(t, "ERR invalid longitude,latitude pair 13.361389,86.000000", n.do(t, "GEOADD", "Sicily", "13.361389", "86", "x").String)
+	require.Equal(t, "ERR syntax error", n.do(t, "GEOADD", "Sicily", "CH", "13.361389", "38.115556").String)
+	require.Equal(t, "ERR unsupported unit provided. please use M, KM, FT, MI", n.do(t, "GEODIST", "Sicily", "Palermo", "Catania", "yd").String)
+}
+
+func TestGeo_Search(t *testing.T) {
+	n := startTestServer(t, Config{})
+	sicily(t, n)
+	n.do(t, "GEOADD", "Sicily", "12.758489", "38.788135", "edge1", "17.241510", "38.788135", "edge2")
+
+	require.Equal(t, []string{"Catania", "Palermo"}, bulks(n.do(t, "GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "ASC")))
+	require.Equal(t, []string{"Palermo", "Catania"}, bulks(n.do(t, "GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "DESC")))
+	require.Equal(t, []string{"Catania"}, bulks(n.do(t, "GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "COUNT", "1")))
+
+	v := n.do(t, "GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYBOX", "400", "400", "km", "ASC", "WITHCOORD", "WITHDIST", "WITHHASH")
+	require.Len(t, v.Array, 4)
+	var names, dists []string
+	for _, e := range v.Array {
+		names = append(names, e.Array[0].Bulk)
+		dists = append(dists, e.Array[1].Bulk)
+		require.Len(t, e.Array[3].Array, 2)
+	}
+	require.Equal(t, []string{"Catania", "Palermo", "edge2", "edge1"}, names)
+	require.Equal(t, []string{"56.4413", "190.4424", "279.7403", "279.7405"}, dists)
+	require.Equal(t, 3479447370796909, v.Array[0].Array[2].Int)
+
+	require.Equal(t, []string{"Palermo", "edge1", "Catania"}, bulks(n.do(t, "GEOSEARCH", "Sicily", "FROMMEMBER", "Palermo", "BYRADIUS", "170", "km", "ASC")))
+	require.Len(t, n.do(t, "GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYBOX", "400", "400", "km", "COUNT", "2", "ANY").Array, 2)
+	require.Empty(t, n.do(t, "GEOSEARCH", "missing", "FROMMEMBER", "x", "BYRADIUS", "1", "m").Array)
+
+	for msg, args := range map[string][]string{
+		"ERR could not decode requested zset member":                                 {"FROMMEMBER", "nobody", "BYRADIUS", "1", "m"},
+		"ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH": {"BYRADIUS", "1", "m", "ASC", "WITHDIST"},
+		"ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH":       {"FROMLONLAT", "15", "37", "ASC", "WITHDIST"},
+		"ERR the ANY argument requires COUNT argument":                               {"FROMLONLAT", "15", "37", "BYRADIUS", "1", "m", "ANY"},
+		"ERR COUNT must be > 0":                                                      {"FROMLONLAT", "15", "37", "BYRADIUS", "1", "m", "COUNT", "0"},
+		"ERR radius cannot be negative":                                              {"FROMLONLAT", "15", "37", "BYRADIUS", "-1", "m"},
+	} {
+		require.Equal(t, msg, n.do(t, append([]string{"GEOSEARCH", "Sicily"}, args...)...).String)
+	}
+}
+
+// the cells searched must cover the whole shape, wherever it is and whatever its size
+func TestGeo_SearchMatchesBruteForce(t *testing.T) {
+	n := startTestServer(t, Config{})
+
+	type point struct{ lon, lat float64 }
+	points := make(map[string]point)
+	args := []string{"GEOADD", "points"}
+	for i := 0; i < 2000; i++ {
+		p := point{lon: rand.Float64()*360 - 180, lat: rand.Float64()*170 - 85}
+		if i%2 == 0 {
+			// cluster half of them around a few spots
+			p = point{lon: 2.35 + rand.NormFloat64(), lat: 48.85 + rand.NormFloat64()}
+		}
+		name := fmt.Sprint("p", i)
+		args = append(args, fmt.Sprint(p.lon), fmt.Sprint(p.lat), name)
+	}
+	n.do(t, args...)
+	for _, name := range bulks(n.do(t, "ZRANGE", "points", "0", "-1")) {
+		pos := n.do(t, "GEOPOS", "points", name).Array[0]
+		lon, _ := strconv.ParseFloat(pos.Array[0].Bulk, 64)
+		lat, _ := strconv.ParseFloat(pos.Array[1].Bulk, 64)
+		points[name] = point{lon, lat}
+	}
+
+	for i := 0; i < 50; i++ {
+		center := point{lon: rand.Float64()*360 - 180, lat: rand.Float64()*160 - 80}
+		if i%2 == 0 {
+			center = point{lon: 2.35 + rand.NormFloat64(), lat: 48.85 + rand.NormFloat64()}
+		}
+		radius := []float64{1, 50, 300, 2000, 8000}[i%5]
+		width, height := radius*2, radius
+
+		shape := &geoShape{lon: center.lon, lat: center.lat, radius: radius * 1000}
+		box := &geoShape{lon: center.lon, lat: center.lat, width: width * 1000, height: height * 1000, box: true}
+		var wantRadius, wantBox []string
+		for name, p := range points {
+			if _, ok := shape.contains(p.lon, p.lat); ok {
+				wantRadius = append(wantRadius, name)
+			}
+			if _, ok := box.contains(p.lon, p.lat); ok {
+				wantBox = append(wantBox, name)
+			}
+		}
+
+		lon, lat := fmt.Sprint(center.lon), fmt.Sprint(center.lat)
+		got := bulks(n.do(t, "GEOSEARCH", "points", "FROMLONLAT", lon, lat, "BYRADIUS", fmt.Sprint(radius), "km"))
+		require.ElementsMatch(t, wantRadius, got, "radius %v km around %v", radius, center)
+		got = bulks(n.do(t, "GEOSEARCH", "points", "FROMLONLAT", lon, lat, "BYBOX", fmt.Sprint(width), fmt.Sprint(height), "km"))
+		require.ElementsMatch(t, wantBox, got, "box %vx%v km around %v", width, height, center)
+	}
+}
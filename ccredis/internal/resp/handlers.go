@@ -3,39 +3,206 @@ package redis
 import (
 	"errors"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
-	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type RedisItem struct {
+	// the concrete type depends on the encoding, see object.go
 	value    interface{}
 	itemType redisType
 	ttl      time.Time
+
+	// access time in unix nanoseconds and logarithmic access counter, used by OBJECT
+	// IDLETIME/FREQ. Readers update them under the shard read lock, hence the atomics.
+	accessed atomic.Int64
+	freq     atomic.Uint32
 }
 
-type HandlerFunc func(args []Value) Value
+type HandlerFunc func(c *Client, args []Value) Value
+
+type cmdFlag int
+
+const (
+	cmdWrite cmdFlag = 1 << iota
+	cmdReadonly
+	// rejected inside MULTI
+	cmdNoMulti
+	// may block the client for long, runs without holding execMu
+	cmdBlocking
+	// rejected when called by a function
+	cmdNoScript
+)
+
+// command describes a command the way the dispatcher needs to see it. arity counts the
+// command name itself, a negative arity means "at least". firstKey, lastKey and step locate
+// the key arguments (lastKey -1 means the last argument), firstKey 0 means no keys.
+type command struct {
+	handler  HandlerFunc
+	arity    int
+	flags    cmdFlag
+	firstKey int
+	lastKey  int
+	step     int
+}
 
 var (
-	storeMu  sync.RWMutex
-	store    = make(map[string]*RedisItem)
-	Handlers = map[string]HandlerFunc{
-		"MSET":     middleware(MSET),
-		"SET":      middleware(SET),
-		"GET":      middleware(GET),
-		"DEL":      middleware(DEL),
-		"TTL":      middleware(TTL),
-		"TYPE":     middleware(TYPE),
-		"KEYS":     middleware(KEYS),
-		"EXPIRE":   middleware(EXPIRE),
-		"PING":     PONG,
-		"PERSIST":  PERSIST,
-		"FLUSHALL": FLUSHALL,
-	}
+	// commands is filled in init, handlers like MULTI/EXEC dispatch other commands themselves
+	commands map[string]*command
+
+	// Handlers maps every command name to its handler wrapped with the middleware
+	Handlers map[string]HandlerFunc
 )
 
-func EXPIRE(args []Value) Value {
+func init() {
+	commands = map[string]*command{
+		"MSET":     {handler: MSET, arity: -3, flags: cmdWrite, firstKey: 1, lastKey: -1, step: 2},
+		"SET":      {handler: SET, arity: -3, flags: cmdWrite, firstKey: 1, lastKey: 1, step: 1},
+		"GET":      {handler: GET, arity: 2, flags: cmdReadonly, firstKey: 1, lastKey: 1, step: 1},
+		"DEL":      {handler: DEL, arity: -2, flags: cmdWrite, firstKey: 1, lastKey: -1, step: 1},
+		"TTL":      {handler: TTL, arity: 2, flags: cmdReadonly, firstKey: 1, lastKey: 1, step: 1},
+		"TYPE":     {handler: TYPE, arity: 2, flags: cmdReadonly, firstKey: 1, lastKey: 1, step: 1},
+		"KEYS":     {handler: KEYS, arity: 2, flags: cmdReadonly},
+		"EXPIRE":   {handler: EXPIRE, arity: -3, flags: cmdWrite, firstKey: 1, lastKey: 1, step: 1},
+		"PING":     {handler: PONG, arity: -1},
+		"PERSIST":  {handler: PERSIST, arity: 2, flags: cmdWrite, firstKey: 1, lastKey: 1, step: 1},
+		"FLUSHALL": {handler: FLUSHALL, arity: -1, flags: cmdWrite},
+		"CLUSTER":  {handler: CLUSTER, arity: -2},
+		"ASKING":   {handler: ASKING, arity: 1},
+		"MIGRATE":  {handler: MIGRATE, arity: -6, flags: cmdWrite},
+		"CONFIG":   {handler: CONFIG, arity: -2},
+		"CLIENT":   {handler: CLIENT, arity: -2},
+		"HELLO":    {handler: HELLO, arity: -1},
+		"MULTI":    {handler: MULTI, arity: 1, flags: cmdNoScript},
+		"EXEC":     {handler: EXEC, arity: 1, flags: cmdNoScript},
+		"DISCARD":  {handler: DISCARD, arity: 1, flags: cmdNoScript},
+		"SHUTDOWN": {handler: SHUTDOWN, arity: -1, flags: cmdNoMulti},
+		"MONITOR":  {handler: MONITOR, arity: 1, flags: cmdNoMulti},
+		"SAVE":     {handler: SAVE, arity: 1},
+		"BGSAVE":   {handler: BGSAVE, arity: -1},
+		"LASTSAVE": {handler: LASTSAVE, arity: 1},
+		"SLOWLOG":  {handler: SLOWLOG, arity: -2},
+		"LATENCY":  {handler: LATENCY, arity: -2},
+		"INFO":     {handler: INFO, arity: -1},
+
+		"PSYNC":        {handler: PSYNC, arity: 3, flags: cmdNoMulti},
+		"REPLCONF":     {handler: REPLCONF, arity: -1, flags: cmdNoMulti},
+		"REPLICAOF":    {handler: REPLICAOF, arity: 3, flags: cmdNoMulti | cmdBlocking},
+		"SLAVEOF":      {handler: REPLICAOF, arity: 3, flags: cmdNoMulti | cmdBlocking},
+		"WAIT":         {handler: WAIT, arity: 3, flags: cmdNoMulti | cmdBlocking},
+		"WAITAOF":      {handler: WAITAOF, arity: 4, flags: cmdNoMulti | cmdBlocking},
+		"BGREWRITEAOF": {handler: BGREWRITEAOF, arity: 1},
+
+		"INCR":      {handler: INCR, arity: 2, flags: cmdWrite, firstKey: 1, lastKey: 1, step: 1},
+		"DECR":      {handler: DECR, arity: 2, flags: cmdWrite, firstKey: 1, lastKey: 1, step: 1},
+		"INCRBY":    {handler: INCRBY, arity: 3, flags: cmdWrite, firstKey: 1, lastKey: 1, step: 1},
+		"DECRBY":    {handler: DECRBY, arity: 3, flags: cmdWrite, firstKey: 1, lastKey: 1, step: 1},
+		"APPEND":    {handler: APPEND, arity: 3, flags: cmdWrite, firstKey: 1, lastKey: 1, step: 1},
+		"STRLEN":    {handler: STRLEN, arity: 2, flags: cmdReadonly, firstKey: 1, lastKey: 1, step: 1},
+		"HSET":      {handler: HSET, arity: -4, flags: cmdWrite, firstKey: 1, lastKey: 1, step: 1},
+		"HGET":      {handler: HGET, arity: 3, flags: cmdReadonly, firstKey: 1, lastKey: 1, step: 1},
+		"HMGET":     {handler: HMGET, arity: -3, flags: cmdReadonly, firstKey: 1, lastKey: 1, step: 1},
+		"HGETALL":   {handler: HGETALL, arity: 2, flags: cmdReadonly, firstKey: 1, lastKey: 1, step: 1},
+		"HDEL":      {handler: HDEL, arity: -3, flags: cmdWrite, firstKey: 1, lastKey: 1, step: 1},
+		"HLEN":      {handler: HLEN, arity: 2, flags: cmdReadonly, firstKey: 1, lastKey: 1, step: 1},
+		"HEXISTS":   {handler: HEXISTS, arity: 3, flags: cmdReadonly, firstKey: 1, lastKey: 1, step: 1},
+		"HINCRBY":   {handler: HINCRBY, arity: 4, flags: cmdWrite, firstKey: 1, lastKey: 1, step: 1},
+		"SADD":      {handler: SADD, arity: -3, flags: cmdWrite, firstKey: 1, lastKey: 1, step: 1},
+		"SREM":      {handler: SREM, arity: -3, flags: cmdWrite, firstKey: 1, lastKey: 1, step: 1},
+		"SISMEMBER": {handler: SISMEMBER, arity: 3, flags: cmdReadonly, firstKey: 1, lastKey: 1, step: 1},
+		"SMEMBERS":  {handler: SMEMBERS, arity: 2, flags: cmdReadonly, firstKey: 1, lastKey: 1, step: 1},
+		"SCARD":     {handler: SCARD, arity: 2, flags: cmdReadonly, firstKey: 1, lastKey: 1, step: 1},
+		"SPOP":      {handler: SPOP, arity: -2, flags: cmdWrite, firstKey: 1, lastKey: 1, step: 1},
+		"LPUSH":     {handler: LPUSH, arity: -3, flags: cmdWrite, firstKey: 1, lastKey: 1, step: 1},
+		"RPUSH":     {handler: RPUSH, arity: -3, flags: cmdWrite, firstKey: 1, lastKey: 1, step: 1},
+		"LPOP":      {handler: LPOP, arity: -2, flags: cmdWrite, firstKey: 1, lastKey: 1, step: 1},
+		"RPOP":      {handler: RPOP, arity: -2, flags: cmdWrite, firstKey: 1, lastKey: 1, step: 1},
+		"LLEN":      {handler: LLEN, arity: 2, flags: cmdReadonly, firstKey: 1, lastKey: 1, step: 1},
+		"LINDEX":    {handler: LINDEX, arity: 3, flags: cmdReadonly, firstKey: 1, lastKey: 1, step: 1},
+		"LRANGE":    {handler: LRANGE, arity: 4, flags: cmdReadonly, firstKey: 1, lastKey: 1, step: 1},
+
+		"SETBIT":      {handler: SETBIT, arity: 4, flags: cmdWrite, firstKey: 1, lastKey: 1, step: 1},
+		"GETBIT":      {handler: GETBIT, arity: 3, flags: cmdReadonly, firstKey: 1, lastKey: 1, step: 1},
+		"BITCOUNT":    {handler: BITCOUNT, arity: -2, flags: cmdReadonly, firstKey: 1, lastKey: 1, step: 1},
+		"BITPOS":      {handler: BITPOS, arity: -3, flags: cmdReadonly, firstKey: 1, lastKey: 1, step: 1},
+		"BITOP":       {handler: BITOP, arity: -4, flags: cmdWrite, firstKey: 2, lastKey: -1, step: 1},
+		"BITFIELD":    {handler: BITFIELD, arity: -2, flags: cmdWrite, firstKey: 1, lastKey: 1, step: 1},
+		"BITFIELD_RO": {handler: BITFIELD_RO, arity: -2, flags: cmdReadonly, firstKey: 1, lastKey: 1, step: 1},
+
+		"ZADD":      {handler: ZADD, arity: -4, flags: cmdWrite, firstKey: 1, lastKey: 1, step: 1},
+		"ZINCRBY":   {handler: ZINCRBY, arity: 4, flags: cmdWrite, firstKey: 1, lastKey: 1, step: 1},
+		"ZREM":      {handler: ZREM, arity: -3, flags: cmdWrite, firstKey: 1, lastKey: 1, step: 1},
+		"ZCARD":     {handler: ZCARD, arity: 2, flags: cmdReadonly, firstKey: 1, lastKey: 1, step: 1},
+		"ZSCORE":    {handler: ZSCORE, arity: 3, flags: cmdReadonly, firstKey: 1, lastKey: 1, step: 1},
+		"ZMSCORE":   {handler: ZMSCORE, arity: -3, flags: cmdReadonly, firstKey: 1, lastKey: 1, step: 1},
+		"ZRANK":     {handler: ZRANK, arity: -3, flags: cmdReadonly, firstKey: 1, lastKey: 1, step: 1},
+		"ZREVRANK":  {handler: ZREVRANK, arity: -3, flags: cmdReadonly, firstKey: 1, lastKey: 1, step: 1},
+		"ZCOUNT":    {handler: ZCOUNT, arity: 4, flags: cmdReadonly, firstKey: 1, lastKey: 1, step: 1},
+		"ZRANGE":    {handler: ZRANGE, arity: -4, flags: cmdReadonly, firstKey: 1, lastKey: 1, step: 1},
+		"GEOADD":    {handler: GEOADD, arity: -5, flags: cmdWrite, firstKey: 1, lastKey: 1, step: 1},
+		"GEODIST":   {handler: GEODIST, arity: -4, flags: cmdReadonly, firstKey: 1, lastKey: 1, step: 1},
+		"GEOPOS":    {handler: GEOPOS, arity: -2, flags: cmdReadonly, firstKey: 1, lastKey: 1, step: 1},
+		"GEOHASH":   {handler: GEOHASH, arity: -2, flags: cmdReadonly, firstKey: 1, lastKey: 1, step: 1},
+		"GEOSEARCH": {handler: GEOSEARCH, arity: -7, flags: cmdReadonly, firstKey: 1, lastKey: 1, step: 1},
+
+		"PFADD":   {handler: PFADD, arity: -2, flags: cmdWrite, firstKey: 1, lastKey: 1, step: 1},
+		"PFCOUNT": {handler: PFCOUNT, arity: -2, flags: cmdReadonly, firstKey: 1, lastKey: -1, step: 1},
+		"PFMERGE": {handler: PFMERGE, arity: -2, flags: cmdWrite, firstKey: 1, lastKey: -1, step: 1},
+
+		"EXISTS":    {handler: EXISTS, arity: -2, flags: cmdReadonly, firstKey: 1, lastKey: -1, step: 1},
+		"TOUCH":     {handler: TOUCH, arity: -2, flags: cmdReadonly, firstKey: 1, lastKey: -1, step: 1},
+		"UNLINK":    {handler: UNLINK, arity: -2, flags: cmdWrite, firstKey: 1, lastKey: -1, step: 1},
+		"RANDOMKEY": {handler: RANDOMKEY, arity: 1, flags: cmdReadonly},
+		"SCAN":      {handler: SCAN, arity: -2, flags: cmdReadonly},
+		"RENAME":    {handler: RENAME, arity: 3, flags: cmdWrite, firstKey: 1, lastKey: 2, step: 1},
+		"RENAMENX":  {handler: RENAMENX, arity: 3, flags: cmdWrite, firstKey: 1, lastKey: 2, step: 1},
+		"COPY":      {handler: COPY, arity: -3, flags: cmdWrite, firstKey: 1, lastKey: 2, step: 1},
+		"DUMP":      {handler: DUMP, arity: 2, flags: cmdReadonly, firstKey: 1, lastKey: 1, step: 1},
+		"RESTORE":   {handler: RESTORE, arity: -4, flags: cmdWrite, firstKey: 1, lastKey: 1, step: 1},
+		"PEXPIREAT": {handler: PEXPIREAT, arity: -3, flags: cmdWrite, firstKey: 1, lastKey: 1, step: 1},
+
+		"OBJECT": {handler: OBJECT, arity: -2, flags: cmdReadonly, firstKey: 2, lastKey: 2, step: 1},
+		"MEMORY": {handler: MEMORY, arity: -2, flags: cmdReadonly, firstKey: 2, lastKey: 2, step: 1},
+		"DEBUG":  {handler: DEBUG, arity: -2},
+
+		"SUBSCRIBE":    {handler: SUBSCRIBE, arity: -2, flags: cmdNoScript},
+		"PSUBSCRIBE":   {handler: PSUBSCRIBE, arity: -2, flags: cmdNoScript},
+		"UNSUBSCRIBE":  {handler: UNSUBSCRIBE, arity: -1, flags: cmdNoScript},
+		"PUNSUBSCRIBE": {handler: PUNSUBSCRIBE, arity: -1, flags: cmdNoScript},
+		"PUBLISH":      {handler: PUBLISH, arity: 3},
+		"PUBSUB":       {handler: PUBSUB, arity: -2},
+
+		"FUNCTION": {handler: FUNCTION, arity: -2, flags: cmdNoScript},
+		"FCALL":    {handler: FCALL, arity: -3, flags: cmdNoScript},
+		"FCALL_RO": {handler: FCALL_RO, arity: -3, flags: cmdNoScript},
+	}
+
+	Handlers = make(map[string]HandlerFunc, len(commands))
+	for name, cmd := range commands {
+		Handlers[name] = middleware(cmd)
+	}
+}
+
+// keys returns the key arguments of a command invocation, args includes the command name
+func (cmd *command) keys(args []Value) []string {
+	if cmd.firstKey == 0 || cmd.firstKey >= len(args) {
+		return nil
+	}
+	last := cmd.lastKey
+	if last < 0 {
+		last = len(args) + last
+	}
+	var keys []string
+	for i := cmd.firstKey; i <= last && i < len(args); i += cmd.step {
+		keys = append(keys, args[i].Bulk)
+	}
+	return keys
+}
+
+func EXPIRE(c *Client, args []Value) Value {
 	key := args[0].Bulk
 
 	if len(args) < 2 {
@@ -63,11 +230,42 @@ func EXPIRE(args []Value) Value {
 			}
 		}
 	}
+	return setExpire(c, key, ttl, nx, xx, gt, lt)
+}
 
+// PEXPIREAT key unix-time-milliseconds [NX | XX | GT | LT]
+func PEXPIREAT(c *Client, args []Value) Value {
+	key := args[0].Bulk
+	ms, err := strconv.ParseInt(args[1].Bulk, 10, 64)
+	if err != nil {
+		return errVal("value is not an integer or out of range")
+	}
+
+	var nx, xx, gt, lt bool
+	for _, arg := range args[2:] {
+		switch strings.ToUpper(arg.Bulk) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "GT":
+			gt = true
+		case "LT":
+			lt = true
+		default:
+			return syntaxErr()
+		}
+	}
+	return setExpire(c, key, time.UnixMilli(ms), nx, xx, gt, lt)
+}
+
+// setExpire sets the ttl of key unless the NX, XX, GT or LT condition rules it out. The
+// ttl is propagated as PEXPIREAT, so replaying it later doesn't push the expiry back.
+func setExpire(c *Client, key string, ttl time.Time, nx, xx, gt, lt bool) Value {
 	status := 0
 
-	storeMu.Lock()
-	if v, ok := store[key]; ok {
+	unlock := c.store.lock(key)
+	if v, ok := c.store.getLive(key); ok {
 		shouldSet := false
 		if nx && v.ttl.IsZero() {
 			shouldSet = true
@@ -87,67 +285,68 @@ func EXPIRE(args []Value) Value {
 		if shouldSet {
 			status = 1
 			v.ttl = ttl
+			c.replicateAs("PEXPIREAT", key, strconv.FormatInt(ttl.UnixMilli(), 10))
+			c.signalModifiedKey(key)
+			c.srv.notify(notifyGeneric, "expire", key)
 		}
 	}
-	storeMu.Unlock()
+	unlock()
 
 	return Value{Type: "integer", Int: status}
 }
 
-func PERSIST(args []Value) Value {
+func PERSIST(c *Client, args []Value) Value {
 	key := args[0].Bulk
-	if v, ok := store[key]; ok {
-		storeMu.Lock()
-		v.ttl = time.Time{}
-		storeMu.Unlock()
+
+	defer c.store.lock(key)()
+
+	v, ok := c.store.getLive(key)
+	if !ok || v.ttl.IsZero() {
+		return intVal(0)
 	}
-	return ok()
+	v.ttl = time.Time{}
+	c.signalModifiedKey(key)
+	c.srv.notify(notifyGeneric, "persist", key)
+	return intVal(1)
 }
 
-func KEYS(args []Value) Value {
-	typ := args[0].Bulk
+// KEYS pattern
+func KEYS(c *Client, args []Value) Value {
+	pattern := args[0].Bulk
 
-	storeMu.RLock()
-	defer storeMu.RUnlock()
+	defer c.store.rlockAll()()
 
 	v := Value{Type: "array", Array: []Value{}}
 
-	for _, item := range store {
-		switch typ {
-		case "*":
-			if itemVal, ok := item.value.(string); ok {
-				newVal := Value{Type: "bulk", Bulk: itemVal}
-				v.Array = append(v.Array, newVal)
-			}
+	c.store.each(func(key string, item *RedisItem) {
+		if !isExpired(item.ttl) && matchPattern(pattern, key) {
+			v.Array = append(v.Array, bulkVal(key))
 		}
-	}
+	})
 
 	return v
 }
 
-func FLUSHALL(args []Value) Value {
-	storeMu.Lock()
-	store = make(map[string]*RedisItem)
-	storeMu.Unlock()
+func FLUSHALL(c *Client, args []Value) Value {
+	unlock := c.store.lockAll()
+	n := c.store.flush()
+	c.propagate()
+	unlock()
+	c.srv.dirty.Add(int64(n) + 1)
+	c.srv.tracking.invalidateAll()
 	return ok()
 }
 
-func TTL(args []Value) Value {
+func TTL(c *Client, args []Value) Value {
 	key := args[0].Bulk
 
-	storeMu.Lock()
-	defer storeMu.Unlock()
+	defer c.store.lock(key)()
 
-	obj, ok := store[key]
+	obj, ok := c.store.getLive(key)
 	if !ok {
 		return intVal(-2)
 	}
 
-	if isExpired(obj.ttl) {
-		delete(store, key)
-		return intVal(-2)
-	}
-
 	if obj.ttl.IsZero() {
 		return intVal(-1)
 	}
@@ -155,7 +354,17 @@ func TTL(args []Value) Value {
 	return intVal(int(time.Until(obj.ttl).Seconds()))
 }
 
-func PONG(args []Value) Value {
+func PONG(c *Client, args []Value) Value {
+	if c.subscribed() {
+		msg := ""
+		if len(args) > 0 {
+			msg = args[0].Bulk
+		}
+		return Value{Type: "array", Array: []Value{bulkVal("pong"), bulkVal(msg)}}
+	}
+	if len(args) > 0 {
+		return bulkVal(args[0].Bulk)
+	}
 	return strVal("PONG")
 }
 
@@ -173,15 +382,33 @@ func UnknownCmd(cmd string, args []Value) Value {
 	return errVal(msg)
 }
 
-func addTTL(strVal string, dur time.Duration) (time.Time, error) {
-	ttl, err := strconv.Atoi(strVal)
+// expireAt converts the argument of an EX, PX, EXAT or PXAT option of cmd to an absolute time
+func expireAt(opt, arg, cmd string) (time.Time, error) {
+	n, err := strconv.ParseInt(arg, 10, 64)
 	if err != nil {
 		return time.Time{}, errors.New("value is not an integer or out of range")
 	}
-	if ttl < 0 {
-		return time.Time{}, errors.New("invalid expire time in 'set' command")
+	invalid := fmt.Errorf("invalid expire time in '%s' command", cmd)
+	if n <= 0 {
+		return time.Time{}, invalid
+	}
+
+	switch opt {
+	case "EX":
+		if n > math.MaxInt64/int64(time.Second) {
+			return time.Time{}, invalid
+		}
+		return time.Now().Add(time.Duration(n) * time.Second), nil
+	case "PX":
+		if n > math.MaxInt64/int64(time.Millisecond) {
+			return time.Time{}, invalid
+		}
+		return time.Now().Add(time.Duration(n) * time.Millisecond), nil
+	case "EXAT":
+		return time.Unix(n, 0), nil
+	default:
+		return time.UnixMilli(n), nil
 	}
-	return time.Now().Add(time.Duration(ttl) * dur), nil
 }
 
 func isExpired(ttl time.Time) bool {
@@ -0,0 +1,296 @@
+package redis
+
+import (
+	"math"
+	"strconv"
+)
+
+// The helpers below work on hash items of either encoding. A listpack hash converts to a
+// hashtable once it holds more than hash-max-listpack-entries fields or a field or value
+// longer than hash-max-listpack-value bytes.
+
+func hashGet(item *RedisItem, field string) (string, bool) {
+	switch h := item.value.(type) {
+	case *listpack:
+		for i := 0; i < len(h.entries); i += 2 {
+			if h.entries[i] == field {
+				return h.entries[i+1], true
+			}
+		}
+		return "", false
+	case map[string]string:
+		v, ok := h[field]
+		return v, ok
+	}
+	return "", false
+}
+
+// hashSet reports whether field is new
+func hashSet(l *encodingLimits, item *RedisItem, field, value string) bool {
+	if h, ok := item.value.(*listpack); ok {
+		for i := 0; i < len(h.entries); i += 2 {
+			if h.entries[i] == field {
+				h.entries[i+1] = value
+				return false
+			}
+		}
+		maxValue := int(l.hashMaxListpackValue.Load())
+		if len(h.entries)/2 < int(l.hashMaxListpackEntries.Load()) && len(field) <= maxValue && len(value) <= maxValue {
+			h.entries = append(h.entries, field, value)
+			return true
+		}
+		hashConvert(item)
+	}
+
+	h := item.value.(map[string]string)
+	_, exists := h[field]
+	h[field] = value
+	return !exists
+}
+
+func hashConvert(item *RedisItem) {
+	lp := item.value.(*listpack)
+	h := make(map[string]string, len(lp.entries)/2+1)
+	for i := 0; i < len(lp.entries); i += 2 {
+		h[lp.entries[i]] = lp.entries[i+1]
+	}
+	item.value = h
+}
+
+func hashDel(item *RedisItem, field string) bool {
+	switch h := item.value.(type) {
+	case *listpack:
+		for i := 0; i < len(h.entries); i += 2 {
+			if h.entries[i] == field {
+				h.entries = append(h.entries[:i], h.entries[i+2:]...)
+				return true
+			}
+		}
+	case map[string]string:
+		if _, ok := h[field]; ok {
+			delete(h, field)
+			return true
+		}
+	}
+	return false
+}
+
+func hashLen(item *RedisItem) int {
+	switch h := item.value.(type) {
+	case *listpack:
+		return len(h.entries) / 2
+	case map[string]string:
+		return len(h)
+	}
+	return 0
+}
+
+func hashEach(item *RedisItem, fn func(field, value string)) {
+	switch h := item.value.(type) {
+	case *listpack:
+		for i := 0; i < len(h.entries); i += 2 {
+			fn(h.entries[i], h.entries[i+1])
+		}
+	case map[string]string:
+		for f, v := range h {
+			fn(f, v)
+		}
+	}
+}
+
+// HSET key field value [field value ...]
+func HSET(c *Client, args []Value) Value {
+	if len(args)%2 != 1 {
+		return errWrongArgs("hset")
+	}
+	key := args[0].Bulk
+
+	defer c.store.lock(key)()
+
+	item, ok := c.store.lookup(key)
+	if ok && item.itemType != REDIS_HASH {
+		return errWrongType()
+	}
+	if !ok {
+		item = newItem(REDIS_HASH, &listpack{})
+		c.store.set(key, item)
+		c.srv.notify(notifyNew, "new", key)
+	}
+
+	added := 0
+	for i := 1; i < len(args); i += 2 {
+		if hashSet(c.srv.encLimits, item, args[i].Bulk, args[i+1].Bulk) {
+			added++
+		}
+	}
+
+	c.signalModifiedKey(key)
+	c.srv.notify(notifyHash, "hset", key)
+	return intVal(added)
+}
+
+// HGET key field
+func HGET(c *Client, args []Value) Value {
+	key := args[0].Bulk
+	defer c.store.rlock(key)()
+
+	item, ok := c.store.lookupRead(key)
+	if !ok {
+		return nullVal()
+	}
+	if item.itemType != REDIS_HASH {
+		return errWrongType()
+	}
+	if v, ok := hashGet(item, args[1].Bulk); ok {
+		return bulkVal(v)
+	}
+	return nullVal()
+}
+
+// HMGET key field [field ...]
+func HMGET(c *Client, args []Value) Value {
+	key := args[0].Bulk
+	defer c.store.rlock(key)()
+
+	item, ok := c.store.lookupRead(key)
+	if ok && item.itemType != REDIS_HASH {
+		return errWrongType()
+	}
+
+	res := Value{Type: "array", Array: make([]Value, 0, len(args)-1)}
+	for _, f := range args[1:] {
+		v, found := "", false
+		if ok {
+			v, found = hashGet(item, f.Bulk)
+		}
+		if found {
+			res.Array = append(res.Array, bulkVal(v))
+		} else {
+			res.Array = append(res.Array, nullVal())
+		}
+	}
+	return res
+}
+
+// HGETALL key, a map for RESP3 clients
+func HGETALL(c *Client, args []Value) Value {
+	key := args[0].Bulk
+	defer c.store.rlock(key)()
+
+	item, ok := c.store.lookupRead(key)
+	if !ok {
+		return c.mapVal([]Value{})
+	}
+	if item.itemType != REDIS_HASH {
+		return errWrongType()
+	}
+
+	kv := make([]Value, 0, 2*hashLen(item))
+	hashEach(item, func(f, v string) {
+		kv = append(kv, bulkVal(f), bulkVal(v))
+	})
+	return c.mapVal(kv)
+}
+
+// HDEL key field [field ...]
+func HDEL(c *Client, args []Value) Value {
+	key := args[0].Bulk
+	defer c.store.lock(key)()
+
+	item, ok := c.store.lookup(key)
+	if !ok {
+		return intVal(0)
+	}
+	if item.itemType != REDIS_HASH {
+		return errWrongType()
+	}
+
+	n := 0
+	for _, f := range args[1:] {
+		if hashDel(item, f.Bulk) {
+			n++
+		}
+	}
+	if n > 0 {
+		c.signalModifiedKey(key)
+		c.srv.notify(notifyHash, "hdel", key)
+		if hashLen(item) == 0 {
+			c.store.del(key)
+			c.srv.notify(notifyGeneric, "del", key)
+		}
+	}
+	return intVal(n)
+}
+
+// HLEN key
+func HLEN(c *Client, args []Value) Value {
+	key := args[0].Bulk
+	defer c.store.rlock(key)()
+
+	item, ok := c.store.lookupRead(key)
+	if !ok {
+		return intVal(0)
+	}
+	if item.itemType != REDIS_HASH {
+		return errWrongType()
+	}
+	return intVal(hashLen(item))
+}
+
+// HEXISTS key field
+func HEXISTS(c *Client, args []Value) Value {
+	key := args[0].Bulk
+	defer c.store.rlock(key)()
+
+	item, ok := c.store.lookupRead(key)
+	if !ok {
+		return intVal(0)
+	}
+	if item.itemType != REDIS_HASH {
+		return errWrongType()
+	}
+	if _, ok := hashGet(item, args[1].Bulk); ok {
+		return intVal(1)
+	}
+	return intVal(0)
+}
+
+// HINCRBY key field increment
+func HINCRBY(c *Client, args []Value) Value {
+	key, field := args[0].Bulk, args[1].Bulk
+	incr, err := strconv.ParseInt(args[2].Bulk, 10, 64)
+	if err != nil {
+		return errVal("value is not an integer or out of range")
+	}
+
+	defer c.store.lock(key)()
+
+	item, ok := c.store.lookup(key)
+	if ok && item.itemType != REDIS_HASH {
+		return errWrongType()
+	}
+
+	var cur int64
+	if ok {
+		if v, found := hashGet(item, field); found {
+			if cur, err = strconv.ParseInt(v, 10, 64); err != nil {
+				return errVal("hash value is not an integer")
+			}
+		}
+	}
+	if incr > 0 && cur > math.MaxInt64-incr || incr < 0 && cur < math.MinInt64-incr {
+		return errVal("increment or decrement would overflow")
+	}
+	cur += incr
+
+	if !ok {
+		item = newItem(REDIS_HASH, &listpack{})
+		c.store.set(key, item)
+		c.srv.notify(notifyNew, "new", key)
+	}
+	hashSet(c.srv.encLimits, item, field, strconv.FormatInt(cur, 10))
+
+	c.signalModifiedKey(key)
+	c.srv.notify(notifyHash, "hincrby", key)
+	return intVal(int(cur))
+}
@@ -0,0 +1,550 @@
+package redis
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// HyperLogLogs are string values laid out like in Redis, so that a DUMP or GET of one
+// is meaningful to other tools:
+//
+//	"HYLL" | encoding (0 dense, 1 sparse) | 3 unused | cached cardinality (uint64 LE)
+//
+// The msb of the last cardinality byte flags the cache as stale. Dense HLLs store 16384
+// registers of 6 bits, sparse ones run length encode them with the opcodes
+//
+//	00xxxxxx           ZERO   1-64 zero registers
+//	01xxxxxx yyyyyyyy  XZERO  1-16384 zero registers
+//	1vvvvvxx           VAL    1-4 registers set to 1-32
+//
+// A sparse HLL turns dense once a register needs a value above 32 or it grows past
+// hll-sparse-max-bytes.
+const (
+	hllP          = 14
+	hllQ          = 64 - hllP
+	hllRegisters  = 1 << hllP
+	hllBits       = 6
+	hllHdrSize    = 16
+	hllDenseSize  = hllHdrSize + (hllRegisters*hllBits+7)/8
+	hllDense      = 0
+	hllSparse     = 1
+	hllSparseMax  = 32
+	hllXZeroMax   = 16384
+	hllZeroMax    = 64
+	hllValRunMax  = 4
+	hllAlphaInf   = 0.721347520444481703680 // 0.5/ln(2)
+	hllHashSeed   = 0xadc83b19
+	hllStaleCache = 1 << 7
+)
+
+var errHLLCorrupt = errors.New("INVALIDOBJ Corrupted HLL object detected")
+
+func errNotHLL() Value {
+	return errCode("WRONGTYPE", "Key is not a valid HyperLogLog string value.")
+}
+
+// murmurHash64A is the hash Redis uses for HLLs
+func murmurHash64A(key string, seed uint64) uint64 {
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+	h := seed ^ uint64(len(key))*m
+
+	data := key
+	for len(data) >= 8 {
+		k := binary.LittleEndian.Uint64([]byte(data[:8]))
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+		data = data[8:]
+	}
+
+	switch len(data) {
+	case 7:
+		h ^= uint64(data[6]) << 48
+		fallthrough
+	case 6:
+		h ^= uint64(data[5]) << 40
+		fallthrough
+	case 5:
+		h ^= uint64(data[4]) << 32
+		fallthrough
+	case 4:
+		h ^= uint64(data[3]) << 24
+		fallthrough
+	case 3:
+		h ^= uint64(data[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint64(data[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint64(data[0])
+		h *= m
+	}
+
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
+
+// hllPatLen returns the register of elem and the length of the run of zeros in the rest
+// of its hash plus one, the value the register has to reach
+func hllPatLen(elem string) (int, uint8) {
+	hash := murmurHash64A(elem, hllHashSeed)
+	index := int(hash & (hllRegisters - 1))
+	hash >>= hllP
+	hash |= 1 << hllQ
+	count := uint8(1)
+	for bit := uint64(1); hash&bit == 0; bit <<= 1 {
+		count++
+	}
+	return index, count
+}
+
+func newHLL() []byte {
+	b := make([]byte, hllHdrSize, hllHdrSize+2)
+	copy(b, "HYLL")
+	b[4] = hllSparse
+	hllInvalidate(b)
+	return append(b, 0x40|byte((hllRegisters-1)>>8), byte((hllRegisters-1)&0xff))
+}
+
+// isHLL checks the header, the sparse body is validated when it is decoded
+func isHLL(b []byte) bool {
+	if len(b) < hllHdrSize || string(b[:4]) != "HYLL" {
+		return false
+	}
+	switch b[4] {
+	case hllDense:
+		return len(b) == hllDenseSize
+	case hllSparse:
+		return true
+	}
+	return false
+}
+
+func hllInvalidate(b []byte) { b[15] |= hllStaleCache }
+
+func hllCached(b []byte) (uint64, bool) {
+	if b[15]&hllStaleCache != 0 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint64(b[8:16]), true
+}
+
+func hllSetCache(b []byte, card uint64) {
+	binary.LittleEndian.PutUint64(b[8:16], card)
+}
+
+func denseGet(regs []byte, i int) uint8 {
+	byteIdx := i * hllBits / 8
+	fb := uint(i * hllBits & 7)
+	v := regs[byteIdx] >> fb
+	if byteIdx+1 < len(regs) {
+		v |= regs[byteIdx+1] << (8 - fb)
+	}
+	return v & (1<<hllBits - 1)
+}
+
+func denseSet(regs []byte, i int, val uint8) {
+	byteIdx := i * hllBits / 8
+	fb := uint(i * hllBits & 7)
+	regs[byteIdx] &^= (1<<hllBits - 1) << fb
+	regs[byteIdx] |= val << fb
+	if byteIdx+1 < len(regs) {
+		regs[byteIdx+1] &^= (1<<hllBits - 1) >> (8 - fb)
+		regs[byteIdx+1] |= val >> (8 - fb)
+	}
+}
+
+// hllRun is a sequence of n registers with the same value
+type hllRun struct {
+	val uint8
+	n   int
+}
+
+func sparseDecode(body []byte) ([]hllRun, error) {
+	var runs []hllRun
+	total := 0
+	for i := 0; i < len(body); i++ {
+		op := body[i]
+		var r hllRun
+		switch {
+		case op&0xc0 == 0x00:
+			r.n = int(op&0x3f) + 1
+		case op&0xc0 == 0x40:
+			if i+1 >= len(body) {
+				return nil, errHLLCorrupt
+			}
+			r.n = int(op&0x3f)<<8 | int(body[i+1]) + 1
+			i++
+		default:
+			r.val = (op>>2)&0x1f + 1
+			r.n = int(op&0x3) + 1
+		}
+		total += r.n
+		if k := len(runs) - 1; k >= 0 && runs[k].val == r.val {
+			runs[k].n += r.n
+		} else {
+			runs = append(runs, r)
+		}
+	}
+	if total != hllRegisters {
+		return nil, errHLLCorrupt
+	}
+	return runs, nil
+}
+
+func sparseEncode(b []byte, runs []hllRun) []byte {
+	for _, r := range runs {
+		for n := r.n; n > 0; {
+			switch {
+			case r.val == 0 && n <= hllZeroMax:
+				b = append(b, byte(n-1))
+				n = 0
+			case r.val == 0:
+				k := min(n, hllXZeroMax)
+				b = append(b, 0x40|byte((k-1)>>8), byte((k-1)&0xff))
+				n -= k
+			default:
+				k := min(n, hllValRunMax)
+				b = append(b, 0x80|(r.val-1)<<2|byte(k-1))
+				n -= k
+			}
+		}
+	}
+	return b
+}
+
+// hllRegistersOf returns one byte per register of an HLL of either encoding
+func hllRegistersOf(b []byte) ([]uint8, error) {
+	regs := make([]uint8, hllRegisters)
+	if b[4] == hllDense {
+		for i := range regs {
+			regs[i] = denseGet(b[hllHdrSize:], i)
+		}
+		return regs, nil
+	}
+	runs, err := sparseDecode(b[hllHdrSize:])
+	if err != nil {
+		return nil, err
+	}
+	i := 0
+	for _, r := range runs {
+		for j := 0; j < r.n; j++ {
+			regs[i] = r.val
+			i++
+		}
+	}
+	return regs, nil
+}
+
+// hllFromRegisters encodes regs, sparse when allowed and it fits within maxSparse bytes
+func hllFromRegisters(regs []uint8, sparse bool, maxSparse int) []byte {
+	if sparse {
+		var runs []hllRun
+		for _, v := range regs {
+			if v > hllSparseMax {
+				sparse = false
+				break
+			}
+			if k := len(runs) - 1; k >= 0 && runs[k].val == v {
+				runs[k].n++
+			} else {
+				runs = append(runs, hllRun{val: v, n: 1})
+			}
+		}
+		if sparse {
+			b := newHLL()[:hllHdrSize]
+			b = sparseEncode(b, runs)
+			if len(b)-hllHdrSize <= maxSparse {
+				return b
+			}
+		}
+	}
+
+	b := make([]byte, hllDenseSize)
+	copy(b, "HYLL")
+	b[4] = hllDense
+	hllInvalidate(b)
+	for i, v := range regs {
+		denseSet(b[hllHdrSize:], i, v)
+	}
+	return b
+}
+
+// hllAdd adds elem to the HLL b and returns the possibly reallocated HLL and whether a
+// register changed
+func hllAdd(b []byte, elem string, maxSparse int) ([]byte, bool, error) {
+	index, count := hllPatLen(elem)
+
+	if b[4] == hllDense {
+		regs := b[hllHdrSize:]
+		if denseGet(regs, index) >= count {
+			return b, false, nil
+		}
+		denseSet(regs, index, count)
+		return b, true, nil
+	}
+
+	runs, err := sparseDecode(b[hllHdrSize:])
+	if err != nil {
+		return b, false, err
+	}
+
+	start := 0
+	for i, r := range runs {
+		if index >= start+r.n {
+			start += r.n
+			continue
+		}
+		if r.val >= count {
+			return b, false, nil
+		}
+		if count > hllSparseMax {
+			regs, err := hllRegistersOf(b)
+			if err != nil {
+				return b, false, err
+			}
+			regs[index] = count
+			return hllFromRegisters(regs, false, 0), true, nil
+		}
+
+		// split the run around the register
+		split := make([]hllRun, 0, 3)
+		if before := index - start; before > 0 {
+			split = append(split, hllRun{val: r.val, n: before})
+		}
+		split = append(split, hllRun{val: count, n: 1})
+		if after := start + r.n - index - 1; after > 0 {
+			split = append(split, hllRun{val: r.val, n: after})
+		}
+		runs = append(runs[:i], append(split, runs[i+1:]...)...)
+		break
+	}
+
+	out := sparseEncode(b[:hllHdrSize:hllHdrSize], mergeRuns(runs))
+	if len(out)-hllHdrSize > maxSparse {
+		regs, err := hllRegistersOf(out)
+		if err != nil {
+			return b, false, err
+		}
+		return hllFromRegisters(regs, false, 0), true, nil
+	}
+	return out, true, nil
+}
+
+func mergeRuns(runs []hllRun) []hllRun {
+	merged := runs[:0]
+	for _, r := range runs {
+		if k := len(merged) - 1; k >= 0 && merged[k].val == r.val {
+			merged[k].n += r.n
+		} else {
+			merged = append(merged, r)
+		}
+	}
+	return merged
+}
+
+// hllCount estimates the cardinality with the estimator of Otmar Ertl, "New cardinality
+// estimation algorithms for HyperLogLog sketches", the one Redis uses
+func hllCount(regs []uint8) uint64 {
+	var histo [64]int
+	for _, v := range regs {
+		histo[v]++
+	}
+
+	m := float64(hllRegisters)
+	z := m * hllTau((m-float64(histo[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histo[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histo[0])/m)
+	return uint64(math.Round(hllAlphaInf * m * m / z))
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y, z := 1.0, x
+	for {
+		x *= x
+		zPrime := z
+		z += x * y
+		y += y
+		if zPrime == z {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		zPrime := z
+		y *= 0.5
+		z -= math.Pow(1-x, 2) * y
+		if zPrime == z {
+			return z / 3
+		}
+	}
+}
+
+// lookupHLL returns the HLL bytes stored at key, ok is false with the reply to send when
+// the key holds something else
+func lookupHLL(item *RedisItem) ([]byte, Value, bool) {
+	if item.itemType != REDIS_STRING {
+		return nil, errWrongType(), false
+	}
+	b := item.bytesView()
+	if !isHLL(b) {
+		return nil, errNotHLL(), false
+	}
+	return b, Value{}, true
+}
+
+// PFADD key [element ...]
+func PFADD(c *Client, args []Value) Value {
+	key := args[0].Bulk
+	defer c.store.lock(key)()
+
+	changed := false
+	item, ok := c.store.lookup(key)
+	if !ok {
+		item = newItem(REDIS_STRING, newHLL())
+		c.store.set(key, item)
+		c.srv.notify(notifyNew, "new", key)
+		changed = true
+	} else if _, errReply, ok := lookupHLL(item); !ok {
+		return errReply
+	}
+
+	b := item.mutableBytes(0)
+	maxSparse := int(c.srv.encLimits.hllSparseMaxBytes.Load())
+	for _, elem := range args[1:] {
+		var updated bool
+		var err error
+		if b, updated, err = hllAdd(b, elem.Bulk, maxSparse); err != nil {
+			return errVal(err.Error())
+		}
+		changed = changed || updated
+	}
+	item.value = b
+
+	if !changed {
+		return intVal(0)
+	}
+	hllInvalidate(b)
+	c.signalModifiedKey(key)
+	c.srv.notify(notifyString, "pfadd", key)
+	return intVal(1)
+}
+
+// PFCOUNT key [key ...], a single key caches its cardinality in the HLL header
+func PFCOUNT(c *Client, args []Value) Value {
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		keys[i] = arg.Bulk
+	}
+	defer c.store.lock(keys...)()
+
+	if len(keys) == 1 {
+		item, ok := c.store.lookup(keys[0])
+		if !ok {
+			return intVal(0)
+		}
+		if _, errReply, ok := lookupHLL(item); !ok {
+			return errReply
+		}
+		b := item.mutableBytes(0)
+		if card, ok := hllCached(b); ok {
+			return intVal(int(card))
+		}
+		regs, err := hllRegistersOf(b)
+		if err != nil {
+			return errVal(err.Error())
+		}
+		card := hllCount(regs)
+		hllSetCache(b, card)
+		return intVal(int(card))
+	}
+
+	union := make([]uint8, hllRegisters)
+	for _, key := range keys {
+		item, ok := c.store.lookup(key)
+		if !ok {
+			continue
+		}
+		b, errReply, ok := lookupHLL(item)
+		if !ok {
+			return errReply
+		}
+		regs, err := hllRegistersOf(b)
+		if err != nil {
+			return errVal(err.Error())
+		}
+		for i, v := range regs {
+			union[i] = max(union[i], v)
+		}
+	}
+	return intVal(int(hllCount(union)))
+}
+
+// PFMERGE destkey [sourcekey ...]
+func PFMERGE(c *Client, args []Value) Value {
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		keys[i] = arg.Bulk
+	}
+	dest := keys[0]
+	defer c.store.lock(keys...)()
+
+	union := make([]uint8, hllRegisters)
+	sparse := true
+	destExists := false
+	for i, key := range keys {
+		item, ok := c.store.lookup(key)
+		if !ok {
+			continue
+		}
+		b, errReply, ok := lookupHLL(item)
+		if !ok {
+			return errReply
+		}
+		if i == 0 {
+			destExists = true
+		}
+		if b[4] == hllDense {
+			sparse = false
+		}
+		regs, err := hllRegistersOf(b)
+		if err != nil {
+			return errVal(err.Error())
+		}
+		for j, v := range regs {
+			union[j] = max(union[j], v)
+		}
+	}
+
+	b := hllFromRegisters(union, sparse, int(c.srv.encLimits.hllSparseMaxBytes.Load()))
+	if destExists {
+		item, _ := c.store.lookup(dest)
+		item.value = b
+	} else {
+		c.store.set(dest, newItem(REDIS_STRING, b))
+		c.srv.notify(notifyNew, "new", dest)
+	}
+	c.signalModifiedKey(dest)
+	c.srv.notify(notifyString, "pfadd", dest)
+	return ok()
+}
@@ -0,0 +1,148 @@
+package redis
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// pfadd adds the elements prefix:from..prefix:to-1 to key in batches
+func pfadd(t *testing.T, n *testNode, key, prefix string, from, to int) {
+	for i := from; i < to; i += 1000 {
+		args := []string{"PFADD", key}
+		for j := i; j < min(i+1000, to); j++ {
+			args = append(args, fmt.Sprintf("%s:%d", prefix, j))
+		}
+		n.do(t, args...)
+	}
+}
+
+func hllEncoding(t *testing.T, n *testNode, key string) byte {
+	v := n.do(t, "GET", key).Bulk
+	require.True(t, isHLL([]byte(v)))
+	return v[4]
+}
+
+func TestHyperLogLog_Basics(t *testing.T) {
+	n := startTestServer(t, Config{})
+
+	require.Equal(t, 1, n.do(t, "PFADD", "hll").Int)
+	require.Equal(t, 0, n.do(t, "PFADD", "hll").Int)
+	require.Equal(t, 0, n.do(t, "PFCOUNT", "hll").Int)
+
+	require.Equal(t, 1, n.do(t, "PFADD", "hll", "a", "b", "c").Int)
+	require.Equal(t, 0, n.do(t, "PFADD", "hll", "a", "b").Int)
+	require.Equal(t, 3, n.do(t, "PFCOUNT", "hll").Int)
+	require.Equal(t, 0, n.do(t, "PFCOUNT", "missing").Int)
+	require.Equal(t, byte(hllSparse), hllEncoding(t, n, "hll"))
+
+	// PFCOUNT caches the cardinality in the header until the next change
+	v := n.do(t, "GET", "hll").Bulk
+	card, ok := hllCached([]byte(v))
+	require.True(t, ok)
+	require.Equal(t, uint64(3), card)
+	n.do(t, "PFADD", "hll", "d")
+	_, ok = hllCached([]byte(n.do(t, "GET", "hll").Bulk))
+	require.False(t, ok)
+	require.Equal(t, 4, n.do(t, "PFCOUNT", "hll").Int)
+
+	// an HLL survives being copied around as a plain string
+	require.Equal(t, "OK", n.do(t, "SET", "copy", n.do(t, "GET", "hll").Bulk).String)
+	require.Equal(t, 4, n.do(t, "PFCOUNT", "copy").Int)
+	require.Equal(t, 1, n.do(t, "PFADD", "copy", "e").Int)
+	require.Equal(t, 5, n.do(t, "PFCOUNT", "copy").Int)
+
+	n.do(t, "SET", "str", "HYLL but not really")
+	require.Equal(t, "WRONGTYPE Key is not a valid HyperLogLog string value.", n.do(t, "PFADD", "str", "a").String)
+	require.Equal(t, "WRONGTYPE Key is not a valid HyperLogLog string value.", n.do(t, "PFCOUNT", "hll", "str").String)
+	n.do(t, "LPUSH", "list", "a")
+	require.Equal(t, "WRONGTYPE Operation against a key holding the wrong kind of value", n.do(t, "PFCOUNT", "list").String)
+
+	// a sparse HLL whose runs don't cover every register is rejected
+	n.do(t, "SET", "corrupt", "HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x80\x7f")
+	require.Equal(t, "ERR INVALIDOBJ Corrupted HLL object detected", n.do(t, "PFCOUNT", "corrupt").String)
+}
+
+func TestHyperLogLog_ErrorBounds(t *testing.T) {
+	n := startTestServer(t, Config{})
+
+	// the standard error with 16384 registers is 0.81%, allow for about three times that
+	for _, card := range []int{10, 100, 1000, 10000, 100000} {
+		key := fmt.Sprint("hll:", card)
+		pfadd(t, n, key, "elem", 0, card)
+		got := n.do(t, "PFCOUNT", key).Int
+		require.InDelta(t, card, got, math.Max(2, 0.025*float64(card)), "cardinality %d", card)
+	}
+
+	// the sparse encoding is only kept while it is small
+	require.Equal(t, byte(hllSparse), hllEncoding(t, n, "hll:100"))
+	require.Equal(t, byte(hllDense), hllEncoding(t, n, "hll:100000"))
+	require.Len(t, n.do(t, "GET", "hll:100000").Bulk, hllDenseSize)
+}
+
+func TestHyperLogLog_SparseToDense(t *testing.T) {
+	n := startTestServer(t, Config{})
+
+	require.Equal(t, "OK", n.do(t, "CONFIG", "SET", "hll-sparse-max-bytes", "100").String)
+	pfadd(t, n, "hll", "x", 0, 10)
+	require.Equal(t, byte(hllSparse), hllEncoding(t, n, "hll"))
+	before := n.do(t, "PFCOUNT", "hll").Int
+
+	pfadd(t, n, "hll", "x", 10, 200)
+	require.Equal(t, byte(hllDense), hllEncoding(t, n, "hll"))
+	require.InDelta(t, 200, n.do(t, "PFCOUNT", "hll").Int, 5)
+
+	// the conversion keeps every register, the same elements count the same
+	pfadd(t, n, "small", "x", 0, 10)
+	require.Equal(t, before, n.do(t, "PFCOUNT", "small").Int)
+	require.Equal(t, 0, n.do(t, "PFADD", "hll", "x:0", "x:5", "x:9").Int)
+}
+
+func TestHyperLogLog_Merge(t *testing.T) {
+	n := startTestServer(t, Config{})
+
+	pfadd(t, n, "a", "e", 0, 1000)
+	pfadd(t, n, "b", "e", 500, 1500)
+	pfadd(t, n, "c", "e", 1000, 50000)
+
+	// PFCOUNT of several keys counts the union without changing them
+	require.InDelta(t, 1500, n.do(t, "PFCOUNT", "a", "b").Int, 40)
+	require.InDelta(t, 50000, n.do(t, "PFCOUNT", "a", "b", "c", "missing").Int, 1250)
+	require.Equal(t, byte(hllSparse), hllEncoding(t, n, "a"))
+
+	require.Equal(t, "OK", n.do(t, "PFMERGE", "ab", "a", "b").String)
+	require.Equal(t, n.do(t, "PFCOUNT", "a", "b").Int, n.do(t, "PFCOUNT", "ab").Int)
+	require.Equal(t, byte(hllSparse), hllEncoding(t, n, "ab"))
+
+	// the destination is part of the union and merging a dense source makes it dense
+	require.Equal(t, "OK", n.do(t, "PFMERGE", "ab", "c").String)
+	require.Equal(t, n.do(t, "PFCOUNT", "a", "b", "c").Int, n.do(t, "PFCOUNT", "ab").Int)
+	require.Equal(t, byte(hllDense), hllEncoding(t, n, "ab"))
+
+	require.Equal(t, "OK", n.do(t, "PFMERGE", "empty").String)
+	require.Equal(t, 0, n.do(t, "PFCOUNT", "empty").Int)
+	require.Equal(t, byte(hllSparse), hllEncoding(t, n, "empty"))
+
+	n.do(t, "SET", "str", "foo")
+	require.Equal(t, "WRONGTYPE Key is not a valid HyperLogLog string value.", n.do(t, "PFMERGE", "ab", "str").String)
+}
+
+func TestHyperLogLog_RegisterPacking(t *testing.T) {
+	t.Parallel()
+
+	regs := make([]byte, hllDenseSize-hllHdrSize)
+	for i := 0; i < hllRegisters; i++ {
+		denseSet(regs, i, uint8(i%64))
+	}
+	for i := 0; i < hllRegisters; i++ {
+		require.Equal(t, uint8(i%64), denseGet(regs, i), "register %d", i)
+	}
+
+	// sparse runs round trip, including values that need several VAL opcodes
+	runs := []hllRun{{0, 100}, {3, 9}, {32, 1}, {0, hllRegisters - 110}}
+	decoded, err := sparseDecode(sparseEncode(nil, runs))
+	require.NoError(t, err)
+	require.Equal(t, runs, decoded)
+}
@@ -0,0 +1,148 @@
+package redis
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// infoSections in the order INFO prints them, each writes its "field:value" lines. Like in
+// Redis the extra sections are left out unless asked for by name or with everything.
+var infoSections = []struct {
+	name  string
+	write func(s *Server, b *strings.Builder)
+	extra bool
+}{
+	{"server", infoServer, false},
+	{"clients", infoClients, false},
+	{"memory", infoMemory, false},
+	{"persistence", infoPersistence, false},
+	{"stats", infoStats, false},
+	{"replication", infoReplication, false},
+	{"commandstats", infoCommandstats, true},
+	{"keyspace", infoKeyspace, false},
+}
+
+func infoServer(s *Server, b *strings.Builder) {
+	mode := "standalone"
+	if s.cluster != nil {
+		mode = "cluster"
+	}
+	uptime := time.Since(s.startedAt)
+	fmt.Fprintf(b, "redis_version:7.2.0\r\nredis_mode:%s\r\nprocess_id:%d\r\nrun_id:%s\r\ntcp_port:%d\r\n", mode, os.Getpid(), s.runID, s.cfg.Port)
+	fmt.Fprintf(b, "uptime_in_seconds:%d\r\nuptime_in_days:%d\r\n", int(uptime.Seconds()), int(uptime.Hours()/24))
+	fmt.Fprintf(b, "config_file:%s\r\n", s.cfg.File)
+}
+
+func infoClients(s *Server, b *strings.Builder) {
+	s.clientsMu.RLock()
+	n := len(s.clients)
+	s.clientsMu.RUnlock()
+	fmt.Fprintf(b, "connected_clients:%d\r\nmaxclients:%d\r\n", n, s.maxClients.Load())
+}
+
+func infoMemory(s *Server, b *strings.Builder) {
+	used, dataset := s.memoryStats()
+	fmt.Fprintf(b, "used_memory:%d\r\nused_memory_human:%s\r\nused_memory_dataset:%d\r\n", used, humanBytes(used), dataset)
+	fmt.Fprintf(b, "lazyfree_pending_objects:%d\r\n", s.lazyfreePending.Load())
+}
+
+// humanBytes formats n like the *_human fields of INFO, e.g. 1.50M
+func humanBytes(n int) string {
+	if n < 1024 {
+		return fmt.Sprintf("%dB", n)
+	}
+	f, units, i := float64(n)/1024, "KMG", 0
+	for ; f >= 1024 && i < len(units)-1; i++ {
+		f /= 1024
+	}
+	return fmt.Sprintf("%.2f%c", f, units[i])
+}
+
+func infoPersistence(s *Server, b *strings.Builder) {
+	status := func(ok bool) string {
+		if ok {
+			return "ok"
+		}
+		return "err"
+	}
+	fmt.Fprintf(b, "loading:0\r\nrdb_changes_since_last_save:%d\r\nrdb_bgsave_in_progress:%d\r\n", s.dirty.Load(), btoi(s.bgsaveInProgress.Load()))
+	fmt.Fprintf(b, "rdb_last_save_time:%d\r\nrdb_last_bgsave_status:%s\r\n", time.Unix(0, s.lastSave.Load()).Unix(), status(s.lastSaveOK.Load()))
+	fmt.Fprintf(b, "aof_enabled:%d\r\naof_rewrite_in_progress:%d\r\naof_last_write_status:%s\r\n",
+		btoi(s.repl.aofEnabled()), btoi(s.aofRewriteInProgress.Load()), status(s.aofLastWriteOK.Load()))
+}
+
+func infoStats(s *Server, b *strings.Builder) {
+	// maxmemory isn't enforced, nothing is ever evicted
+	fmt.Fprintf(b, "total_commands_processed:%d\r\nexpired_keys:%d\r\nevicted_keys:0\r\n", s.cmdStats.total(), s.expiredKeys.Load())
+}
+
+func infoCommandstats(s *Server, b *strings.Builder) {
+	for _, name := range slices.Sorted(maps.Keys(s.cmdStats)) {
+		st := s.cmdStats[name]
+		calls := st.calls.Load()
+		if calls == 0 {
+			continue
+		}
+		usec := st.usec.Load()
+		fmt.Fprintf(b, "cmdstat_%s:calls=%d,usec=%d,usec_per_call=%.2f,rejected_calls=0,failed_calls=%d\r\n",
+			strings.ToLower(name), calls, usec, float64(usec)/float64(calls), st.failed.Load())
+	}
+}
+
+func infoReplication(s *Server, b *strings.Builder) {
+	r := s.repl
+	r.mu.Lock()
+	id := r.id
+	r.mu.Unlock()
+	offset := r.offset.Load()
+	if l := r.link.Load(); l != nil {
+		b.WriteString("role:slave\r\n")
+		l.info(b)
+		// like Redis a replica reports the history and offset of its primary's stream
+		l.mu.Lock()
+		if l.replid != "" {
+			id = l.replid
+		}
+		l.mu.Unlock()
+		offset = l.offset.Load()
+	} else {
+		b.WriteString("role:master\r\n")
+	}
+	r.replicaInfo(b)
+	fmt.Fprintf(b, "master_replid:%s\r\nmaster_repl_offset:%d\r\n", id, offset)
+}
+
+func infoKeyspace(s *Server, b *strings.Builder) {
+	if keys, expires := s.keyspaceStats(); keys > 0 {
+		fmt.Fprintf(b, "db0:keys=%d,expires=%d,avg_ttl=0\r\n", keys, expires)
+	}
+}
+
+// INFO [section [section ...]]
+func INFO(c *Client, args []Value) Value {
+	want := make([]string, 0, len(args))
+	for _, arg := range args {
+		want = append(want, strings.ToLower(arg.Bulk))
+	}
+	all := len(want) == 0 || slices.ContainsFunc(want, func(s string) bool {
+		return s == "all" || s == "everything" || s == "default"
+	})
+	everything := slices.ContainsFunc(want, func(s string) bool { return s == "all" || s == "everything" })
+
+	var b strings.Builder
+	for _, sec := range infoSections {
+		if !slices.Contains(want, sec.name) && !(all && (!sec.extra || everything)) {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		fmt.Fprintf(&b, "# %s\r\n", strings.ToUpper(sec.name[:1])+sec.name[1:])
+		sec.write(c.srv, &b)
+	}
+	return bulkVal(b.String())
+}
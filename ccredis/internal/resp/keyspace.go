@@ -0,0 +1,255 @@
+package redis
+
+import (
+	"hash/maphash"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// number of independently locked partitions of the keyspace, must be a power of two
+const defaultShards = 64
+
+type shard struct {
+	mu    sync.RWMutex
+	items map[string]*RedisItem
+
+	// what the shard held when the cron last walked it, see refreshStats
+	dataset atomic.Int64
+	expires atomic.Int64
+}
+
+// keyspace splits the keys over shards so that commands touching different keys
+// don't contend on a single lock. Multi-key commands lock every shard they touch,
+// always in ascending shard order, which keeps them atomic and deadlock free.
+type keyspace struct {
+	seed   maphash.Seed
+	mask   uint64
+	shards []*shard
+
+	// called with the shard lock held whenever an expired key is removed
+	onExpire func(key string)
+}
+
+func newKeyspace(n int) *keyspace {
+	if n <= 0 || n&(n-1) != 0 {
+		panic("keyspace: shard count must be a power of two")
+	}
+	ks := &keyspace{
+		seed:   maphash.MakeSeed(),
+		mask:   uint64(n - 1),
+		shards: make([]*shard, n),
+	}
+	for i := range ks.shards {
+		ks.shards[i] = &shard{items: make(map[string]*RedisItem)}
+	}
+	return ks
+}
+
+func (ks *keyspace) shardIndex(key string) int {
+	return int(maphash.String(ks.seed, key) & ks.mask)
+}
+
+// scanPos is where key is in the order SCAN visits its shard, taken from the hash bits that
+// don't select the shard
+func (ks *keyspace) scanPos(key string) uint32 {
+	return uint32(maphash.String(ks.seed, key) >> 32)
+}
+
+func (ks *keyspace) shardFor(key string) *shard {
+	return ks.shards[ks.shardIndex(key)]
+}
+
+// shardsFor returns the distinct shards owning keys, sorted by shard index
+func (ks *keyspace) shardsFor(keys ...string) []*shard {
+	if len(keys) == 1 {
+		return []*shard{ks.shardFor(keys[0])}
+	}
+
+	idx := make([]int, 0, len(keys))
+	seen := make(map[int]struct{}, len(keys))
+	for _, key := range keys {
+		i := ks.shardIndex(key)
+		if _, ok := seen[i]; ok {
+			continue
+		}
+		seen[i] = struct{}{}
+		idx = append(idx, i)
+	}
+	sort.Ints(idx)
+
+	shards := make([]*shard, len(idx))
+	for i, j := range idx {
+		shards[i] = ks.shards[j]
+	}
+	return shards
+}
+
+// lock write-locks every shard owning one of keys and returns the matching unlock func
+func (ks *keyspace) lock(keys ...string) func() {
+	shards := ks.shardsFor(keys...)
+	for _, s := range shards {
+		s.mu.Lock()
+	}
+	return func() {
+		for i := len(shards) - 1; i >= 0; i-- {
+			shards[i].mu.Unlock()
+		}
+	}
+}
+
+// rlock is the read-only counterpart of lock
+func (ks *keyspace) rlock(keys ...string) func() {
+	shards := ks.shardsFor(keys...)
+	for _, s := range shards {
+		s.mu.RLock()
+	}
+	return func() {
+		for i := len(shards) - 1; i >= 0; i-- {
+			shards[i].mu.RUnlock()
+		}
+	}
+}
+
+// lockAll write-locks the whole keyspace, used by commands like FLUSHALL
+func (ks *keyspace) lockAll() func() {
+	for _, s := range ks.shards {
+		s.mu.Lock()
+	}
+	return func() {
+		for i := len(ks.shards) - 1; i >= 0; i-- {
+			ks.shards[i].mu.Unlock()
+		}
+	}
+}
+
+// rlockAll read-locks the whole keyspace, used by commands like KEYS
+func (ks *keyspace) rlockAll() func() {
+	for _, s := range ks.shards {
+		s.mu.RLock()
+	}
+	return func() {
+		for i := len(ks.shards) - 1; i >= 0; i-- {
+			ks.shards[i].mu.RUnlock()
+		}
+	}
+}
+
+// The accessors below expect the caller to hold the lock of the key's shard.
+
+func (ks *keyspace) get(key string) (*RedisItem, bool) {
+	item, ok := ks.shardFor(key).items[key]
+	return item, ok
+}
+
+// getLive is like get, but deletes and hides the key if it is expired. Requires the write lock.
+func (ks *keyspace) getLive(key string) (*RedisItem, bool) {
+	s := ks.shardFor(key)
+	item, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+	if isExpired(item.ttl) {
+		ks.expire(s, key)
+		return nil, false
+	}
+	return item, true
+}
+
+// lookup is getLive for commands that access the value, it records the access for OBJECT
+// IDLETIME/FREQ. Requires the write lock.
+func (ks *keyspace) lookup(key string) (*RedisItem, bool) {
+	item, ok := ks.getLive(key)
+	if ok {
+		item.touch()
+	}
+	return item, ok
+}
+
+// lookupRead is lookup for readers holding only the read lock, expired keys are
+// reported missing and left for writers or the active expire cycle to delete
+func (ks *keyspace) lookupRead(key string) (*RedisItem, bool) {
+	item, ok := ks.shardFor(key).items[key]
+	if !ok || isExpired(item.ttl) {
+		return nil, false
+	}
+	item.touch()
+	return item, true
+}
+
+func (ks *keyspace) expire(s *shard, key string) {
+	delete(s.items, key)
+	if ks.onExpire != nil {
+		ks.onExpire(key)
+	}
+}
+
+// number of keys with a ttl checked per shard and round of the active expire cycle,
+// and the cap on keys visited while looking for them
+const (
+	activeExpireSamples  = 20
+	activeExpireMaxVisit = 400
+)
+
+// activeExpire removes expired keys nobody is reading anymore. Like Redis it samples
+// a few keys of every shard and keeps going on a shard while more than a quarter of
+// the sampled keys turned out to be expired.
+func (ks *keyspace) activeExpire() {
+	for _, s := range ks.shards {
+		for {
+			s.mu.Lock()
+			visited, sampled, expired := 0, 0, 0
+			for key, item := range s.items {
+				if sampled == activeExpireSamples || visited == activeExpireMaxVisit {
+					break
+				}
+				visited++
+				if item.ttl.IsZero() {
+					continue
+				}
+				sampled++
+				if isExpired(item.ttl) {
+					ks.expire(s, key)
+					expired++
+				}
+			}
+			s.mu.Unlock()
+
+			if expired <= activeExpireSamples/4 {
+				break
+			}
+		}
+	}
+}
+
+func (ks *keyspace) set(key string, item *RedisItem) {
+	ks.shardFor(key).items[key] = item
+}
+
+func (ks *keyspace) del(key string) bool {
+	s := ks.shardFor(key)
+	if _, ok := s.items[key]; !ok {
+		return false
+	}
+	delete(s.items, key)
+	return true
+}
+
+// each calls fn for every key in the keyspace. Requires lockAll or rlockAll.
+func (ks *keyspace) each(fn func(key string, item *RedisItem)) {
+	for _, s := range ks.shards {
+		for k, v := range s.items {
+			fn(k, v)
+		}
+	}
+}
+
+// flush drops every key and returns how many there were. Requires lockAll.
+func (ks *keyspace) flush() int {
+	n := 0
+	for _, s := range ks.shards {
+		n += len(s.items)
+		s.items = make(map[string]*RedisItem)
+	}
+	return n
+}
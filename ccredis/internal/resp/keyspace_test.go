@@ -0,0 +1,147 @@
+package redis
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func cmdArgs(args ...string) []Value {
+	v := make([]Value, len(args))
+	for i, arg := range args {
+		v[i] = bulkVal(arg)
+	}
+	return v
+}
+
+func newTestClient(shards int) *Client {
+	srv := NewServer(Config{})
+	srv.store = newKeyspace(shards)
+	return srv.newClient(nil)
+}
+
+func TestKeyspace_MSETIsAtomic(t *testing.T) {
+	c := newTestClient(defaultShards)
+	store := c.store
+
+	keys := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			args := []string{"MSET"}
+			for _, k := range keys {
+				args = append(args, k, strconv.Itoa(i))
+			}
+			for range 200 {
+				Handlers["MSET"](c, cmdArgs(args...))
+			}
+		}()
+	}
+
+	for range 500 {
+		unlock := store.rlock(keys...)
+		var first interface{}
+		for i, k := range keys {
+			item, ok := store.get(k)
+			if !ok {
+				break
+			}
+			if i == 0 {
+				first = item.value
+			}
+			require.Equal(t, first, item.value, "observed a partially applied MSET")
+		}
+		unlock()
+	}
+
+	wg.Wait()
+}
+
+func TestKeyspace_LockOrder(t *testing.T) {
+	ks := newKeyspace(defaultShards)
+
+	shards := ks.shardsFor("x", "y", "z", "x", "{a}", "b")
+	for i := 1; i < len(shards); i++ {
+		require.NotSame(t, shards[i-1], shards[i])
+	}
+
+	// lock the same keys from both ends, deadlocks if lock order depended on argument order
+	var wg sync.WaitGroup
+	for i := range 100 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if i%2 == 0 {
+				ks.lock("x", "y", "z")()
+			} else {
+				ks.lock("z", "y", "x")()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// The single shard keyspace has the same locking behaviour as the old global storeMu design.
+func benchmarkKeyspace(b *testing.B, shards int, fn func(c *Client, i int)) {
+	c := newTestClient(shards)
+	for i := range 10_000 {
+		key := "key:" + strconv.Itoa(i)
+		c.store.set(key, &RedisItem{itemType: REDIS_STRING, value: "value"})
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			fn(c, i)
+			i++
+		}
+	})
+}
+
+func BenchmarkKeyspace(b *testing.B) {
+	set := Handlers["SET"]
+	get := Handlers["GET"]
+	mset := Handlers["MSET"]
+
+	workloads := []struct {
+		name string
+		fn   func(c *Client, i int)
+	}{
+		{"SET", func(c *Client, i int) {
+			set(c, cmdArgs("SET", "key:"+strconv.Itoa(i%10_000), "value"))
+		}},
+		{"GET", func(c *Client, i int) {
+			get(c, cmdArgs("GET", "key:"+strconv.Itoa(i%10_000)))
+		}},
+		{"GET/SET 80/20", func(c *Client, i int) {
+			key := "key:" + strconv.Itoa(i%10_000)
+			if i%5 == 0 {
+				set(c, cmdArgs("SET", key, "value"))
+			} else {
+				get(c, cmdArgs("GET", key))
+			}
+		}},
+		{"MSET 4 keys", func(c *Client, i int) {
+			args := []string{"MSET"}
+			for j := range 4 {
+				args = append(args, "key:"+strconv.Itoa((i+j*2500)%10_000), "value")
+			}
+			mset(c, cmdArgs(args...))
+		}},
+	}
+
+	for _, w := range workloads {
+		for _, shards := range []int{1, defaultShards} {
+			b.Run(fmt.Sprintf("%s/shards=%d", w.name, shards), func(b *testing.B) {
+				benchmarkKeyspace(b, shards, w.fn)
+			})
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package redis
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latency events, samples are only recorded at or above latency-monitor-threshold
+const (
+	latencyCommand     = "command"
+	latencyExpireCycle = "expire-cycle"
+	// snapshots are written by the server itself instead of a forked child
+	latencySave       = "fork-less-save"
+	latencyAOFRewrite = "aof-rewrite"
+)
+
+// samples kept per event, one per second like Redis
+const latencyHistoryLen = 160
+
+type latencySample struct {
+	time    int64 // unix seconds
+	latency int64 // milliseconds
+}
+
+type latencyEvent struct {
+	history []latencySample
+	max     int64
+}
+
+// latencyMonitor keeps the latency spikes of every event class
+type latencyMonitor struct {
+	mu     sync.Mutex
+	events map[string]*latencyEvent
+}
+
+func newLatencyMonitor() *latencyMonitor {
+	return &latencyMonitor{events: make(map[string]*latencyEvent)}
+}
+
+func (m *latencyMonitor) add(event string, d time.Duration) {
+	ms := d.Milliseconds()
+	now := time.Now().Unix()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e := m.events[event]
+	if e == nil {
+		e = &latencyEvent{}
+		m.events[event] = e
+	}
+	e.max = max(e.max, ms)
+
+	// spikes within the same second are merged into the highest one
+	if n := len(e.history); n > 0 && e.history[n-1].time == now {
+		e.history[n-1].latency = max(e.history[n-1].latency, ms)
+		return
+	}
+	e.history = append(e.history, latencySample{time: now, latency: ms})
+	if len(e.history) > latencyHistoryLen {
+		e.history = slices.Delete(e.history, 0, len(e.history)-latencyHistoryLen)
+	}
+}
+
+// recordLatency records d for event if the latency monitor is enabled and d reaches the threshold
+func (s *Server) recordLatency(event string, d time.Duration) {
+	threshold := s.latencyThreshold.Load()
+	if threshold == 0 || d < time.Duration(threshold)*time.Millisecond {
+		return
+	}
+	s.latency.add(event, d)
+}
+
+// LATENCY LATEST | HISTORY event | RESET [event ...]
+func LATENCY(c *Client, args []Value) Value {
+	m := c.srv.latency
+	switch strings.ToUpper(args[0].Bulk) {
+	case "HELP":
+		return helpVal("LATENCY",
+			"HISTORY <event>",
+			"    Return time-latency samples for the <event> class.",
+			"LATEST",
+			"    Return the latest latency samples for all events.",
+			"RESET [<event> ...]",
+			"    Reset latency data of one or more <event> classes.",
+			"    (default: reset all data for all event classes)",
+		)
+	case "LATEST":
+		if len(args) != 1 {
+			return errWrongArgs("latency|latest")
+		}
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		names := make([]string, 0, len(m.events))
+		for name := range m.events {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+
+		res := Value{Type: "array", Array: make([]Value, 0, len(names))}
+		for _, name := range names {
+			e := m.events[name]
+			last := e.history[len(e.history)-1]
+			res.Array = append(res.Array, Value{Type: "array", Array: []Value{
+				bulkVal(name), intVal(int(last.time)), intVal(int(last.latency)), intVal(int(e.max)),
+			}})
+		}
+		return res
+	case "HISTORY":
+		if len(args) != 2 {
+			return errWrongArgs("latency|history")
+		}
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		res := Value{Type: "array", Array: []Value{}}
+		if e := m.events[strings.ToLower(args[1].Bulk)]; e != nil {
+			for _, s := range e.history {
+				res.Array = append(res.Array, Value{Type: "array", Array: []Value{intVal(int(s.time)), intVal(int(s.latency))}})
+			}
+		}
+		return res
+	case "RESET":
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		if len(args) == 1 {
+			n := len(m.events)
+			clear(m.events)
+			return intVal(n)
+		}
+		n := 0
+		for _, arg := range args[1:] {
+			name := strings.ToLower(arg.Bulk)
+			if _, ok := m.events[name]; ok {
+				delete(m.events, name)
+				n++
+			}
+		}
+		return intVal(n)
+	default:
+		return errVal(fmt.Sprintf("unknown subcommand or wrong number of arguments for '%s'. Try LATENCY HELP.", args[0].Bulk))
+	}
+}
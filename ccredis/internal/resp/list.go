@@ -0,0 +1,265 @@
+package redis
+
+import (
+	"strconv"
+)
+
+// The helpers below work on list items of either encoding. A listpack list becomes a
+// quicklist once it holds more than list-max-listpack-size elements and converts back
+// when it shrinks to half of that, so a list hovering around the limit doesn't keep
+// flipping between the two.
+
+func listPush(l *encodingLimits, item *RedisItem, s string, left bool) {
+	limit := int(l.listMaxListpackSize.Load())
+	if lp, ok := item.value.(*listpack); ok {
+		if len(lp.entries) < limit {
+			if left {
+				lp.entries = append([]string{s}, lp.entries...)
+			} else {
+				lp.entries = append(lp.entries, s)
+			}
+			return
+		}
+		item.value = newQuicklist(limit, lp.entries)
+	}
+
+	ql := item.value.(*quicklist)
+	if left {
+		ql.pushFront(s)
+	} else {
+		ql.pushBack(s)
+	}
+}
+
+// listPop removes an element from a non empty list
+func listPop(l *encodingLimits, item *RedisItem, left bool) string {
+	switch v := item.value.(type) {
+	case *listpack:
+		var s string
+		if left {
+			s, v.entries = v.entries[0], v.entries[1:]
+		} else {
+			s, v.entries = v.entries[len(v.entries)-1], v.entries[:len(v.entries)-1]
+		}
+		return s
+	case *quicklist:
+		var s string
+		if left {
+			s = v.popFront()
+		} else {
+			s = v.popBack()
+		}
+		if v.n <= int(l.listMaxListpackSize.Load())/2 {
+			item.value = &listpack{entries: v.slice()}
+		}
+		return s
+	}
+	return ""
+}
+
+func listLen(item *RedisItem) int {
+	switch v := item.value.(type) {
+	case *listpack:
+		return len(v.entries)
+	case *quicklist:
+		return v.n
+	}
+	return 0
+}
+
+func listIndex(item *RedisItem, i int) string {
+	switch v := item.value.(type) {
+	case *listpack:
+		return v.entries[i]
+	case *quicklist:
+		return v.index(i)
+	}
+	return ""
+}
+
+// listRange resolves negative indexes like LRANGE and clamps them to the list
+func listRange(n, start, stop int) (int, int, bool) {
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	start = max(start, 0)
+	stop = min(stop, n-1)
+	return start, stop, start <= stop && start < n
+}
+
+func push(c *Client, args []Value, left bool) Value {
+	key := args[0].Bulk
+	defer c.store.lock(key)()
+
+	item, ok := c.store.lookup(key)
+	if ok && item.itemType != REDIS_LIST {
+		return errWrongType()
+	}
+	if !ok {
+		item = newItem(REDIS_LIST, &listpack{})
+		c.store.set(key, item)
+		c.srv.notify(notifyNew, "new", key)
+	}
+
+	for _, v := range args[1:] {
+		listPush(c.srv.encLimits, item, v.Bulk, left)
+	}
+
+	c.signalModifiedKey(key)
+	if left {
+		c.srv.notify(notifyList, "lpush", key)
+	} else {
+		c.srv.notify(notifyList, "rpush", key)
+	}
+	return intVal(listLen(item))
+}
+
+// LPUSH key element [element ...]
+func LPUSH(c *Client, args []Value) Value { return push(c, args, true) }
+
+// RPUSH key element [element ...]
+func RPUSH(c *Client, args []Value) Value { return push(c, args, false) }
+
+func pop(c *Client, args []Value, left bool) Value {
+	if len(args) > 2 {
+		return syntaxErr()
+	}
+	key := args[0].Bulk
+	count := -1
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1].Bulk)
+		if err != nil || n < 0 {
+			return errVal("value is out of range, must be positive")
+		}
+		count = n
+	}
+
+	defer c.store.lock(key)()
+
+	item, ok := c.store.lookup(key)
+	if !ok {
+		return nullVal()
+	}
+	if item.itemType != REDIS_LIST {
+		return errWrongType()
+	}
+
+	n := count
+	if count < 0 {
+		n = 1
+	}
+	popped := make([]Value, 0, min(n, listLen(item)))
+	for len(popped) < n && listLen(item) > 0 {
+		popped = append(popped, bulkVal(listPop(c.srv.encLimits, item, left)))
+	}
+
+	if len(popped) > 0 {
+		c.signalModifiedKey(key)
+		if left {
+			c.srv.notify(notifyList, "lpop", key)
+		} else {
+			c.srv.notify(notifyList, "rpop", key)
+		}
+		if listLen(item) == 0 {
+			c.store.del(key)
+			c.srv.notify(notifyGeneric, "del", key)
+		}
+	}
+
+	if count < 0 {
+		return popped[0]
+	}
+	return Value{Type: "array", Array: popped}
+}
+
+// LPOP key [count]
+func LPOP(c *Client, args []Value) Value { return pop(c, args, true) }
+
+// RPOP key [count]
+func RPOP(c *Client, args []Value) Value { return pop(c, args, false) }
+
+// LLEN key
+func LLEN(c *Client, args []Value) Value {
+	key := args[0].Bulk
+	defer c.store.rlock(key)()
+
+	item, ok := c.store.lookupRead(key)
+	if !ok {
+		return intVal(0)
+	}
+	if item.itemType != REDIS_LIST {
+		return errWrongType()
+	}
+	return intVal(listLen(item))
+}
+
+// LINDEX key index
+func LINDEX(c *Client, args []Value) Value {
+	key := args[0].Bulk
+	i, err := strconv.Atoi(args[1].Bulk)
+	if err != nil {
+		return errVal("value is not an integer or out of range")
+	}
+
+	defer c.store.rlock(key)()
+
+	item, ok := c.store.lookupRead(key)
+	if !ok {
+		return nullVal()
+	}
+	if item.itemType != REDIS_LIST {
+		return errWrongType()
+	}
+	n := listLen(item)
+	if i < 0 {
+		i += n
+	}
+	if i < 0 || i >= n {
+		return nullVal()
+	}
+	return bulkVal(listIndex(item, i))
+}
+
+// LRANGE key start stop
+func LRANGE(c *Client, args []Value) Value {
+	key := args[0].Bulk
+	start, err1 := strconv.Atoi(args[1].Bulk)
+	stop, err2 := strconv.Atoi(args[2].Bulk)
+	if err1 != nil || err2 != nil {
+		return errVal("value is not an integer or out of range")
+	}
+
+	defer c.store.rlock(key)()
+
+	res := Value{Type: "array", Array: []Value{}}
+	item, ok := c.store.lookupRead(key)
+	if !ok {
+		return res
+	}
+	if item.itemType != REDIS_LIST {
+		return errWrongType()
+	}
+
+	start, stop, ok = listRange(listLen(item), start, stop)
+	if !ok {
+		return res
+	}
+	switch v := item.value.(type) {
+	case *listpack:
+		for _, e := range v.entries[start : stop+1] {
+			res.Array = append(res.Array, bulkVal(e))
+		}
+	case *quicklist:
+		i := 0
+		v.each(func(e string) {
+			if i >= start && i <= stop {
+				res.Array = append(res.Array, bulkVal(e))
+			}
+			i++
+		})
+	}
+	return res
+}
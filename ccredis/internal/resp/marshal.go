@@ -4,6 +4,8 @@ import (
 	"strconv"
 )
 
+// Value is a RESP value. Bulk and String hold raw bytes, Go strings are binary safe so keys
+// and values may contain CRLF, NUL or invalid UTF-8.
 type Value struct {
 	Type   string
 	Bulk   string
@@ -13,77 +15,97 @@ type Value struct {
 }
 
 func (v Value) Marshal() []byte {
+	return v.appendTo(make([]byte, 0, v.encodedLen()))
+}
+
+// appendTo appends the encoding of v to b
+func (v Value) appendTo(b []byte) []byte {
 	switch v.Type {
-	case "array":
-		return v.marshalArray()
+	case "array", "push", "map":
+		b = v.appendAggregateHeader(b)
+		for _, e := range v.Array {
+			b = e.appendTo(b)
+		}
+		return b
 	case "bulk":
-		return v.marshalBulk()
+		b = appendHeader(b, BULK, len(v.Bulk))
+		b = append(b, v.Bulk...)
+		return append(b, '\r', '\n')
 	case "error":
-		return v.marshalError()
+		return appendLine(b, ERROR, v.String)
 	case "null":
-		return v.marshalNull()
+		return append(b, "$-1\r\n"...)
 	case "string":
-		return v.marshalString()
+		return appendLine(b, STRING, v.String)
 	case "integer":
-		return v.marshalInt()
+		return appendHeader(b, INT, v.Int)
 	default:
-		return []byte{}
+		return b
 	}
 }
 
-func (v Value) marshalInt() []byte {
-	var bytes []byte
-	bytes = append(bytes, INT)
-	bytes = append(bytes, strconv.Itoa(v.Int)...)
-	bytes = append(bytes, '\r', '\n')
-	return bytes
-}
-
-func (v Value) marshalArray() []byte {
-	var bytes []byte
-	bytes = append(bytes, ARRAY)
-	bytes = append(bytes, strconv.Itoa(len(v.Array))...)
-	bytes = append(bytes, '\r', '\n')
-	for _, v := range v.Array {
-		bytes = append(bytes, v.Marshal()...)
+// appendAggregateHeader appends the type byte and length of an aggregate, maps keep keys and
+// values interleaved in Array and count pairs
+func (v Value) appendAggregateHeader(b []byte) []byte {
+	switch v.Type {
+	case "push":
+		return appendHeader(b, PUSH, len(v.Array))
+	case "map":
+		return appendHeader(b, MAP, len(v.Array)/2)
+	default:
+		return appendHeader(b, ARRAY, len(v.Array))
 	}
-	// bytes = append(bytes, '\r', '\n')
-	return bytes
 }
 
-func (v Value) marshalBulk() []byte {
-	var bytes []byte
-	bytes = append(bytes, BULK)
-
-	if len(v.Bulk) == 0 {
-		bytes = append(bytes, '0')
-		bytes = append(bytes, '\r', '\n')
-		bytes = append(bytes, '\r', '\n')
-		return bytes
-	}
-
-	bytes = append(bytes, strconv.Itoa(len(v.Bulk))...)
-	bytes = append(bytes, '\r', '\n')
-	bytes = append(bytes, v.Bulk...)
-	bytes = append(bytes, '\r', '\n')
-	return bytes
+// appendHeader appends a type byte followed by a number, the length of a bulk or aggregate
+func appendHeader(b []byte, prefix byte, n int) []byte {
+	b = append(b, prefix)
+	b = strconv.AppendInt(b, int64(n), 10)
+	return append(b, '\r', '\n')
 }
 
-func (v Value) marshalString() []byte {
-	var bytes []byte
-	bytes = append(bytes, STRING)
-	bytes = append(bytes, v.String...)
-	bytes = append(bytes, '\r', '\n')
-	return bytes
+func appendLine(b []byte, prefix byte, s string) []byte {
+	b = append(b, prefix)
+	b = append(b, s...)
+	return append(b, '\r', '\n')
 }
 
-func (v Value) marshalError() []byte {
-	var bytes []byte
-	bytes = append(bytes, ERROR)
-	bytes = append(bytes, "ERR "...)
-	bytes = append(bytes, v.String...)
-	bytes = append(bytes, '\r', '\n')
-	return bytes
+// encodedLen is the length of the encoding of v, without encoding it
+func (v Value) encodedLen() int {
+	switch v.Type {
+	case "array", "push", "map":
+		n := len(v.Array)
+		if v.Type == "map" {
+			n /= 2
+		}
+		size := headerLen(n)
+		for _, e := range v.Array {
+			size += e.encodedLen()
+		}
+		return size
+	case "bulk":
+		return headerLen(len(v.Bulk)) + len(v.Bulk) + 2
+	case "error":
+		return len(v.String) + 3
+	case "null":
+		return 5
+	case "string":
+		return len(v.String) + 3
+	case "integer":
+		return headerLen(v.Int)
+	default:
+		return 0
+	}
 }
 
-func (v Value) marshalNull() []byte { return []byte("$-1\r\n") }
+// headerLen is the length of a type byte, n and CRLF
+func headerLen(n int) int {
+	size, u := 4, uint64(n)
+	if n < 0 {
+		size, u = size+1, -u
+	}
+	for ; u >= 10; u /= 10 {
+		size++
+	}
+	return size
+}
@@ -0,0 +1,206 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net"
+	"net/http"
+	"runtime/metrics"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics are served in the Prometheus text format on Config.MetricsAddr, under the names
+// redis_exporter uses for the same INFO fields so existing dashboards keep working. They
+// are computed from the same counters INFO reports, per-command ones are recorded by
+// dispatch for every command a client, a transaction or a function runs.
+
+// upper bounds of the command latency histogram in seconds
+var latencyBuckets = []float64{0.00001, 0.00005, 0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.05, 0.1, 0.5, 1}
+
+type commandStat struct {
+	calls  atomic.Int64
+	failed atomic.Int64
+	usec   atomic.Int64
+	// calls per latency bucket, the last one counts the calls slower than every bound
+	buckets []atomic.Int64
+}
+
+// commandStats has the counters of every command from the start, so recording a call is a
+// few atomic adds and never takes a lock
+type commandStats map[string]*commandStat
+
+func newCommandStats() commandStats {
+	stats := make(commandStats, len(commands))
+	for name := range commands {
+		stats[name] = &commandStat{buckets: make([]atomic.Int64, len(latencyBuckets)+1)}
+	}
+	return stats
+}
+
+func (cs commandStats) record(cmd string, d time.Duration, failed bool) {
+	st, ok := cs[cmd]
+	if !ok {
+		return
+	}
+	st.calls.Add(1)
+	st.usec.Add(d.Microseconds())
+	if failed {
+		st.failed.Add(1)
+	}
+	i, _ := slices.BinarySearch(latencyBuckets, d.Seconds())
+	st.buckets[i].Add(1)
+}
+
+// total is the number of calls of every command, total_commands_processed of INFO
+func (cs commandStats) total() int64 {
+	var n int64
+	for _, st := range cs {
+		n += st.calls.Load()
+	}
+	return n
+}
+
+// keyspaceStats counts the keys, and the keys with a ttl as of the last refreshStats
+func (s *Server) keyspaceStats() (keys, expires int) {
+	for _, sh := range s.store.shards {
+		sh.mu.RLock()
+		keys += len(sh.items)
+		sh.mu.RUnlock()
+		expires += int(sh.expires.Load())
+	}
+	return keys, expires
+}
+
+// memoryStats returns the bytes the Go heap has in use and the estimate of MEMORY USAGE
+// summed over the keyspace, what the data would take in Redis. The heap is read from
+// runtime/metrics, which unlike ReadMemStats doesn't stop the world, and the dataset is
+// what the cron last computed.
+func (s *Server) memoryStats() (used, dataset int) {
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	metrics.Read(sample)
+	for _, sh := range s.store.shards {
+		dataset += int(sh.dataset.Load())
+	}
+	return int(sample[0].Value.Uint64()), dataset
+}
+
+// shards whose stats the cron refreshes on every run, a full pass takes
+// defaultShards/statsShardsPerRun runs
+const statsShardsPerRun = 8
+
+// refreshStats recomputes the dataset estimate and the keys with a ttl of the next few
+// shards, so scrapes and INFO never walk the keyspace themselves. Only the cron calls it.
+func (s *Server) refreshStats() {
+	for range statsShardsPerRun {
+		sh := s.store.shards[s.statsCursor%len(s.store.shards)]
+		s.statsCursor++
+		var dataset, expires int
+		sh.mu.RLock()
+		for key, item := range sh.items {
+			dataset += memoryUsage(key, item, 5)
+			if !item.ttl.IsZero() {
+				expires++
+			}
+		}
+		sh.mu.RUnlock()
+		sh.dataset.Store(int64(dataset))
+		sh.expires.Store(int64(expires))
+	}
+}
+
+// writeMetrics writes every metric to w in the Prometheus text format
+func (s *Server) writeMetrics(w io.Writer) error {
+	var b strings.Builder
+	metric := func(name, typ, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+
+	metric("redis_uptime_in_seconds", "gauge", "Seconds since the server started.")
+	fmt.Fprintf(&b, "redis_uptime_in_seconds %d\n", int(time.Since(s.startedAt).Seconds()))
+
+	s.clientsMu.RLock()
+	clients := len(s.clients)
+	s.clientsMu.RUnlock()
+	metric("redis_connected_clients", "gauge", "Connected clients.")
+	fmt.Fprintf(&b, "redis_connected_clients %d\n", clients)
+
+	keys, expires := s.keyspaceStats()
+	metric("redis_db_keys", "gauge", "Keys per database.")
+	fmt.Fprintf(&b, "redis_db_keys{db=\"db0\"} %d\n", keys)
+	metric("redis_db_keys_expiring", "gauge", "Keys with an expiration per database.")
+	fmt.Fprintf(&b, "redis_db_keys_expiring{db=\"db0\"} %d\n", expires)
+
+	metric("redis_expired_keys_total", "counter", "Keys removed because their ttl passed.")
+	fmt.Fprintf(&b, "redis_expired_keys_total %d\n", s.expiredKeys.Load())
+	// maxmemory isn't enforced, nothing is ever evicted
+	metric("redis_evicted_keys_total", "counter", "Keys evicted because of maxmemory.")
+	b.WriteString("redis_evicted_keys_total 0\n")
+
+	used, dataset := s.memoryStats()
+	metric("redis_memory_used_bytes", "gauge", "Bytes of the heap in use.")
+	fmt.Fprintf(&b, "redis_memory_used_bytes %d\n", used)
+	metric("redis_memory_used_dataset_bytes", "gauge", "Estimate of the bytes the keyspace takes, summed MEMORY USAGE.")
+	fmt.Fprintf(&b, "redis_memory_used_dataset_bytes %d\n", dataset)
+
+	metric("redis_commands_processed_total", "counter", "Commands processed.")
+	fmt.Fprintf(&b, "redis_commands_processed_total %d\n", s.cmdStats.total())
+
+	// commands never called are left out, like in INFO commandstats
+	names := slices.Sorted(maps.Keys(s.cmdStats))
+	names = slices.DeleteFunc(names, func(name string) bool { return s.cmdStats[name].calls.Load() == 0 })
+	metric("redis_commands_total", "counter", "Calls per command.")
+	for _, name := range names {
+		fmt.Fprintf(&b, "redis_commands_total{cmd=%q} %d\n", strings.ToLower(name), s.cmdStats[name].calls.Load())
+	}
+	metric("redis_commands_failed_calls_total", "counter", "Calls per command that returned an error.")
+	for _, name := range names {
+		fmt.Fprintf(&b, "redis_commands_failed_calls_total{cmd=%q} %d\n", strings.ToLower(name), s.cmdStats[name].failed.Load())
+	}
+	metric("redis_commands_latency_seconds", "histogram", "Latency of the calls per command.")
+	for _, name := range names {
+		st, cmd := s.cmdStats[name], strings.ToLower(name)
+		var cumulative int64
+		for i, le := range latencyBuckets {
+			cumulative += st.buckets[i].Load()
+			fmt.Fprintf(&b, "redis_commands_latency_seconds_bucket{cmd=%q,le=\"%g\"} %d\n", cmd, le, cumulative)
+		}
+		cumulative += st.buckets[len(latencyBuckets)].Load()
+		fmt.Fprintf(&b, "redis_commands_latency_seconds_bucket{cmd=%q,le=\"+Inf\"} %d\n", cmd, cumulative)
+		fmt.Fprintf(&b, "redis_commands_latency_seconds_sum{cmd=%q} %g\n", cmd, float64(st.usec.Load())/1e6)
+		fmt.Fprintf(&b, "redis_commands_latency_seconds_count{cmd=%q} %d\n", cmd, cumulative)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// serveMetrics serves GET /metrics on ln until the server is closed
+func (s *Server) serveMetrics(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		ln.Close()
+		return nil
+	}
+	s.listeners = append(s.listeners, ln)
+	s.mu.Unlock()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := s.writeMetrics(w); err != nil {
+			log.Printf("error writing metrics: %v", err)
+		}
+	})
+	err := http.Serve(ln, mux)
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,74 @@
+package redis
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(freePort(t)))
+	srv := NewServer(Config{Port: freePort(t), MetricsAddr: addr})
+	listenAndServe(t, srv)
+	waitListening(t, "tcp", srv.Addr())
+	waitListening(t, "tcp", addr)
+	n := dialTestServer(t, srv)
+
+	n.do(t, "SET", "k", "v")
+	n.do(t, "SET", "ttl", "v", "PX", "1")
+	n.do(t, "SET", "expiring", "v", "EX", "100")
+	n.do(t, "GET", "k")
+	n.do(t, "GET", "k")
+	n.do(t, "INCR", "k")
+	n.do(t, "MULTI")
+	n.do(t, "GET", "k")
+	n.do(t, "EXEC")
+	time.Sleep(5 * time.Millisecond)
+	n.do(t, "GET", "ttl")
+	// the keys with a ttl and the dataset estimate are refreshed by the cron
+	require.Eventually(t, func() bool {
+		_, expires := srv.keyspaceStats()
+		_, dataset := srv.memoryStats()
+		return expires == 1 && dataset > 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	resp, err := http.Get("http://" + addr + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Contains(t, resp.Header.Get("Content-Type"), "version=0.0.4")
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	metrics := string(body)
+
+	for _, line := range []string{
+		"# TYPE redis_commands_total counter\n",
+		`redis_commands_total{cmd="get"} 4` + "\n",
+		`redis_commands_total{cmd="set"} 3` + "\n",
+		`redis_commands_total{cmd="exec"} 1` + "\n",
+		`redis_commands_failed_calls_total{cmd="incr"} 1` + "\n",
+		"# TYPE redis_commands_latency_seconds histogram\n",
+		`redis_commands_latency_seconds_bucket{cmd="get",le="+Inf"} 4` + "\n",
+		`redis_commands_latency_seconds_count{cmd="get"} 4` + "\n",
+		"redis_connected_clients 1\n",
+		`redis_db_keys{db="db0"} 2` + "\n",
+		`redis_db_keys_expiring{db="db0"} 1` + "\n",
+		"redis_expired_keys_total 1\n",
+		"redis_evicted_keys_total 0\n",
+	} {
+		require.Contains(t, metrics, line)
+	}
+	require.Regexp(t, `redis_memory_used_dataset_bytes [1-9]\d*\n`, metrics)
+	require.NotContains(t, metrics, `cmd="zadd"`, "commands never called are left out")
+
+	// INFO reports the same counters
+	require.Contains(t, n.do(t, "INFO", "stats").Bulk, "expired_keys:1\r\nevicted_keys:0\r\n")
+	require.NotContains(t, n.do(t, "INFO").Bulk, "cmdstat_get")
+	require.Regexp(t, `cmdstat_get:calls=4,usec=\d+,usec_per_call=[\d.]+,rejected_calls=0,failed_calls=0\r\n`, n.do(t, "INFO", "commandstats").Bulk)
+	require.Contains(t, n.do(t, "INFO", "everything").Bulk, "cmdstat_incr:")
+}
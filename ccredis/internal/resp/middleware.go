@@ -1,12 +1,20 @@
 package redis
 
+import "strings"
+
 // TODO: need middleware for checking permissions, memorylimits on write commands
-func middleware(handler HandlerFunc) HandlerFunc {
-	return func(args []Value) Value {
-		if len(args) <= 1 {
-			cmd := args[0].Bulk
-			return errWrongArgs(cmd)
+func middleware(cmd *command) HandlerFunc {
+	return func(c *Client, args []Value) Value {
+		if cmd.arity > 0 && len(args) != cmd.arity || len(args) < -cmd.arity {
+			return errWrongArgs(strings.ToLower(args[0].Bulk))
+		}
+
+		if c.srv.cluster != nil {
+			if redirect, ok := c.srv.cluster.route(c, cmd, args); !ok {
+				return redirect
+			}
 		}
-		return handler(args[1:])
+
+		return cmd.handler(c, args[1:])
 	}
 }
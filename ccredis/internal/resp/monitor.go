@@ -0,0 +1,118 @@
+package redis
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// monitors are the clients that ran MONITOR, each receives a line for every processed command
+type monitors struct {
+	mu      sync.RWMutex
+	clients map[*Client]struct{}
+	// lets feed skip the lock while nobody is monitoring
+	n atomic.Int32
+}
+
+func newMonitors() *monitors {
+	return &monitors{clients: make(map[*Client]struct{})}
+}
+
+func (m *monitors) add(c *Client) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.clients[c]; !ok {
+		m.clients[c] = struct{}{}
+		m.n.Add(1)
+	}
+}
+
+func (m *monitors) remove(c *Client) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.clients[c]; ok {
+		delete(m.clients, c)
+		m.n.Add(-1)
+	}
+}
+
+func (m *monitors) has(c *Client) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.clients[c]
+	return ok
+}
+
+// feed sends the command args of c to every monitor. It never blocks, monitors go through
+// push, which disconnects them when they fall behind instead of slowing down c.
+func (m *monitors) feed(c *Client, args []Value) {
+	if m.n.Load() == 0 {
+		return
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if len(m.clients) == 0 {
+		return
+	}
+	line := strVal(monitorLine(time.Now(), c, args))
+	for mc := range m.clients {
+		mc.push(line)
+	}
+}
+
+// monitorLine formats a command like Redis: 1339518083.107412 [0 127.0.0.1:60866] "keys" "*"
+func monitorLine(now time.Time, c *Client, args []Value) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d.%06d [0 ", now.Unix(), now.Nanosecond()/1000)
+	if c.unixSocket() {
+		b.WriteString("unix:" + c.conn.LocalAddr().String())
+	} else {
+		b.WriteString(c.addr())
+	}
+	b.WriteByte(']')
+	for _, arg := range args {
+		b.WriteByte(' ')
+		writeRepr(&b, arg.Bulk)
+	}
+	return b.String()
+}
+
+// writeRepr quotes s escaping everything that isn't printable ASCII
+func writeRepr(b *strings.Builder, s string) {
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; ch {
+		case '\\', '"':
+			b.WriteByte('\\')
+			b.WriteByte(ch)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\a':
+			b.WriteString(`\a`)
+		case '\b':
+			b.WriteString(`\b`)
+		default:
+			if ch < 0x20 || ch > 0x7e {
+				fmt.Fprintf(b, `\x%02x`, ch)
+			} else {
+				b.WriteByte(ch)
+			}
+		}
+	}
+	b.WriteByte('"')
+}
+
+// MONITOR streams every command processed by the server to the client
+func MONITOR(c *Client, args []Value) Value {
+	// the reply goes out before the first monitored command
+	c.write(ok())
+	c.srv.monitors.add(c)
+	return noReply()
+}
@@ -0,0 +1,56 @@
+package redis
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMonitor(t *testing.T) {
+	n := startTestServer(t, Config{})
+	mon := dialTestServer(t, n.srv)
+	require.Equal(t, "OK", mon.do(t, "MONITOR").String)
+
+	n.do(t, "SET", "k", "a \"quoted\"\r\n\x00\xff value")
+	n.do(t, "MULTI")
+	n.do(t, "INCR", "counter")
+	n.do(t, "EXEC")
+	n.do(t, "NOSUCHCOMMAND")
+	n.do(t, "PING")
+
+	addr := regexp.QuoteMeta(n.conn.LocalAddr().String())
+	for _, want := range []string{
+		`"SET" "k" "a \"quoted\"\r\n\x00\xff value"`,
+		`"MULTI"`,
+		`"EXEC"`,
+		`"INCR" "counter"`,
+		`"PING"`,
+	} {
+		line := mon.read(t)
+		require.Equal(t, "string", line.Type)
+		require.Regexp(t, `^\d+\.\d{6} \[0 `+addr+`\] `+regexp.QuoteMeta(want)+`$`, line.String)
+	}
+
+	multi := dialTestServer(t, n.srv)
+	multi.do(t, "MULTI")
+	require.Equal(t, "ERR Command not allowed inside a transaction", multi.do(t, "MONITOR").String)
+}
+
+func TestMonitor_SlowReaderIsDropped(t *testing.T) {
+	n := startTestServer(t, Config{})
+	mon := dialTestServer(t, n.srv)
+	require.Equal(t, "OK", mon.do(t, "MONITOR").String)
+
+	// the monitor never reads, its client must not hold up the one sending commands
+	value := strings.Repeat("v", 32<<10)
+	start := time.Now()
+	for range 2000 {
+		require.Equal(t, "OK", n.do(t, "SET", "k", value).String)
+	}
+	require.Less(t, time.Since(start), 10*time.Second)
+
+	require.Eventually(t, func() bool { return n.srv.monitors.n.Load() == 0 }, 5*time.Second, 10*time.Millisecond)
+}
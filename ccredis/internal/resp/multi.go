@@ -0,0 +1,84 @@
+package redis
+
+import "strings"
+
+type multiState struct {
+	cmds [][]Value
+	// set when a command failed to queue, EXEC then discards the transaction
+	dirty bool
+}
+
+// commands that are executed right away instead of being queued inside MULTI
+var multiControl = map[string]bool{
+	"MULTI":   true,
+	"EXEC":    true,
+	"DISCARD": true,
+}
+
+func (c *Client) queue(cmd *command, args []Value) Value {
+	if cmd.arity > 0 && len(args) != cmd.arity || len(args) < -cmd.arity {
+		c.multi.dirty = true
+		return errWrongArgs(strings.ToLower(args[0].Bulk))
+	}
+	c.multi.cmds = append(c.multi.cmds, args)
+	return strVal("QUEUED")
+}
+
+func MULTI(c *Client, args []Value) Value {
+	if c.multi != nil {
+		return errVal("MULTI calls can not be nested")
+	}
+	c.multi = &multiState{}
+	return ok()
+}
+
+func DISCARD(c *Client, args []Value) Value {
+	if c.multi == nil {
+		return errVal("DISCARD without MULTI")
+	}
+	c.multi = nil
+	return ok()
+}
+
+// EXEC runs the queued commands while holding the server exclusively, so no other
+// client observes or interleaves with a partially applied transaction
+func EXEC(c *Client, args []Value) Value {
+	if c.multi == nil {
+		return errVal("EXEC without MULTI")
+	}
+	m := c.multi
+	c.multi = nil
+
+	if m.dirty {
+		return errCode("EXECABORT", "Transaction discarded because of previous errors.")
+	}
+
+	return c.atomically(func() Value {
+		res := Value{Type: "array", Array: make([]Value, 0, len(m.cmds))}
+		for _, cmdArgs := range m.cmds {
+			name := strings.ToUpper(cmdArgs[0].Bulk)
+			res.Array = append(res.Array, c.call(name, Handlers[name], cmdArgs))
+		}
+		return res
+	})
+}
+
+// atomically runs fn holding the server exclusively, the writes it propagates reach the
+// replicas wrapped in MULTI/EXEC. Inside EXEC, e.g. for FCALL, fn is part of the
+// transaction already.
+func (c *Client) atomically(fn func() Value) Value {
+	if c.inExec {
+		return fn()
+	}
+	c.srv.execMu.Lock()
+	defer c.srv.execMu.Unlock()
+
+	c.inExec = true
+	defer func() {
+		if c.execPropagated {
+			c.srv.repl.feed([]Value{bulkVal("EXEC")}, true)
+		}
+		c.inExec, c.execPropagated = false, false
+	}()
+	return fn()
+}
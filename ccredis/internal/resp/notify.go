@@ -0,0 +1,119 @@
+package redis
+
+import (
+	"errors"
+	"strconv"
+)
+
+// keyspace event classes, the same letters notify-keyspace-events uses
+const (
+	notifyKeyspace = 1 << iota // K
+	notifyKeyevent             // E
+	notifyGeneric              // g
+	notifyString               // $
+	notifyList                 // l
+	notifySet                  // s
+	notifyHash                 // h
+	notifyZset                 // z
+	notifyExpired              // x
+	notifyEvicted              // e
+	notifyStream               // t
+	notifyKeyMiss              // m
+	notifyModule               // d
+	notifyNew                  // n
+
+	// A, every class except key-miss and new
+	notifyAll = notifyGeneric | notifyString | notifyList | notifySet | notifyHash | notifyZset |
+		notifyExpired | notifyEvicted | notifyStream | notifyModule
+)
+
+var notifyClassChars = []struct {
+	flag int
+	c    byte
+}{
+	{notifyGeneric, 'g'},
+	{notifyString, '$'},
+	{notifyList, 'l'},
+	{notifySet, 's'},
+	{notifyHash, 'h'},
+	{notifyZset, 'z'},
+	{notifyExpired, 'x'},
+	{notifyEvicted, 'e'},
+	{notifyStream, 't'},
+	{notifyModule, 'd'},
+	{notifyKeyMiss, 'm'},
+	{notifyNew, 'n'},
+}
+
+// parseNotifyFlags parses notify-keyspace-events. The evicted class (e) is accepted like in
+// Redis so existing settings keep working, but nothing publishes it until keys can be evicted.
+func parseNotifyFlags(s string) (int, error) {
+	flags := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case 'A':
+			flags |= notifyAll
+		case 'K':
+			flags |= notifyKeyspace
+		case 'E':
+			flags |= notifyKeyevent
+		default:
+			found := false
+			for _, cc := range notifyClassChars {
+				if cc.c == s[i] {
+					flags |= cc.flag
+					found = true
+				}
+			}
+			if !found {
+				return 0, errors.New("invalid event class character")
+			}
+		}
+	}
+	return flags, nil
+}
+
+func formatNotifyFlags(flags int) string {
+	var b []byte
+	if flags&notifyAll == notifyAll {
+		b = append(b, 'A')
+	} else {
+		for _, cc := range notifyClassChars[:10] {
+			if flags&cc.flag != 0 {
+				b = append(b, cc.c)
+			}
+		}
+	}
+	for _, cc := range notifyClassChars[10:] {
+		if flags&cc.flag != 0 {
+			b = append(b, cc.c)
+		}
+	}
+	if flags&notifyKeyspace != 0 {
+		b = append(b, 'K')
+	}
+	if flags&notifyKeyevent != 0 {
+		b = append(b, 'E')
+	}
+	return string(b)
+}
+
+// notify publishes a keyspace event for key if its class is enabled by notify-keyspace-events.
+// Events go to __keyspace@<db>__:<key> with the event as message and to
+// __keyevent@<db>__:<event> with the key as message.
+func (s *Server) notify(class int, event, key string) {
+	flags := int(s.notifyFlags.Load())
+	if flags&class == 0 {
+		return
+	}
+
+	const db = 0
+	prefix := "__keyspace@" + strconv.Itoa(db) + "__:"
+	if flags&notifyKeyspace != 0 {
+		s.pubsub.publish(prefix+key, event)
+	}
+	prefix = "__keyevent@" + strconv.Itoa(db) + "__:"
+	if flags&notifyKeyevent != 0 {
+		s.pubsub.publish(prefix+event, key)
+	}
+}
@@ -0,0 +1,72 @@
+package redis
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotifyFlags(t *testing.T) {
+	t.Parallel()
+
+	flags, err := parseNotifyFlags("KEA")
+	require.NoError(t, err)
+	require.Equal(t, "AKE", formatNotifyFlags(flags))
+
+	flags, err = parseNotifyFlags("Kx$g")
+	require.NoError(t, err)
+	require.Equal(t, "g$xK", formatNotifyFlags(flags))
+
+	flags, err = parseNotifyFlags("Exe")
+	require.NoError(t, err, "the evicted class is accepted even though nothing is evicted")
+	require.Equal(t, "xeE", formatNotifyFlags(flags))
+
+	_, err = parseNotifyFlags("KZ")
+	require.Error(t, err)
+	require.ErrorContains(t, NewServer(Config{NotifyKeyspaceEvents: "KZ"}).ListenAndServe(), "notify-keyspace-events")
+}
+
+func TestNotify_KeyspaceEvents(t *testing.T) {
+	sub := startTestServer(t, Config{})
+	c := dialTestServer(t, sub.srv)
+
+	require.Equal(t, "", c.do(t, "CONFIG", "GET", "notify-keyspace-events").Array[1].Bulk)
+	require.Equal(t, "OK", c.do(t, "CONFIG", "SET", "notify-keyspace-events", "KEA").String)
+	require.Equal(t, "AKE", c.do(t, "CONFIG", "GET", "notify-keyspace-events").Array[1].Bulk)
+
+	v := sub.do(t, "PSUBSCRIBE", "__key*@0__:*")
+	require.Equal(t, []Value{bulkVal("psubscribe"), bulkVal("__key*@0__:*"), intVal(1)}, v.Array)
+
+	// commands other than (un)subscribe are rejected in subscribe mode
+	require.Contains(t, sub.do(t, "GET", "foo").String, "only (P|S)SUBSCRIBE")
+
+	expect := func(channel, message string) {
+		t.Helper()
+		v := sub.read(t)
+		require.Equal(t, "pmessage", v.Array[0].Bulk)
+		require.Equal(t, channel, v.Array[2].Bulk)
+		require.Equal(t, message, v.Array[3].Bulk)
+	}
+
+	require.Equal(t, "OK", c.do(t, "SET", "foo", "bar").String)
+	expect("__keyspace@0__:foo", "set")
+	expect("__keyevent@0__:set", "foo")
+
+	require.Equal(t, 1, c.do(t, "EXPIRE", "foo", "1").Int)
+	expect("__keyspace@0__:foo", "expire")
+	expect("__keyevent@0__:expire", "foo")
+
+	// removed by the active expire cycle, nobody reads the key
+	expect("__keyspace@0__:foo", "expired")
+	expect("__keyevent@0__:expired", "foo")
+
+	// only generic events from here on
+	require.Equal(t, "OK", c.do(t, "CONFIG", "SET", "notify-keyspace-events", "Eg").String)
+	require.Equal(t, "OK", c.do(t, "MSET", "a", "1", "b", "2").String)
+	require.Equal(t, 2, c.do(t, "DEL", "a", "b", "c").Int)
+	expect("__keyevent@0__:del", "a")
+	expect("__keyevent@0__:del", "b")
+
+	require.Equal(t, "ERR CONFIG SET failed (possibly related to argument 'notify-keyspace-events') - invalid event class character",
+		c.do(t, "CONFIG", "SET", "notify-keyspace-events", "KZ").String)
+}
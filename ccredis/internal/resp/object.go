@@ -0,0 +1,442 @@
+package redis
+
+import (
+	"fmt"
+	"math/bits"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Values are stored in the most compact encoding that fits them, like Redis does:
+//
+//	string  int64 (int), string of up to 44 bytes (embstr), longer string or []byte (raw)
+//	hash    *listpack of field value pairs, map[string]string (hashtable)
+//	set     *intset, *listpack, map[string]struct{} (hashtable)
+//	list    *listpack, *quicklist
+//	zset    *listpack of member score pairs, *zset (skiplist)
+//
+// Small aggregates convert to the full structure once they grow past the limits
+// configured with the *-max-listpack-* and set-max-intset-entries parameters.
+const (
+	encodingInt       = "int"
+	encodingEmbstr    = "embstr"
+	encodingRaw       = "raw"
+	encodingListpack  = "listpack"
+	encodingIntset    = "intset"
+	encodingHashtable = "hashtable"
+	encodingQuicklist = "quicklist"
+	encodingSkiplist  = "skiplist"
+)
+
+// longest string that is still embedded into the object header in Redis
+const embstrSizeLimit = 44
+
+func newItem(t redisType, value interface{}) *RedisItem {
+	item := &RedisItem{itemType: t, value: value}
+	item.accessed.Store(time.Now().UnixNano())
+	item.freq.Store(lfuInitVal)
+	return item
+}
+
+// newStringItem stores s as an integer when it is the canonical representation of one
+func newStringItem(s string) *RedisItem {
+	if n, ok := parseCanonicalInt(s); ok {
+		return newItem(REDIS_STRING, n)
+	}
+	return newItem(REDIS_STRING, s)
+}
+
+// parseCanonicalInt only accepts strings that format back to themselves, so that
+// "007" or "+1" keep their exact bytes
+func parseCanonicalInt(s string) (int64, bool) {
+	if len(s) == 0 || len(s) > 20 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || strconv.FormatInt(n, 10) != s {
+		return 0, false
+	}
+	return n, true
+}
+
+// str returns the value of a string item whatever its encoding
+func (item *RedisItem) str() string {
+	switch v := item.value.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		panic(fmt.Sprintf("str called on a %s", item.itemType))
+	}
+}
+
+func (item *RedisItem) encoding() string {
+	switch v := item.value.(type) {
+	case int64:
+		return encodingInt
+	case string:
+		if len(v) <= embstrSizeLimit {
+			return encodingEmbstr
+		}
+		return encodingRaw
+	case []byte:
+		return encodingRaw
+	case *listpack:
+		return encodingListpack
+	case *intset:
+		return encodingIntset
+	case map[string]string, map[string]struct{}:
+		return encodingHashtable
+	case *quicklist:
+		return encodingQuicklist
+	case *zset:
+		return encodingSkiplist
+	default:
+		return "unknown"
+	}
+}
+
+// LFU counter parameters, the Redis defaults of lfu-log-factor and lfu-decay-time
+const (
+	lfuInitVal   = 5
+	lfuLogFactor = 10
+	lfuDecayTime = time.Minute
+)
+
+// touch records an access to item for the idle time and the access frequency
+func (item *RedisItem) touch() {
+	now := time.Now()
+	counter := item.lfuDecayed(now)
+	if counter < 255 {
+		base := max(int(counter)-lfuInitVal, 0)
+		if rand.Float64() < 1/float64(base*lfuLogFactor+1) {
+			counter++
+		}
+	}
+	item.freq.Store(counter)
+	item.accessed.Store(now.UnixNano())
+}
+
+// lfuDecayed returns the access counter decremented by one for every lfuDecayTime without access
+func (item *RedisItem) lfuDecayed(now time.Time) uint32 {
+	counter := item.freq.Load()
+	periods := uint32(now.Sub(time.Unix(0, item.accessed.Load())) / lfuDecayTime)
+	if periods >= counter {
+		return 0
+	}
+	return counter - periods
+}
+
+func (item *RedisItem) idleTime() time.Duration {
+	return time.Since(time.Unix(0, item.accessed.Load()))
+}
+
+// OBJECT ENCODING|IDLETIME|FREQ|REFCOUNT key, OBJECT HELP
+func OBJECT(c *Client, args []Value) Value {
+	sub := strings.ToUpper(args[0].Bulk)
+	if sub == "HELP" {
+		return helpVal("OBJECT",
+			"ENCODING <key>",
+			"    Return the kind of internal representation used in order to store the value",
+			"    associated with a <key>.",
+			"FREQ <key>",
+			"    Return the access frequency index of the <key>. The returned integer is",
+			"    proportional to the logarithm of the recent access frequency of the key.",
+			"IDLETIME <key>",
+			"    Return the idle time of the <key>, that is the approximated number of",
+			"    seconds elapsed since the last access to the key.",
+			"REFCOUNT <key>",
+			"    Return the number of references of the value associated with the specified",
+			"    <key>.",
+		)
+	}
+	if len(args) != 2 {
+		return errVal(fmt.Sprintf("unknown subcommand or wrong number of arguments for '%s'. Try OBJECT HELP.", args[0].Bulk))
+	}
+
+	key := args[1].Bulk
+	defer c.store.rlock(key)()
+
+	item, ok := c.store.get(key)
+	if !ok || isExpired(item.ttl) {
+		return nullVal()
+	}
+
+	switch sub {
+	case "ENCODING":
+		return bulkVal(item.encoding())
+	case "REFCOUNT":
+		// Redis shares the objects of small integers
+		if n, ok := item.value.(int64); ok && n >= 0 && n < 10000 {
+			return intVal(2147483647)
+		}
+		return intVal(1)
+	case "IDLETIME":
+		if c.srv.lfuPolicy() {
+			return errVal("An LFU maxmemory policy is selected, idle time not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")
+		}
+		return intVal(int(item.idleTime() / time.Second))
+	case "FREQ":
+		if !c.srv.lfuPolicy() {
+			return errVal("An LFU maxmemory policy is not selected, access frequency not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")
+		}
+		return intVal(int(item.lfuDecayed(time.Now())))
+	default:
+		return errVal(fmt.Sprintf("unknown subcommand or wrong number of arguments for '%s'. Try OBJECT HELP.", args[0].Bulk))
+	}
+}
+
+// MEMORY USAGE key [SAMPLES count], MEMORY HELP
+func MEMORY(c *Client, args []Value) Value {
+	switch strings.ToUpper(args[0].Bulk) {
+	case "HELP":
+		return helpVal("MEMORY",
+			"USAGE <key> [SAMPLES <count>]",
+			"    Return memory in bytes used by <key> and its value. Nested values are",
+			"    sampled up to <count> times (default: 5, 0 means sample all).",
+		)
+	case "USAGE":
+		if len(args) != 2 && len(args) != 4 {
+			return syntaxErr()
+		}
+		samples := 5
+		if len(args) == 4 {
+			if !strings.EqualFold(args[2].Bulk, "SAMPLES") {
+				return syntaxErr()
+			}
+			n, err := strconv.Atoi(args[3].Bulk)
+			if err != nil || n < 0 {
+				return errVal("value is out of range, must be positive")
+			}
+			samples = n
+		}
+
+		key := args[1].Bulk
+		defer c.store.rlock(key)()
+
+		item, ok := c.store.get(key)
+		if !ok || isExpired(item.ttl) {
+			return nullVal()
+		}
+		return intVal(memoryUsage(key, item, samples))
+	default:
+		return errVal(fmt.Sprintf("unknown subcommand or wrong number of arguments for '%s'. Try MEMORY HELP.", args[0].Bulk))
+	}
+}
+
+// sizes of the Redis structures the estimate below is based on, on a 64 bit build
+const (
+	objectHeaderSize = 16
+	dictEntrySize    = 24
+	dictSize         = 56
+	quicklistSize    = 40
+	quicklistNode    = 32
+	zsetSize         = 16
+	zslSize          = 32
+	zslNodeSize      = 24
+	zslLevelSize     = 16
+	listpackHeader   = 7
+)
+
+// sdsSize is the allocation of a Redis dynamic string of n bytes
+func sdsSize(n int) int {
+	switch {
+	case n < 1<<5:
+		return n + 2
+	case n < 1<<8:
+		return n + 4
+	case n < 1<<16:
+		return n + 6
+	default:
+		return n + 10
+	}
+}
+
+// lpEntrySize is the size of a listpack entry holding s: encoding byte(s), data and backlen
+func lpEntrySize(s string) int {
+	if _, ok := parseCanonicalInt(s); ok {
+		return 1 + 8 + 1
+	}
+	switch n := len(s); {
+	case n < 64:
+		return 1 + n + 1
+	case n < 4096:
+		return 2 + n + 2
+	default:
+		return 5 + n + 5
+	}
+}
+
+func lpSize(entries []string, samples int) int {
+	return listpackHeader + sampled(len(entries), samples, func(i int) int { return lpEntrySize(entries[i]) }) + 1
+}
+
+func dictBuckets(n int) int {
+	if n == 0 {
+		return 0
+	}
+	return 8 << bits.Len(uint(n-1))
+}
+
+// sampled sums size(i) over the first samples of n elements and extrapolates to all of them
+func sampled(n, samples int, size func(i int) int) int {
+	if n == 0 {
+		return 0
+	}
+	if samples == 0 || samples > n {
+		samples = n
+	}
+	total := 0
+	for i := 0; i < samples; i++ {
+		total += size(i)
+	}
+	return total * n / samples
+}
+
+// memoryUsage estimates the bytes Redis would allocate for key and its value
+func memoryUsage(key string, item *RedisItem, samples int) int {
+	size := dictEntrySize + sdsSize(len(key)) + objectHeaderSize
+
+	switch v := item.value.(type) {
+	case int64:
+	case string:
+		if len(v) <= embstrSizeLimit {
+			size += len(v) + 4
+		} else {
+			size += sdsSize(len(v))
+		}
+	case []byte:
+		size += sdsSize(cap(v))
+	case *listpack:
+		size += lpSize(v.entries, samples)
+	case *intset:
+		size += 8 + v.width()*len(v.members)
+	case map[string]string:
+		fields := make([]string, 0, min(len(v), max(samples, 1)))
+		for f := range v {
+			if samples > 0 && len(fields) == samples {
+				break
+			}
+			fields = append(fields, f)
+		}
+		size += dictSize + dictBuckets(len(v)) + sampled(len(v), len(fields), func(i int) int {
+			return dictEntrySize + sdsSize(len(fields[i])) + sdsSize(len(v[fields[i]]))
+		})
+	case map[string]struct{}:
+		members := make([]string, 0, min(len(v), max(samples, 1)))
+		for m := range v {
+			if samples > 0 && len(members) == samples {
+				break
+			}
+			members = append(members, m)
+		}
+		size += dictSize + dictBuckets(len(v)) + sampled(len(v), len(members), func(i int) int {
+			return dictEntrySize + sdsSize(len(members[i]))
+		})
+	case *quicklist:
+		size += quicklistSize + sampled(len(v.nodes), samples, func(i int) int {
+			return quicklistNode + lpSize(v.nodes[i], 0)
+		})
+	case *zset:
+		var nodes []*zskiplistNode
+		for x := v.zsl.header.level[0].forward; x != nil && (samples == 0 || len(nodes) < samples); x = x.level[0].forward {
+			nodes = append(nodes, x)
+		}
+		size += zsetSize + dictSize + dictBuckets(len(v.dict)) + zslSize + zslNodeSize + zskiplistMaxLevel*zslLevelSize +
+			sampled(len(v.dict), len(nodes), func(i int) int {
+				return dictEntrySize + sdsSize(len(nodes[i].member)) + zslNodeSize + len(nodes[i].level)*zslLevelSize
+			})
+	}
+	return size
+}
+
+// DEBUG OBJECT key, DEBUG SLEEP seconds
+func DEBUG(c *Client, args []Value) Value {
+	switch strings.ToUpper(args[0].Bulk) {
+	case "HELP":
+		return helpVal("DEBUG",
+			"OBJECT <key>",
+			"    Show low level info about the <key> and associated value.",
+			"SLEEP <seconds>",
+			"    Stop the server for <seconds>. Decimals are allowed.",
+		)
+	case "OBJECT":
+		if len(args) != 2 {
+			return syntaxErr()
+		}
+		key := args[1].Bulk
+		defer c.store.rlock(key)()
+
+		item, ok := c.store.get(key)
+		if !ok || isExpired(item.ttl) {
+			return errVal("no such key")
+		}
+		info := fmt.Sprintf("Value at:%p refcount:1 encoding:%s serializedlength:%d lru:%d lru_seconds_idle:%d",
+			item, item.encoding(), serializedLength(item), item.accessed.Load()/int64(time.Second)&(1<<24-1), int(item.idleTime()/time.Second))
+		if ql, ok := item.value.(*quicklist); ok {
+			info += fmt.Sprintf(" ql_nodes:%d ql_avg_node:%.2f", len(ql.nodes), float64(ql.n)/float64(len(ql.nodes)))
+		}
+		return strVal(info)
+	case "SLEEP":
+		if len(args) != 2 {
+			return syntaxErr()
+		}
+		secs, err := strconv.ParseFloat(args[1].Bulk, 64)
+		if err != nil || secs < 0 {
+			return errVal("value is not a valid float")
+		}
+		time.Sleep(time.Duration(secs * float64(time.Second)))
+		return ok()
+	default:
+		return errVal(fmt.Sprintf("unknown subcommand or wrong number of arguments for '%s'. Try DEBUG HELP.", args[0].Bulk))
+	}
+}
+
+// serializedLength approximates the size of the value in a dump, one length byte per element plus its bytes
+func serializedLength(item *RedisItem) int {
+	n := 0
+	switch v := item.value.(type) {
+	case int64:
+		return 1 + len(strconv.FormatInt(v, 10))
+	case string:
+		return 1 + len(v)
+	case []byte:
+		return 1 + len(v)
+	case *listpack:
+		for _, e := range v.entries {
+			n += 1 + len(e)
+		}
+	case *intset:
+		n = 8 + v.width()*len(v.members)
+	case map[string]string:
+		for f, val := range v {
+			n += 2 + len(f) + len(val)
+		}
+	case map[string]struct{}:
+		for m := range v {
+			n += 1 + len(m)
+		}
+	case *quicklist:
+		v.each(func(e string) { n += 1 + len(e) })
+	case *zset:
+		for m := range v.dict {
+			n += 1 + len(m) + 8
+		}
+	}
+	return n
+}
+
+// helpVal formats the reply of a HELP subcommand
+func helpVal(cmd string, lines ...string) Value {
+	v := Value{Type: "array", Array: []Value{strVal(cmd + " <subcommand> [<arg> [value] [opt] ...]. Subcommands are:")}}
+	for _, l := range lines {
+		v.Array = append(v.Array, strVal(l))
+	}
+	v.Array = append(v.Array, strVal("HELP"), strVal("    Print this help."))
+	return v
+}
@@ -0,0 +1,149 @@
+package redis
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestObject_StringEncodings(t *testing.T) {
+	n := startTestServer(t, Config{})
+
+	require.Equal(t, "OK", n.do(t, "SET", "int", "12345").String)
+	require.Equal(t, "int", n.do(t, "OBJECT", "ENCODING", "int").Bulk)
+	require.Equal(t, 1, n.do(t, "OBJECT", "REFCOUNT", "int").Int)
+	require.Equal(t, "OK", n.do(t, "SET", "shared", "123").String)
+	require.Equal(t, 2147483647, n.do(t, "OBJECT", "REFCOUNT", "shared").Int)
+
+	// not the canonical form of an integer, the exact bytes must be kept
+	require.Equal(t, "OK", n.do(t, "SET", "padded", "007").String)
+	require.Equal(t, "embstr", n.do(t, "OBJECT", "ENCODING", "padded").Bulk)
+	require.Equal(t, "007", n.do(t, "GET", "padded").Bulk)
+
+	require.Equal(t, "OK", n.do(t, "SET", "long", strings.Repeat("x", 45)).String)
+	require.Equal(t, "raw", n.do(t, "OBJECT", "ENCODING", "long").Bulk)
+
+	require.Equal(t, 12346, n.do(t, "INCR", "int").Int)
+	require.Equal(t, 12336, n.do(t, "DECRBY", "int", "10").Int)
+	require.Equal(t, "12336", n.do(t, "GET", "int").Bulk)
+	require.Equal(t, "int", n.do(t, "OBJECT", "ENCODING", "int").Bulk)
+
+	require.Equal(t, 7, n.do(t, "APPEND", "int", "ab").Int)
+	require.Equal(t, "12336ab", n.do(t, "GET", "int").Bulk)
+	require.Equal(t, "ERR value is not an integer or out of range", n.do(t, "INCR", "int").String)
+
+	require.Equal(t, "OK", n.do(t, "SET", "max", "9223372036854775807").String)
+	require.Equal(t, "ERR increment or decrement would overflow", n.do(t, "INCR", "max").String)
+
+	require.Equal(t, "null", n.do(t, "OBJECT", "ENCODING", "missing").Type)
+}
+
+func TestObject_AggregateEncodings(t *testing.T) {
+	n := startTestServer(t, Config{})
+
+	require.Equal(t, "OK", n.do(t, "CONFIG", "SET", "hash-max-listpack-entries", "4", "set-max-intset-entries", "4",
+		"set-max-listpack-entries", "4", "list-max-listpack-size", "4").String)
+
+	// hashes
+	require.Equal(t, 2, n.do(t, "HSET", "h", "a", "1", "b", "2").Int)
+	require.Equal(t, "listpack", n.do(t, "OBJECT", "ENCODING", "h").Bulk)
+	require.Equal(t, 1, n.do(t, "HSET", "h", "c", strings.Repeat("v", 65)).Int)
+	require.Equal(t, "hashtable", n.do(t, "OBJECT", "ENCODING", "h").Bulk)
+	require.Equal(t, "1", n.do(t, "HGET", "h", "a").Bulk)
+	require.Equal(t, 3, n.do(t, "HLEN", "h").Int)
+	require.Len(t, n.do(t, "HGETALL", "h").Array, 6)
+
+	require.Equal(t, 3, n.do(t, "HSET", "h2", "a", "1", "b", "2", "c", "3").Int)
+	require.Equal(t, 10, n.do(t, "HINCRBY", "h2", "a", "9").Int)
+	require.Equal(t, "listpack", n.do(t, "OBJECT", "ENCODING", "h2").Bulk)
+	require.Equal(t, 2, n.do(t, "HSET", "h2", "d", "4", "e", "5").Int)
+	require.Equal(t, "hashtable", n.do(t, "OBJECT", "ENCODING", "h2").Bulk)
+	require.Equal(t, "10", n.do(t, "HMGET", "h2", "a", "x").Array[0].Bulk)
+	require.Equal(t, 5, n.do(t, "HDEL", "h2", "a", "b", "c", "d", "e").Int)
+	require.Equal(t, -2, n.do(t, "TTL", "h2").Int)
+
+	// sets go from intset to listpack to hashtable
+	require.Equal(t, 3, n.do(t, "SADD", "s", "1", "2", "3").Int)
+	require.Equal(t, "intset", n.do(t, "OBJECT", "ENCODING", "s").Bulk)
+	require.Equal(t, 1, n.do(t, "SADD", "s", "x").Int)
+	require.Equal(t, "listpack", n.do(t, "OBJECT", "ENCODING", "s").Bulk)
+	require.Equal(t, 1, n.do(t, "SADD", "s", "y").Int)
+	require.Equal(t, "hashtable", n.do(t, "OBJECT", "ENCODING", "s").Bulk)
+	require.Equal(t, 1, n.do(t, "SISMEMBER", "s", "2").Int)
+	require.Equal(t, 5, n.do(t, "SCARD", "s").Int)
+	require.ElementsMatch(t, []Value{bulkVal("1"), bulkVal("2"), bulkVal("3"), bulkVal("x"), bulkVal("y")}, n.do(t, "SMEMBERS", "s").Array)
+
+	for i := 0; i < 5; i++ {
+		require.Equal(t, 1, n.do(t, "SADD", "ints", fmt.Sprint(i)).Int)
+	}
+	require.Equal(t, "hashtable", n.do(t, "OBJECT", "ENCODING", "ints").Bulk)
+	require.Len(t, n.do(t, "SPOP", "ints", "10").Array, 5)
+	require.Equal(t, "none", n.do(t, "TYPE", "ints").String)
+
+	// lists go to a quicklist and back once they shrink to half the limit
+	require.Equal(t, 4, n.do(t, "RPUSH", "l", "a", "b", "c", "d").Int)
+	require.Equal(t, "listpack", n.do(t, "OBJECT", "ENCODING", "l").Bulk)
+	require.Equal(t, 6, n.do(t, "LPUSH", "l", "y", "z").Int)
+	require.Equal(t, "quicklist", n.do(t, "OBJECT", "ENCODING", "l").Bulk)
+	require.Equal(t, []Value{bulkVal("z"), bulkVal("y"), bulkVal("a"), bulkVal("b"), bulkVal("c"), bulkVal("d")}, n.do(t, "LRANGE", "l", "0", "-1").Array)
+	require.Equal(t, "c", n.do(t, "LINDEX", "l", "-2").Bulk)
+	require.Equal(t, "z", n.do(t, "LPOP", "l").Bulk)
+	require.Equal(t, []Value{bulkVal("d"), bulkVal("c"), bulkVal("b")}, n.do(t, "RPOP", "l", "3").Array)
+	require.Equal(t, "listpack", n.do(t, "OBJECT", "ENCODING", "l").Bulk)
+	require.Equal(t, 2, n.do(t, "LLEN", "l").Int)
+
+	require.Equal(t, "WRONGTYPE Operation against a key holding the wrong kind of value", n.do(t, "GET", "l").String)
+	require.Equal(t, "WRONGTYPE Operation against a key holding the wrong kind of value", n.do(t, "SADD", "h", "x").String)
+	require.Equal(t, "WRONGTYPE Operation against a key holding the wrong kind of value", n.do(t, "SET", "s", "x", "GET").String)
+}
+
+func TestObject_IdleTimeAndFreq(t *testing.T) {
+	n := startTestServer(t, Config{})
+
+	require.Equal(t, "OK", n.do(t, "SET", "foo", "bar").String)
+	require.Equal(t, 0, n.do(t, "OBJECT", "IDLETIME", "foo").Int)
+	require.Contains(t, n.do(t, "OBJECT", "FREQ", "foo").String, "An LFU maxmemory policy is not selected")
+
+	require.Equal(t, "OK", n.do(t, "CONFIG", "SET", "maxmemory-policy", "allkeys-lfu").String)
+	require.Contains(t, n.do(t, "OBJECT", "IDLETIME", "foo").String, "An LFU maxmemory policy is selected")
+	require.Equal(t, lfuInitVal, n.do(t, "OBJECT", "FREQ", "foo").Int)
+
+	// the first accesses always count, the counter is logarithmic after that
+	for i := 0; i < 100; i++ {
+		n.do(t, "GET", "foo")
+	}
+	freq := n.do(t, "OBJECT", "FREQ", "foo").Int
+	require.Greater(t, freq, lfuInitVal)
+	require.Less(t, freq, 50)
+
+	require.Contains(t, n.do(t, "CONFIG", "SET", "maxmemory-policy", "bogus").String, "must be one of the following")
+}
+
+func TestObject_MemoryUsage(t *testing.T) {
+	n := startTestServer(t, Config{})
+
+	n.do(t, "SET", "small", "1")
+	n.do(t, "SET", "big", strings.Repeat("x", 1000))
+	small := n.do(t, "MEMORY", "USAGE", "small").Int
+	big := n.do(t, "MEMORY", "USAGE", "big").Int
+	require.Greater(t, small, 0)
+	require.Greater(t, big, small+1000)
+
+	for i := 0; i < 200; i++ {
+		n.do(t, "HSET", "h", fmt.Sprint("field", i), "value")
+	}
+	require.Equal(t, "hashtable", n.do(t, "OBJECT", "ENCODING", "h").Bulk)
+	all := n.do(t, "MEMORY", "USAGE", "h", "SAMPLES", "0").Int
+	sampledUsage := n.do(t, "MEMORY", "USAGE", "h").Int
+	require.Greater(t, all, 200*20)
+	require.InEpsilon(t, all, sampledUsage, 0.2)
+
+	require.Equal(t, "null", n.do(t, "MEMORY", "USAGE", "missing").Type)
+	require.Equal(t, "ERR syntax error", n.do(t, "MEMORY", "USAGE", "h", "SAMPLE", "1").String)
+
+	info := n.do(t, "DEBUG", "OBJECT", "big").String
+	require.Contains(t, info, "encoding:raw")
+	require.Contains(t, info, "serializedlength:1001")
+}
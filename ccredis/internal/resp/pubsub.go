@@ -0,0 +1,198 @@
+package redis
+
+import (
+	"slices"
+	"strings"
+	"sync"
+)
+
+type pubsub struct {
+	mu       sync.RWMutex
+	channels map[string]map[*Client]struct{}
+	patterns map[string]map[*Client]struct{}
+}
+
+func newPubsub() *pubsub {
+	return &pubsub{
+		channels: make(map[string]map[*Client]struct{}),
+		patterns: make(map[string]map[*Client]struct{}),
+	}
+}
+
+// publish delivers message to every client subscribed to channel, directly or through
+// a pattern, and returns the number of clients that received it
+func (ps *pubsub) publish(channel, message string) int {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	n := 0
+	for c := range ps.channels[channel] {
+		c.push(Value{Type: "array", Array: []Value{bulkVal("message"), bulkVal(channel), bulkVal(message)}})
+		n++
+	}
+	for pattern, clients := range ps.patterns {
+		if !matchPattern(pattern, channel) {
+			continue
+		}
+		for c := range clients {
+			c.push(Value{Type: "array", Array: []Value{bulkVal("pmessage"), bulkVal(pattern), bulkVal(channel), bulkVal(message)}})
+			n++
+		}
+	}
+	return n
+}
+
+func (ps *pubsub) subscribe(c *Client, channel string, pattern bool) int {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	registry, subs := ps.channels, c.subs
+	if pattern {
+		registry, subs = ps.patterns, c.psubs
+	}
+
+	if _, ok := subs[channel]; !ok {
+		subs[channel] = struct{}{}
+		if registry[channel] == nil {
+			registry[channel] = make(map[*Client]struct{})
+		}
+		registry[channel][c] = struct{}{}
+	}
+	return len(c.subs) + len(c.psubs)
+}
+
+func (ps *pubsub) unsubscribe(c *Client, channel string, pattern bool) int {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	registry, subs := ps.channels, c.subs
+	if pattern {
+		registry, subs = ps.patterns, c.psubs
+	}
+
+	if _, ok := subs[channel]; ok {
+		delete(subs, channel)
+		delete(registry[channel], c)
+		if len(registry[channel]) == 0 {
+			delete(registry, channel)
+		}
+	}
+	return len(c.subs) + len(c.psubs)
+}
+
+// subscriptions returns the channels (or patterns) c is subscribed to
+func (ps *pubsub) subscriptions(c *Client, pattern bool) []string {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	subs := c.subs
+	if pattern {
+		subs = c.psubs
+	}
+	names := make([]string, 0, len(subs))
+	for name := range subs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// unsubscribeAll drops every subscription of a disconnecting client
+func (ps *pubsub) unsubscribeAll(c *Client) {
+	for _, ch := range ps.subscriptions(c, false) {
+		ps.unsubscribe(c, ch, false)
+	}
+	for _, p := range ps.subscriptions(c, true) {
+		ps.unsubscribe(c, p, true)
+	}
+}
+
+func (ps *pubsub) count(c *Client) int {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	return len(c.subs) + len(c.psubs)
+}
+
+func (c *Client) subscribed() bool {
+	return c.srv.pubsub.count(c) > 0
+}
+
+func subscribeCommand(c *Client, args []Value, kind string, pattern bool) Value {
+	for _, arg := range args {
+		n := c.srv.pubsub.subscribe(c, arg.Bulk, pattern)
+		c.write(Value{Type: "array", Array: []Value{bulkVal(kind), bulkVal(arg.Bulk), intVal(n)}})
+	}
+	return noReply()
+}
+
+func unsubscribeCommand(c *Client, args []Value, kind string, pattern bool) Value {
+	var names []string
+	for _, arg := range args {
+		names = append(names, arg.Bulk)
+	}
+	if len(names) == 0 {
+		names = c.srv.pubsub.subscriptions(c, pattern)
+	}
+
+	if len(names) == 0 {
+		return Value{Type: "array", Array: []Value{bulkVal(kind), nullVal(), intVal(c.srv.pubsub.count(c))}}
+	}
+
+	for _, name := range names {
+		n := c.srv.pubsub.unsubscribe(c, name, pattern)
+		c.write(Value{Type: "array", Array: []Value{bulkVal(kind), bulkVal(name), intVal(n)}})
+	}
+	return noReply()
+}
+
+func SUBSCRIBE(c *Client, args []Value) Value {
+	return subscribeCommand(c, args, "subscribe", false)
+}
+
+func PSUBSCRIBE(c *Client, args []Value) Value {
+	return subscribeCommand(c, args, "psubscribe", true)
+}
+
+func UNSUBSCRIBE(c *Client, args []Value) Value {
+	return unsubscribeCommand(c, args, "unsubscribe", false)
+}
+
+func PUNSUBSCRIBE(c *Client, args []Value) Value {
+	return unsubscribeCommand(c, args, "punsubscribe", true)
+}
+
+func PUBLISH(c *Client, args []Value) Value {
+	return intVal(c.srv.pubsub.publish(args[0].Bulk, args[1].Bulk))
+}
+
+// PUBSUB CHANNELS [pattern] | NUMSUB [channel ...] | NUMPAT
+func PUBSUB(c *Client, args []Value) Value {
+	ps := c.srv.pubsub
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	switch strings.ToUpper(args[0].Bulk) {
+	case "CHANNELS":
+		pattern := "*"
+		if len(args) > 1 {
+			pattern = args[1].Bulk
+		}
+		v := Value{Type: "array", Array: []Value{}}
+		for ch := range ps.channels {
+			if matchPattern(pattern, ch) {
+				v.Array = append(v.Array, bulkVal(ch))
+			}
+		}
+		return v
+	case "NUMSUB":
+		v := Value{Type: "array", Array: []Value{}}
+		for _, arg := range args[1:] {
+			v.Array = append(v.Array, bulkVal(arg.Bulk), intVal(len(ps.channels[arg.Bulk])))
+		}
+		return v
+	case "NUMPAT":
+		return intVal(len(ps.patterns))
+	default:
+		return errVal("unknown subcommand '" + args[0].Bulk + "'. Try PUBSUB HELP.")
+	}
+}
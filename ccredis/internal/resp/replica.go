@@ -0,0 +1,382 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// how long a replica waits before reconnecting to its primary
+	replReconnectDelay = time.Second
+	// a link that sees nothing from the primary for this long is considered dead, the
+	// primary pings every replPingPeriod
+	replTimeout = 60 * time.Second
+	// how often a replica acknowledges its offset without being asked
+	replAckPeriod = time.Second
+)
+
+const (
+	linkConnecting int32 = iota
+	linkSyncing
+	linkUp
+)
+
+var errLinkStopped = errors.New("replication stopped")
+
+// masterLink replicates the primary at host:port, reconnecting and resyncing until it is stopped
+type masterLink struct {
+	srv  *Server
+	host string
+	port int
+
+	stopc chan struct{}
+	done  chan struct{}
+
+	// the current connection, closed by stop, and the replication id of the primary
+	mu      sync.Mutex
+	conn    net.Conn
+	stopped bool
+	replid  string
+	// serializes the acks written by the ack ticker and in reply to GETACK
+	wmu sync.Mutex
+
+	state atomic.Int32
+	// bytes of the stream of the primary processed so far
+	offset    atomic.Int64
+	lastIO    atomic.Int64
+	downSince atomic.Int64
+
+	// own offsets after applying the commands of the primary paired with the primary offset,
+	// maps the offset the local AOF is fsynced to onto the stream of the primary for FACK
+	pointsMu sync.Mutex
+	points   []ackPoint
+	fack     int64
+}
+
+type ackPoint struct {
+	local, primary int64
+}
+
+func (l *masterLink) addr() string {
+	return net.JoinHostPort(l.host, strconv.Itoa(l.port))
+}
+
+// replicaOf makes the server a replica of host:port, dropping the link to a previous primary
+func (r *replication) replicaOf(host string, port int) {
+	r.linkMu.Lock()
+	defer r.linkMu.Unlock()
+
+	l := &masterLink{
+		srv:   r.srv,
+		host:  host,
+		port:  port,
+		stopc: make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	l.downSince.Store(time.Now().UnixNano())
+	if old := r.link.Swap(l); old != nil {
+		old.stop(true)
+	}
+	log.Printf("connecting to primary %s", l.addr())
+	go l.run()
+}
+
+// promote stops replicating and starts a new history, like REPLICAOF NO ONE
+func (r *replication) promote() {
+	r.linkMu.Lock()
+	defer r.linkMu.Unlock()
+
+	old := r.link.Swap(nil)
+	if old == nil {
+		return
+	}
+	old.stop(true)
+	r.mu.Lock()
+	r.id = newNodeID()
+	r.mu.Unlock()
+	log.Printf("replication from %s stopped, serving as a primary", old.addr())
+}
+
+// stop closes the link, with wait it returns once the last command of the primary is applied
+func (l *masterLink) stop(wait bool) {
+	l.mu.Lock()
+	if !l.stopped {
+		l.stopped = true
+		close(l.stopc)
+		if l.conn != nil {
+			l.conn.Close()
+		}
+	}
+	l.mu.Unlock()
+	if wait {
+		<-l.done
+	}
+}
+
+func (l *masterLink) run() {
+	defer close(l.done)
+	for {
+		err := l.sync()
+		l.state.Store(linkConnecting)
+		l.downSince.Store(time.Now().UnixNano())
+		select {
+		case <-l.stopc:
+			return
+		default:
+		}
+		log.Printf("replication from %s failed: %v, reconnecting in %v", l.addr(), err, replReconnectDelay)
+		select {
+		case <-l.stopc:
+			return
+		case <-time.After(replReconnectDelay):
+		}
+	}
+}
+
+// sync connects to the primary, loads its snapshot and applies its stream until the link breaks
+func (l *masterLink) sync() error {
+	conn, err := net.DialTimeout("tcp", l.addr(), replTimeout)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	if l.stopped {
+		l.mu.Unlock()
+		conn.Close()
+		return errLinkStopped
+	}
+	l.conn = conn
+	l.mu.Unlock()
+	defer conn.Close()
+
+	r := NewReader(conn)
+	w := NewWriter(conn)
+	call := func(args ...string) (Value, error) {
+		conn.SetDeadline(time.Now().Add(replTimeout))
+		if _, err := w.Write(Value{Type: "array", Array: bulkVals(args)}); err != nil {
+			return Value{}, err
+		}
+		v, err := r.Read()
+		if err == nil && v.Type == "error" {
+			err = fmt.Errorf("%s replied %q", args[0], v.String)
+		}
+		return v, err
+	}
+
+	if _, err := call("PING"); err != nil {
+		return err
+	}
+	if _, err := call("REPLCONF", "listening-port", strconv.Itoa(l.srv.cfg.Port)); err != nil {
+		return err
+	}
+	l.state.Store(linkSyncing)
+	v, err := call("PSYNC", "?", "-1")
+	if err != nil {
+		return err
+	}
+	var id string
+	var off int64
+	if _, err := fmt.Sscanf(v.String, "FULLRESYNC %s %d", &id, &off); err != nil {
+		return fmt.Errorf("unexpected PSYNC reply %q", v.String)
+	}
+	snapshot, err := r.Read()
+	if err != nil {
+		return err
+	}
+	if snapshot.Type != "bulk" {
+		return errors.New("expected the snapshot of the primary")
+	}
+	if err := l.load([]byte(snapshot.Bulk), off); err != nil {
+		return err
+	}
+	log.Printf("synchronized with primary %s, replication id %s offset %d", l.addr(), id, off)
+	l.mu.Lock()
+	l.replid = id
+	l.mu.Unlock()
+	l.state.Store(linkUp)
+	l.lastIO.Store(time.Now().UnixNano())
+
+	acks := make(chan struct{})
+	defer close(acks)
+	go func() {
+		ticker := time.NewTicker(replAckPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-acks:
+				return
+			case <-ticker.C:
+				l.ack(w, conn)
+			}
+		}
+	}()
+	l.ack(w, conn)
+
+	// the primary's commands run as a client whose replies are discarded
+	mc := l.srv.newClient(conn)
+	mc.replicated = true
+	defer mc.close()
+	for {
+		conn.SetReadDeadline(time.Now().Add(replTimeout))
+		v, err := r.Read()
+		if err != nil {
+			return err
+		}
+		l.lastIO.Store(time.Now().UnixNano())
+		n := int64(v.encodedLen())
+		if v.Type != "array" || len(v.Array) == 0 {
+			return fmt.Errorf("unexpected %s in the replication stream", v.Type)
+		}
+
+		if strings.EqualFold(v.Array[0].Bulk, "REPLCONF") && len(v.Array) > 1 && strings.EqualFold(v.Array[1].Bulk, "GETACK") {
+			l.offset.Add(n)
+			l.ack(w, conn)
+			continue
+		}
+		mc.dispatch(v.Array)
+		l.applied(l.offset.Add(n))
+	}
+}
+
+// load replaces the keyspace with the snapshot of the primary, the stream continues at off
+func (l *masterLink) load(data []byte, off int64) error {
+	records, rest, err := decodeSnapshot(data)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 0 {
+		return errSnapshotFormat
+	}
+
+	s := l.srv
+	s.aofRewriteMu.Lock()
+	defer s.aofRewriteMu.Unlock()
+	unlock := s.store.lockAll()
+	defer unlock()
+
+	removed := s.store.flush()
+	s.functions.flush()
+	n, err := s.restoreSnapshot(records)
+	s.dirty.Add(int64(removed + n))
+	s.tracking.invalidateAll()
+	// replicas of this server saw none of it, they have to resync
+	s.repl.dropReplicas()
+	if err != nil {
+		return err
+	}
+	if s.repl.aofEnabled() {
+		if err := s.rewriteAOFLocked(true); err != nil {
+			return err
+		}
+	}
+
+	l.offset.Store(off)
+	l.pointsMu.Lock()
+	l.points = []ackPoint{{local: s.repl.offset.Load(), primary: off}}
+	l.fack = 0
+	l.pointsMu.Unlock()
+	return nil
+}
+
+// applied records that the primary offset primary is applied, see points
+func (l *masterLink) applied(primary int64) {
+	local := l.srv.repl.offset.Load()
+	l.pointsMu.Lock()
+	defer l.pointsMu.Unlock()
+	if n := len(l.points); n > 0 && l.points[n-1].local == local {
+		l.points[n-1].primary = primary
+		return
+	}
+	l.points = append(l.points, ackPoint{local: local, primary: primary})
+}
+
+// fsynced returns the primary offset up to which the commands of the primary are fsynced to
+// the local AOF
+func (l *masterLink) fsynced() int64 {
+	synced := l.srv.repl.aofSynced.Load()
+	l.pointsMu.Lock()
+	defer l.pointsMu.Unlock()
+	i := 0
+	for i < len(l.points) && l.points[i].local <= synced {
+		l.fack = l.points[i].primary
+		i++
+	}
+	if i > 1 {
+		// keep the last fsynced point, later commands that write nothing are fsynced with it
+		l.points = l.points[i-1:]
+	}
+	return l.fack
+}
+
+// ack sends REPLCONF ACK with the processed offset, and the fsynced one with an AOF
+func (l *masterLink) ack(w *Writer, conn net.Conn) {
+	args := []string{"REPLCONF", "ACK", strconv.FormatInt(l.offset.Load(), 10)}
+	if l.srv.repl.aofEnabled() {
+		args = append(args, "FACK", strconv.FormatInt(l.fsynced(), 10))
+	}
+	l.wmu.Lock()
+	defer l.wmu.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(replTimeout))
+	w.Write(Value{Type: "array", Array: bulkVals(args)})
+}
+
+func bulkVals(args []string) []Value {
+	vals := make([]Value, len(args))
+	for i, arg := range args {
+		vals[i] = bulkVal(arg)
+	}
+	return vals
+}
+
+// info formats the replica fields of INFO replication
+func (l *masterLink) info(b *strings.Builder) {
+	up := l.state.Load() == linkUp
+	fmt.Fprintf(b, "master_host:%s\r\nmaster_port:%d\r\n", l.host, l.port)
+	status, lastIO := "down", -1
+	if up {
+		status, lastIO = "up", int(time.Since(time.Unix(0, l.lastIO.Load())).Seconds())
+	}
+	fmt.Fprintf(b, "master_link_status:%s\r\n", status)
+	fmt.Fprintf(b, "master_last_io_seconds_ago:%d\r\n", lastIO)
+	fmt.Fprintf(b, "master_sync_in_progress:%d\r\n", btoi(l.state.Load() == linkSyncing))
+	fmt.Fprintf(b, "slave_read_repl_offset:%d\r\nslave_repl_offset:%d\r\n", l.offset.Load(), l.offset.Load())
+	if !up {
+		fmt.Fprintf(b, "master_link_down_since_seconds:%d\r\n", int(time.Since(time.Unix(0, l.downSince.Load())).Seconds()))
+	}
+	fmt.Fprintf(b, "slave_priority:%d\r\nslave_read_only:1\r\n", l.srv.replicaPriority.Load())
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// REPLICAOF host port | NO ONE
+func REPLICAOF(c *Client, args []Value) Value {
+	if c.srv.cluster != nil {
+		return errVal("REPLICAOF not allowed in cluster mode.")
+	}
+	if strings.EqualFold(args[0].Bulk, "no") && strings.EqualFold(args[1].Bulk, "one") {
+		c.srv.repl.promote()
+		return ok()
+	}
+
+	port, err := strconv.Atoi(args[1].Bulk)
+	if err != nil || port < 0 || port > 65535 {
+		return errVal("Invalid master port")
+	}
+	if l := c.srv.repl.link.Load(); l != nil && l.host == args[0].Bulk && l.port == port {
+		return strVal("OK Already connected to specified master")
+	}
+	c.srv.repl.replicaOf(args[0].Bulk, port)
+	return ok()
+}
@@ -0,0 +1,467 @@
+package redis
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Replication works like Redis without partial resyncs: a replica connects like any other
+// client, sends PSYNC and gets a snapshot of the keyspace followed by the stream of write
+// commands executed since the snapshot was taken. The offset counts the bytes of the stream,
+// replicas acknowledge the offset they processed and, when they have an AOF, the offset they
+// fsynced, which WAIT and WAITAOF compare with the offset of the last write of a client.
+//
+// A command is propagated the first time it modifies a key, while it still holds the lock of
+// the key, so the stream orders the writes to a key the way they were executed. Commands
+// whose effects depend on randomness or on the local keyspace, like SPOP or MIGRATE,
+// propagate their effects instead, see replicateAs, and relative expire times are
+// propagated as absolute ones, so replaying a write later doesn't extend its ttl. The same
+// stream feeds the AOF.
+
+// bytes a replica may have queued before it is disconnected and has to resync
+const replicaOutputLimit = 256 << 20
+
+// how often the primary pings its replicas, which lets them detect a dead link
+const replPingPeriod = 10 * time.Second
+
+type replication struct {
+	srv *Server
+
+	mu sync.Mutex
+	// id of the history the stream belongs to, a promoted replica starts a new one
+	id string
+	// bytes fed to the stream so far, written under mu
+	offset   atomic.Int64
+	replicas map[*Client]*replica
+	aof      *aof
+	lastPing time.Time
+	// set while there are replicas or an AOF, lets feed skip encoding commands nobody reads
+	listening atomic.Bool
+
+	// the offset up to which the AOF is fsynced
+	aofSynced atomic.Int64
+	// broadcast whenever a replica acknowledges an offset or the AOF is fsynced
+	acked notifier
+
+	// the link to the primary while this server is a replica, changed under linkMu
+	linkMu sync.Mutex
+	link   atomic.Pointer[masterLink]
+}
+
+func newReplication(s *Server) *replication {
+	return &replication{
+		srv:      s,
+		id:       newNodeID(),
+		replicas: make(map[*Client]*replica),
+	}
+}
+
+// notifier wakes up everyone waiting for something to change
+type notifier struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+// wait returns a channel closed by the next broadcast
+func (n *notifier) wait() <-chan struct{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.ch == nil {
+		n.ch = make(chan struct{})
+	}
+	return n.ch
+}
+
+func (n *notifier) broadcast() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.ch != nil {
+		close(n.ch)
+		n.ch = nil
+	}
+}
+
+// updateListening must be called under mu whenever the replicas or the AOF change
+func (r *replication) updateListening() {
+	r.listening.Store(len(r.replicas) > 0 || r.aof != nil)
+}
+
+// feed appends a command to the stream, and to the AOF when toAOF is set, and returns the
+// offset right after it. Writes must be fed with the lock of the keys they modify held.
+func (r *replication) feed(args []Value, toAOF bool) int64 {
+	if !r.listening.Load() {
+		// nobody can see the offset move, a replica or AOF attached later starts from a
+		// snapshot taken under the keyspace locks, which includes this write
+		return r.offset.Load()
+	}
+
+	v := Value{Type: "array", Array: args}
+	size := v.encodedLen()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	off := r.offset.Add(int64(size))
+	for _, rep := range r.replicas {
+		rep.send(v, size)
+	}
+	if toAOF && r.aof != nil {
+		r.aof.append(v, off)
+	}
+	return off
+}
+
+// feedExpired propagates the removal of an expired key, called under the lock of its shard
+func (r *replication) feedExpired(key string) {
+	r.feed([]Value{bulkVal("DEL"), bulkVal(key)}, true)
+}
+
+// cron pings the replicas every replPingPeriod
+func (r *replication) cron() {
+	r.mu.Lock()
+	due := len(r.replicas) > 0 && time.Since(r.lastPing) >= replPingPeriod
+	if due {
+		r.lastPing = time.Now()
+	}
+	r.mu.Unlock()
+	if due {
+		r.feed([]Value{bulkVal("PING")}, false)
+	}
+}
+
+// close stops replicating from the primary and flushes the AOF
+func (r *replication) close() {
+	r.linkMu.Lock()
+	if l := r.link.Swap(nil); l != nil {
+		// the link may be applying a command that waits for execMu, held by Shutdown
+		l.stop(false)
+	}
+	r.linkMu.Unlock()
+	r.disableAOF()
+}
+
+// propagate replicates the command c is running, once, the first time it modifies a key
+func (c *Client) propagate() {
+	if c.propagating == nil || c.propagated {
+		return
+	}
+	c.propagated = true
+	if c.inExec && !c.execPropagated {
+		// the commands of a transaction reach the replicas wrapped in MULTI/EXEC
+		c.execPropagated = true
+		c.srv.repl.feed([]Value{bulkVal("MULTI")}, true)
+	}
+	c.woff = c.srv.repl.feed(c.propagating, true)
+}
+
+// replicateAs replaces what the running command propagates, for commands whose effects
+// can't be reproduced by running them again. It must be called before the first
+// signalModifiedKey.
+func (c *Client) replicateAs(args ...string) {
+	if c.propagating == nil {
+		return
+	}
+	vals := make([]Value, len(args))
+	for i, arg := range args {
+		vals[i] = bulkVal(arg)
+	}
+	c.propagating = vals
+}
+
+// replica is the primary side of a connected replica
+type replica struct {
+	c *Client
+	// port the replica listens on, sent with REPLCONF listening-port
+	port int
+
+	// offsets the replica processed and fsynced to its AOF, and when it last acknowledged
+	ack     atomic.Int64
+	fack    atomic.Int64
+	ackTime atomic.Int64
+
+	// commands fed to the replica, moved to the client's output by forward
+	mu     sync.Mutex
+	queue  []Value
+	queued int
+	wake   chan struct{}
+}
+
+// send queues a command of n bytes, called under replication.mu. A replica that falls too
+// far behind is disconnected instead of buffering without bounds.
+func (rep *replica) send(v Value, n int) {
+	rep.mu.Lock()
+	if rep.queued+n > replicaOutputLimit {
+		rep.mu.Unlock()
+		rep.c.close()
+		rep.c.conn.Close()
+		return
+	}
+	rep.queue = append(rep.queue, v)
+	rep.queued += n
+	rep.mu.Unlock()
+
+	select {
+	case rep.wake <- struct{}{}:
+	default:
+	}
+}
+
+// forward writes the queued commands to the replica until it disconnects
+func (rep *replica) forward() {
+	for {
+		select {
+		case <-rep.wake:
+		case <-rep.c.quit:
+			return
+		}
+		rep.mu.Lock()
+		queue := rep.queue
+		rep.queue, rep.queued = nil, 0
+		rep.mu.Unlock()
+		for _, v := range queue {
+			rep.c.write(v)
+		}
+	}
+}
+
+// addReplica registers c as a replica and returns a snapshot of the keyspace together with
+// the offset the stream continues from
+func (r *replication) addReplica(c *Client) (*replica, []byte, int64, string, error) {
+	rep := &replica{c: c, port: c.listeningPort, wake: make(chan struct{}, 1)}
+	rep.ackTime.Store(time.Now().UnixNano())
+
+	// no command can modify a key while the keyspace is read-locked, so the snapshot holds
+	// exactly the writes fed before the replica was registered
+	unlock := r.srv.store.rlockAll()
+	r.mu.Lock()
+	r.replicas[c] = rep
+	r.updateListening()
+	off, id := r.offset.Load(), r.id
+	r.mu.Unlock()
+
+	var buf bytes.Buffer
+	err := r.srv.writeSnapshot(&buf, snapshotLocked)
+	unlock()
+	if err != nil {
+		r.removeReplica(c)
+		return nil, nil, 0, "", err
+	}
+	return rep, buf.Bytes(), off, id, nil
+}
+
+func (r *replication) removeReplica(c *Client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.replicas[c]; ok {
+		delete(r.replicas, c)
+		r.updateListening()
+		r.acked.broadcast()
+	}
+}
+
+// dropReplicas disconnects every replica, they resync with whatever the keyspace becomes
+func (r *replication) dropReplicas() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for c := range r.replicas {
+		c.close()
+		c.conn.Close()
+	}
+}
+
+// countAcks returns how many replicas processed, or with fsynced set fsynced, the stream up to off
+func (r *replication) countAcks(off int64, fsynced bool) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, rep := range r.replicas {
+		ack := rep.ack.Load()
+		if fsynced {
+			ack = rep.fack.Load()
+		}
+		if ack >= off {
+			n++
+		}
+	}
+	return n
+}
+
+// waitFor blocks c until done reports true, timeout passes (0 waits forever), or c or the
+// server is closed. The replicas are asked to acknowledge their offset right away.
+func (r *replication) waitFor(c *Client, timeout time.Duration, done func() bool) {
+	if done() {
+		return
+	}
+	r.feed([]Value{bulkVal("REPLCONF"), bulkVal("GETACK"), bulkVal("*")}, false)
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		expired = t.C
+	}
+	for {
+		changed := r.acked.wait()
+		if done() {
+			return
+		}
+		select {
+		case <-changed:
+		case <-expired:
+			return
+		case <-c.quit:
+			return
+		case <-r.srv.done:
+			return
+		}
+	}
+}
+
+// replicaInfo formats the replicas for INFO replication
+func (r *replication) replicaInfo(b *strings.Builder) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fmt.Fprintf(b, "connected_slaves:%d\r\n", len(r.replicas))
+	i := 0
+	for _, rep := range r.replicas {
+		ip, _, _ := net.SplitHostPort(rep.c.addr())
+		lag := time.Since(time.Unix(0, rep.ackTime.Load()))
+		fmt.Fprintf(b, "slave%d:ip=%s,port=%d,state=online,offset=%d,lag=%d\r\n", i, ip, rep.port, rep.ack.Load(), int(lag.Seconds()))
+		i++
+	}
+}
+
+// PSYNC replicationid offset, always answered with a full resync
+func PSYNC(c *Client, args []Value) Value {
+	if c.replica != nil {
+		return errVal("PSYNC already in progress")
+	}
+	rep, snapshot, off, id, err := c.srv.repl.addReplica(c)
+	if err != nil {
+		return errVal(err.Error())
+	}
+	c.srv.clientsMu.Lock()
+	c.replica = rep
+	c.srv.clientsMu.Unlock()
+
+	c.write(strVal(fmt.Sprintf("FULLRESYNC %s %d", id, off)))
+	c.write(bulkVal(string(snapshot)))
+	go rep.forward()
+	return noReply()
+}
+
+// REPLCONF option value [option value ...], sent by replicas
+func REPLCONF(c *Client, args []Value) Value {
+	if len(args)%2 != 0 {
+		return syntaxErr()
+	}
+	for i := 0; i < len(args); i += 2 {
+		opt, val := strings.ToLower(args[i].Bulk), args[i+1].Bulk
+		switch opt {
+		case "listening-port":
+			port, err := strconv.Atoi(val)
+			if err != nil {
+				return errVal("value is not an integer or out of range")
+			}
+			c.listeningPort = port
+		case "capa":
+		case "ack":
+			// ACK offset [FACK aofoffset], never replied to
+			rep := c.replica
+			if rep == nil {
+				return noReply()
+			}
+			off, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return noReply()
+			}
+			rep.ack.Store(off)
+			if i+3 < len(args) && strings.EqualFold(args[i+2].Bulk, "fack") {
+				if fack, err := strconv.ParseInt(args[i+3].Bulk, 10, 64); err == nil {
+					rep.fack.Store(fack)
+				}
+			}
+			rep.ackTime.Store(time.Now().UnixNano())
+			c.srv.repl.acked.broadcast()
+			return noReply()
+		default:
+			return errVal(fmt.Sprintf("Unrecognized REPLCONF option: %s", args[i].Bulk))
+		}
+	}
+	return ok()
+}
+
+// parseWaitTimeout parses the timeout in milliseconds of WAIT and WAITAOF
+func parseWaitTimeout(arg string) (time.Duration, Value, bool) {
+	ms, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return 0, errVal("timeout is not an integer or out of range"), false
+	}
+	if ms < 0 {
+		return 0, errVal("timeout is negative"), false
+	}
+	return time.Duration(ms) * time.Millisecond, Value{}, true
+}
+
+// WAIT numreplicas timeout, blocks until numreplicas acknowledged the last write of the client
+func WAIT(c *Client, args []Value) Value {
+	if c.srv.repl.link.Load() != nil {
+		return errVal("WAIT cannot be used with replica instances. Please also note that since Redis 4.0 if a replica is configured to be writable (which is not the default) writes to replicas are just local and are not propagated.")
+	}
+	numreplicas, err := strconv.Atoi(args[0].Bulk)
+	if err != nil {
+		return errVal("value is not an integer or out of range")
+	}
+	timeout, errv, ok := parseWaitTimeout(args[1].Bulk)
+	if !ok {
+		return errv
+	}
+
+	r := c.srv.repl
+	r.waitFor(c, timeout, func() bool { return r.countAcks(c.woff, false) >= numreplicas })
+	return intVal(r.countAcks(c.woff, false))
+}
+
+// WAITAOF numlocal numreplicas timeout, blocks until the last write of the client is fsynced to
+// the local AOF, if numlocal is set, and to the AOF of numreplicas replicas
+func WAITAOF(c *Client, args []Value) Value {
+	if c.srv.repl.link.Load() != nil {
+		return errVal("WAITAOF cannot be used with replica instances. Please also note that writes to replicas are just local and are not propagated.")
+	}
+	numlocal, err1 := strconv.Atoi(args[0].Bulk)
+	numreplicas, err2 := strconv.Atoi(args[1].Bulk)
+	if err1 != nil || err2 != nil {
+		return errVal("value is not an integer or out of range")
+	}
+	if numlocal < 0 || numreplicas < 0 {
+		return errVal("value is out of range, must be positive")
+	}
+	timeout, errv, ok := parseWaitTimeout(args[2].Bulk)
+	if !ok {
+		return errv
+	}
+
+	r := c.srv.repl
+	if numlocal > 0 && !r.aofEnabled() {
+		return errVal("WAITAOF cannot be used when numlocal is set but appendonly is disabled.")
+	}
+
+	local := func() int {
+		if r.aofEnabled() && r.aofSynced.Load() >= c.woff {
+			return 1
+		}
+		return 0
+	}
+	if numlocal > 0 {
+		r.requestAOFSync()
+	}
+	r.waitFor(c, timeout, func() bool {
+		return local() >= min(numlocal, 1) && r.countAcks(c.woff, true) >= numreplicas
+	})
+	return Value{Type: "array", Array: []Value{intVal(local()), intVal(r.countAcks(c.woff, true))}}
+}
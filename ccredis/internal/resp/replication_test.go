@@ -0,0 +1,163 @@
+package redis
+
+import (
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// startReplica serves a replica of primary configured by cfg and waits until it is in sync
+func startReplica(t *testing.T, primary *Server, cfg Config) *testNode {
+	n := startTestServer(t, cfg)
+	host, port, err := net.SplitHostPort(primary.Addr())
+	require.NoError(t, err)
+	require.Equal(t, "OK", n.do(t, "REPLICAOF", host, port).String)
+	waitLinkUp(t, n.srv)
+	return n
+}
+
+func waitLinkUp(t *testing.T, srv *Server) {
+	require.Eventually(t, func() bool {
+		l := srv.repl.link.Load()
+		return l != nil && l.state.Load() == linkUp
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func sorted(v Value) []string {
+	s := bulks(v)
+	slices.Sort(s)
+	return s
+}
+
+func TestReplication(t *testing.T) {
+	p := startTestServer(t, Config{})
+	p.do(t, "SET", "before", "sync")
+	p.do(t, "SADD", "set", "a", "b", "c", "d", "e")
+
+	r := startReplica(t, p.srv, Config{})
+	require.Equal(t, "sync", r.do(t, "GET", "before").Bulk)
+
+	p.do(t, "SET", "k", "v")
+	p.do(t, "RPUSH", "list", "1", "2", "3")
+	p.do(t, "SPOP", "set", "2")
+	p.do(t, "MULTI")
+	p.do(t, "INCR", "counter")
+	p.do(t, "INCR", "counter")
+	p.do(t, "EXEC")
+	p.do(t, "DEL", "before")
+	p.do(t, "SET", "ignored", "x", "NX")
+	p.do(t, "SET", "ignored", "y", "NX")
+	require.Equal(t, 1, p.do(t, "WAIT", "1", "5000").Int)
+
+	require.Equal(t, "v", r.do(t, "GET", "k").Bulk)
+	require.Equal(t, []string{"1", "2", "3"}, bulks(r.do(t, "LRANGE", "list", "0", "-1")))
+	require.Equal(t, sorted(p.do(t, "SMEMBERS", "set")), sorted(r.do(t, "SMEMBERS", "set")), "SPOP removes the same members")
+	require.Equal(t, "2", r.do(t, "GET", "counter").Bulk)
+	require.Equal(t, "null", r.do(t, "GET", "before").Type)
+	require.Equal(t, "x", r.do(t, "GET", "ignored").Bulk)
+
+	require.Equal(t, "READONLY You can't write against a read only replica.", r.do(t, "SET", "k", "w").String)
+	require.Contains(t, r.do(t, "INFO", "replication").Bulk, "role:slave\r\n")
+	require.Contains(t, r.do(t, "INFO", "replication").Bulk, "master_link_status:up\r\n")
+	info := p.do(t, "INFO", "replication").Bulk
+	require.Contains(t, info, "role:master\r\nconnected_slaves:1\r\n")
+	require.Regexp(t, `slave0:ip=127\.0\.0\.1,port=\d+,state=online`, info)
+
+	// a dropped replica resyncs and catches up with what it missed
+	p.srv.repl.dropReplicas()
+	p.do(t, "SET", "k", "after-resync")
+	require.Eventually(t, func() bool { return p.srv.repl.countAcks(0, false) == 1 }, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, 1, p.do(t, "WAIT", "1", "5000").Int)
+	require.Equal(t, "after-resync", r.do(t, "GET", "k").Bulk)
+
+	// a promoted replica takes writes and keeps its data
+	require.Equal(t, "OK", r.do(t, "REPLICAOF", "NO", "ONE").String)
+	require.Equal(t, "OK", r.do(t, "SET", "k", "promoted").String)
+	require.Equal(t, "2", r.do(t, "GET", "counter").Bulk)
+	require.Contains(t, r.do(t, "INFO", "replication").Bulk, "role:master\r\n")
+	require.Eventually(t, func() bool { return p.srv.repl.countAcks(0, false) == 0 }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestReplication_ConcurrentWrites(t *testing.T) {
+	p := startTestServer(t, Config{})
+	r := startReplica(t, p.srv, Config{})
+
+	writers := make([]*testNode, 4)
+	done := make(chan struct{})
+	for i := range writers {
+		writers[i] = dialTestServer(t, p.srv)
+		go func(n *testNode) {
+			defer func() { done <- struct{}{} }()
+			for range 200 {
+				if _, err := n.w.Write(Value{Type: "array", Array: cmdArgs("INCR", "counter")}); err != nil {
+					return
+				}
+				if _, err := n.r.Read(); err != nil {
+					return
+				}
+			}
+		}(writers[i])
+	}
+	for range writers {
+		<-done
+	}
+
+	require.Equal(t, 1, p.do(t, "WAIT", "1", "5000").Int)
+	require.Equal(t, "800", r.do(t, "GET", "counter").Bulk)
+}
+
+func TestWait(t *testing.T) {
+	p := startTestServer(t, Config{})
+	require.Equal(t, 0, p.do(t, "WAIT", "0", "0").Int)
+
+	start := time.Now()
+	require.Equal(t, 0, p.do(t, "WAIT", "1", "100").Int)
+	require.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+
+	require.Equal(t, "ERR timeout is negative", p.do(t, "WAIT", "1", "-1").String)
+	p.do(t, "MULTI")
+	require.Equal(t, "ERR Command not allowed inside a transaction", p.do(t, "WAIT", "0", "0").String)
+	p.do(t, "DISCARD")
+
+	r := startReplica(t, p.srv, Config{})
+	p.do(t, "SET", "k", "v")
+	require.Equal(t, 1, p.do(t, "WAIT", "1", "0").Int)
+	require.Contains(t, r.do(t, "WAIT", "1", "0").String, "WAIT cannot be used with replica instances")
+
+	// a blocked WAIT holds up neither other clients nor transactions
+	blocked := dialTestServer(t, p.srv)
+	blocked.w.Write(Value{Type: "array", Array: cmdArgs("WAIT", "2", "500")})
+	other := dialTestServer(t, p.srv)
+	other.do(t, "MULTI")
+	other.do(t, "INCR", "n")
+	require.Equal(t, []Value{intVal(1)}, other.do(t, "EXEC").Array)
+	require.Equal(t, 1, blocked.read(t).Int)
+}
+
+func TestWaitAOF(t *testing.T) {
+	p := startTestServer(t, Config{Dir: t.TempDir()})
+	require.Equal(t, "ERR WAITAOF cannot be used when numlocal is set but appendonly is disabled.", p.do(t, "WAITAOF", "1", "0", "0").String)
+	require.Equal(t, []Value{intVal(0), intVal(0)}, p.do(t, "WAITAOF", "0", "0", "0").Array)
+
+	require.Equal(t, "OK", p.do(t, "CONFIG", "SET", "appendonly", "yes").String)
+	p.do(t, "SET", "k", "v")
+	require.Equal(t, []Value{intVal(1), intVal(0)}, p.do(t, "WAITAOF", "1", "0", "1000").Array)
+
+	// replicas count once their own AOF is fsynced
+	plain := startReplica(t, p.srv, Config{})
+	durable := startTestServer(t, Config{Dir: t.TempDir()})
+	require.Equal(t, "OK", durable.do(t, "CONFIG", "SET", "appendonly", "yes").String)
+	host, port, _ := net.SplitHostPort(p.srv.Addr())
+	durable.do(t, "REPLICAOF", host, port)
+	waitLinkUp(t, durable.srv)
+
+	p.do(t, "SET", "k", "w")
+	require.Equal(t, []Value{intVal(1), intVal(1)}, p.do(t, "WAITAOF", "1", "1", "5000").Array)
+	require.Equal(t, 2, p.do(t, "WAIT", "2", "5000").Int)
+	require.Equal(t, []Value{intVal(1), intVal(1)}, p.do(t, "WAITAOF", "1", "2", "200").Array, "a replica without AOF never fsyncs")
+	require.Equal(t, "w", plain.do(t, "GET", "k").Bulk)
+	require.Equal(t, "w", durable.do(t, "GET", "k").Bulk)
+}
@@ -2,15 +2,20 @@ package redis
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"math"
+	"slices"
 	"strconv"
+	"unsafe"
 )
 
 type Resp struct {
 	reader *bufio.Reader
+	// nesting of the aggregate being read
+	depth int
 }
 
 func NewReader(r io.Reader) *Resp {
@@ -23,97 +28,204 @@ const (
 	ERROR  = '-'
 	STRING = '+'
 	INT    = ':'
+	PUSH   = '>'
+	MAP    = '%'
 )
 
+// Limits of what a peer may send, like proto-max-bulk-len and the inline buffer of Redis.
+// Lengths are not trusted for allocations, memory is only used as the data arrives.
+const (
+	maxBulkLen  = 512 << 20
+	maxLineLen  = 64 << 10
+	maxDepth    = 512
+	maxPrealloc = 64 << 10
+)
+
+// errProtocol is returned for input that isn't valid RESP, the stream can't be read further
+var errProtocol = errors.New("Protocol error")
+
+func protocolErr(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", errProtocol, fmt.Sprintf(format, args...))
+}
+
+// Read reads the next value. Invalid input returns an error wrapping errProtocol, a stream
+// that ends inside a value io.ErrUnexpectedEOF and one that ends between values io.EOF.
 func (r *Resp) Read() (Value, error) {
 	b, err := r.reader.ReadByte()
 	if err != nil {
 		return Value{}, err
 	}
+	v, err := r.readValue(b)
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	return v, err
+}
 
+func (r *Resp) readValue(b byte) (Value, error) {
 	switch b {
 	case ARRAY:
 		return r.readArray()
+	case PUSH:
+		v, err := r.readArray()
+		if v.Type == "array" {
+			v.Type = "push"
+		}
+		return v, err
+	case MAP:
+		return r.readMap()
 	case BULK:
 		return r.readBulk()
+	case STRING:
+		line, err := r.readLine()
+		return Value{Type: "string", String: string(line)}, err
+	case ERROR:
+		line, err := r.readLine()
+		return Value{Type: "error", String: string(line)}, err
+	case INT:
+		n, err := r.readInt()
+		return Value{Type: "integer", Int: n}, err
 	default:
-		return Value{}, errors.New("unknown sign?")
+		return Value{}, protocolErr("unexpected type byte %q", b)
 	}
 }
 
-func (r *Resp) readLine() (n int, buf []byte, err error) {
+// readLine reads up to the next CRLF and returns the line without it. A bare \r inside the
+// line is an error, simple strings and lengths can't contain one.
+func (r *Resp) readLine() ([]byte, error) {
+	var line []byte
 	for {
-		b, err := r.reader.ReadByte()
-		if err != nil {
-			return 0, nil, err
+		chunk, err := r.reader.ReadSlice('\n')
+		if len(line)+len(chunk) > maxLineLen {
+			return nil, protocolErr("too big inline request")
 		}
-
-		n++
-		buf = append(buf, b)
-
-		if len(buf) >= 2 && buf[len(buf)-2] == '\r' {
+		line = append(line, chunk...)
+		if err == nil {
 			break
 		}
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return nil, err
+		}
 	}
-
-	return n - 2, buf[:len(buf)-2], nil
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, protocolErr("expected CRLF")
+	}
+	line = line[:len(line)-2]
+	if bytes.IndexByte(line, '\r') >= 0 {
+		return nil, protocolErr("unexpected CR in line")
+	}
+	return line, nil
 }
 
+// readInt reads a line holding a decimal integer
 func (r *Resp) readInt() (int, error) {
-	_, line, err := r.readLine()
+	line, err := r.readLine()
 	if err != nil {
 		return 0, err
 	}
-	i64, err := strconv.ParseInt(string(line), 10, 64)
+	n, err := strconv.ParseInt(string(line), 10, 64)
 	if err != nil {
-		log.Fatal(err)
+		return 0, protocolErr("invalid integer %q", line)
 	}
-	return int(i64), nil
+	return int(n), nil
 }
 
-func (r *Resp) readArray() (Value, error) {
-	v := Value{
-		Type: "array",
+// readLength reads the length of an aggregate or bulk, what is invalid names the kind in errors
+func (r *Resp) readLength(what string, max int) (int, error) {
+	n, err := r.readInt()
+	if errors.Is(err, errProtocol) || err == nil && (n < -1 || n > max) {
+		return 0, protocolErr("invalid %s length", what)
 	}
+	return n, err
+}
 
-	length, err := r.readInt()
-	if err != nil {
-		fmt.Println("error reading int: ", err)
-		return v, err
+// readElements reads the n values of an aggregate
+func (r *Resp) readElements(n int) ([]Value, error) {
+	if r.depth == maxDepth {
+		return nil, protocolErr("too deeply nested")
 	}
+	r.depth++
+	defer func() { r.depth-- }()
 
-	v.Array = make([]Value, length)
-
-	for i := range length {
-		readval, err := r.Read()
+	vals := make([]Value, 0, min(n, maxPrealloc/64))
+	for range n {
+		b, err := r.reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		v, err := r.readValue(b)
 		if err != nil {
-			fmt.Println("error reading value: ", i, err)
-			return v, err
+			return nil, err
 		}
-		v.Array[i] = readval
+		vals = append(vals, v)
 	}
-
-	return v, nil
+	return vals, nil
 }
 
-func (r *Resp) readBulk() (Value, error) {
-	v := Value{}
-	v.Type = "bulk"
-
-	length, err := r.readInt()
+func (r *Resp) readArray() (Value, error) {
+	n, err := r.readLength("multibulk", math.MaxInt32)
 	if err != nil {
-		return v, err
+		return Value{}, err
+	}
+	if n == -1 {
+		return Value{Type: "null"}, nil
 	}
+	vals, err := r.readElements(n)
+	if err != nil {
+		return Value{}, err
+	}
+	return Value{Type: "array", Array: vals}, nil
+}
 
-	buf := make([]byte, length)
+func (r *Resp) readMap() (Value, error) {
+	n, err := r.readLength("map", math.MaxInt32/2)
+	if err != nil {
+		return Value{}, err
+	}
+	if n < 0 {
+		return Value{}, protocolErr("invalid map length")
+	}
+	vals, err := r.readElements(2 * n)
+	if err != nil {
+		return Value{}, err
+	}
+	return Value{Type: "map", Array: vals}, nil
+}
 
-	_, err = r.reader.Read(buf)
+func (r *Resp) readBulk() (Value, error) {
+	n, err := r.readLength("bulk", maxBulkLen)
 	if err != nil {
-		return v, err
+		return Value{}, err
+	}
+	if n == -1 {
+		return Value{Type: "null"}, nil
 	}
 
-	r.readLine()
+	buf, err := r.readN(n + 2)
+	if err != nil {
+		return Value{}, err
+	}
+	if buf[n] != '\r' || buf[n+1] != '\n' {
+		return Value{}, protocolErr("expected CRLF after bulk of length %d", n)
+	}
+	// nothing else refers to buf, the string shares it instead of copying a big value again
+	return Value{Type: "bulk", Bulk: unsafe.String(unsafe.SliceData(buf), n)}, nil
+}
 
-	v.Bulk = string(buf)
-	return v, nil
+// readN reads exactly n bytes, however short the reads of the underlying reader are. The
+// buffer grows with the data instead of trusting n, a peer announcing a huge bulk has to
+// send it before the memory is used.
+func (r *Resp) readN(n int) ([]byte, error) {
+	buf := make([]byte, 0, min(n, maxPrealloc))
+	for len(buf) < n {
+		if len(buf) == cap(buf) {
+			buf = slices.Grow(buf, min(n-len(buf), cap(buf)))
+		}
+		m, err := r.reader.Read(buf[len(buf):min(n, cap(buf))])
+		buf = buf[:len(buf)+m]
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
 }
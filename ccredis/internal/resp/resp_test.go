@@ -1,8 +1,11 @@
 package redis_test
 
 import (
+	"bytes"
+	"io"
 	"strings"
 	"testing"
+	"testing/iotest"
 
 	redis "github.com/Kostaaa1/redis-clone/internal/resp"
 	"github.com/stretchr/testify/require"
@@ -34,3 +37,173 @@ func TestResp_ReadArray(t *testing.T) {
 	require.Equal(t, value.Array[0].Bulk, "hello")
 	require.Equal(t, value.Array[1].Bulk, "world")
 }
+
+// values covering every type, read back from the encoding in tests
+var sample = []redis.Value{
+	{Type: "array", Array: []redis.Value{
+		{Type: "bulk", Bulk: "SET"},
+		{Type: "bulk", Bulk: "binary\r\n\x00key"},
+		{Type: "bulk", Bulk: ""},
+	}},
+	{Type: "string", String: "OK"},
+	{Type: "error", String: "ERR boom"},
+	{Type: "integer", Int: -42},
+	{Type: "null"},
+	{Type: "bulk", Bulk: strings.Repeat("x", 100_000)},
+	{Type: "push", Array: []redis.Value{{Type: "bulk", Bulk: "message"}, {Type: "array", Array: []redis.Value{}}}},
+	{Type: "map", Array: []redis.Value{{Type: "bulk", Bulk: "k"}, {Type: "integer", Int: 1}}},
+}
+
+func encode(vals ...redis.Value) []byte {
+	var b []byte
+	for _, v := range vals {
+		b = append(b, v.Marshal()...)
+	}
+	return b
+}
+
+func TestResp_ShortReads(t *testing.T) {
+	t.Parallel()
+
+	// a socket returns whatever has arrived, down to a single byte per Read
+	for name, wrap := range map[string]func(io.Reader) io.Reader{
+		"one byte": iotest.OneByteReader,
+		"half":     iotest.HalfReader,
+	} {
+		r := redis.NewReader(wrap(bytes.NewReader(encode(sample...))))
+		for _, want := range sample {
+			v, err := r.Read()
+			require.NoError(t, err, name)
+			require.Equal(t, want, v, name)
+		}
+		_, err := r.Read()
+		require.ErrorIs(t, err, io.EOF, name)
+	}
+}
+
+func TestResp_ReadInvalid(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		in, err string
+	}{
+		{":abc\r\n", "Protocol error: invalid integer"},
+		{"*x\r\n", "Protocol error: invalid multibulk length"},
+		{"*-2\r\n", "Protocol error: invalid multibulk length"},
+		{"$-5\r\n", "Protocol error: invalid bulk length"},
+		{"$1000000000\r\n", "Protocol error: invalid bulk length"},
+		{"%-1\r\n", "Protocol error: invalid map length"},
+		{"$3\r\nabcde\r\n", "Protocol error: expected CRLF after bulk of length 3"},
+		{"+OK\n", "Protocol error: expected CRLF"},
+		{"+O\rK\r\n", "Protocol error: unexpected CR in line"},
+		{"+" + strings.Repeat("x", 100_000) + "\r\n", "Protocol error: too big inline request"},
+		{"!oops\r\n", "Protocol error: unexpected type byte '!'"},
+		{strings.Repeat("*1\r\n", 1000), "Protocol error: too deeply nested"},
+	} {
+		_, err := redis.NewReader(strings.NewReader(tc.in)).Read()
+		require.ErrorContains(t, err, tc.err, tc.in)
+	}
+
+	// a value cut short, a declared length isn't trusted for the allocation
+	for _, in := range []string{"$5\r\nhel", "$5\r\nhello", "$5\r\nhello\r", "*2\r\n$1\r\na\r\n", "+OK", "$400000000\r\nabc", "*100000000\r\n"} {
+		_, err := redis.NewReader(strings.NewReader(in)).Read()
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF, in)
+	}
+}
+
+// FuzzResp_Read feeds arbitrary bytes to the reader, it never panics and every value it
+// accepts reads back the same from its encoding
+func FuzzResp_Read(f *testing.F) {
+	f.Add(encode(sample[:5]...))
+	f.Add([]byte("*2\r\n$3\r\nGET\r\n$1\r\nk\r\n"))
+	f.Add([]byte("%1\r\n+a\r\n:-0\r\n"))
+	f.Add([]byte(">-1\r\n$-1\r\n"))
+	f.Add([]byte("$3\r\nab"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := redis.NewReader(bytes.NewReader(data))
+		for {
+			v, err := r.Read()
+			if err != nil {
+				return
+			}
+			again, err := redis.NewReader(bytes.NewReader(v.Marshal())).Read()
+			require.NoError(t, err)
+			require.Equal(t, v, again)
+		}
+	})
+}
+
+// FuzzResp_RoundTrip marshals values built from the fuzzer's bytes and reads them back one
+// byte at a time
+func FuzzResp_RoundTrip(f *testing.F) {
+	f.Add([]byte{6, 3, 0, 1, 'a', 1, 2, 'b', 'c', 4, 0xff})
+	f.Add([]byte{7, 2, 2, 3, 'O', '\r', 'K', 5, 8, 1})
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var vals []redis.Value
+		for len(data) > 0 {
+			var v redis.Value
+			v, data = genValue(data, 0)
+			vals = append(vals, v)
+		}
+		r := redis.NewReader(iotest.OneByteReader(bytes.NewReader(encode(vals...))))
+		for _, want := range vals {
+			v, err := r.Read()
+			require.NoError(t, err)
+			require.Equal(t, want, v)
+		}
+		_, err := r.Read()
+		require.ErrorIs(t, err, io.EOF)
+	})
+}
+
+// genValue builds a value from the start of data and returns the rest. The first byte picks
+// the type, aggregates nest up to a few levels and simple strings drop CR and LF, which RESP
+// can't carry in them.
+func genValue(data []byte, depth int) (redis.Value, []byte) {
+	next := func() int {
+		if len(data) == 0 {
+			return 0
+		}
+		b := data[0]
+		data = data[1:]
+		return int(b)
+	}
+	text := func() string {
+		n := min(next(), len(data))
+		s := string(data[:n])
+		data = data[n:]
+		return s
+	}
+
+	kind := next() % 8
+	if depth >= 4 && kind >= 5 {
+		kind = 0
+	}
+	var v redis.Value
+	switch kind {
+	case 0:
+		v = redis.Value{Type: "bulk", Bulk: text()}
+	case 1:
+		v = redis.Value{Type: "string", String: strings.NewReplacer("\r", "", "\n", "").Replace(text())}
+	case 2:
+		v = redis.Value{Type: "error", String: strings.NewReplacer("\r", "", "\n", "").Replace(text())}
+	case 3:
+		v = redis.Value{Type: "integer", Int: (next()<<8 | next()) - 1<<15}
+	case 4:
+		v = redis.Value{Type: "null"}
+	default:
+		typ := [...]string{"array", "push", "map"}[kind-5]
+		n := next() % 5
+		if typ == "map" {
+			n *= 2
+		}
+		v = redis.Value{Type: typ, Array: make([]redis.Value, n)}
+		for i := range v.Array {
+			v.Array[i], data = genValue(data, depth+1)
+		}
+	}
+	return v, data
+}
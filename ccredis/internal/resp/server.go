@@ -0,0 +1,645 @@
+package redis
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Config struct {
+	// config file the server was started with, CONFIG REWRITE writes to it, see configfile.go
+	File string
+
+	Bind string
+	// plain TCP port, 0 disables the TCP listener when another one is configured
+	Port int
+
+	// TLS listener, enabled by a non zero TLSPort, see tls.go
+	TLSPort        int
+	TLSCertFile    string
+	TLSKeyFile     string
+	TLSCACertFile  string
+	TLSAuthClients string
+
+	// path of a Unix domain socket to listen on and its permissions, 0 keeps the umask default
+	UnixSocket     string
+	UnixSocketPerm os.FileMode
+
+	ClusterEnabled bool
+	// cluster bus port, defaults to Port+10000
+	ClusterPort int
+
+	// keyspace event classes to publish, see notify.go
+	NotifyKeyspaceEvents string
+
+	// connections beyond MaxClients are refused, defaults to 10000
+	MaxClients int
+	// seconds after which idle clients are disconnected, 0 never disconnects them
+	Timeout int
+	// seconds between TCP keepalive probes, 0 disables keepalive
+	TCPKeepalive int
+
+	// snapshots are written to Dir/DBFilename and loaded from it on startup, see snapshot.go
+	Dir        string
+	DBFilename string
+	// save points, "<seconds> <changes>" pairs like Redis, empty disables automatic saves
+	Save string
+
+	// AppendOnly logs every write to Dir/AppendFilename and loads it on startup instead of
+	// the snapshot, see aof.go. AppendFsync is always, everysec (the default) or no.
+	AppendOnly     bool
+	AppendFilename string
+	AppendFsync    string
+
+	// "<host> <port>" of the primary to replicate on startup, see replication.go
+	ReplicaOf string
+
+	// "<host>:<port>" of the HTTP listener serving Prometheus metrics, empty disables it,
+	// see metrics.go
+	MetricsAddr string
+}
+
+type Server struct {
+	cfg      Config
+	store    *keyspace
+	cluster  *clusterState
+	pubsub   *pubsub
+	tracking *tracking
+	// FUNCTION libraries
+	functions *functions
+	// the function FCALL is running, nil when none is, and the milliseconds it runs before
+	// other clients get BUSY
+	runningFunction    atomic.Pointer[runningFunction]
+	busyReplyThreshold atomic.Int64
+
+	notifyFlags atomic.Int32
+	encLimits   *encodingLimits
+	// index into maxmemoryPolicies
+	maxmemoryPolicy atomic.Int32
+	// values UNLINK and friends handed to the lazyfree worker, see freeAsync
+	lazyfree        chan *RedisItem
+	lazyfreeOnce    sync.Once
+	lazyfreePending atomic.Int64
+
+	maxClients   atomic.Int64
+	timeout      atomic.Int64
+	tcpKeepalive atomic.Int64
+
+	// calls and latencies per command, keys removed because their ttl passed
+	cmdStats    commandStats
+	expiredKeys atomic.Int64
+	// next shard refreshStats walks, only touched by the cron
+	statsCursor int
+
+	// writes since the last successful save
+	dirty            atomic.Int64
+	savePoints       atomic.Pointer[[]savePoint]
+	lastSave         atomic.Int64
+	lastSaveAttempt  atomic.Int64
+	lastSaveOK       atomic.Bool
+	bgsaveInProgress atomic.Bool
+	// serializes snapshot writers so the last one to finish is the newest
+	saveMu sync.Mutex
+
+	// replication stream, replicas, the link to the primary and the AOF
+	repl                 *replication
+	appendFsync          atomic.Int32
+	aofRewriteMu         sync.Mutex
+	aofRewriteInProgress atomic.Bool
+	aofLastWriteOK       atomic.Bool
+	replicaPriority      atomic.Int64
+
+	runID     string
+	startedAt time.Time
+
+	slowlog           *slowlog
+	slowlogSlowerThan atomic.Int64
+	slowlogMaxLen     atomic.Int64
+	latency           *latencyMonitor
+	monitors          *monitors
+	latencyThreshold  atomic.Int64
+
+	// indexes into shutdownModes
+	shutdownOnSigterm atomic.Int32
+	shutdownOnSigint  atomic.Int32
+	shuttingDown      atomic.Bool
+	// connections being served, drained by ListenAndServe after a shutdown
+	conns sync.WaitGroup
+
+	// held shared by every command and exclusively by EXEC, which makes transactions atomic
+	execMu sync.RWMutex
+
+	clientsMu    sync.RWMutex
+	clients      map[int64]*Client
+	nextClientID atomic.Int64
+
+	mu        sync.Mutex
+	listeners []net.Listener
+	done      chan struct{}
+	closed    bool
+	cronOnce  sync.Once
+}
+
+func NewServer(cfg Config) *Server {
+	if cfg.Bind == "" {
+		cfg.Bind = "127.0.0.1"
+	}
+	if cfg.TLSAuthClients == "" {
+		cfg.TLSAuthClients = tlsAuthYes
+	}
+	if cfg.ClusterPort == 0 {
+		cfg.ClusterPort = cfg.Port + 10000
+	}
+	if cfg.MaxClients == 0 {
+		cfg.MaxClients = 10000
+	}
+	if cfg.Dir == "" {
+		cfg.Dir = "."
+	}
+	if cfg.DBFilename == "" {
+		cfg.DBFilename = "dump.ccdb"
+	}
+	if cfg.AppendFilename == "" {
+		cfg.AppendFilename = "appendonly.aof"
+	}
+
+	s := &Server{
+		cfg:       cfg,
+		store:     newKeyspace(defaultShards),
+		encLimits: newEncodingLimits(),
+		pubsub:    newPubsub(),
+		functions: newFunctions(),
+		cmdStats:  newCommandStats(),
+		slowlog:   &slowlog{},
+		latency:   newLatencyMonitor(),
+		monitors:  newMonitors(),
+		clients:   make(map[int64]*Client),
+		done:      make(chan struct{}),
+		runID:     newNodeID(),
+		startedAt: time.Now(),
+	}
+	s.tracking = newTracking(s)
+	s.repl = newReplication(s)
+	s.store.onExpire = func(key string) {
+		s.dirty.Add(1)
+		s.expiredKeys.Add(1)
+		s.repl.feedExpired(key)
+		s.tracking.invalidate(nil, key)
+		s.notify(notifyExpired, "expired", key)
+	}
+	if cfg.ClusterEnabled {
+		s.cluster = newClusterState(s)
+	}
+	if flags, err := parseNotifyFlags(cfg.NotifyKeyspaceEvents); err == nil {
+		s.notifyFlags.Store(int32(flags))
+	}
+	s.maxClients.Store(int64(cfg.MaxClients))
+	s.timeout.Store(int64(cfg.Timeout))
+	s.tcpKeepalive.Store(int64(cfg.TCPKeepalive))
+	s.slowlogSlowerThan.Store(10000)
+	s.busyReplyThreshold.Store(5000)
+	s.slowlogMaxLen.Store(128)
+
+	points, err := parseSavePoints(cfg.Save)
+	if err != nil {
+		points = nil
+	}
+	s.savePoints.Store(&points)
+	s.lastSave.Store(time.Now().UnixNano())
+	s.lastSaveOK.Store(true)
+
+	s.appendFsync.Store(fsyncEverysec)
+	if i := slices.Index(appendFsyncPolicies, strings.ToLower(cfg.AppendFsync)); i >= 0 {
+		s.appendFsync.Store(int32(i))
+	}
+	s.aofLastWriteOK.Store(true)
+	s.replicaPriority.Store(100)
+	return s
+}
+
+func (s *Server) Addr() string {
+	return net.JoinHostPort(s.cfg.Bind, strconv.Itoa(s.cfg.Port))
+}
+
+// ListenAndServe loads the AOF or the snapshot and serves every configured listener until
+// the server is closed or one of them fails. After a Shutdown it returns once every client
+// is gone.
+func (s *Server) ListenAndServe() error {
+	if _, err := parseNotifyFlags(s.cfg.NotifyKeyspaceEvents); err != nil {
+		return fmt.Errorf("invalid notify-keyspace-events %q: %w", s.cfg.NotifyKeyspaceEvents, err)
+	}
+	if s.cfg.AppendOnly {
+		if err := s.loadAOF(); err != nil {
+			return err
+		}
+	} else if err := s.loadSnapshot(); err != nil {
+		return fmt.Errorf("loading %s: %w", s.snapshotPath(), err)
+	}
+
+	if s.cfg.ReplicaOf != "" {
+		host, port, ok := strings.Cut(s.cfg.ReplicaOf, " ")
+		p, err := strconv.Atoi(strings.TrimSpace(port))
+		if !ok || err != nil {
+			return fmt.Errorf("invalid replicaof %q, expected \"<host> <port>\"", s.cfg.ReplicaOf)
+		}
+		s.repl.replicaOf(host, p)
+	}
+
+	lns, err := s.listen()
+	if err != nil {
+		return err
+	}
+
+	if s.cluster != nil {
+		if err := s.cluster.listen(); err != nil {
+			closeListeners(lns)
+			return err
+		}
+	}
+
+	if s.cfg.MetricsAddr != "" {
+		ln, err := net.Listen("tcp", s.cfg.MetricsAddr)
+		if err != nil {
+			closeListeners(lns)
+			return err
+		}
+		fmt.Printf("Metrics served on http://%s/metrics\n", ln.Addr())
+		go func() {
+			if err := s.serveMetrics(ln); err != nil {
+				log.Printf("metrics listener: %v", err)
+			}
+		}()
+	}
+
+	errc := make(chan error, len(lns))
+	for _, ln := range lns {
+		fmt.Printf("Redis server started: %s\n", listenerAddr(ln))
+		go func() { errc <- s.Serve(ln) }()
+	}
+	for range lns {
+		if err := <-errc; err != nil {
+			s.Close()
+			return err
+		}
+	}
+	if s.shuttingDown.Load() {
+		s.conns.Wait()
+	}
+	return nil
+}
+
+// listen opens the TCP, TLS and Unix socket listeners the config asks for
+func (s *Server) listen() ([]net.Listener, error) {
+	var lns []net.Listener
+	fail := func(err error) ([]net.Listener, error) {
+		closeListeners(lns)
+		return nil, err
+	}
+
+	if s.cfg.Port != 0 || s.cfg.TLSPort == 0 && s.cfg.UnixSocket == "" {
+		ln, err := net.Listen("tcp", s.Addr())
+		if err != nil {
+			return fail(err)
+		}
+		lns = append(lns, ln)
+	}
+
+	if s.cfg.TLSPort != 0 {
+		conf, err := s.cfg.tlsConfig()
+		if err != nil {
+			return fail(err)
+		}
+		ln, err := tls.Listen("tcp", net.JoinHostPort(s.cfg.Bind, strconv.Itoa(s.cfg.TLSPort)), conf)
+		if err != nil {
+			return fail(err)
+		}
+		lns = append(lns, ln)
+	}
+
+	if s.cfg.UnixSocket != "" {
+		ln, err := listenUnix(s.cfg.UnixSocket, s.cfg.UnixSocketPerm)
+		if err != nil {
+			return fail(err)
+		}
+		lns = append(lns, ln)
+	}
+	return lns, nil
+}
+
+func closeListeners(lns []net.Listener) {
+	for _, ln := range lns {
+		ln.Close()
+	}
+}
+
+func listenerAddr(ln net.Listener) string {
+	if _, ok := ln.(*net.UnixListener); ok {
+		return "unix:" + ln.Addr().String()
+	}
+	return ln.Addr().String()
+}
+
+// Serve accepts connections on ln, it can be called for several listeners
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		ln.Close()
+		return nil
+	}
+	s.listeners = append(s.listeners, ln)
+	s.mu.Unlock()
+
+	s.cronOnce.Do(func() { go s.cron() })
+
+	var backoff time.Duration
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			// running out of file descriptors and friends are temporary, keep accepting
+			backoff = min(max(2*backoff, 5*time.Millisecond), time.Second)
+			log.Printf("accept error: %v; retrying in %v", err, backoff)
+			time.Sleep(backoff)
+			continue
+		}
+		backoff = 0
+		s.setKeepalive(conn)
+		s.conns.Add(1)
+		go s.handleConn(conn)
+	}
+}
+
+// setKeepalive applies tcp-keepalive to TCP and TLS connections
+func (s *Server) setKeepalive(conn net.Conn) {
+	if tc, ok := conn.(*tls.Conn); ok {
+		conn = tc.NetConn()
+	}
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	secs := s.tcpKeepalive.Load()
+	if secs == 0 {
+		tcp.SetKeepAlive(false)
+		return
+	}
+	tcp.SetKeepAliveConfig(net.KeepAliveConfig{
+		Enable:   true,
+		Idle:     time.Duration(secs) * time.Second,
+		Interval: time.Duration(secs) * time.Second / 3,
+		Count:    3,
+	})
+}
+
+// Close stops accepting connections on every listener and shuts down the cluster bus
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+
+	if s.cluster != nil {
+		s.cluster.close()
+	}
+	s.repl.close()
+	var err error
+	for _, ln := range s.listeners {
+		if cerr := ln.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// cron runs the periodic background tasks until the server is closed
+func (s *Server) cron() {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			start := time.Now()
+			s.store.activeExpire()
+			s.recordLatency(latencyExpireCycle, time.Since(start))
+			s.refreshStats()
+			s.closeIdleClients()
+			s.checkSavePoints()
+			s.repl.cron()
+		}
+	}
+}
+
+// closeIdleClients disconnects clients idle for longer than timeout, subscribers are exempt
+// since they only wait for messages
+func (s *Server) closeIdleClients() {
+	secs := s.timeout.Load()
+	if secs == 0 {
+		return
+	}
+	limit := time.Duration(secs) * time.Second
+
+	// like Redis subscribers, monitors and replicas only ever read, they are never idle
+	s.clientsMu.RLock()
+	idle := make([]*Client, 0)
+	for _, c := range s.clients {
+		if time.Since(time.Unix(0, c.lastInteraction.Load())) > limit && !c.subscribed() &&
+			c.replica == nil && !s.monitors.has(c) {
+			idle = append(idle, c)
+		}
+	}
+	s.clientsMu.RUnlock()
+
+	for _, c := range idle {
+		c.close()
+	}
+}
+
+func (s *Server) handleConn(conn net.Conn) {
+	defer s.conns.Done()
+
+	c := s.newClient(conn)
+	if !s.registerClient(c) {
+		conn.SetWriteDeadline(time.Now().Add(time.Second))
+		conn.Write([]byte("-ERR max number of clients reached\r\n"))
+		conn.Close()
+		return
+	}
+	// a shutdown right before the client was registered didn't see it
+	if s.shuttingDown.Load() {
+		s.unregisterClient(c)
+		conn.Close()
+		return
+	}
+
+	written := make(chan struct{})
+	go func() {
+		c.writeLoop()
+		close(written)
+	}()
+	defer func() {
+		s.unregisterClient(c)
+		s.monitors.remove(c)
+		s.repl.removeReplica(c)
+		s.pubsub.unsubscribeAll(c)
+		s.tracking.disable(c)
+		c.close()
+		<-written
+	}()
+
+	r := NewReader(conn)
+
+	for {
+		v, err := r.Read()
+		if s.shuttingDown.Load() {
+			return
+		}
+		if err != nil {
+			if err == io.EOF {
+				fmt.Println("client closed the connection")
+				return
+			}
+			// like Redis the client learns why it is disconnected
+			if errors.Is(err, errProtocol) {
+				c.write(errVal(err.Error()))
+			}
+			log.Println(err)
+			return
+		}
+
+		if v.Type != "array" {
+			fmt.Println("invalid request, expected array")
+			continue
+		}
+
+		if len(v.Array) == 0 {
+			fmt.Println("invalid request, expected array length > 0")
+			continue
+		}
+
+		c.write(c.dispatch(v.Array))
+	}
+}
+
+var allowedInSubscribeMode = map[string]bool{
+	"SUBSCRIBE":    true,
+	"PSUBSCRIBE":   true,
+	"UNSUBSCRIBE":  true,
+	"PUNSUBSCRIBE": true,
+	"PING":         true,
+}
+
+// dispatch runs a single command, args includes the command name
+func (c *Client) dispatch(args []Value) Value {
+	cmd := strings.ToUpper(args[0].Bulk)
+
+	handler, ok := Handlers[cmd]
+	if !ok {
+		if c.multi != nil {
+			c.multi.dirty = true
+		}
+		return UnknownCmd(cmd, args[1:])
+	}
+
+	c.lastInteraction.Store(time.Now().UnixNano())
+
+	if c.subscribed() && !allowedInSubscribeMode[cmd] {
+		return errVal(fmt.Sprintf("Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context", strings.ToLower(cmd)))
+	}
+
+	if commands[cmd].flags&cmdWrite != 0 && !c.replicated && c.srv.repl.link.Load() != nil {
+		if c.multi != nil {
+			c.multi.dirty = true
+		}
+		return errCode("READONLY", "You can't write against a read only replica.")
+	}
+
+	// the replication link and the AOF wait for the function like they wait for anything else
+	if !c.replicated && c.srv.functionBusy() && !(isFunctionKill(args) || cmd == "SHUTDOWN" && isShutdownNoSave(args)) {
+		return errCode("BUSY", "Redis is busy running a script. You can only call FUNCTION KILL or SHUTDOWN NOSAVE.")
+	}
+
+	if c.multi != nil && commands[cmd].flags&cmdNoMulti != 0 {
+		c.multi.dirty = true
+		return errVal("Command not allowed inside a transaction")
+	}
+
+	if c.multi != nil && !multiControl[cmd] {
+		return c.queue(commands[cmd], args)
+	}
+
+	// EXEC, FCALL and SHUTDOWN take the exclusive lock themselves, FUNCTION KILL stops the
+	// function holding it and blocking commands must not hold up the ones that do, every other
+	// command runs under the shared one
+	if cmd == "EXEC" || cmd == "FCALL" || cmd == "FCALL_RO" || cmd == "SHUTDOWN" || isFunctionKill(args) || commands[cmd].flags&cmdBlocking != 0 {
+		c.srv.monitors.feed(c, args)
+		start := time.Now()
+		res := handler(c, args)
+		c.srv.cmdStats.record(cmd, time.Since(start), res.Type == "error")
+		return res
+	}
+
+	c.srv.execMu.RLock()
+	defer c.srv.execMu.RUnlock()
+
+	return c.call(cmd, handler, args)
+}
+
+// call runs the handler of cmd and updates the per-client state that depends on it
+func (c *Client) call(cmd string, handler HandlerFunc, args []Value) Value {
+	// sending all args, middleware func extracts the command from other arguments (command included)
+	c.srv.monitors.feed(c, args)
+
+	var tracked []string
+	if c.tracking != nil {
+		tracked = c.srv.tracking.beforeRead(c, commands[cmd], args)
+	}
+
+	c.propagating, c.propagated = args, false
+	start := time.Now()
+	res := handler(c, args)
+	d := time.Since(start)
+	c.propagating = nil
+	c.slowlogCommand(args, d)
+	c.srv.recordLatency(latencyCommand, d)
+	c.srv.cmdStats.record(cmd, d, res.Type == "error")
+
+	// with appendfsync always nothing is acknowledged before it is on disk
+	if c.propagated && c.srv.appendFsync.Load() == fsyncAlways {
+		c.srv.repl.waitAOFSynced(c.woff)
+	}
+
+	if cmd != "ASKING" {
+		c.asking = false
+	}
+
+	if tracked != nil {
+		c.srv.tracking.afterRead(c, tracked)
+	}
+	if c.cachingArmed {
+		c.cachingArmed = false
+	} else {
+		c.caching = cachingDefault
+	}
+
+	return res
+}
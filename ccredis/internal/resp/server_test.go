@@ -0,0 +1,77 @@
+package redis
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func freePort(t *testing.T) int {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+type testNode struct {
+	srv  *Server
+	conn net.Conn
+	r    *Resp
+	w    *Writer
+}
+
+// startTestServer serves cfg on free ports and returns a connection to it
+func startTestServer(t *testing.T, cfg Config) *testNode {
+	cfg.Port = freePort(t)
+	if cfg.ClusterEnabled {
+		cfg.ClusterPort = freePort(t)
+	}
+	return dialTestServer(t, serveTestServer(t, NewServer(cfg)))
+}
+
+// serveTestServer serves srv on its configured ports until the test ends
+func serveTestServer(t *testing.T, srv *Server) *Server {
+	ln, err := net.Listen("tcp", srv.Addr())
+	require.NoError(t, err)
+	if srv.cluster != nil {
+		require.NoError(t, srv.cluster.listen())
+	}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+func dialTestServer(t *testing.T, srv *Server) *testNode {
+	conn, err := net.Dial("tcp", srv.Addr())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return &testNode{srv: srv, conn: conn, r: NewReader(conn), w: NewWriter(conn)}
+}
+
+func (n *testNode) do(t *testing.T, args ...string) Value {
+	_, err := n.w.Write(Value{Type: "array", Array: cmdArgs(args...)})
+	require.NoError(t, err)
+	return n.read(t)
+}
+
+func (n *testNode) read(t *testing.T) Value {
+	n.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	v, err := n.r.Read()
+	require.NoError(t, err)
+	return v
+}
+
+func TestServer_ProtocolError(t *testing.T) {
+	n := startTestServer(t, Config{})
+
+	// the client gets the reason before it is disconnected
+	_, err := n.conn.Write([]byte("*1\r\n$4\r\nPINGPONG\r\n"))
+	require.NoError(t, err)
+	require.Equal(t, "ERR Protocol error: expected CRLF after bulk of length 4", n.read(t).String)
+	_, err = n.r.Read()
+	require.ErrorIs(t, err, io.EOF)
+}
@@ -1 +1,314 @@
 package redis
+
+import (
+	"math/rand/v2"
+	"strconv"
+)
+
+// The helpers below work on set items of any encoding. A set starts as an intset while
+// every member is an integer and as a listpack otherwise, and converts to a hashtable
+// once it outgrows the set-max-* limits. An intset that gets a non integer member
+// becomes a listpack if it still fits one.
+
+func newSetItem(member string) *RedisItem {
+	if _, ok := parseCanonicalInt(member); ok {
+		return newItem(REDIS_SET, &intset{})
+	}
+	return newItem(REDIS_SET, &listpack{})
+}
+
+func setAdd(l *encodingLimits, item *RedisItem, member string) bool {
+	switch s := item.value.(type) {
+	case *intset:
+		if n, ok := parseCanonicalInt(member); ok {
+			if len(s.members) < int(l.setMaxIntsetEntries.Load()) {
+				return s.add(n)
+			}
+			if _, found := s.find(n); found {
+				return false
+			}
+			setToHashtable(item)
+			break
+		}
+		if len(s.members) < int(l.setMaxListpackEntries.Load()) && len(member) <= int(l.setMaxListpackValue.Load()) {
+			item.value = &listpack{entries: append(s.strings(), member)}
+			return true
+		}
+		setToHashtable(item)
+	case *listpack:
+		if s.index(member) >= 0 {
+			return false
+		}
+		if len(s.entries) < int(l.setMaxListpackEntries.Load()) && len(member) <= int(l.setMaxListpackValue.Load()) {
+			s.entries = append(s.entries, member)
+			return true
+		}
+		setToHashtable(item)
+	}
+
+	s := item.value.(map[string]struct{})
+	if _, ok := s[member]; ok {
+		return false
+	}
+	s[member] = struct{}{}
+	return true
+}
+
+func setToHashtable(item *RedisItem) {
+	members := setMembers(item)
+	s := make(map[string]struct{}, len(members)+1)
+	for _, m := range members {
+		s[m] = struct{}{}
+	}
+	item.value = s
+}
+
+func setRem(item *RedisItem, member string) bool {
+	switch s := item.value.(type) {
+	case *intset:
+		if n, ok := parseCanonicalInt(member); ok {
+			return s.remove(n)
+		}
+	case *listpack:
+		if i := s.index(member); i >= 0 {
+			s.entries = append(s.entries[:i], s.entries[i+1:]...)
+			return true
+		}
+	case map[string]struct{}:
+		if _, ok := s[member]; ok {
+			delete(s, member)
+			return true
+		}
+	}
+	return false
+}
+
+func setHas(item *RedisItem, member string) bool {
+	switch s := item.value.(type) {
+	case *intset:
+		if n, ok := parseCanonicalInt(member); ok {
+			_, found := s.find(n)
+			return found
+		}
+	case *listpack:
+		return s.index(member) >= 0
+	case map[string]struct{}:
+		_, ok := s[member]
+		return ok
+	}
+	return false
+}
+
+func setLen(item *RedisItem) int {
+	switch s := item.value.(type) {
+	case *intset:
+		return len(s.members)
+	case *listpack:
+		return len(s.entries)
+	case map[string]struct{}:
+		return len(s)
+	}
+	return 0
+}
+
+func setMembers(item *RedisItem) []string {
+	switch s := item.value.(type) {
+	case *intset:
+		return s.strings()
+	case *listpack:
+		return append([]string(nil), s.entries...)
+	case map[string]struct{}:
+		members := make([]string, 0, len(s))
+		for m := range s {
+			members = append(members, m)
+		}
+		return members
+	}
+	return nil
+}
+
+// setRandom returns a random member of a non empty set
+func setRandom(item *RedisItem) string {
+	switch s := item.value.(type) {
+	case *intset:
+		return strconv.FormatInt(s.members[rand.IntN(len(s.members))], 10)
+	case *listpack:
+		return s.entries[rand.IntN(len(s.entries))]
+	case map[string]struct{}:
+		// map iteration starts at a random position
+		for m := range s {
+			return m
+		}
+	}
+	return ""
+}
+
+// SADD key member [member ...]
+func SADD(c *Client, args []Value) Value {
+	key := args[0].Bulk
+	defer c.store.lock(key)()
+
+	item, ok := c.store.lookup(key)
+	if ok && item.itemType != REDIS_SET {
+		return errWrongType()
+	}
+	if !ok {
+		item = newSetItem(args[1].Bulk)
+		c.store.set(key, item)
+		c.srv.notify(notifyNew, "new", key)
+	}
+
+	added := 0
+	for _, m := range args[1:] {
+		if setAdd(c.srv.encLimits, item, m.Bulk) {
+			added++
+		}
+	}
+	if added > 0 {
+		c.signalModifiedKey(key)
+		c.srv.notify(notifySet, "sadd", key)
+	}
+	return intVal(added)
+}
+
+// SREM key member [member ...]
+func SREM(c *Client, args []Value) Value {
+	key := args[0].Bulk
+	defer c.store.lock(key)()
+
+	item, ok := c.store.lookup(key)
+	if !ok {
+		return intVal(0)
+	}
+	if item.itemType != REDIS_SET {
+		return errWrongType()
+	}
+
+	n := 0
+	for _, m := range args[1:] {
+		if setRem(item, m.Bulk) {
+			n++
+		}
+	}
+	if n > 0 {
+		c.signalModifiedKey(key)
+		c.srv.notify(notifySet, "srem", key)
+		if setLen(item) == 0 {
+			c.store.del(key)
+			c.srv.notify(notifyGeneric, "del", key)
+		}
+	}
+	return intVal(n)
+}
+
+// SISMEMBER key member
+func SISMEMBER(c *Client, args []Value) Value {
+	key := args[0].Bulk
+	defer c.store.rlock(key)()
+
+	item, ok := c.store.lookupRead(key)
+	if !ok {
+		return intVal(0)
+	}
+	if item.itemType != REDIS_SET {
+		return errWrongType()
+	}
+	if setHas(item, args[1].Bulk) {
+		return intVal(1)
+	}
+	return intVal(0)
+}
+
+// SMEMBERS key
+func SMEMBERS(c *Client, args []Value) Value {
+	key := args[0].Bulk
+	defer c.store.rlock(key)()
+
+	res := Value{Type: "array", Array: []Value{}}
+	item, ok := c.store.lookupRead(key)
+	if !ok {
+		return res
+	}
+	if item.itemType != REDIS_SET {
+		return errWrongType()
+	}
+	for _, m := range setMembers(item) {
+		res.Array = append(res.Array, bulkVal(m))
+	}
+	return res
+}
+
+// SCARD key
+func SCARD(c *Client, args []Value) Value {
+	key := args[0].Bulk
+	defer c.store.rlock(key)()
+
+	item, ok := c.store.lookupRead(key)
+	if !ok {
+		return intVal(0)
+	}
+	if item.itemType != REDIS_SET {
+		return errWrongType()
+	}
+	return intVal(setLen(item))
+}
+
+// SPOP key [count]
+func SPOP(c *Client, args []Value) Value {
+	if len(args) > 2 {
+		return syntaxErr()
+	}
+	key := args[0].Bulk
+	count := -1
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1].Bulk)
+		if err != nil || n < 0 {
+			return errVal("value is out of range, must be positive")
+		}
+		count = n
+	}
+
+	defer c.store.lock(key)()
+
+	item, ok := c.store.lookup(key)
+	if !ok {
+		if count >= 0 {
+			return Value{Type: "array", Array: []Value{}}
+		}
+		return nullVal()
+	}
+	if item.itemType != REDIS_SET {
+		return errWrongType()
+	}
+
+	n := count
+	if count < 0 {
+		n = 1
+	}
+	popped := make([]Value, 0, min(n, setLen(item)))
+	for len(popped) < n && setLen(item) > 0 {
+		m := setRandom(item)
+		setRem(item, m)
+		popped = append(popped, bulkVal(m))
+	}
+
+	if len(popped) > 0 {
+		// the members are random, replicas remove the same ones
+		rem := []string{"SREM", key}
+		for _, m := range popped {
+			rem = append(rem, m.Bulk)
+		}
+		c.replicateAs(rem...)
+		c.signalModifiedKey(key)
+		c.srv.notify(notifySet, "spop", key)
+		if setLen(item) == 0 {
+			c.store.del(key)
+			c.srv.notify(notifyGeneric, "del", key)
+		}
+	}
+
+	if count < 0 {
+		return popped[0]
+	}
+	return Value{Type: "array", Array: popped}
+}
@@ -0,0 +1,127 @@
+package redis
+
+import (
+	"errors"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// shutdown-on-sigterm and shutdown-on-sigint values, default saves only when save points are configured
+var shutdownModes = []string{"default", "save", "nosave"}
+
+const (
+	shutdownDefault = iota
+	shutdownSave
+	shutdownNoSave
+)
+
+// time connections get to receive the replies still queued for them once the server shuts down
+const shutdownFlushTimeout = time.Second
+
+// Shutdown waits for the commands in flight, optionally saves a snapshot, stops accepting
+// connections and disconnects every client once its pending replies are written. If saving
+// fails the server keeps running and the error is returned.
+func (s *Server) Shutdown(save bool) error {
+	// a running function holds execMu, one that wrote nothing is killed like FUNCTION KILL
+	// would, without saving nothing is left to keep consistent and it is killed anyway
+	s.killFunction(!save)
+	s.execMu.Lock()
+	defer s.execMu.Unlock()
+
+	s.mu.Lock()
+	closed := s.closed
+	s.mu.Unlock()
+	if closed {
+		return nil
+	}
+
+	if save {
+		if err := s.save(false); err != nil {
+			return err
+		}
+	}
+
+	log.Println("shutting down, no longer accepting connections")
+	s.shuttingDown.Store(true)
+	s.Close()
+
+	// unblock every connection reader, handleConn sees the server is done and disconnects
+	now := time.Now()
+	s.clientsMu.RLock()
+	for _, c := range s.clients {
+		c.conn.SetReadDeadline(now)
+		c.conn.SetWriteDeadline(now.Add(shutdownFlushTimeout))
+	}
+	s.clientsMu.RUnlock()
+	return nil
+}
+
+// ShutdownOnSignal shuts the server down the way shutdown-on-sigterm or shutdown-on-sigint say
+func (s *Server) ShutdownOnSignal(sig os.Signal) error {
+	mode := s.shutdownOnSigint.Load()
+	if sig == syscall.SIGTERM {
+		mode = s.shutdownOnSigterm.Load()
+	}
+	log.Printf("received %v, scheduling shutdown", sig)
+	return s.Shutdown(s.shutdownSaves(int(mode)))
+}
+
+func (s *Server) shutdownSaves(mode int) bool {
+	switch mode {
+	case shutdownSave:
+		return true
+	case shutdownNoSave:
+		return false
+	default:
+		return len(*s.savePoints.Load()) > 0
+	}
+}
+
+func shutdownModeParam(name string, field func(s *Server) *atomic.Int32) *configParam {
+	return &configParam{
+		name: name,
+		get:  func(s *Server) string { return shutdownModes[field(s).Load()] },
+		set: func(s *Server, v string) error {
+			i := slices.Index(shutdownModes, strings.ToLower(v))
+			if i < 0 {
+				return errors.New("argument(s) must be one of the following: " + strings.Join(shutdownModes, ", "))
+			}
+			field(s).Store(int32(i))
+			return nil
+		},
+	}
+}
+
+// isShutdownNoSave reports whether args is SHUTDOWN NOSAVE, which is allowed while a function is busy
+func isShutdownNoSave(args []Value) bool {
+	return len(args) == 2 && strings.EqualFold(args[1].Bulk, "NOSAVE")
+}
+
+// SHUTDOWN [NOSAVE|SAVE], runs outside of the shared exec lock since it waits for every other command
+func SHUTDOWN(c *Client, args []Value) Value {
+	mode := shutdownDefault
+	for _, arg := range args {
+		switch strings.ToUpper(arg.Bulk) {
+		case "SAVE":
+			mode = shutdownSave
+		case "NOSAVE":
+			mode = shutdownNoSave
+		default:
+			return syntaxErr()
+		}
+	}
+	if len(args) > 1 {
+		return syntaxErr()
+	}
+
+	if err := c.srv.Shutdown(c.srv.shutdownSaves(mode)); err != nil {
+		return errVal("Errors trying to SHUTDOWN. Check logs.")
+	}
+	// like Redis the connection is closed without a reply
+	return noReply()
+}
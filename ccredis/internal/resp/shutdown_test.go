@@ -0,0 +1,139 @@
+package redis
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// requireClosed asserts the server closed conn without sending anything
+func requireClosed(t *testing.T, conn net.Conn) {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err := NewReader(conn).Read()
+	require.Error(t, err)
+	require.False(t, os.IsTimeout(err), "connection is still open")
+}
+
+func TestShutdown_Command(t *testing.T) {
+	for _, tc := range []struct {
+		args  []string
+		saved bool
+	}{
+		{args: []string{"SHUTDOWN", "NOSAVE"}},
+		{args: []string{"SHUTDOWN", "SAVE"}, saved: true},
+		{args: []string{"SHUTDOWN"}},
+	} {
+		dir := t.TempDir()
+		srv := NewServer(Config{Port: freePort(t), Dir: dir})
+		listenAndServe(t, srv)
+		waitListening(t, "tcp", srv.Addr())
+		n := dialTestServer(t, srv)
+		other := dialTestServer(t, srv)
+
+		n.do(t, "SET", "k", "v")
+		require.Equal(t, "error", n.do(t, "SHUTDOWN", "SAVE", "NOSAVE").Type)
+
+		_, err := n.w.Write(Value{Type: "array", Array: cmdArgs(tc.args...)})
+		require.NoError(t, err)
+		requireClosed(t, n.conn)
+		requireClosed(t, other.conn)
+
+		_, err = net.Dial("tcp", srv.Addr())
+		require.Error(t, err, "%v still accepts connections", tc.args)
+		if tc.saved {
+			require.FileExists(t, filepath.Join(dir, "dump.ccdb"))
+		} else {
+			require.NoFileExists(t, filepath.Join(dir, "dump.ccdb"))
+		}
+	}
+}
+
+func TestShutdown_DefaultSavesWithSavePoints(t *testing.T) {
+	dir := t.TempDir()
+	srv := NewServer(Config{Port: freePort(t), Dir: dir, Save: "3600 1"})
+	n := dialTestServer(t, serveTestServer(t, srv))
+	n.do(t, "SET", "k", "v")
+
+	require.Equal(t, "OK", n.do(t, "CONFIG", "SET", "shutdown-on-sigterm", "nosave").String)
+	require.NoError(t, srv.ShutdownOnSignal(syscall.SIGTERM))
+	require.NoFileExists(t, filepath.Join(dir, "dump.ccdb"))
+
+	srv = NewServer(Config{Port: freePort(t), Dir: dir, Save: "3600 1"})
+	n = dialTestServer(t, serveTestServer(t, srv))
+	n.do(t, "SET", "k", "v")
+	require.NoError(t, srv.ShutdownOnSignal(syscall.SIGINT))
+	require.FileExists(t, filepath.Join(dir, "dump.ccdb"))
+}
+
+func TestShutdown_FailedSaveKeepsRunning(t *testing.T) {
+	n := startTestServer(t, Config{Dir: filepath.Join(t.TempDir(), "missing")})
+
+	require.Equal(t, "ERR Errors trying to SHUTDOWN. Check logs.", n.do(t, "SHUTDOWN", "SAVE").String)
+	require.Equal(t, "PONG", n.do(t, "PING").String)
+}
+
+func TestShutdown_WaitsForInflightCommands(t *testing.T) {
+	n := startTestServer(t, Config{})
+	other := dialTestServer(t, n.srv)
+	multi := dialTestServer(t, n.srv)
+
+	require.Equal(t, "OK", multi.do(t, "MULTI").String)
+	require.Equal(t, "Command not allowed inside a transaction", multi.do(t, "SHUTDOWN").String[len("ERR "):])
+	require.Equal(t, "EXECABORT", multi.do(t, "EXEC").String[:len("EXECABORT")])
+
+	_, err := n.w.Write(Value{Type: "array", Array: cmdArgs("DEBUG", "SLEEP", "0.3")})
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	require.NoError(t, n.srv.Shutdown(false))
+	require.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+
+	require.Equal(t, "OK", n.read(t).String, "the reply of the command in flight is delivered")
+	requireClosed(t, n.conn)
+	requireClosed(t, other.conn)
+}
+
+func TestServer_MaxClients(t *testing.T) {
+	n := startTestServer(t, Config{MaxClients: 2})
+	dialTestServer(t, n.srv).do(t, "PING")
+
+	conn, err := net.Dial("tcp", n.srv.Addr())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	v, err := NewReader(conn).Read()
+	require.NoError(t, err)
+	require.Equal(t, "ERR max number of clients reached", v.String)
+	requireClosed(t, conn)
+
+	require.Equal(t, "OK", n.do(t, "CONFIG", "SET", "maxclients", "3").String)
+	require.Equal(t, "PONG", dialTestServer(t, n.srv).do(t, "PING").String)
+}
+
+func TestServer_IdleTimeout(t *testing.T) {
+	n := startTestServer(t, Config{Timeout: 1})
+	sub := dialTestServer(t, n.srv)
+	sub.do(t, "SUBSCRIBE", "ch")
+	mon := dialTestServer(t, n.srv)
+	require.Equal(t, "OK", mon.do(t, "MONITOR").String)
+
+	active := dialTestServer(t, n.srv)
+	for range 6 {
+		require.Equal(t, "PONG", active.do(t, "PING").String)
+		time.Sleep(250 * time.Millisecond)
+	}
+
+	requireClosed(t, n.conn)
+	require.Equal(t, 1, active.do(t, "PUBLISH", "ch", "still here").Int, "subscribers are never idle")
+	require.Equal(t, "still here", sub.read(t).Array[2].Bulk)
+	for line := ""; !strings.Contains(line, `"still here"`); {
+		line = mon.read(t).String
+	}
+}
@@ -0,0 +1,148 @@
+package redis
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// like Redis long commands are shortened before being kept in the slowlog
+const (
+	slowlogMaxArgc   = 32
+	slowlogMaxArgLen = 128
+)
+
+type slowlogEntry struct {
+	id       int64
+	time     time.Time
+	duration time.Duration
+	args     []string
+	addr     string
+	name     string
+}
+
+// slowlog keeps the most recent commands that ran longer than slowlog-log-slower-than,
+// oldest first
+type slowlog struct {
+	mu      sync.Mutex
+	entries []slowlogEntry
+	nextID  int64
+}
+
+func (l *slowlog) add(e slowlogEntry, maxLen int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e.id = l.nextID
+	l.nextID++
+	l.entries = append(l.entries, e)
+	if over := len(l.entries) - maxLen; over > 0 {
+		l.entries = slices.Delete(l.entries, 0, over)
+	}
+}
+
+// slowlogArgs copies args the way the slowlog shows them
+func slowlogArgs(args []Value) []string {
+	n := min(len(args), slowlogMaxArgc)
+	out := make([]string, n)
+	for i := range n {
+		if i == slowlogMaxArgc-1 && len(args) > slowlogMaxArgc {
+			out[i] = fmt.Sprintf("... (%d more arguments)", len(args)-slowlogMaxArgc+1)
+			break
+		}
+		arg := args[i].Bulk
+		if len(arg) > slowlogMaxArgLen {
+			arg = fmt.Sprintf("%s... (%d more bytes)", arg[:slowlogMaxArgLen], len(arg)-slowlogMaxArgLen)
+		}
+		out[i] = arg
+	}
+	return out
+}
+
+// slowlogCommand records the command in args if it took longer than slowlog-log-slower-than
+func (c *Client) slowlogCommand(args []Value, d time.Duration) {
+	threshold := c.srv.slowlogSlowerThan.Load()
+	if threshold < 0 || d < time.Duration(threshold)*time.Microsecond {
+		return
+	}
+
+	c.srv.clientsMu.RLock()
+	name := c.name
+	c.srv.clientsMu.RUnlock()
+
+	c.srv.slowlog.add(slowlogEntry{
+		time:     time.Now(),
+		duration: d,
+		args:     slowlogArgs(args),
+		addr:     c.addr(),
+		name:     name,
+	}, int(c.srv.slowlogMaxLen.Load()))
+}
+
+// SLOWLOG GET [count] | LEN | RESET
+func SLOWLOG(c *Client, args []Value) Value {
+	l := c.srv.slowlog
+	switch strings.ToUpper(args[0].Bulk) {
+	case "HELP":
+		return helpVal("SLOWLOG",
+			"GET [<count>]",
+			"    Return top <count> entries from the slowlog (default: 10, -1 mean all).",
+			"    Entries are made of:",
+			"    id, timestamp, time in microseconds, arguments array, client IP and port,",
+			"    client name",
+			"LEN",
+			"    Return the length of the slowlog.",
+			"RESET",
+			"    Reset the slowlog.",
+		)
+	case "GET":
+		if len(args) > 2 {
+			return errWrongArgs("slowlog|get")
+		}
+		count := 10
+		if len(args) == 2 {
+			n, err := strconv.Atoi(args[1].Bulk)
+			if err != nil || n < -1 {
+				return errVal("count should be greater than or equal to -1")
+			}
+			count = n
+		}
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if count == -1 || count > len(l.entries) {
+			count = len(l.entries)
+		}
+		res := Value{Type: "array", Array: make([]Value, 0, count)}
+		for i := range count {
+			e := l.entries[len(l.entries)-1-i]
+			argv := Value{Type: "array", Array: make([]Value, len(e.args))}
+			for j, arg := range e.args {
+				argv.Array[j] = bulkVal(arg)
+			}
+			res.Array = append(res.Array, Value{Type: "array", Array: []Value{
+				intVal(int(e.id)),
+				intVal(int(e.time.Unix())),
+				intVal(int(e.duration.Microseconds())),
+				argv,
+				bulkVal(e.addr),
+				bulkVal(e.name),
+			}})
+		}
+		return res
+	case "LEN":
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return intVal(len(l.entries))
+	case "RESET":
+		l.mu.Lock()
+		l.entries = nil
+		l.mu.Unlock()
+		return ok()
+	default:
+		return errVal(fmt.Sprintf("unknown subcommand or wrong number of arguments for '%s'. Try SLOWLOG HELP.", args[0].Bulk))
+	}
+}
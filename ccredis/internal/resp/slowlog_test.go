@@ -0,0 +1,84 @@
+package redis
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlowlog(t *testing.T) {
+	n := startTestServer(t, Config{})
+
+	require.Equal(t, "OK", n.do(t, "CONFIG", "SET", "slowlog-log-slower-than", "20000").String)
+	n.do(t, "CLIENT", "SETNAME", "sleeper")
+	n.do(t, "SET", "fast", "v")
+	n.do(t, "DEBUG", "SLEEP", "0.03")
+	require.Equal(t, 1, n.do(t, "SLOWLOG", "LEN").Int)
+
+	entries := n.do(t, "SLOWLOG", "GET").Array
+	require.Len(t, entries, 1)
+	e := entries[0].Array
+	require.Equal(t, 0, e[0].Int)
+	require.InDelta(t, time.Now().Unix(), e[1].Int, 1)
+	require.GreaterOrEqual(t, e[2].Int, 30000)
+	require.Equal(t, []string{"DEBUG", "SLEEP", "0.03"}, bulks(e[3]))
+	require.Equal(t, n.conn.LocalAddr().String(), e[4].Bulk)
+	require.Equal(t, "sleeper", e[5].Bulk)
+
+	// 0 logs every command, long arguments are shortened
+	require.Equal(t, "OK", n.do(t, "CONFIG", "SET", "slowlog-log-slower-than", "0").String)
+	require.Equal(t, "OK", n.do(t, "CONFIG", "SET", "slowlog-max-len", "3").String)
+	args := []string{"RPUSH", "list", strings.Repeat("x", 200)}
+	for range 40 {
+		args = append(args, "e")
+	}
+	n.do(t, args...)
+	argv := bulks(n.do(t, "SLOWLOG", "GET", "1").Array[0].Array[3])
+	require.Len(t, argv, slowlogMaxArgc)
+	require.Equal(t, strings.Repeat("x", 128)+"... (72 more bytes)", argv[2])
+	require.Equal(t, "... (12 more arguments)", argv[31])
+
+	for range 5 {
+		n.do(t, "PING")
+	}
+	entries = n.do(t, "SLOWLOG", "GET", "-1").Array
+	require.Len(t, entries, 3)
+	require.Greater(t, entries[0].Array[0].Int, entries[2].Array[0].Int, "newest entries come first")
+
+	require.Equal(t, "OK", n.do(t, "CONFIG", "SET", "slowlog-log-slower-than", "-1").String)
+	require.Equal(t, "OK", n.do(t, "SLOWLOG", "RESET").String)
+	n.do(t, "PING")
+	require.Equal(t, 0, n.do(t, "SLOWLOG", "LEN").Int)
+	require.Equal(t, "ERR count should be greater than or equal to -1", n.do(t, "SLOWLOG", "GET", "-2").String)
+}
+
+func TestLatency(t *testing.T) {
+	n := startTestServer(t, Config{})
+
+	n.do(t, "DEBUG", "SLEEP", "0.02")
+	require.Empty(t, n.do(t, "LATENCY", "LATEST").Array, "the monitor is disabled by default")
+
+	require.Equal(t, "OK", n.do(t, "CONFIG", "SET", "latency-monitor-threshold", "10").String)
+	n.do(t, "DEBUG", "SLEEP", "0.02")
+	n.do(t, "DEBUG", "SLEEP", "0.04")
+	n.do(t, "PING")
+
+	latest := n.do(t, "LATENCY", "LATEST").Array
+	require.Len(t, latest, 1)
+	require.Equal(t, "command", latest[0].Array[0].Bulk)
+	require.InDelta(t, time.Now().Unix(), latest[0].Array[1].Int, 1)
+	require.GreaterOrEqual(t, latest[0].Array[3].Int, 40)
+
+	history := n.do(t, "LATENCY", "HISTORY", "command").Array
+	require.NotEmpty(t, history)
+	require.LessOrEqual(t, len(history), 2, "samples of the same second are merged")
+	require.Empty(t, n.do(t, "LATENCY", "HISTORY", "expire-cycle").Array)
+
+	n.srv.recordLatency(latencySave, 15*time.Millisecond)
+	require.Len(t, n.do(t, "LATENCY", "LATEST").Array, 2)
+	require.Equal(t, 1, n.do(t, "LATENCY", "RESET", "fork-less-save", "unknown").Int)
+	require.Equal(t, 1, n.do(t, "LATENCY", "RESET").Int)
+	require.Empty(t, n.do(t, "LATENCY", "LATEST").Array)
+}
@@ -0,0 +1,379 @@
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Snapshots store the keyspace in a file of dir named dbfilename:
+//
+//	"CCREDIS" | version (uint16 LE) | records | 0xff | CRC-64 of everything before (uint64 LE)
+//
+// A record is 0x01, the key and its DUMP payload as uvarint prefixed strings, and the
+// expire time in unix milliseconds (int64 LE, 0 without ttl), or 0x02 and the code of a
+// function library as a uvarint prefixed string. Libraries come before the keys. The file
+// is written to a temporary file and renamed over the previous snapshot, so it is never
+// seen half written.
+const (
+	snapshotMagic     = "CCREDIS"
+	snapshotVersion   = 2
+	snapshotOpKey     = 0x01
+	snapshotOpLibrary = 0x02
+	snapshotOpEOF     = 0xff
+)
+
+var errSnapshotFormat = errors.New("snapshot is corrupted")
+
+// savePoint triggers a background save once changes writes happened within seconds
+type savePoint struct {
+	seconds int
+	changes int
+}
+
+// parseSavePoints parses the save parameter, "<seconds> <changes>" pairs, "" disables saving
+func parseSavePoints(v string) ([]savePoint, error) {
+	fields := strings.Fields(v)
+	if len(fields)%2 != 0 {
+		return nil, errors.New("Invalid save parameters")
+	}
+	points := make([]savePoint, 0, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		secs, err1 := strconv.Atoi(fields[i])
+		changes, err2 := strconv.Atoi(fields[i+1])
+		if err1 != nil || err2 != nil || secs < 1 || changes < 0 {
+			return nil, errors.New("Invalid save parameters")
+		}
+		points = append(points, savePoint{seconds: secs, changes: changes})
+	}
+	return points, nil
+}
+
+func formatSavePoints(points []savePoint) string {
+	parts := make([]string, 0, 2*len(points))
+	for _, p := range points {
+		parts = append(parts, strconv.Itoa(p.seconds), strconv.Itoa(p.changes))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (s *Server) snapshotPath() string {
+	return filepath.Join(s.cfg.Dir, s.cfg.DBFilename)
+}
+
+// snapshotLocking is how writeSnapshot keeps the keys it reads from changing
+type snapshotLocking int
+
+const (
+	// read-locks the whole keyspace for the duration of the snapshot
+	snapshotConsistent snapshotLocking = iota
+	// the caller holds rlockAll or lockAll already
+	snapshotLocked
+)
+
+// writeSnapshot writes every function library and key to w
+func (s *Server) writeSnapshot(w io.Writer, locking snapshotLocking) error {
+	crc := crc64.New(crcTable)
+	bw := bufio.NewWriter(io.MultiWriter(w, crc))
+
+	var scratch []byte
+	writeStr := func(b []byte) {
+		scratch = binary.AppendUvarint(scratch[:0], uint64(len(b)))
+		bw.Write(scratch)
+		bw.Write(b)
+	}
+	writeShard := func(sh *shard) {
+		for key, item := range sh.items {
+			if isExpired(item.ttl) {
+				continue
+			}
+			bw.WriteByte(snapshotOpKey)
+			writeStr([]byte(key))
+			writeStr(dumpValue(item))
+			var expireAt int64
+			if !item.ttl.IsZero() {
+				expireAt = item.ttl.UnixMilli()
+			}
+			scratch = binary.LittleEndian.AppendUint64(scratch[:0], uint64(expireAt))
+			bw.Write(scratch)
+		}
+	}
+
+	bw.WriteString(snapshotMagic)
+	bw.Write(binary.LittleEndian.AppendUint16(nil, snapshotVersion))
+	for _, code := range s.functions.codes() {
+		bw.WriteByte(snapshotOpLibrary)
+		writeStr([]byte(code))
+	}
+	switch locking {
+	case snapshotConsistent:
+		unlock := s.store.rlockAll()
+		for _, sh := range s.store.shards {
+			writeShard(sh)
+		}
+		unlock()
+	default:
+		for _, sh := range s.store.shards {
+			writeShard(sh)
+		}
+	}
+	bw.WriteByte(snapshotOpEOF)
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	_, err := w.Write(binary.LittleEndian.AppendUint64(nil, crc.Sum64()))
+	return err
+}
+
+// save writes a snapshot and records the outcome for LASTSAVE and the save points. The
+// caller of a foreground save holds execMu, like SAVE and SHUTDOWN do, so no transaction
+// or function is halfway through. A background save takes it itself, only while it copies
+// the keyspace to memory, and writes the file after letting writers go on.
+func (s *Server) save(background bool) error {
+	if background {
+		// taken before saveMu, in the order of SAVE and SHUTDOWN
+		s.execMu.RLock()
+	}
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	dirty := s.dirty.Load()
+	start := time.Now()
+
+	var err error
+	if background {
+		var buf bytes.Buffer
+		err = s.writeSnapshot(&buf, snapshotConsistent)
+		s.execMu.RUnlock()
+		if err == nil {
+			err = s.saveFile(func(w io.Writer) error {
+				_, err := buf.WriteTo(w)
+				return err
+			})
+		}
+	} else {
+		err = s.saveFile(func(w io.Writer) error { return s.writeSnapshot(w, snapshotConsistent) })
+	}
+	s.recordLatency(latencySave, time.Since(start))
+	s.lastSaveAttempt.Store(start.UnixNano())
+	s.lastSaveOK.Store(err == nil)
+	if err != nil {
+		log.Printf("error saving snapshot: %v", err)
+		return err
+	}
+	s.dirty.Add(-dirty)
+	s.lastSave.Store(start.UnixNano())
+	return nil
+}
+
+// saveFile replaces the snapshot file with what write writes
+func (s *Server) saveFile(write func(w io.Writer) error) error {
+	path := s.snapshotPath()
+	tmp := filepath.Join(filepath.Dir(path), fmt.Sprintf("temp-%d-%d.ccdb", os.Getpid(), time.Now().UnixNano()))
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// bgsave saves in a goroutine, it reports false when a background save is running already
+func (s *Server) bgsave() bool {
+	if !s.bgsaveInProgress.CompareAndSwap(false, true) {
+		return false
+	}
+	go func() {
+		defer s.bgsaveInProgress.Store(false)
+		s.save(true)
+	}()
+	return true
+}
+
+// time to wait before retrying a failed background save
+const bgsaveRetryDelay = 5 * time.Second
+
+// checkSavePoints starts a background save when a save point is reached
+func (s *Server) checkSavePoints() {
+	dirty := s.dirty.Load()
+	if dirty == 0 || s.bgsaveInProgress.Load() {
+		return
+	}
+	if !s.lastSaveOK.Load() && time.Since(time.Unix(0, s.lastSaveAttempt.Load())) < bgsaveRetryDelay {
+		return
+	}
+	since := time.Since(time.Unix(0, s.lastSave.Load()))
+	for _, p := range *s.savePoints.Load() {
+		if dirty >= int64(p.changes) && since >= time.Duration(p.seconds)*time.Second {
+			s.bgsave()
+			return
+		}
+	}
+}
+
+// loadSnapshot fills the keyspace from the snapshot file, a missing file is an empty keyspace
+func (s *Server) loadSnapshot() error {
+	data, err := os.ReadFile(s.snapshotPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	records, rest, err := decodeSnapshot(data)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 0 {
+		return errSnapshotFormat
+	}
+	defer s.store.lockAll()()
+	n, err := s.restoreSnapshot(records)
+	if err != nil {
+		return err
+	}
+	log.Printf("loaded %d keys from %s", n, s.snapshotPath())
+	return nil
+}
+
+// snapshotRecord is a key of a decoded snapshot, its value still DUMP encoded, or the code
+// of a function library
+type snapshotRecord struct {
+	key      string
+	payload  string
+	expireAt int64
+	library  string
+}
+
+// hasSnapshotMagic reports whether data starts like a snapshot
+func hasSnapshotMagic(data []byte) bool {
+	return len(data) >= len(snapshotMagic) && string(data[:len(snapshotMagic)]) == snapshotMagic
+}
+
+// decodeSnapshot decodes and verifies the snapshot at the start of data, rest is what
+// follows it, e.g. the commands appended to an AOF
+func decodeSnapshot(data []byte) (records []snapshotRecord, rest []byte, err error) {
+	header := len(snapshotMagic) + 2
+	if len(data) < header+1+8 || !hasSnapshotMagic(data) {
+		return nil, nil, errSnapshotFormat
+	}
+	if v := binary.LittleEndian.Uint16(data[len(snapshotMagic):]); v > snapshotVersion {
+		return nil, nil, fmt.Errorf("snapshot version %d is newer than the supported %d", v, snapshotVersion)
+	}
+
+	r := &dumpReader{b: data[header:]}
+	for {
+		if r.err != nil || len(r.b) == 0 {
+			return nil, nil, errSnapshotFormat
+		}
+		op := r.b[0]
+		r.b = r.b[1:]
+		if op == snapshotOpEOF {
+			break
+		}
+		if op == snapshotOpLibrary {
+			rec := snapshotRecord{library: r.str()}
+			if rec.library == "" {
+				return nil, nil, errSnapshotFormat
+			}
+			records = append(records, rec)
+			continue
+		}
+		if op != snapshotOpKey {
+			return nil, nil, errSnapshotFormat
+		}
+		rec := snapshotRecord{key: r.str(), payload: r.str()}
+		if r.err != nil || len(r.b) < 8 {
+			return nil, nil, errSnapshotFormat
+		}
+		rec.expireAt = int64(binary.LittleEndian.Uint64(r.b))
+		r.b = r.b[8:]
+		records = append(records, rec)
+	}
+
+	end := len(data) - len(r.b)
+	if len(r.b) < 8 || crc64.Checksum(data[:end], crcTable) != binary.LittleEndian.Uint64(r.b) {
+		return nil, nil, errSnapshotFormat
+	}
+	return records, r.b[8:], nil
+}
+
+// restoreSnapshot adds the decoded keys to the keyspace, skipping the expired ones, and
+// returns how many were added. Libraries replace those of the same name. Requires lockAll.
+func (s *Server) restoreSnapshot(records []snapshotRecord) (int, error) {
+	n := 0
+	var libs []*library
+	for _, rec := range records {
+		if rec.library != "" {
+			lib, err := loadLibrary(rec.library)
+			if err != nil {
+				return n, fmt.Errorf("function library: %w", err)
+			}
+			libs = append(libs, lib)
+			continue
+		}
+		item, err := restoreValue(s.encLimits, []byte(rec.payload))
+		if err != nil {
+			return n, fmt.Errorf("key %q: %w", rec.key, err)
+		}
+		if rec.expireAt != 0 {
+			item.ttl = time.UnixMilli(rec.expireAt)
+			if isExpired(item.ttl) {
+				continue
+			}
+		}
+		s.store.set(rec.key, item)
+		n++
+	}
+	if err := s.functions.add(libs, true); err != nil {
+		return n, fmt.Errorf("function library: %w", err)
+	}
+	return n, nil
+}
+
+// SAVE
+func SAVE(c *Client, args []Value) Value {
+	if c.srv.bgsaveInProgress.Load() {
+		return errVal("Background save already in progress")
+	}
+	if err := c.srv.save(false); err != nil {
+		return errVal(err.Error())
+	}
+	return ok()
+}
+
+// BGSAVE
+func BGSAVE(c *Client, args []Value) Value {
+	if !c.srv.bgsave() {
+		return errVal("Background save already in progress")
+	}
+	return strVal("Background saving started")
+}
+
+// LASTSAVE, the unix time of the last successful save
+func LASTSAVE(c *Client, args []Value) Value {
+	return intVal(int(time.Unix(0, c.srv.lastSave.Load()).Unix()))
+}
@@ -0,0 +1,155 @@
+package redis
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot_RoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	n := startTestServer(t, Config{Dir: dir})
+
+	n.do(t, "SET", "str", "hello")
+	n.do(t, "SET", "int", "42")
+	n.do(t, "SET", "ttl", "v", "EX", "100")
+	n.do(t, "SET", "gone", "v", "PX", "1")
+	n.do(t, "RPUSH", "list", "a", "b", "c")
+	n.do(t, "HSET", "hash", "f1", "v1", "f2", "v2")
+	n.do(t, "SADD", "set", "1", "2", "3")
+	n.do(t, "ZADD", "zset", "1.5", "a", "-2", "b")
+	for i := range 200 {
+		n.do(t, "SADD", "bigset", "m"+strconv.Itoa(i))
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	require.Equal(t, "OK", n.do(t, "SAVE").String)
+	require.InDelta(t, time.Now().Unix(), n.do(t, "LASTSAVE").Int, 1)
+	require.Zero(t, n.srv.dirty.Load())
+
+	restored := NewServer(Config{Dir: dir, Port: freePort(t)})
+	require.NoError(t, restored.loadSnapshot())
+	m := dialTestServer(t, serveTestServer(t, restored))
+
+	require.Equal(t, "hello", m.do(t, "GET", "str").Bulk)
+	require.Equal(t, "int", m.do(t, "OBJECT", "ENCODING", "int").Bulk)
+	require.InDelta(t, 100, m.do(t, "TTL", "ttl").Int, 1)
+	require.Equal(t, 0, m.do(t, "EXISTS", "gone").Int)
+	require.Equal(t, []string{"a", "b", "c"}, bulks(m.do(t, "LRANGE", "list", "0", "-1")))
+	require.Equal(t, "v2", m.do(t, "HGET", "hash", "f2").Bulk)
+	require.Equal(t, "intset", m.do(t, "OBJECT", "ENCODING", "set").Bulk)
+	require.Equal(t, 200, m.do(t, "SCARD", "bigset").Int)
+	require.Equal(t, []string{"b", "-2", "a", "1.5"}, bulks(m.do(t, "ZRANGE", "zset", "0", "-1", "WITHSCORES")))
+}
+
+func TestSnapshot_Corrupted(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, NewServer(Config{Dir: dir}).loadSnapshot(), "a missing snapshot is an empty keyspace")
+
+	n := startTestServer(t, Config{Dir: dir})
+	n.do(t, "SET", "k", "v")
+
+	var buf bytes.Buffer
+	require.NoError(t, n.srv.writeSnapshot(&buf, snapshotConsistent))
+	data := buf.Bytes()
+
+	for name, b := range map[string][]byte{
+		"flipped bit": func() []byte { b := bytes.Clone(data); b[12] ^= 1; return b }(),
+		"truncated":   data[:len(data)-3],
+		"bad magic":   append([]byte("XX"), data[2:]...),
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "dump.ccdb"), b, 0o644))
+		require.Error(t, NewServer(Config{Dir: dir}).loadSnapshot(), name)
+	}
+}
+
+func TestSnapshot_SavePoints(t *testing.T) {
+	dir := t.TempDir()
+	n := startTestServer(t, Config{Dir: dir})
+	path := filepath.Join(dir, "dump.ccdb")
+
+	require.Equal(t, "", n.do(t, "CONFIG", "GET", "save").Array[1].Bulk)
+	require.Equal(t, "error", n.do(t, "CONFIG", "SET", "save", "10").Type)
+	require.Equal(t, "OK", n.do(t, "CONFIG", "SET", "save", "1 3").String)
+	require.Equal(t, "1 3", n.do(t, "CONFIG", "GET", "save").Array[1].Bulk)
+
+	n.do(t, "SET", "a", "1")
+	n.do(t, "SET", "b", "2")
+	time.Sleep(1200 * time.Millisecond)
+	require.NoFileExists(t, path, "two changes don't reach the save point")
+
+	n.do(t, "SET", "c", "3")
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(path)
+		return err == nil && n.srv.dirty.Load() == 0
+	}, 5*time.Second, 20*time.Millisecond)
+
+	require.Equal(t, "Background saving started", n.do(t, "BGSAVE").String)
+}
+
+func TestSnapshot_BackgroundSaveIsConsistent(t *testing.T) {
+	dir := t.TempDir()
+	n := startTestServer(t, Config{Dir: dir})
+	writer := dialTestServer(t, n.srv)
+
+	// two keys in shards far apart, and other keys in between so saving takes a while
+	ks := n.srv.store
+	a, b := "a", "b"
+	for i := 0; ks.shardIndex(b)-ks.shardIndex(a) < len(ks.shards)/2; i++ {
+		a, b = fmt.Sprint("a", i), fmt.Sprint("b", i)
+	}
+	for i := 0; i < 256; i++ {
+		args := []string{"HSET", fmt.Sprint("filler:", i)}
+		for j := 0; j < 500; j++ {
+			args = append(args, strconv.Itoa(j), "x")
+		}
+		n.do(t, args...)
+	}
+
+	// transactions and multi-key writes keep a and b equal, a snapshot must as well
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 1; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			v := strconv.Itoa(i)
+			writer.w.Write(Value{Type: "array", Array: cmdArgs("MSET", a, v, b, v)})
+			writer.w.Write(Value{Type: "array", Array: cmdArgs("MULTI")})
+			writer.w.Write(Value{Type: "array", Array: cmdArgs("SET", a, v+"tx")})
+			writer.w.Write(Value{Type: "array", Array: cmdArgs("SET", b, v+"tx")})
+			writer.w.Write(Value{Type: "array", Array: cmdArgs("EXEC")})
+			for range 5 {
+				writer.read(t)
+			}
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+
+	for range 50 {
+		require.Equal(t, "Background saving started", n.do(t, "BGSAVE").String)
+		require.Eventually(t, func() bool { return !n.srv.bgsaveInProgress.Load() }, 5*time.Second, time.Millisecond)
+
+		restored := NewServer(Config{Dir: dir})
+		require.NoError(t, restored.loadSnapshot())
+		va, okA := restored.store.get(a)
+		vb, okB := restored.store.get(b)
+		require.Equal(t, okA, okB)
+		if okA {
+			require.Equal(t, va.str(), vb.str())
+		}
+	}
+}
@@ -1,112 +1,121 @@
 package redis
 
 import (
-	"time"
+	"math"
+	"strconv"
+	"strings"
 )
 
-func DEL(args []Value) Value {
-	storeMu.Lock()
-	defer storeMu.Unlock()
+func DEL(c *Client, args []Value) Value {
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		keys[i] = arg.Bulk
+	}
+
+	defer c.store.lock(keys...)()
 
-	c := 0
-	for _, opt := range args {
-		if _, ok := store[opt.Bulk]; ok {
-			c++
-			delete(store, opt.Bulk)
+	n := 0
+	for _, key := range keys {
+		if _, ok := c.store.getLive(key); ok {
+			c.store.del(key)
+			c.signalModifiedKey(key)
+			c.srv.notify(notifyGeneric, "del", key)
+			n++
 		}
 	}
 
-	return intVal(c)
+	return intVal(n)
 }
 
-func GET(args []Value) Value {
+func GET(c *Client, args []Value) Value {
 	key := args[0].Bulk
 
-	storeMu.RLock()
-	obj, ok := store[key]
+	s := c.store.shardFor(key)
+
+	s.mu.RLock()
+	obj, ok := s.items[key]
 	expired := ok && isExpired(obj.ttl)
-	val := obj.value
-	storeMu.RUnlock()
+	var val Value
+	if ok && !expired {
+		if obj.itemType != REDIS_STRING {
+			val = errWrongType()
+		} else {
+			obj.touch()
+			val = bulkVal(obj.str())
+		}
+	}
+	s.mu.RUnlock()
 
 	if !ok || expired {
 		if expired {
-			storeMu.Lock()
-			if val2, ok := store[key]; ok && isExpired(val2.ttl) {
-				delete(store, key)
-			}
-			storeMu.Unlock()
+			s.mu.Lock()
+			c.store.getLive(key)
+			s.mu.Unlock()
 		}
+		c.srv.notify(notifyKeyMiss, "keymiss", key)
 		return nullVal()
 	}
 
-	return bulkVal(val.(string))
+	return val
 }
 
-func MSET(args []Value) Value {
+func MSET(c *Client, args []Value) Value {
 	if len(args)%2 != 0 {
 		return errWrongArgs("mset")
 	}
 
-	storeMu.Lock()
-	defer storeMu.Unlock()
+	keys := make([]string, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		keys = append(keys, args[i].Bulk)
+	}
+
+	defer c.store.lock(keys...)()
 
 	for i := 0; i < len(args); i++ {
 		key := args[i].Bulk
 		val := args[i+1].Bulk
-		store[key] = &RedisItem{itemType: REDIS_STRING, value: val}
+		if _, exists := c.store.getLive(key); !exists {
+			c.srv.notify(notifyNew, "new", key)
+		}
+		c.store.set(key, newStringItem(val))
+		c.signalModifiedKey(key)
+		c.srv.notify(notifyString, "set", key)
 		i++
 	}
 
 	return ok()
 }
 
-// Options
-// EX - seconds
-// PX - milliseconds
-// [TODO] EXAT - set specific unix time, in seconds - positive int
-// [TODO] PXAT - set specific unix time, in milliseconds - positive int
-// NX - only set the key if it does not exist
-// XX - only set the key if it exists
-// GET - return the old string or nil if key did not exist.
-// KEEPTTL - Retain the TTL
-func SET(args []Value) Value {
+// SET key value [NX | XX] [GET]
+// [EX seconds | PX milliseconds | EXAT unix-time-seconds | PXAT unix-time-milliseconds | KEEPTTL]
+//
+// GET returns the previous value, or nil if key did not exist, whether the key was set or not.
+func SET(c *Client, args []Value) Value {
 	key := args[0].Bulk
-	value := args[1].Bulk
-	newval := &RedisItem{itemType: REDIS_STRING, value: value, ttl: time.Time{}}
+	newval := newStringItem(args[1].Bulk)
 
-	var nx, xx, get, keepttl bool
-	var ttlOptCount int
+	var nx, xx, get, keepttl, expire, relative bool
 
 	opts := args[2:]
-
-	for i, opt := range opts {
-		switch opt.Bulk {
-		case "EX", "PX":
-			ttlOptCount++
-			if ttlOptCount > 1 || i+1 >= len(opts) {
+	for i := 0; i < len(opts); i++ {
+		switch opt := strings.ToUpper(opts[i].Bulk); opt {
+		case "EX", "PX", "EXAT", "PXAT":
+			if expire || keepttl || i+1 >= len(opts) {
 				return syntaxErr()
 			}
-
-			var dur time.Duration
-			if opt.Bulk == "EX" {
-				dur = time.Second
-			} else {
-				dur = time.Millisecond
-			}
-
-			ttl, err := addTTL(opts[i+1].Bulk, dur)
+			i++
+			ttl, err := expireAt(opt, opts[i].Bulk, "set")
 			if err != nil {
 				return errVal(err.Error())
 			}
 			newval.ttl = ttl
+			expire = true
+			relative = opt == "EX" || opt == "PX"
 		case "KEEPTTL":
-			ttlOptCount++
-			if ttlOptCount > 1 {
+			if expire || keepttl {
 				return syntaxErr()
 			}
 			keepttl = true
-		// case "EXAT":
-		// case "PXAT":
 		case "NX":
 			nx = true
 		case "XX":
@@ -118,16 +127,32 @@ func SET(args []Value) Value {
 		}
 	}
 
-	if xx && nx {
+	if nx && xx {
 		return syntaxErr()
 	}
+	if relative {
+		// replayed with the absolute ttl, a relative one would start over
+		c.replicateAs("SET", key, args[1].Bulk, "PXAT", strconv.FormatInt(newval.ttl.UnixMilli(), 10))
+	}
 
-	storeMu.Lock()
-	defer storeMu.Unlock()
+	defer c.store.lock(key)()
 
-	val, exists := store[key]
+	val, exists := c.store.getLive(key)
+	if exists && val.itemType != REDIS_STRING && get {
+		return errWrongType()
+	}
 
+	reply := ok()
+	if get {
+		reply = nullVal()
+		if exists {
+			reply = bulkVal(val.str())
+		}
+	}
 	if nx && exists || xx && !exists {
+		if get {
+			return reply
+		}
 		return nullVal()
 	}
 
@@ -135,11 +160,116 @@ func SET(args []Value) Value {
 		newval.ttl = val.ttl
 	}
 
-	store[key] = newval
+	if !exists {
+		c.srv.notify(notifyNew, "new", key)
+	}
+	c.store.set(key, newval)
+	c.signalModifiedKey(key)
+	c.srv.notify(notifyString, "set", key)
+	if !newval.ttl.IsZero() && !keepttl {
+		c.srv.notify(notifyGeneric, "expire", key)
+	}
+	return reply
+}
 
-	if get && exists {
-		return bulkVal(val.value.(string))
+func incrBy(c *Client, key string, incr int64) Value {
+	defer c.store.lock(key)()
+
+	var cur int64
+	item, exists := c.store.lookup(key)
+	if exists {
+		if item.itemType != REDIS_STRING {
+			return errWrongType()
+		}
+		switch v := item.value.(type) {
+		case int64:
+			cur = v
+		case string, []byte:
+			n, err := strconv.ParseInt(item.str(), 10, 64)
+			if err != nil {
+				return errVal("value is not an integer or out of range")
+			}
+			cur = n
+		}
 	}
 
-	return ok()
+	if incr > 0 && cur > math.MaxInt64-incr || incr < 0 && cur < math.MinInt64-incr {
+		return errVal("increment or decrement would overflow")
+	}
+	cur += incr
+
+	if exists {
+		item.value = cur
+	} else {
+		c.store.set(key, newItem(REDIS_STRING, cur))
+		c.srv.notify(notifyNew, "new", key)
+	}
+	c.signalModifiedKey(key)
+	c.srv.notify(notifyString, "incrby", key)
+	return intVal(int(cur))
+}
+
+func INCR(c *Client, args []Value) Value {
+	return incrBy(c, args[0].Bulk, 1)
+}
+
+func DECR(c *Client, args []Value) Value {
+	return incrBy(c, args[0].Bulk, -1)
+}
+
+func INCRBY(c *Client, args []Value) Value {
+	n, err := strconv.ParseInt(args[1].Bulk, 10, 64)
+	if err != nil {
+		return errVal("value is not an integer or out of range")
+	}
+	return incrBy(c, args[0].Bulk, n)
+}
+
+func DECRBY(c *Client, args []Value) Value {
+	n, err := strconv.ParseInt(args[1].Bulk, 10, 64)
+	if err != nil || n == math.MinInt64 {
+		return errVal("value is not an integer or out of range")
+	}
+	return incrBy(c, args[0].Bulk, -n)
+}
+
+// APPEND key value, returns the new length
+func APPEND(c *Client, args []Value) Value {
+	key := args[0].Bulk
+	defer c.store.lock(key)()
+
+	item, exists := c.store.lookup(key)
+	if !exists {
+		item = newStringItem(args[1].Bulk)
+		c.store.set(key, item)
+		c.srv.notify(notifyNew, "new", key)
+	} else {
+		if item.itemType != REDIS_STRING {
+			return errWrongType()
+		}
+		// like in Redis an appended value is never int encoded again, a bitmap grows in place
+		if b, ok := item.value.([]byte); ok {
+			item.value = append(b, args[1].Bulk...)
+		} else {
+			item.value = item.str() + args[1].Bulk
+		}
+	}
+
+	c.signalModifiedKey(key)
+	c.srv.notify(notifyString, "append", key)
+	return intVal(len(item.bytesView()))
+}
+
+func STRLEN(c *Client, args []Value) Value {
+	key := args[0].Bulk
+	defer c.store.rlock(key)()
+
+	item, ok := c.store.lookupRead(key)
+	if !ok {
+		return intVal(0)
+	}
+	if item.itemType != REDIS_STRING {
+		return errWrongType()
+	}
+	return intVal(len(item.bytesView()))
 }
@@ -0,0 +1,56 @@
+package redis
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestString_SetOptions(t *testing.T) {
+	n := startTestServer(t, Config{})
+
+	require.Equal(t, "OK", n.do(t, "SET", "k", "v", "ex", "100").String)
+	require.InDelta(t, 100, n.do(t, "TTL", "k").Int, 1)
+	require.Equal(t, "OK", n.do(t, "SET", "k", "v", "PX", "100000", "XX").String)
+	require.InDelta(t, 100, n.do(t, "TTL", "k").Int, 1)
+	require.Equal(t, "OK", n.do(t, "SET", "k", "v2", "KEEPTTL").String)
+	require.InDelta(t, 100, n.do(t, "TTL", "k").Int, 1)
+
+	at := time.Now().Add(time.Hour)
+	require.Equal(t, "OK", n.do(t, "SET", "k", "v", "EXAT", strconv.FormatInt(at.Unix(), 10)).String)
+	require.InDelta(t, 3600, n.do(t, "TTL", "k").Int, 1)
+	require.Equal(t, "OK", n.do(t, "SET", "k", "v", "PXAT", strconv.FormatInt(at.Add(time.Hour).UnixMilli(), 10)).String)
+	require.InDelta(t, 7200, n.do(t, "TTL", "k").Int, 1)
+	require.Equal(t, "OK", n.do(t, "SET", "past", "v", "PXAT", "1").String)
+	require.Equal(t, "null", n.do(t, "GET", "past").Type)
+
+	for _, args := range [][]string{
+		{"EX", "10", "PX", "10"},
+		{"EX", "10", "KEEPTTL"},
+		{"NX", "XX"},
+		{"EX"},
+		{"BOGUS"},
+	} {
+		require.Equal(t, "ERR syntax error", n.do(t, append([]string{"SET", "k", "v"}, args...)...).String, args)
+	}
+	require.Equal(t, "ERR invalid expire time in 'set' command", n.do(t, "SET", "k", "v", "EX", "0").String)
+	require.Equal(t, "ERR invalid expire time in 'set' command", n.do(t, "SET", "k", "v", "EX", "9223372036854775807").String)
+	require.Equal(t, "ERR value is not an integer or out of range", n.do(t, "SET", "k", "v", "PX", "soon").String)
+
+	// GET returns the previous value whether the key was set or not
+	n.do(t, "SET", "k", "old")
+	require.Equal(t, "old", n.do(t, "SET", "k", "new", "NX", "GET").Bulk)
+	require.Equal(t, "old", n.do(t, "GET", "k").Bulk)
+	require.Equal(t, "null", n.do(t, "SET", "missing", "v", "XX", "GET").Type)
+	require.Equal(t, "null", n.do(t, "GET", "missing").Type)
+	require.Equal(t, "null", n.do(t, "SET", "fresh", "v", "NX", "GET").Type)
+	require.Equal(t, "v", n.do(t, "GET", "fresh").Bulk)
+
+	n.do(t, "RPUSH", "list", "a")
+	require.Equal(t, "WRONGTYPE", n.do(t, "SET", "list", "v", "GET").String[:9])
+	require.Equal(t, "WRONGTYPE", n.do(t, "SET", "list", "v", "NX", "GET").String[:9])
+	require.Equal(t, "list", n.do(t, "TYPE", "list").String)
+	require.Equal(t, "OK", n.do(t, "SET", "list", "v").String, "without GET any type is overwritten")
+}
@@ -0,0 +1,80 @@
+package redis
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+)
+
+// values of tls-auth-clients, like in Redis clients must present a certificate signed by
+// the CA unless it is "no", and "optional" only verifies the ones that do
+const (
+	tlsAuthYes      = "yes"
+	tlsAuthNo       = "no"
+	tlsAuthOptional = "optional"
+)
+
+// tlsConfig builds the server side TLS config from the tls-* parameters
+func (cfg *Config) tlsConfig() (*tls.Config, error) {
+	if cfg.TLSCertFile == "" || cfg.TLSKeyFile == "" {
+		return nil, errors.New("tls-port requires tls-cert-file and tls-key-file")
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading tls certificate: %w", err)
+	}
+	conf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	switch cfg.TLSAuthClients {
+	case tlsAuthYes:
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	case tlsAuthOptional:
+		conf.ClientAuth = tls.VerifyClientCertIfGiven
+	case tlsAuthNo:
+		conf.ClientAuth = tls.NoClientCert
+	default:
+		return nil, fmt.Errorf("tls-auth-clients must be one of yes, no, optional, got %q", cfg.TLSAuthClients)
+	}
+
+	if cfg.TLSCACertFile == "" {
+		if conf.ClientAuth != tls.NoClientCert {
+			return nil, errors.New("tls-ca-cert-file must be specified when tls-auth-clients is enabled")
+		}
+		return conf, nil
+	}
+	pem, err := os.ReadFile(cfg.TLSCACertFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading tls ca certificate: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", cfg.TLSCACertFile)
+	}
+	conf.ClientCAs = pool
+	return conf, nil
+}
+
+// listenUnix listens on the Unix domain socket at path, replacing a stale socket file left
+// behind by a previous run. The socket file is removed again when the listener closes.
+func listenUnix(path string, perm os.FileMode) (net.Listener, error) {
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if perm != 0 {
+		if err := os.Chmod(path, perm); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+	return ln, nil
+}
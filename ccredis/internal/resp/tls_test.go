@@ -0,0 +1,223 @@
+package redis
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testPKI is a CA with a server certificate for 127.0.0.1 and a client certificate
+type testPKI struct {
+	dir                       string
+	caFile, certFile, keyFile string
+	ca                        *x509.CertPool
+	client                    tls.Certificate
+	caCert                    *x509.Certificate
+	caKey                     *ecdsa.PrivateKey
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	p := &testPKI{dir: t.TempDir()}
+
+	var err error
+	p.caKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ccredis test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &p.caKey.PublicKey, p.caKey)
+	require.NoError(t, err)
+	p.caCert, err = x509.ParseCertificate(der)
+	require.NoError(t, err)
+	p.ca = x509.NewCertPool()
+	p.ca.AddCert(p.caCert)
+	p.caFile = p.writePEM(t, "ca.crt", "CERTIFICATE", der)
+
+	serverDER, serverKey := p.issue(t, 2, x509.ExtKeyUsageServerAuth)
+	p.certFile = p.writePEM(t, "server.crt", "CERTIFICATE", serverDER)
+	keyDER, err := x509.MarshalECPrivateKey(serverKey)
+	require.NoError(t, err)
+	p.keyFile = p.writePEM(t, "server.key", "EC PRIVATE KEY", keyDER)
+
+	clientDER, clientKey := p.issue(t, 3, x509.ExtKeyUsageClientAuth)
+	p.client = tls.Certificate{Certificate: [][]byte{clientDER}, PrivateKey: clientKey}
+	return p
+}
+
+func (p *testPKI) issue(t *testing.T, serial int64, usage x509.ExtKeyUsage) ([]byte, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "ccredis"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, p.caCert, &key.PublicKey, p.caKey)
+	require.NoError(t, err)
+	return der, key
+}
+
+func (p *testPKI) writePEM(t *testing.T, name, typ string, der []byte) string {
+	path := filepath.Join(p.dir, name)
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600))
+	return path
+}
+
+// listenAndServe runs srv.ListenAndServe until the test ends
+func listenAndServe(t *testing.T, srv *Server) {
+	errc := make(chan error, 1)
+	go func() { errc <- srv.ListenAndServe() }()
+	t.Cleanup(func() {
+		srv.Close()
+		require.NoError(t, <-errc)
+	})
+}
+
+// pingConn sends a PING, unlike rawDo it returns errors, e.g. of a failed TLS handshake
+func pingConn(conn net.Conn) (Value, error) {
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := NewWriter(conn).Write(Value{Type: "array", Array: cmdArgs("PING")}); err != nil {
+		return Value{}, err
+	}
+	return NewReader(conn).Read()
+}
+
+// waitListening waits until something accepts connections at addr
+func waitListening(t *testing.T, network, addr string) {
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial(network, addr)
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestTLS_ClientAuth(t *testing.T) {
+	pki := newTestPKI(t)
+
+	for _, tc := range []struct {
+		auth           string
+		withoutCertErr bool
+	}{
+		{tlsAuthYes, true},
+		{tlsAuthOptional, false},
+		{tlsAuthNo, false},
+	} {
+		t.Run(tc.auth, func(t *testing.T) {
+			srv := NewServer(Config{
+				TLSPort:        freePort(t),
+				TLSCertFile:    pki.certFile,
+				TLSKeyFile:     pki.keyFile,
+				TLSCACertFile:  pki.caFile,
+				TLSAuthClients: tc.auth,
+			})
+			listenAndServe(t, srv)
+			addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(srv.cfg.TLSPort))
+			waitListening(t, "tcp", addr)
+
+			// only TLS is served, no plain TCP port was configured
+			_, err := net.Dial("tcp", srv.Addr())
+			require.Error(t, err)
+
+			conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: pki.ca, Certificates: []tls.Certificate{pki.client}})
+			require.NoError(t, err)
+			defer conn.Close()
+			v, err := pingConn(conn)
+			require.NoError(t, err)
+			require.Equal(t, "PONG", v.String)
+			require.Equal(t, strconv.Itoa(srv.cfg.TLSPort), rawDo(t, conn, "CONFIG", "GET", "tls-port").Array[1].Bulk)
+
+			// with TLS 1.3 a missing certificate only surfaces on the first read
+			conn, err = tls.Dial("tcp", addr, &tls.Config{RootCAs: pki.ca})
+			if err == nil {
+				defer conn.Close()
+				_, err = pingConn(conn)
+			}
+			if tc.withoutCertErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+// rawDo sends a command on a raw connection
+func rawDo(t *testing.T, conn net.Conn, args ...string) Value {
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err := NewWriter(conn).Write(Value{Type: "array", Array: cmdArgs(args...)})
+	require.NoError(t, err)
+	v, err := NewReader(conn).Read()
+	require.NoError(t, err)
+	return v
+}
+
+func TestTLS_InvalidConfig(t *testing.T) {
+	pki := newTestPKI(t)
+
+	for msg, cfg := range map[string]Config{
+		"tls-port requires tls-cert-file and tls-key-file":                    {TLSPort: 1},
+		"tls-ca-cert-file must be specified when tls-auth-clients is enabled": {TLSPort: 1, TLSCertFile: pki.certFile, TLSKeyFile: pki.keyFile},
+		`tls-auth-clients must be one of yes, no, optional, got "sometimes"`:  {TLSPort: 1, TLSCertFile: pki.certFile, TLSKeyFile: pki.keyFile, TLSAuthClients: "sometimes"},
+	} {
+		cfg.Port = freePort(t)
+		err := NewServer(cfg).ListenAndServe()
+		require.EqualError(t, err, msg)
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "redis.sock")
+	// a socket file left behind by a crashed server does not prevent startup
+	stale, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	srv := NewServer(Config{Port: freePort(t), UnixSocket: path, UnixSocketPerm: 0o700})
+	errc := make(chan error, 1)
+	go func() { errc <- srv.ListenAndServe() }()
+	waitListening(t, "unix", path)
+	waitListening(t, "tcp", srv.Addr())
+
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o700), fi.Mode().Perm())
+
+	conn, err := net.Dial("unix", path)
+	require.NoError(t, err)
+	defer conn.Close()
+	info := rawDo(t, conn, "CLIENT", "INFO").Bulk
+	require.Contains(t, info, "addr="+path+":0 ")
+	require.Contains(t, info, "flags=U ")
+	require.Equal(t, "700", rawDo(t, conn, "CONFIG", "GET", "unixsocketperm").Array[1].Bulk)
+
+	require.NoError(t, srv.Close())
+	require.NoError(t, <-errc)
+	_, err = os.Stat(path)
+	require.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,419 @@
+package redis
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const invalidateChannel = "__redis__:invalidate"
+
+// trackingOpts are the CLIENT TRACKING options of a client
+type trackingOpts struct {
+	bcast    bool
+	optin    bool
+	optout   bool
+	noloop   bool
+	redirect int64
+	prefixes []string
+}
+
+const (
+	cachingDefault = iota
+	cachingYes
+	cachingNo
+)
+
+// tracking remembers which clients may hold a cached copy of a key. In the default mode
+// that is every tracking client that read the key since its last invalidation, in BCAST
+// mode every client subscribed to a prefix of the key. Each invalidation is sent once,
+// the client has to read the key again to be notified about the next change.
+type tracking struct {
+	srv *Server
+
+	// number of clients with tracking enabled, lets writes skip the lock when nobody tracks
+	active atomic.Int32
+
+	mu       sync.Mutex
+	keys     map[string]map[*Client]struct{}
+	prefixes map[string]map[*Client]struct{}
+	// keys being read right now by a tracking client, an invalidation keeps the client
+	// registered until its read is done since it may have read the new value
+	reading map[string]map[*Client]struct{}
+}
+
+func newTracking(srv *Server) *tracking {
+	return &tracking{
+		srv:      srv,
+		keys:     make(map[string]map[*Client]struct{}),
+		prefixes: make(map[string]map[*Client]struct{}),
+		reading:  make(map[string]map[*Client]struct{}),
+	}
+}
+
+func (t *tracking) enable(c *Client, opts *trackingOpts) error {
+	if len(opts.prefixes) > 0 && !opts.bcast {
+		return errors.New("PREFIX option requires BCAST mode to be enabled")
+	}
+	if opts.optin && opts.optout {
+		return errors.New("You can't use both OPTIN and OPTOUT")
+	}
+	if opts.bcast && (opts.optin || opts.optout) {
+		return errors.New("OPTIN and OPTOUT are not compatible with BCAST")
+	}
+	if opts.redirect != 0 {
+		if opts.redirect == c.id {
+			return errors.New("A client can only redirect to a different client")
+		}
+		if _, ok := t.srv.clientByID(opts.redirect); !ok {
+			return errors.New("The client ID you want redirect to does not exist")
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if c.tracking != nil && c.tracking.bcast != opts.bcast {
+		return errors.New("You can't switch BCAST mode on/off before disabling tracking for this client, and then re-enabling it with a different mode.")
+	}
+
+	if opts.bcast && len(opts.prefixes) == 0 {
+		opts.prefixes = []string{""}
+	}
+	for i, p := range opts.prefixes {
+		for _, other := range opts.prefixes[i+1:] {
+			if strings.HasPrefix(p, other) || strings.HasPrefix(other, p) {
+				return errors.New("Prefix '" + p + "' overlaps with another provided prefix '" + other + "'. Prefixes for a single client must not overlap.")
+			}
+		}
+	}
+
+	if c.tracking != nil {
+		t.removePrefixes(c)
+	} else {
+		t.active.Add(1)
+	}
+	c.tracking = opts
+
+	for _, p := range opts.prefixes {
+		if t.prefixes[p] == nil {
+			t.prefixes[p] = make(map[*Client]struct{})
+		}
+		t.prefixes[p][c] = struct{}{}
+	}
+	return nil
+}
+
+func (t *tracking) disable(c *Client) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if c.tracking == nil {
+		return
+	}
+	t.removePrefixes(c)
+	for key := range c.trackedKeys {
+		delete(t.keys[key], c)
+		if len(t.keys[key]) == 0 {
+			delete(t.keys, key)
+		}
+	}
+	c.trackedKeys = nil
+	c.tracking = nil
+	t.active.Add(-1)
+}
+
+// removePrefixes unregisters the BCAST prefixes of c. Requires t.mu.
+func (t *tracking) removePrefixes(c *Client) {
+	for _, p := range c.tracking.prefixes {
+		delete(t.prefixes[p], c)
+		if len(t.prefixes[p]) == 0 {
+			delete(t.prefixes, p)
+		}
+	}
+}
+
+// beforeRead remembers the keys a command of c is about to read, if c tracks them, and
+// returns them for afterRead. Registering before the read means a write that lands
+// between the read and its reply still invalidates the key, the client discards a reply
+// that arrives after an invalidation of the key.
+func (t *tracking) beforeRead(c *Client, cmd *command, args []Value) []string {
+	opts := c.tracking
+	if opts == nil || opts.bcast || cmd.flags&cmdReadonly == 0 {
+		return nil
+	}
+	if opts.optin && c.caching != cachingYes || opts.optout && c.caching == cachingNo {
+		return nil
+	}
+
+	keys := cmd.keys(args)
+	if len(keys) == 0 {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, key := range keys {
+		t.track(c, key)
+		addTrackingClient(t.reading, key, c)
+	}
+	return keys
+}
+
+// afterRead ends the read of keys started by beforeRead
+func (t *tracking) afterRead(c *Client, keys []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, key := range keys {
+		delete(t.reading[key], c)
+		if len(t.reading[key]) == 0 {
+			delete(t.reading, key)
+		}
+	}
+}
+
+// track registers c as a client that may cache key. Requires t.mu.
+func (t *tracking) track(c *Client, key string) {
+	addTrackingClient(t.keys, key, c)
+	if c.trackedKeys == nil {
+		c.trackedKeys = make(map[string]struct{})
+	}
+	c.trackedKeys[key] = struct{}{}
+}
+
+func addTrackingClient(m map[string]map[*Client]struct{}, key string, c *Client) {
+	if m[key] == nil {
+		m[key] = make(map[*Client]struct{})
+	}
+	m[key][c] = struct{}{}
+}
+
+// invalidate tells every client that may cache key that it changed. by is the client
+// that modified it, nil when the key was expired or evicted by the server.
+func (t *tracking) invalidate(by *Client, key string) {
+	if t.active.Load() == 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	msg := Value{Type: "array", Array: []Value{bulkVal(key)}}
+
+	for c := range t.keys[key] {
+		delete(c.trackedKeys, key)
+		if c.tracking == nil || c.tracking.bcast || c.tracking.noloop && c == by {
+			continue
+		}
+		t.send(c, msg)
+	}
+	delete(t.keys, key)
+	for c := range t.reading[key] {
+		t.track(c, key)
+	}
+
+	for p, clients := range t.prefixes {
+		if !strings.HasPrefix(key, p) {
+			continue
+		}
+		for c := range clients {
+			if c.tracking.noloop && c == by {
+				continue
+			}
+			t.send(c, msg)
+		}
+	}
+}
+
+// invalidateAll is sent on FLUSHALL, a null invalidation tells clients to drop their whole cache
+func (t *tracking) invalidateAll() {
+	if t.active.Load() == 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, clients := range t.keys {
+		for c := range clients {
+			c.trackedKeys = nil
+		}
+	}
+	t.keys = make(map[string]map[*Client]struct{})
+	for key, clients := range t.reading {
+		for c := range clients {
+			t.track(c, key)
+		}
+	}
+
+	t.srv.clientsMu.RLock()
+	defer t.srv.clientsMu.RUnlock()
+	for _, c := range t.srv.clients {
+		if c.tracking != nil {
+			t.send(c, nullVal())
+		}
+	}
+}
+
+// send delivers an invalidation message for c, to its redirect client if it has one.
+// RESP3 clients get a push message, RESP2 clients get it through the pub/sub channel
+// __redis__:invalidate, which is why RESP2 clients need a redirect. Requires t.mu.
+func (t *tracking) send(c *Client, keys Value) {
+	target := c
+	if id := c.tracking.redirect; id != 0 {
+		var ok bool
+		if target, ok = t.srv.clientByID(id); !ok {
+			if c.resp.Load() == 3 {
+				c.push(Value{Type: "push", Array: []Value{bulkVal("tracking-redir-broken"), intVal(int(id))}})
+			}
+			return
+		}
+	}
+
+	if target.resp.Load() == 3 {
+		target.push(Value{Type: "push", Array: []Value{bulkVal("invalidate"), keys}})
+		return
+	}
+
+	t.srv.pubsub.mu.RLock()
+	_, subscribed := target.subs[invalidateChannel]
+	t.srv.pubsub.mu.RUnlock()
+	if subscribed {
+		target.push(Value{Type: "array", Array: []Value{bulkVal("message"), bulkVal(invalidateChannel), keys}})
+	}
+}
+
+// signalModifiedKey must be called by every command that changes the value of key
+func (c *Client) signalModifiedKey(key string) {
+	c.srv.dirty.Add(1)
+	c.propagate()
+	c.srv.tracking.invalidate(c, key)
+}
+
+// CLIENT TRACKING ON|OFF [REDIRECT id] [PREFIX prefix [PREFIX prefix ...]] [BCAST] [OPTIN] [OPTOUT] [NOLOOP]
+func clientTracking(c *Client, args []Value) Value {
+	if len(args) == 0 {
+		return errWrongArgs("client|tracking")
+	}
+
+	switch strings.ToUpper(args[0].Bulk) {
+	case "OFF":
+		c.srv.tracking.disable(c)
+		return ok()
+	case "ON":
+	default:
+		return syntaxErr()
+	}
+
+	opts := &trackingOpts{}
+	for i := 1; i < len(args); i++ {
+		switch strings.ToUpper(args[i].Bulk) {
+		case "REDIRECT":
+			if i+1 >= len(args) {
+				return syntaxErr()
+			}
+			id, err := strconv.ParseInt(args[i+1].Bulk, 10, 64)
+			if err != nil {
+				return errVal("value is not an integer or out of range")
+			}
+			opts.redirect = id
+			i++
+		case "PREFIX":
+			if i+1 >= len(args) {
+				return syntaxErr()
+			}
+			opts.prefixes = append(opts.prefixes, args[i+1].Bulk)
+			i++
+		case "BCAST":
+			opts.bcast = true
+		case "OPTIN":
+			opts.optin = true
+		case "OPTOUT":
+			opts.optout = true
+		case "NOLOOP":
+			opts.noloop = true
+		default:
+			return syntaxErr()
+		}
+	}
+
+	if err := c.srv.tracking.enable(c, opts); err != nil {
+		return errVal(err.Error())
+	}
+	return ok()
+}
+
+// CLIENT CACHING YES|NO, applies to the next command only
+func clientCaching(c *Client, args []Value) Value {
+	if len(args) != 1 {
+		return errWrongArgs("client|caching")
+	}
+	opts := c.tracking
+	if opts == nil {
+		return errVal("CLIENT CACHING can be called only when the client is in tracking mode with OPTIN or OPTOUT mode enabled")
+	}
+
+	switch strings.ToUpper(args[0].Bulk) {
+	case "YES":
+		if !opts.optin {
+			return errVal("CLIENT CACHING YES is only valid when tracking is enabled in OPTIN mode.")
+		}
+		c.caching = cachingYes
+	case "NO":
+		if !opts.optout {
+			return errVal("CLIENT CACHING NO is only valid when tracking is enabled in OPTOUT mode.")
+		}
+		c.caching = cachingNo
+	default:
+		return syntaxErr()
+	}
+	c.cachingArmed = true
+	return ok()
+}
+
+func clientTrackingInfo(c *Client) Value {
+	opts := c.tracking
+
+	flags := Value{Type: "array", Array: []Value{}}
+	redirect := -1
+	prefixes := Value{Type: "array", Array: []Value{}}
+
+	if opts == nil {
+		flags.Array = append(flags.Array, bulkVal("off"))
+	} else {
+		flags.Array = append(flags.Array, bulkVal("on"))
+		redirect = int(opts.redirect)
+		for _, f := range []struct {
+			on   bool
+			name string
+		}{{opts.bcast, "bcast"}, {opts.optin, "optin"}, {opts.optout, "optout"}, {opts.noloop, "noloop"}} {
+			if f.on {
+				flags.Array = append(flags.Array, bulkVal(f.name))
+			}
+		}
+		switch c.caching {
+		case cachingYes:
+			flags.Array = append(flags.Array, bulkVal("caching-yes"))
+		case cachingNo:
+			flags.Array = append(flags.Array, bulkVal("caching-no"))
+		}
+		if opts.redirect != 0 {
+			if _, ok := c.srv.clientByID(opts.redirect); !ok {
+				flags.Array = append(flags.Array, bulkVal("broken_redirect"))
+			}
+		}
+		if opts.bcast {
+			for _, p := range opts.prefixes {
+				prefixes.Array = append(prefixes.Array, bulkVal(p))
+			}
+		}
+	}
+
+	return c.mapVal([]Value{
+		bulkVal("flags"), flags,
+		bulkVal("redirect"), intVal(redirect),
+		bulkVal("prefixes"), prefixes,
+	})
+}
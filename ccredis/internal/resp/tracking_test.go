@@ -0,0 +1,179 @@
+package redis
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func invalidatedKeys(t *testing.T, v Value) []string {
+	t.Helper()
+	var keys Value
+	switch v.Type {
+	case "push":
+		require.Equal(t, "invalidate", v.Array[0].Bulk)
+		keys = v.Array[1]
+	case "array":
+		require.Equal(t, []Value{bulkVal("message"), bulkVal(invalidateChannel)}, v.Array[:2])
+		keys = v.Array[2]
+	default:
+		t.Fatalf("unexpected invalidation message %+v", v)
+	}
+	if keys.Type == "null" {
+		return nil
+	}
+	var names []string
+	for _, k := range keys.Array {
+		names = append(names, k.Bulk)
+	}
+	return names
+}
+
+func TestTracking_RedirectRESP2(t *testing.T) {
+	inv := startTestServer(t, Config{})
+	reader := dialTestServer(t, inv.srv)
+	writer := dialTestServer(t, inv.srv)
+
+	id := inv.do(t, "CLIENT", "ID").Int
+	inv.do(t, "SUBSCRIBE", invalidateChannel)
+
+	require.Equal(t, "OK", reader.do(t, "CLIENT", "TRACKING", "ON", "REDIRECT", strconv.Itoa(id)).String)
+	require.Equal(t, id, reader.do(t, "CLIENT", "GETREDIR").Int)
+
+	reader.do(t, "GET", "foo")
+	writer.do(t, "SET", "foo", "1")
+	require.Equal(t, []string{"foo"}, invalidatedKeys(t, inv.read(t)))
+
+	// invalidations are one shot until the key is read again
+	writer.do(t, "SET", "foo", "2")
+	reader.do(t, "GET", "bar")
+	writer.do(t, "SET", "bar", "1")
+	require.Equal(t, []string{"bar"}, invalidatedKeys(t, inv.read(t)))
+
+	writer.do(t, "FLUSHALL")
+	require.Nil(t, invalidatedKeys(t, inv.read(t)))
+
+	require.Equal(t, "ERR The client ID you want redirect to does not exist", writer.do(t, "CLIENT", "TRACKING", "ON", "REDIRECT", "9999").String)
+}
+
+func TestTracking_RESP3Modes(t *testing.T) {
+	c := startTestServer(t, Config{})
+	writer := dialTestServer(t, c.srv)
+
+	require.Equal(t, "map", c.do(t, "HELLO", "3").Type)
+
+	// OPTIN only tracks the command following CLIENT CACHING yes
+	require.Equal(t, "OK", c.do(t, "CLIENT", "TRACKING", "ON", "OPTIN").String)
+	c.do(t, "GET", "a")
+	require.Equal(t, "OK", c.do(t, "CLIENT", "CACHING", "YES").String)
+	c.do(t, "GET", "b")
+	c.do(t, "GET", "c")
+	writer.do(t, "MSET", "a", "1", "b", "1", "c", "1")
+	require.Equal(t, []string{"b"}, invalidatedKeys(t, c.read(t)))
+
+	// NOLOOP skips the client's own writes
+	require.Equal(t, "OK", c.do(t, "CLIENT", "TRACKING", "OFF").String)
+	require.Equal(t, "OK", c.do(t, "CLIENT", "TRACKING", "ON", "NOLOOP").String)
+	c.do(t, "GET", "a")
+	c.do(t, "GET", "b")
+	c.do(t, "SET", "a", "2")
+	writer.do(t, "DEL", "b")
+	require.Equal(t, []string{"b"}, invalidatedKeys(t, c.read(t)))
+
+	// BCAST notifies about every key under the prefixes, read or not
+	require.Equal(t, "OK", c.do(t, "CLIENT", "TRACKING", "OFF").String)
+	require.Equal(t, "OK", c.do(t, "CLIENT", "TRACKING", "ON", "BCAST", "PREFIX", "user:", "PREFIX", "session:").String)
+	writer.do(t, "SET", "other:1", "x")
+	writer.do(t, "SET", "user:1", "x")
+	require.Equal(t, []string{"user:1"}, invalidatedKeys(t, c.read(t)))
+	writer.do(t, "EXPIRE", "session:9", "10")
+	writer.do(t, "SET", "session:9", "x")
+	require.Equal(t, []string{"session:9"}, invalidatedKeys(t, c.read(t)))
+
+	info := c.do(t, "CLIENT", "TRACKINGINFO")
+	require.Equal(t, "map", info.Type)
+	require.Equal(t, []Value{bulkVal("on"), bulkVal("bcast")}, info.Array[1].Array)
+
+	require.Contains(t, c.do(t, "CLIENT", "TRACKING", "ON", "PREFIX", "a", "PREFIX", "ab", "BCAST").String, "overlaps")
+}
+
+func TestTracking_DisableForgetsKeys(t *testing.T) {
+	c := startTestServer(t, Config{})
+	other := dialTestServer(t, c.srv)
+	tr := c.srv.tracking
+	tableSize := func() int {
+		tr.mu.Lock()
+		defer tr.mu.Unlock()
+		return len(tr.keys)
+	}
+
+	require.Equal(t, "map", c.do(t, "HELLO", "3").Type)
+	require.Equal(t, "OK", c.do(t, "CLIENT", "TRACKING", "ON").String)
+	require.Equal(t, "map", other.do(t, "HELLO", "3").Type)
+	require.Equal(t, "OK", other.do(t, "CLIENT", "TRACKING", "ON").String)
+	for _, key := range []string{"a", "b", "c"} {
+		c.do(t, "GET", key)
+	}
+	other.do(t, "GET", "d")
+	require.Equal(t, 4, tableSize())
+
+	// keys nobody writes to don't keep clients that stopped tracking or left
+	require.Equal(t, "OK", c.do(t, "CLIENT", "TRACKING", "OFF").String)
+	require.Equal(t, 1, tableSize())
+	other.conn.Close()
+	require.Eventually(t, func() bool { return tableSize() == 0 }, time.Second, time.Millisecond)
+}
+
+func TestTracking_ConcurrentReadsAndWrites(t *testing.T) {
+	c := startTestServer(t, Config{})
+	writer := dialTestServer(t, c.srv)
+	require.Equal(t, "map", c.do(t, "HELLO", "3").Type)
+	require.Equal(t, "OK", c.do(t, "CLIENT", "TRACKING", "ON").String)
+
+	// the cache of a client: a reply is cached unless the key was invalidated while it was
+	// on its way, and an invalidation drops it
+	var cached Value
+	valid := false
+	receive := func() Value {
+		for {
+			v := c.read(t)
+			if v.Type != "push" {
+				return v
+			}
+			require.Equal(t, []string{"k"}, invalidatedKeys(t, v))
+			valid = false
+		}
+	}
+
+	// a read and a write at the same time, over and over, the write must never slip in
+	// between the read and the registration of the reader
+	for round := 0; round < 2000; round++ {
+		value := strconv.Itoa(round)
+		valid = true
+		_, err := c.w.Write(Value{Type: "array", Array: cmdArgs("GET", "k")})
+		require.NoError(t, err)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			writer.do(t, "SET", "k", value)
+		}()
+		cached = receive()
+		<-done
+
+		// the invalidation of the write is queued before the reply to PING
+		_, err = c.w.Write(Value{Type: "array", Array: cmdArgs("PING")})
+		require.NoError(t, err)
+		receive()
+		if valid {
+			require.Equal(t, value, cached.Bulk, "round %d: a cached read was never invalidated", round)
+		}
+
+		// one more write drops the registration, so the next round starts untracked
+		writer.do(t, "SET", "k", value)
+		_, err = c.w.Write(Value{Type: "array", Array: cmdArgs("PING")})
+		require.NoError(t, err)
+		receive()
+	}
+}
@@ -30,18 +30,19 @@ func (t redisType) String() string {
 	}
 }
 
-func TYPE(args []Value) Value {
+func TYPE(c *Client, args []Value) Value {
 	key := args[0].Bulk
-	storeMu.RLock()
-	obj, ok := store[key]
-	storeMu.RUnlock()
+	unlock := c.store.rlock(key)
+	obj, ok := c.store.get(key)
+	live := ok && !isExpired(obj.ttl)
+	unlock()
 
-	if ok {
+	if live {
 		return Value{Type: "string", String: obj.itemType.String()}
 	}
 	return Value{Type: "string", String: "none"}
 }
 
 func errWrongType() Value {
-	return errVal("WRONGTYPE Operation against a key holding the wrong kind of value")
+	return errCode("WRONGTYPE", "Operation against a key holding the wrong kind of value")
 }
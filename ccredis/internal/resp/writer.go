@@ -2,30 +2,88 @@ package redis
 
 import (
 	"io"
+	"unsafe"
 )
 
+// bigBulk is the size from which the payload of a bulk is written straight from the value
+// instead of being copied into the encoding, like PROTO_MBULK_BIG_ARG in Redis
+const bigBulk = 32 << 10
+
 type Writer struct {
 	writer io.Writer
+	// encoding of the value being written, up to the next big bulk
+	buf []byte
 }
 
 func NewWriter(w io.Writer) *Writer {
 	return &Writer{writer: w}
 }
 
+// Write writes the encoding of v and returns the number of bytes written. A 100MB value
+// goes to the underlying writer as is, without a copy of its own.
 func (w *Writer) Write(v Value) (int, error) {
-	b := v.Marshal()
-	n, err := w.writer.Write(b)
+	n, err := w.write(v)
+	if err == nil {
+		var m int
+		m, err = w.flush()
+		n += m
+	}
+	if cap(w.buf) > bigBulk {
+		w.buf = nil
+	}
 	if err != nil {
 		return 0, err
 	}
+	return n, nil
+}
+
+func (w *Writer) write(v Value) (int, error) {
+	switch {
+	case v.Type == "bulk" && len(v.Bulk) >= bigBulk:
+		w.buf = appendHeader(w.buf, BULK, len(v.Bulk))
+		n, err := w.flush()
+		if err != nil {
+			return n, err
+		}
+		m, err := w.writer.Write(unsafe.Slice(unsafe.StringData(v.Bulk), len(v.Bulk)))
+		w.buf = append(w.buf, '\r', '\n')
+		return n + m, err
+	case v.Type == "array" || v.Type == "push" || v.Type == "map":
+		// elements are written one by one, any of them may be a big bulk
+		w.buf = v.appendAggregateHeader(w.buf)
+		n := 0
+		for _, e := range v.Array {
+			m, err := w.write(e)
+			n += m
+			if err != nil {
+				return n, err
+			}
+		}
+		return n, nil
+	default:
+		w.buf = v.appendTo(w.buf)
+		return 0, nil
+	}
+}
+
+// flush writes the buffered encoding
+func (w *Writer) flush() (int, error) {
+	n, err := w.writer.Write(w.buf)
+	w.buf = w.buf[:0]
 	return n, err
 }
 
-func syntaxErr() Value { return Value{Type: "error", String: "syntax error"} }
+func syntaxErr() Value { return errVal("syntax error") }
 func nullVal() Value   { return Value{Type: "null"} }
 func ok() Value        { return Value{Type: "string", String: "OK"} }
 
-func errVal(v string) Value  { return Value{Type: "error", String: v} }
+// noReply is returned by handlers that already wrote their replies, e.g. SUBSCRIBE
+func noReply() Value { return Value{} }
+
+func errVal(v string) Value  { return Value{Type: "error", String: "ERR " + v} }
 func intVal(v int) Value     { return Value{Type: "integer", Int: v} }
 func bulkVal(v string) Value { return Value{Type: "bulk", Bulk: v} }
 func strVal(v string) Value  { return Value{Type: "string", String: v} }
+
+// errCode builds an error reply with a custom error code instead of the generic ERR, e.g. MOVED or WRONGTYPE
+func errCode(code, msg string) Value { return Value{Type: "error", String: code + " " + msg} }
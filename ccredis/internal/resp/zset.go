@@ -0,0 +1,858 @@
+package redis
+
+import (
+	"errors"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Sorted sets are a *listpack of member score pairs ordered by score then member, or a
+// *zset once they hold more than zset-max-listpack-entries members or a member longer
+// than zset-max-listpack-value bytes. The listpack keeps scores in their reply format.
+
+type zsetEntry struct {
+	member string
+	score  float64
+}
+
+func zsetLess(aScore float64, aMember string, bScore float64, bMember string) bool {
+	return aScore < bScore || aScore == bScore && aMember < bMember
+}
+
+const (
+	zskiplistMaxLevel = 32
+	zskiplistP        = 0.25
+)
+
+type zskiplistLevel struct {
+	forward *zskiplistNode
+	// number of nodes skipped by forward, the base of rank lookups
+	span int
+}
+
+type zskiplistNode struct {
+	member   string
+	score    float64
+	backward *zskiplistNode
+	level    []zskiplistLevel
+}
+
+// zskiplist is the skiplist of Redis, every level tracks the span of its links so the
+// rank of a node is the sum of the spans on the way to it
+type zskiplist struct {
+	header *zskiplistNode
+	tail   *zskiplistNode
+	length int
+	level  int
+}
+
+// zset is the full sorted set, the dict answers score lookups and the skiplist ranges
+type zset struct {
+	dict map[string]float64
+	zsl  *zskiplist
+}
+
+func newZset() *zset {
+	return &zset{
+		dict: make(map[string]float64),
+		zsl:  &zskiplist{header: &zskiplistNode{level: make([]zskiplistLevel, zskiplistMaxLevel)}, level: 1},
+	}
+}
+
+func zslRandomLevel() int {
+	level := 1
+	for level < zskiplistMaxLevel && rand.Float64() < zskiplistP {
+		level++
+	}
+	return level
+}
+
+func (zsl *zskiplist) insert(score float64, member string) {
+	var update [zskiplistMaxLevel]*zskiplistNode
+	var rank [zskiplistMaxLevel]int
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		if i < zsl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for f := x.level[i].forward; f != nil && zsetLess(f.score, f.member, score, member); f = x.level[i].forward {
+			rank[i] += x.level[i].span
+			x = f
+		}
+		update[i] = x
+	}
+
+	level := zslRandomLevel()
+	if level > zsl.level {
+		for i := zsl.level; i < level; i++ {
+			update[i] = zsl.header
+			update[i].level[i].span = zsl.length
+		}
+		zsl.level = level
+	}
+
+	x = &zskiplistNode{member: member, score: score, level: make([]zskiplistLevel, level)}
+	for i := 0; i < level; i++ {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = rank[0] - rank[i] + 1
+	}
+	for i := level; i < zsl.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] != zsl.header {
+		x.backward = update[0]
+	}
+	if f := x.level[0].forward; f != nil {
+		f.backward = x
+	} else {
+		zsl.tail = x
+	}
+	zsl.length++
+}
+
+func (zsl *zskiplist) delete(score float64, member string) bool {
+	var update [zskiplistMaxLevel]*zskiplistNode
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for f := x.level[i].forward; f != nil && zsetLess(f.score, f.member, score, member); f = x.level[i].forward {
+			x = f
+		}
+		update[i] = x
+	}
+
+	x = x.level[0].forward
+	if x == nil || x.score != score || x.member != member {
+		return false
+	}
+
+	for i := 0; i < zsl.level; i++ {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if f := x.level[0].forward; f != nil {
+		f.backward = x.backward
+	} else {
+		zsl.tail = x.backward
+	}
+	for zsl.level > 1 && zsl.header.level[zsl.level-1].forward == nil {
+		zsl.level--
+	}
+	zsl.length--
+	return true
+}
+
+// rank returns the 1-based rank of the node, 0 if it is not in the skiplist
+func (zsl *zskiplist) rank(score float64, member string) int {
+	x := zsl.header
+	rank := 0
+	for i := zsl.level - 1; i >= 0; i-- {
+		for f := x.level[i].forward; f != nil && !zsetLess(score, member, f.score, f.member); f = x.level[i].forward {
+			rank += x.level[i].span
+			x = f
+		}
+		if x != zsl.header && x.member == member {
+			return rank
+		}
+	}
+	return 0
+}
+
+// byRank returns the node with the 1-based rank
+func (zsl *zskiplist) byRank(rank int) *zskiplistNode {
+	x := zsl.header
+	traversed := 0
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == rank {
+			return x
+		}
+	}
+	return nil
+}
+
+// firstInRange returns the first node with a score in r
+func (zsl *zskiplist) firstInRange(r *zrangeSpec) *zskiplistNode {
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for f := x.level[i].forward; f != nil && !r.gteMin(f.score); f = x.level[i].forward {
+			x = f
+		}
+	}
+	x = x.level[0].forward
+	if x == nil || !r.lteMax(x.score) {
+		return nil
+	}
+	return x
+}
+
+// zrangeSpec is a score interval, min and max may be exclusive
+type zrangeSpec struct {
+	min, max     float64
+	minex, maxex bool
+}
+
+func (r *zrangeSpec) gteMin(score float64) bool {
+	if r.minex {
+		return score > r.min
+	}
+	return score >= r.min
+}
+
+func (r *zrangeSpec) lteMax(score float64) bool {
+	if r.maxex {
+		return score < r.max
+	}
+	return score <= r.max
+}
+
+func (r *zrangeSpec) contains(score float64) bool {
+	return r.gteMin(score) && r.lteMax(score)
+}
+
+var errNotFloat = errors.New("value is not a valid float")
+
+// parseScore accepts what strtod does, including inf, but not NaN
+func parseScore(s string) (float64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil && !errors.Is(err, strconv.ErrRange) || math.IsNaN(f) {
+		return 0, errNotFloat
+	}
+	return f, nil
+}
+
+// parseScoreBound parses a ZRANGE style bound, "(1.5" is exclusive
+func parseScoreBound(s string) (float64, bool, error) {
+	ex := strings.HasPrefix(s, "(")
+	if ex {
+		s = s[1:]
+	}
+	f, err := parseScore(s)
+	if err != nil {
+		return 0, false, errors.New("min or max is not a float")
+	}
+	return f, ex, nil
+}
+
+// formatScore formats a score like Redis replies it, the shortest representation that
+// round trips and an exponent only where %.17g would use one
+func formatScore(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case f != 0 && (math.Abs(f) < 1e-4 || math.Abs(f) >= 1e17):
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// The helpers below work on sorted set items of either encoding.
+
+func zsetLen(item *RedisItem) int {
+	switch z := item.value.(type) {
+	case *listpack:
+		return len(z.entries) / 2
+	case *zset:
+		return len(z.dict)
+	}
+	return 0
+}
+
+func lpZsetScore(lp *listpack, i int) float64 {
+	f, _ := strconv.ParseFloat(lp.entries[2*i+1], 64)
+	return f
+}
+
+func lpZsetFind(lp *listpack, member string) int {
+	for i := 0; i < len(lp.entries); i += 2 {
+		if lp.entries[i] == member {
+			return i / 2
+		}
+	}
+	return -1
+}
+
+func zsetScore(item *RedisItem, member string) (float64, bool) {
+	switch z := item.value.(type) {
+	case *listpack:
+		if i := lpZsetFind(z, member); i >= 0 {
+			return lpZsetScore(z, i), true
+		}
+	case *zset:
+		score, ok := z.dict[member]
+		return score, ok
+	}
+	return 0, false
+}
+
+// zsetAdd sets the score of member and reports whether it is new
+func zsetAdd(l *encodingLimits, item *RedisItem, member string, score float64) bool {
+	if lp, ok := item.value.(*listpack); ok {
+		added := true
+		if i := lpZsetFind(lp, member); i >= 0 {
+			lp.entries = slices.Delete(lp.entries, 2*i, 2*i+2)
+			added = false
+		}
+		if len(lp.entries)/2 < int(l.zsetMaxListpackEntries.Load()) && len(member) <= int(l.zsetMaxListpackValue.Load()) {
+			i := 0
+			for i < len(lp.entries)/2 && zsetLess(lpZsetScore(lp, i), lp.entries[2*i], score, member) {
+				i++
+			}
+			lp.entries = slices.Insert(lp.entries, 2*i, member, formatScore(score))
+			return added
+		}
+		zsetConvert(item)
+	}
+
+	z := item.value.(*zset)
+	cur, exists := z.dict[member]
+	if exists {
+		if cur == score {
+			return false
+		}
+		z.zsl.delete(cur, member)
+	}
+	z.zsl.insert(score, member)
+	z.dict[member] = score
+	return !exists
+}
+
+func zsetConvert(item *RedisItem) {
+	lp := item.value.(*listpack)
+	z := newZset()
+	for i := 0; i < len(lp.entries)/2; i++ {
+		score := lpZsetScore(lp, i)
+		z.zsl.insert(score, lp.entries[2*i])
+		z.dict[lp.entries[2*i]] = score
+	}
+	item.value = z
+}
+
+func zsetRem(item *RedisItem, member string) bool {
+	switch z := item.value.(type) {
+	case *listpack:
+		if i := lpZsetFind(z, member); i >= 0 {
+			z.entries = slices.Delete(z.entries, 2*i, 2*i+2)
+			return true
+		}
+	case *zset:
+		if score, ok := z.dict[member]; ok {
+			z.zsl.delete(score, member)
+			delete(z.dict, member)
+			return true
+		}
+	}
+	return false
+}
+
+// zsetRank returns the 0-based rank of member in ascending order
+func zsetRank(item *RedisItem, member string) (int, bool) {
+	switch z := item.value.(type) {
+	case *listpack:
+		if i := lpZsetFind(z, member); i >= 0 {
+			return i, true
+		}
+	case *zset:
+		if score, ok := z.dict[member]; ok {
+			return z.zsl.rank(score, member) - 1, true
+		}
+	}
+	return 0, false
+}
+
+// zsetRange returns the entries with a rank from start to stop, both inclusive and in range
+func zsetRange(item *RedisItem, start, stop int) []zsetEntry {
+	res := make([]zsetEntry, 0, stop-start+1)
+	switch z := item.value.(type) {
+	case *listpack:
+		for i := start; i <= stop; i++ {
+			res = append(res, zsetEntry{z.entries[2*i], lpZsetScore(z, i)})
+		}
+	case *zset:
+		for x := z.zsl.byRank(start + 1); x != nil && len(res) < stop-start+1; x = x.level[0].forward {
+			res = append(res, zsetEntry{x.member, x.score})
+		}
+	}
+	return res
+}
+
+// zsetRangeByScore returns the entries with a score in r in ascending order
+func zsetRangeByScore(item *RedisItem, r *zrangeSpec) []zsetEntry {
+	var res []zsetEntry
+	switch z := item.value.(type) {
+	case *listpack:
+		for i := 0; i < len(z.entries)/2; i++ {
+			if score := lpZsetScore(z, i); r.contains(score) {
+				res = append(res, zsetEntry{z.entries[2*i], score})
+			}
+		}
+	case *zset:
+		for x := z.zsl.firstInRange(r); x != nil && r.lteMax(x.score); x = x.level[0].forward {
+			res = append(res, zsetEntry{x.member, x.score})
+		}
+	}
+	return res
+}
+
+func zsetEach(item *RedisItem, fn func(member string, score float64)) {
+	switch z := item.value.(type) {
+	case *listpack:
+		for i := 0; i < len(z.entries)/2; i++ {
+			fn(z.entries[2*i], lpZsetScore(z, i))
+		}
+	case *zset:
+		for x := z.zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+			fn(x.member, x.score)
+		}
+	}
+}
+
+// zsetItem returns the sorted set at key, creating it when create is set. Requires the write lock.
+func (c *Client) zsetItem(key string, create bool) (*RedisItem, Value, bool) {
+	item, ok := c.store.lookup(key)
+	if ok && item.itemType != REDIS_ZSET {
+		return nil, errWrongType(), false
+	}
+	if !ok && create {
+		item = newItem(REDIS_ZSET, &listpack{})
+		c.store.set(key, item)
+		c.srv.notify(notifyNew, "new", key)
+	}
+	return item, Value{}, true
+}
+
+// zsetDelIfEmpty removes a sorted set left without members. Requires the write lock.
+func (c *Client) zsetDelIfEmpty(key string, item *RedisItem) {
+	if zsetLen(item) == 0 {
+		c.store.del(key)
+		c.srv.notify(notifyGeneric, "del", key)
+	}
+}
+
+const (
+	zaddNX = 1 << iota
+	zaddXX
+	zaddGT
+	zaddLT
+	zaddCH
+	zaddIncr
+)
+
+// ZADD key [NX|XX] [GT|LT] [CH] [INCR] score member [score member ...]
+func ZADD(c *Client, args []Value) Value {
+	key := args[0].Bulk
+
+	flags := 0
+	i := 1
+opts:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i].Bulk) {
+		case "NX":
+			flags |= zaddNX
+		case "XX":
+			flags |= zaddXX
+		case "GT":
+			flags |= zaddGT
+		case "LT":
+			flags |= zaddLT
+		case "CH":
+			flags |= zaddCH
+		case "INCR":
+			flags |= zaddIncr
+		default:
+			break opts
+		}
+	}
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return syntaxErr()
+	}
+	if flags&zaddNX != 0 && flags&zaddXX != 0 {
+		return errVal("XX and NX options at the same time are not compatible")
+	}
+	if flags&zaddGT != 0 && flags&(zaddLT|zaddNX) != 0 || flags&zaddLT != 0 && flags&zaddNX != 0 {
+		return errVal("GT, LT, and/or NX options at the same time are not compatible")
+	}
+	if flags&zaddIncr != 0 && len(pairs) > 2 {
+		return errVal("INCR option supports a single increment-element pair")
+	}
+
+	scores := make([]float64, len(pairs)/2)
+	for j := range scores {
+		score, err := parseScore(pairs[2*j].Bulk)
+		if err != nil {
+			return errVal(err.Error())
+		}
+		scores[j] = score
+	}
+
+	defer c.store.lock(key)()
+
+	item, errReply, ok := c.zsetItem(key, false)
+	if !ok {
+		return errReply
+	}
+	if item == nil && flags&zaddXX != 0 {
+		if flags&zaddIncr != 0 {
+			return nullVal()
+		}
+		return intVal(0)
+	}
+
+	added, updated := 0, 0
+	var incrResult Value
+	for j, score := range scores {
+		member := pairs[2*j+1].Bulk
+
+		var cur float64
+		exists := false
+		if item != nil {
+			cur, exists = zsetScore(item, member)
+		}
+		if exists && flags&zaddNX != 0 || !exists && flags&zaddXX != 0 {
+			incrResult = nullVal()
+			continue
+		}
+		if flags&zaddIncr != 0 {
+			score += cur
+			if math.IsNaN(score) {
+				return errVal("resulting score is not a number (NaN)")
+			}
+		}
+		if exists && (flags&zaddGT != 0 && score <= cur || flags&zaddLT != 0 && score >= cur) {
+			incrResult = nullVal()
+			continue
+		}
+		incrResult = bulkVal(formatScore(score))
+
+		if exists && cur == score {
+			continue
+		}
+		if item == nil {
+			item, _, _ = c.zsetItem(key, true)
+		}
+		if zsetAdd(c.srv.encLimits, item, member, score) {
+			added++
+		} else {
+			updated++
+		}
+	}
+
+	if added+updated > 0 {
+		c.signalModifiedKey(key)
+		if flags&zaddIncr != 0 {
+			c.srv.notify(notifyZset, "zincr", key)
+		} else {
+			c.srv.notify(notifyZset, "zadd", key)
+		}
+	}
+
+	if flags&zaddIncr != 0 {
+		return incrResult
+	}
+	if flags&zaddCH != 0 {
+		return intVal(added + updated)
+	}
+	return intVal(added)
+}
+
+// ZINCRBY key increment member
+func ZINCRBY(c *Client, args []Value) Value {
+	return ZADD(c, []Value{args[0], {Type: "bulk", Bulk: "INCR"}, args[1], args[2]})
+}
+
+// ZREM key member [member ...]
+func ZREM(c *Client, args []Value) Value {
+	key := args[0].Bulk
+	defer c.store.lock(key)()
+
+	item, errReply, ok := c.zsetItem(key, false)
+	if !ok {
+		return errReply
+	}
+	if item == nil {
+		return intVal(0)
+	}
+
+	n := 0
+	for _, m := range args[1:] {
+		if zsetRem(item, m.Bulk) {
+			n++
+		}
+	}
+	if n > 0 {
+		c.signalModifiedKey(key)
+		c.srv.notify(notifyZset, "zrem", key)
+		c.zsetDelIfEmpty(key, item)
+	}
+	return intVal(n)
+}
+
+// zsetRead returns the sorted set at key for a reader holding the read lock, item is nil
+// when the key does not exist
+func (c *Client) zsetRead(key string) (*RedisItem, Value, bool) {
+	item, ok := c.store.lookupRead(key)
+	if !ok {
+		return nil, Value{}, true
+	}
+	if item.itemType != REDIS_ZSET {
+		return nil, errWrongType(), false
+	}
+	return item, Value{}, true
+}
+
+// ZCARD key
+func ZCARD(c *Client, args []Value) Value {
+	key := args[0].Bulk
+	defer c.store.rlock(key)()
+
+	item, errReply, ok := c.zsetRead(key)
+	if !ok {
+		return errReply
+	}
+	if item == nil {
+		return intVal(0)
+	}
+	return intVal(zsetLen(item))
+}
+
+// ZSCORE key member
+func ZSCORE(c *Client, args []Value) Value {
+	key := args[0].Bulk
+	defer c.store.rlock(key)()
+
+	item, errReply, ok := c.zsetRead(key)
+	if !ok {
+		return errReply
+	}
+	if item == nil {
+		return nullVal()
+	}
+	if score, ok := zsetScore(item, args[1].Bulk); ok {
+		return bulkVal(formatScore(score))
+	}
+	return nullVal()
+}
+
+// ZMSCORE key member [member ...]
+func ZMSCORE(c *Client, args []Value) Value {
+	key := args[0].Bulk
+	defer c.store.rlock(key)()
+
+	item, errReply, ok := c.zsetRead(key)
+	if !ok {
+		return errReply
+	}
+	res := Value{Type: "array", Array: make([]Value, 0, len(args)-1)}
+	for _, m := range args[1:] {
+		if item != nil {
+			if score, ok := zsetScore(item, m.Bulk); ok {
+				res.Array = append(res.Array, bulkVal(formatScore(score)))
+				continue
+			}
+		}
+		res.Array = append(res.Array, nullVal())
+	}
+	return res
+}
+
+func zrank(c *Client, args []Value, rev bool) Value {
+	key := args[0].Bulk
+	withScore := false
+	if len(args) == 3 {
+		if !strings.EqualFold(args[2].Bulk, "WITHSCORE") {
+			return syntaxErr()
+		}
+		withScore = true
+	} else if len(args) != 2 {
+		return syntaxErr()
+	}
+
+	defer c.store.rlock(key)()
+
+	item, errReply, ok := c.zsetRead(key)
+	if !ok {
+		return errReply
+	}
+	if item == nil {
+		return nullVal()
+	}
+	rank, ok := zsetRank(item, args[1].Bulk)
+	if !ok {
+		return nullVal()
+	}
+	if rev {
+		rank = zsetLen(item) - 1 - rank
+	}
+	if withScore {
+		score, _ := zsetScore(item, args[1].Bulk)
+		return Value{Type: "array", Array: []Value{intVal(rank), bulkVal(formatScore(score))}}
+	}
+	return intVal(rank)
+}
+
+// ZRANK key member [WITHSCORE]
+func ZRANK(c *Client, args []Value) Value {
+	return zrank(c, args, false)
+}
+
+// ZREVRANK key member [WITHSCORE]
+func ZREVRANK(c *Client, args []Value) Value {
+	return zrank(c, args, true)
+}
+
+// ZCOUNT key min max
+func ZCOUNT(c *Client, args []Value) Value {
+	key := args[0].Bulk
+	r, err := parseRangeSpec(args[1].Bulk, args[2].Bulk)
+	if err != nil {
+		return errVal(err.Error())
+	}
+
+	defer c.store.rlock(key)()
+
+	item, errReply, ok := c.zsetRead(key)
+	if !ok {
+		return errReply
+	}
+	if item == nil {
+		return intVal(0)
+	}
+	return intVal(len(zsetRangeByScore(item, r)))
+}
+
+func parseRangeSpec(min, max string) (*zrangeSpec, error) {
+	r := &zrangeSpec{}
+	var err error
+	if r.min, r.minex, err = parseScoreBound(min); err != nil {
+		return nil, err
+	}
+	if r.max, r.maxex, err = parseScoreBound(max); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// ZRANGE key start stop [BYSCORE] [REV] [LIMIT offset count] [WITHSCORES]
+func ZRANGE(c *Client, args []Value) Value {
+	key := args[0].Bulk
+
+	var byScore, rev, withScores, limit bool
+	offset, count := 0, -1
+	for i := 3; i < len(args); i++ {
+		switch strings.ToUpper(args[i].Bulk) {
+		case "BYSCORE":
+			byScore = true
+		case "REV":
+			rev = true
+		case "WITHSCORES":
+			withScores = true
+		case "LIMIT":
+			if i+2 >= len(args) {
+				return syntaxErr()
+			}
+			var err1, err2 error
+			offset, err1 = strconv.Atoi(args[i+1].Bulk)
+			count, err2 = strconv.Atoi(args[i+2].Bulk)
+			if err1 != nil || err2 != nil {
+				return errVal("value is not an integer or out of range")
+			}
+			limit = true
+			i += 2
+		default:
+			return syntaxErr()
+		}
+	}
+	if limit && !byScore {
+		return errVal("syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+
+	var r *zrangeSpec
+	var start, stop int
+	if byScore {
+		min, max := args[1].Bulk, args[2].Bulk
+		if rev {
+			min, max = max, min
+		}
+		var err error
+		if r, err = parseRangeSpec(min, max); err != nil {
+			return errVal(err.Error())
+		}
+	} else {
+		var err1, err2 error
+		start, err1 = strconv.Atoi(args[1].Bulk)
+		stop, err2 = strconv.Atoi(args[2].Bulk)
+		if err1 != nil || err2 != nil {
+			return errVal("value is not an integer or out of range")
+		}
+	}
+
+	defer c.store.rlock(key)()
+
+	res := Value{Type: "array", Array: []Value{}}
+	item, errReply, ok := c.zsetRead(key)
+	if !ok {
+		return errReply
+	}
+	if item == nil {
+		return res
+	}
+
+	var entries []zsetEntry
+	if byScore {
+		entries = zsetRangeByScore(item, r)
+		if rev {
+			slices.Reverse(entries)
+		}
+		if offset < 0 || offset >= len(entries) {
+			entries = nil
+		} else {
+			entries = entries[offset:]
+			if count >= 0 && count < len(entries) {
+				entries = entries[:count]
+			}
+		}
+	} else {
+		n := zsetLen(item)
+		if start < 0 {
+			start = max(n+start, 0)
+		}
+		if stop < 0 {
+			stop = n + stop
+		}
+		stop = min(stop, n-1)
+		if start > stop {
+			return res
+		}
+		if rev {
+			entries = zsetRange(item, n-1-stop, n-1-start)
+			slices.Reverse(entries)
+		} else {
+			entries = zsetRange(item, start, stop)
+		}
+	}
+
+	for _, e := range entries {
+		res.Array = append(res.Array, bulkVal(e.member))
+		if withScores {
+			res.Array = append(res.Array, bulkVal(formatScore(e.score)))
+		}
+	}
+	return res
+}
@@ -0,0 +1,139 @@
+package redis
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestZset_AddAndRange(t *testing.T) {
+	n := startTestServer(t, Config{})
+
+	require.Equal(t, 3, n.do(t, "ZADD", "z", "1", "one", "2", "two", "3", "three").Int)
+	require.Equal(t, 1, n.do(t, "ZADD", "z", "1", "uno", "1", "uno").Int)
+	require.Equal(t, 4, n.do(t, "ZCARD", "z").Int)
+	require.Equal(t, "listpack", n.do(t, "OBJECT", "ENCODING", "z").Bulk)
+
+	// equal scores are ordered by member
+	require.Equal(t, []string{"one", "uno", "two", "three"}, bulks(n.do(t, "ZRANGE", "z", "0", "-1")))
+	require.Equal(t, []string{"three", "3", "two", "2"}, bulks(n.do(t, "ZRANGE", "z", "0", "1", "REV", "WITHSCORES")))
+	require.Equal(t, []string{"two", "three"}, bulks(n.do(t, "ZRANGE", "z", "(1", "3", "BYSCORE")))
+	require.Equal(t, []string{"three", "two"}, bulks(n.do(t, "ZRANGE", "z", "+inf", "(1", "BYSCORE", "REV")))
+	require.Equal(t, []string{"uno"}, bulks(n.do(t, "ZRANGE", "z", "-inf", "+inf", "BYSCORE", "LIMIT", "1", "1")))
+	require.Empty(t, n.do(t, "ZRANGE", "z", "5", "10").Array)
+	require.Equal(t, 3, n.do(t, "ZCOUNT", "z", "1", "(3").Int)
+
+	require.Equal(t, 1, n.do(t, "ZRANK", "z", "uno").Int)
+	require.Equal(t, 2, n.do(t, "ZREVRANK", "z", "uno").Int)
+	withScore := n.do(t, "ZRANK", "z", "three", "WITHSCORE")
+	require.Equal(t, 3, withScore.Array[0].Int)
+	require.Equal(t, "3", withScore.Array[1].Bulk)
+	require.Equal(t, "null", n.do(t, "ZRANK", "z", "missing").Type)
+	require.Equal(t, "2", n.do(t, "ZSCORE", "z", "two").Bulk)
+
+	// options
+	require.Equal(t, 0, n.do(t, "ZADD", "z", "NX", "10", "one").Int)
+	require.Equal(t, "1", n.do(t, "ZSCORE", "z", "one").Bulk)
+	require.Equal(t, 0, n.do(t, "ZADD", "z", "XX", "10", "four").Int)
+	require.Equal(t, "null", n.do(t, "ZSCORE", "z", "four").Type)
+	require.Equal(t, 1, n.do(t, "ZADD", "z", "CH", "XX", "1.5", "one", "2", "two").Int)
+	require.Equal(t, 0, n.do(t, "ZADD", "z", "GT", "CH", "0", "one").Int)
+	require.Equal(t, 1, n.do(t, "ZADD", "z", "LT", "CH", "0", "one").Int)
+	require.Equal(t, "2.5", n.do(t, "ZADD", "z", "INCR", "2.5", "one").Bulk)
+	require.Equal(t, "null", n.do(t, "ZADD", "z", "NX", "INCR", "1", "one").Type)
+	require.Equal(t, "5", n.do(t, "ZINCRBY", "z", "2.5", "one").Bulk)
+	require.Equal(t, "inf", n.do(t, "ZINCRBY", "z", "+inf", "one").Bulk)
+	require.Equal(t, "ERR resulting score is not a number (NaN)", n.do(t, "ZINCRBY", "z", "-inf", "one").String)
+	require.Equal(t, "ERR XX and NX options at the same time are not compatible", n.do(t, "ZADD", "z", "NX", "XX", "1", "a").String)
+	require.Equal(t, "ERR GT, LT, and/or NX options at the same time are not compatible", n.do(t, "ZADD", "z", "GT", "LT", "1", "a").String)
+	require.Equal(t, "ERR INCR option supports a single increment-element pair", n.do(t, "ZADD", "z", "INCR", "1", "a", "2", "b").String)
+	require.Equal(t, "ERR value is not a valid float", n.do(t, "ZADD", "z", "nan", "a").String)
+	require.Equal(t, "ERR min or max is not a float", n.do(t, "ZCOUNT", "z", "x", "1").String)
+	require.Equal(t, "ERR syntax error", n.do(t, "ZADD", "z", "NX", "1").String)
+
+	// the last member removed deletes the key
+	require.Equal(t, 2, n.do(t, "ZREM", "z", "one", "uno", "missing").Int)
+	require.Equal(t, 2, n.do(t, "ZREM", "z", "two", "three").Int)
+	require.Equal(t, 0, n.do(t, "EXISTS", "z").Int)
+
+	n.do(t, "SET", "str", "x")
+	require.Equal(t, "WRONGTYPE Operation against a key holding the wrong kind of value", n.do(t, "ZADD", "str", "1", "a").String)
+}
+
+func bulks(v Value) []string {
+	s := make([]string, len(v.Array))
+	for i, e := range v.Array {
+		s[i] = e.Bulk
+	}
+	return s
+}
+
+func TestZset_Skiplist(t *testing.T) {
+	n := startTestServer(t, Config{})
+
+	require.Equal(t, "OK", n.do(t, "CONFIG", "SET", "zset-max-listpack-entries", "16").String)
+	for i := 0; i < 100; i++ {
+		n.do(t, "ZADD", "z", fmt.Sprint(i%10), fmt.Sprintf("m%02d", i))
+	}
+	require.Equal(t, "skiplist", n.do(t, "OBJECT", "ENCODING", "z").Bulk)
+	require.Equal(t, 100, n.do(t, "ZCARD", "z").Int)
+	require.Equal(t, []string{"m00", "m10", "m20"}, bulks(n.do(t, "ZRANGE", "z", "0", "2")))
+	require.Equal(t, []string{"m99", "m89"}, bulks(n.do(t, "ZRANGE", "z", "0", "1", "REV")))
+	require.Equal(t, 10, n.do(t, "ZCOUNT", "z", "3", "3").Int)
+	require.Equal(t, 31, n.do(t, "ZRANK", "z", "m13").Int)
+
+	// DUMP and RESTORE keep members and scores, COPY is a deep copy
+	dump := n.do(t, "DUMP", "z").Bulk
+	require.Equal(t, "OK", n.do(t, "RESTORE", "z2", "0", dump).String)
+	require.Equal(t, n.do(t, "ZRANGE", "z", "0", "-1", "WITHSCORES"), n.do(t, "ZRANGE", "z2", "0", "-1", "WITHSCORES"))
+	require.Equal(t, 1, n.do(t, "COPY", "z", "z3").Int)
+	n.do(t, "ZREM", "z", "m00")
+	require.Equal(t, 100, n.do(t, "ZCARD", "z3").Int)
+
+	// a long member converts the listpack too
+	n.do(t, "ZADD", "small", "1", "a")
+	require.Equal(t, "listpack", n.do(t, "OBJECT", "ENCODING", "small").Bulk)
+	n.do(t, "ZADD", "small", "1", string(make([]byte, 65)))
+	require.Equal(t, "skiplist", n.do(t, "OBJECT", "ENCODING", "small").Bulk)
+}
+
+func TestZskiplist_Ranks(t *testing.T) {
+	t.Parallel()
+
+	z := newZset()
+	var want []zsetEntry
+	for i := 0; i < 2000; i++ {
+		e := zsetEntry{member: fmt.Sprint("m", rand.IntN(500)), score: float64(rand.IntN(100))}
+		if cur, ok := z.dict[e.member]; ok {
+			require.True(t, z.zsl.delete(cur, e.member))
+			want = slices.DeleteFunc(want, func(w zsetEntry) bool { return w.member == e.member })
+		}
+		if rand.IntN(4) == 0 {
+			delete(z.dict, e.member)
+			continue
+		}
+		z.zsl.insert(e.score, e.member)
+		z.dict[e.member] = e.score
+		want = append(want, e)
+	}
+	slices.SortFunc(want, func(a, b zsetEntry) int {
+		if zsetLess(a.score, a.member, b.score, b.member) {
+			return -1
+		}
+		return 1
+	})
+
+	require.Equal(t, len(want), z.zsl.length)
+	for i, e := range want {
+		require.Equal(t, i+1, z.zsl.rank(e.score, e.member))
+		x := z.zsl.byRank(i + 1)
+		require.Equal(t, e.member, x.member)
+		if i > 0 {
+			require.Equal(t, want[i-1].member, x.backward.member)
+		}
+	}
+	require.Equal(t, want[len(want)-1].member, z.zsl.tail.member)
+}
@@ -3,77 +3,83 @@ package main
 import (
 	"flag"
 	"fmt"
-	"io"
 	"log"
-	"net"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 
 	redis "github.com/Kostaaa1/redis-clone/internal/resp"
 )
 
 func main() {
-	port := flag.Int("port", 6380, "redis server port")
-	flag.Parse()
-
-	addr := fmt.Sprintf("127.0.0.1:%d", *port)
-
-	ln, err := net.Listen("tcp", addr)
-	if err != nil {
-		log.Fatal(err)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [/path/to/redis.conf] [options]\n\noptions override the config file:\n", os.Args[0])
+		flag.PrintDefaults()
 	}
-
-	fmt.Printf("Redis server started: %s\n", addr)
-
-	for {
-		conn, err := ln.Accept()
-		if err != nil {
-			if err == io.EOF {
-				fmt.Println("client closed the connection")
-				continue
-			}
-			log.Fatal(err)
-		}
-		go handleConn(conn)
+	def := redis.DefaultConfig()
+	flag.Int("port", def.Port, "redis server port, 0 disables plain TCP when TLS or a unix socket is enabled")
+	flag.Bool("cluster-enabled", false, "run the server as a cluster node")
+	flag.Int("cluster-port", 0, "cluster bus port (default port+10000)")
+	flag.String("notify-keyspace-events", "", "keyspace event classes to publish, e.g. KEA")
+	flag.Int("tls-port", 0, "port of the TLS listener, 0 disables it")
+	flag.String("tls-cert-file", "", "server certificate for the TLS listener")
+	flag.String("tls-key-file", "", "private key of the server certificate")
+	flag.String("tls-ca-cert-file", "", "CA certificates client certificates are verified against")
+	flag.String("tls-auth-clients", def.TLSAuthClients, "require client certificates: yes, no or optional")
+	flag.String("unixsocket", "", "path of a unix domain socket to listen on")
+	flag.String("unixsocketperm", "0", "permissions of the unix socket in octal, e.g. 700")
+	flag.Int("maxclients", def.MaxClients, "maximum number of connected clients")
+	flag.Int("timeout", 0, "close connections idle for this many seconds, 0 disables")
+	flag.Int("tcp-keepalive", def.TCPKeepalive, "seconds between TCP keepalive probes, 0 disables")
+	flag.String("dir", def.Dir, "directory the snapshot is written to")
+	flag.String("dbfilename", def.DBFilename, "name of the snapshot file")
+	flag.String("save", def.Save, `save points as "<seconds> <changes>" pairs, "" disables saving`)
+	flag.Bool("appendonly", false, "log every write to the append only file and load it on startup")
+	flag.String("appendfilename", def.AppendFilename, "name of the append only file in dir")
+	flag.String("appendfsync", def.AppendFsync, "when the append only file is fsynced: always, everysec or no")
+	flag.String("replicaof", "", `replicate the primary at "<host> <port>"`)
+	flag.String("metrics-addr", "", `serve Prometheus metrics over HTTP at "<host>:<port>", e.g. 127.0.0.1:9121`)
+
+	// the config file comes first, like with redis-server
+	var file string
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		file, args = args[0], args[1:]
+	}
+	flag.CommandLine.Parse(args)
+	if flag.NArg() > 0 {
+		flag.Usage()
+		os.Exit(2)
 	}
-}
-
-func handleConn(conn net.Conn) {
-	defer conn.Close()
-
-	for {
-		r := redis.NewReader(conn)
-
-		v, err := r.Read()
-		if err != nil {
-			if err == io.EOF {
-				fmt.Println("client closed the connection")
-				return
-			}
-			log.Fatal(err)
-		}
-
-		if v.Type != "array" {
-			fmt.Println("invalid request, expected array")
-			continue
-		}
 
-		if len(v.Array) == 0 {
-			fmt.Println("invalid request, expected array length > 0")
-			continue
+	// only the flags given override the config file
+	var overrides [][2]string
+	flag.Visit(func(f *flag.Flag) {
+		v := f.Value.String()
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+			v = map[string]string{"true": "yes", "false": "no"}[v]
 		}
+		overrides = append(overrides, [2]string{f.Name, v})
+	})
 
-		cmd := strings.ToUpper(v.Array[0].Bulk)
-		args := v.Array
-
-		w := redis.NewWriter(conn)
+	srv, err := redis.NewConfiguredServer(file, overrides)
+	if err != nil {
+		log.Fatalf("reading the configuration: %v", err)
+	}
 
-		handler, ok := redis.Handlers[cmd]
-		if !ok {
-			w.Write(redis.UnknownCmd(cmd, args[1:]))
-			continue
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		for sig := range sigs {
+			// a failed save keeps the server running, like Redis
+			if err := srv.ShutdownOnSignal(sig); err != nil {
+				log.Printf("shutdown failed: %v", err)
+			}
 		}
+	}()
 
-		// sending all args, middleware func extracts the command from other arguments (command included)
-		w.Write(handler(args))
+	if err := srv.ListenAndServe(); err != nil {
+		log.Fatal(err)
 	}
 }
@@ -0,0 +1,299 @@
+// Package redlock implements the Redlock distributed lock on top of independent servers:
+// a lock is held once a majority of them store its random token with a ttl, for a validity
+// time that accounts for the time spent acquiring it and for clock drift between servers.
+// Every acquisition also gets a fencing token, a number that grows with every new holder,
+// which storage touched under the lock can use to reject writes of a previous holder whose
+// lock expired while it was paused.
+//
+// Releasing and extending compare the token on the server in a function of the redlock
+// library, so a holder never touches a lock that expired and was taken by someone else. The
+// library is loaded with FUNCTION LOAD the first time a server doesn't have it, which needs
+// Redis 7 or later.
+package redlock
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+	"errors"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Kostaaa1/redis-clone/client"
+)
+
+var (
+	// ErrNotAcquired is returned by Lock when no majority could be locked within the tries
+	ErrNotAcquired = errors.New("redlock: lock not acquired")
+	// ErrLockLost is returned by Extend and Unlock when a majority no longer holds the lock
+	ErrLockLost = errors.New("redlock: lock lost")
+)
+
+type Options struct {
+	// how long a lock is valid for, defaults to 8s
+	TTL time.Duration
+	// attempts to acquire a lock before Lock gives up, defaults to 3
+	Tries int
+	// delay between attempts, a random jitter of up to the same amount is added. Defaults to 200ms.
+	RetryDelay time.Duration
+	// expected clock drift between the servers as a fraction of TTL, defaults to 0.01
+	DriftFactor float64
+	// how long to wait for a single server, small compared to TTL so that an unavailable
+	// server doesn't eat the validity of the lock. Defaults to 50ms.
+	InstanceTimeout time.Duration
+}
+
+func (opt *Options) init() {
+	if opt.TTL == 0 {
+		opt.TTL = 8 * time.Second
+	}
+	if opt.Tries == 0 {
+		opt.Tries = 3
+	}
+	if opt.RetryDelay == 0 {
+		opt.RetryDelay = 200 * time.Millisecond
+	}
+	if opt.DriftFactor == 0 {
+		opt.DriftFactor = 0.01
+	}
+	if opt.InstanceTimeout == 0 {
+		opt.InstanceTimeout = 50 * time.Millisecond
+	}
+}
+
+// Redlock acquires locks on a set of independent servers, it is safe for concurrent use
+type Redlock struct {
+	clients []*client.Client
+	quorum  int
+	opt     Options
+
+	// replaced in tests to simulate slow acquisitions
+	now func() time.Time
+}
+
+// New returns a Redlock over clients, each connected to a different server
+func New(clients []*client.Client, opt Options) *Redlock {
+	opt.init()
+	return &Redlock{
+		clients: clients,
+		quorum:  len(clients)/2 + 1,
+		opt:     opt,
+		now:     time.Now,
+	}
+}
+
+// Lock is a lock held on a majority of the servers until Until
+type Lock struct {
+	r     *Redlock
+	name  string
+	token string
+	fence int64
+	until time.Time
+}
+
+func (l *Lock) Name() string { return l.name }
+
+// Token is the random value stored under the lock name
+func (l *Lock) Token() string { return l.token }
+
+// Fence is the fencing token of this acquisition, larger than the one of every previous holder
+// as long as the servers don't lose data
+func (l *Lock) Fence() int64 { return l.fence }
+
+// Until is when the lock expires, the holder must be done with its work before then
+func (l *Lock) Until() time.Time { return l.until }
+
+// Lock acquires the lock name, retrying up to Tries times while it is held by someone else
+func (r *Redlock) Lock(ctx context.Context, name string) (*Lock, error) {
+	for try := 0; try < r.opt.Tries; try++ {
+		if try > 0 {
+			delay := r.opt.RetryDelay + rand.N(r.opt.RetryDelay)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		l, err := r.tryLock(ctx, name)
+		if err == nil {
+			return l, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+	return nil, ErrNotAcquired
+}
+
+func (r *Redlock) tryLock(ctx context.Context, name string) (*Lock, error) {
+	l := &Lock{r: r, name: name, token: newToken()}
+
+	start := r.now()
+	locked := r.each(ctx, func(ctx context.Context, i int, c *client.Client) bool {
+		return c.Do(ctx, "SET", name, l.token, "NX", "PX", r.opt.TTL.Milliseconds()).Err() == nil
+	})
+	if count(locked) < r.quorum {
+		r.release(ctx, l)
+		return nil, ErrNotAcquired
+	}
+
+	fence, ok := r.fence(ctx, name, locked)
+	l.fence = fence
+	l.until = r.validUntil(start)
+	if !ok || !r.now().Before(l.until) {
+		r.release(ctx, l)
+		return nil, ErrNotAcquired
+	}
+	return l, nil
+}
+
+// validUntil is when a lock whose acquisition started at start expires for its holder. The
+// drift accounts for server clocks running faster than ours, plus 2ms for the ttl precision.
+func (r *Redlock) validUntil(start time.Time) time.Time {
+	drift := time.Duration(float64(r.opt.TTL)*r.opt.DriftFactor) + 2*time.Millisecond
+	return start.Add(r.opt.TTL - drift)
+}
+
+// fence increments the fencing counter of name on the servers in locked and brings the ones
+// that are behind up to the highest value. Every majority overlaps the majority of the previous
+// holder, whose counters were all brought to its fence, so the highest value after INCR is
+// always larger than any fence handed out before.
+func (r *Redlock) fence(ctx context.Context, name string, locked []bool) (int64, bool) {
+	key := name + ":fence"
+
+	values := make([]int64, len(r.clients))
+	incremented := r.each(ctx, func(ctx context.Context, i int, c *client.Client) bool {
+		if !locked[i] {
+			return false
+		}
+		n, err := c.Incr(ctx, key)
+		values[i] = n
+		return err == nil
+	})
+	if count(incremented) < r.quorum {
+		return 0, false
+	}
+
+	var fence int64
+	for i, n := range values {
+		if incremented[i] {
+			fence = max(fence, n)
+		}
+	}
+	caughtUp := r.each(ctx, func(ctx context.Context, i int, c *client.Client) bool {
+		if !incremented[i] {
+			return false
+		}
+		if values[i] == fence {
+			return true
+		}
+		_, err := c.IncrBy(ctx, key, fence-values[i])
+		return err == nil
+	})
+	return fence, count(caughtUp) >= r.quorum
+}
+
+// Extend resets the ttl of a lock that is still held, moving Until forward
+func (l *Lock) Extend(ctx context.Context) error {
+	r := l.r
+	start := r.now()
+	extended := r.each(ctx, func(ctx context.Context, i int, c *client.Client) bool {
+		n, err := fcall(ctx, c, "redlock_extend", l.name, l.token, r.opt.TTL.Milliseconds())
+		return err == nil && n == 1
+	})
+	until := r.validUntil(start)
+	if count(extended) < r.quorum || !r.now().Before(until) {
+		return ErrLockLost
+	}
+	l.until = until
+	return nil
+}
+
+// Unlock releases the lock on every server. It returns ErrLockLost if a majority did not hold
+// it anymore, which means the lock expired while the holder thought it owned it.
+func (l *Lock) Unlock(ctx context.Context) error {
+	if l.r.release(ctx, l) < l.r.quorum {
+		return ErrLockLost
+	}
+	return nil
+}
+
+// release deletes the lock on every server that still stores its token, even when ctx is
+// already canceled
+func (r *Redlock) release(ctx context.Context, l *Lock) int {
+	ctx = context.WithoutCancel(ctx)
+	return count(r.each(ctx, func(ctx context.Context, i int, c *client.Client) bool {
+		n, err := fcall(ctx, c, "redlock_release", l.name, l.token)
+		return err == nil && n == 1
+	}))
+}
+
+// library holds the functions that release and extend a lock only while it stores the token
+// of its holder. Extending sets the token again with XX rather than PEXPIRE, which not every
+// server implements.
+const library = `#!lua name=redlock
+redis.register_function('redlock_release', function(keys, args)
+	if redis.call('GET', keys[1]) == args[1] then
+		return redis.call('DEL', keys[1])
+	end
+	return 0
+end)
+redis.register_function('redlock_extend', function(keys, args)
+	if redis.call('GET', keys[1]) == args[1] then
+		redis.call('SET', keys[1], args[1], 'XX', 'PX', args[2])
+		return 1
+	end
+	return 0
+end)
+`
+
+// fcall calls fn of the redlock library on the lock key, loading the library first when the
+// server doesn't have it
+func fcall(ctx context.Context, c *client.Client, fn, key string, args ...any) (int64, error) {
+	call := append([]any{"FCALL", fn, 1, key}, args...)
+	n, err := c.Do(ctx, call...).Int()
+	var e client.Error
+	if !errors.As(err, &e) || !strings.Contains(string(e), "Function not found") {
+		return n, err
+	}
+	if err := c.Do(ctx, "FUNCTION", "LOAD", "REPLACE", library).Err(); err != nil {
+		return 0, err
+	}
+	return c.Do(ctx, call...).Int()
+}
+
+// each runs fn against every server concurrently, each call bounded by InstanceTimeout
+func (r *Redlock) each(ctx context.Context, fn func(ctx context.Context, i int, c *client.Client) bool) []bool {
+	res := make([]bool, len(r.clients))
+	var wg sync.WaitGroup
+	for i, c := range r.clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, r.opt.InstanceTimeout)
+			defer cancel()
+			res[i] = fn(ctx, i, c)
+		}()
+	}
+	wg.Wait()
+	return res
+}
+
+func count(ok []bool) int {
+	n := 0
+	for _, b := range ok {
+		if b {
+			n++
+		}
+	}
+	return n
+}
+
+func newToken() string {
+	b := make([]byte, 20)
+	crand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,198 @@
+package redlock
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Kostaaa1/redis-clone/client"
+	"github.com/Kostaaa1/redis-clone/internal/harness"
+	redis "github.com/Kostaaa1/redis-clone/internal/resp"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRedlock(t *testing.T, n int, opt Options) (*harness.Harness, *Redlock) {
+	h := harness.Start(t, n, redis.Config{})
+	if opt.InstanceTimeout == 0 {
+		opt.InstanceTimeout = 250 * time.Millisecond
+	}
+	return h, New(h.Clients(t, client.Options{PoolSize: 4}), opt)
+}
+
+// requireReleased asserts no reachable instance still stores the lock
+func requireReleased(t *testing.T, h *harness.Harness, name string) {
+	for _, inst := range h.Instances {
+		_, err := inst.Client(t, client.Options{}).Get(context.Background(), name)
+		if err != nil && err != client.Nil {
+			continue
+		}
+		require.ErrorIs(t, err, client.Nil, "%s still holds %s", inst.Addr(), name)
+	}
+}
+
+func TestRedlock_MutualExclusion(t *testing.T) {
+	_, r := newTestRedlock(t, 5, Options{TTL: 5 * time.Second, Tries: 1000, RetryDelay: 2 * time.Millisecond})
+	ctx := context.Background()
+
+	var (
+		held   atomic.Bool
+		mu     sync.Mutex
+		fences []int64
+		wg     sync.WaitGroup
+	)
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 5 {
+				l, err := r.Lock(ctx, "resource")
+				if !assertNoError(t, err) {
+					return
+				}
+				if !held.CompareAndSwap(false, true) {
+					t.Error("two holders at the same time")
+				}
+				mu.Lock()
+				fences = append(fences, l.Fence())
+				mu.Unlock()
+				time.Sleep(time.Millisecond)
+				held.Store(false)
+				assertNoError(t, l.Unlock(ctx))
+			}
+		}()
+	}
+	wg.Wait()
+
+	require.Len(t, fences, 40)
+	for i := 1; i < len(fences); i++ {
+		require.Greater(t, fences[i], fences[i-1], "fencing tokens must grow with every holder")
+	}
+}
+
+func assertNoError(t *testing.T, err error) bool {
+	if err != nil {
+		t.Error(err)
+		return false
+	}
+	return true
+}
+
+func TestRedlock_PartialFailures(t *testing.T) {
+	h, r := newTestRedlock(t, 5, Options{TTL: 5 * time.Second, Tries: 1})
+	ctx := context.Background()
+	inst := h.Instances
+
+	// a minority of unavailable servers doesn't matter
+	inst[0].Partition()
+	inst[1].Stop()
+	l, err := r.Lock(ctx, "resource")
+	require.NoError(t, err)
+	require.NoError(t, l.Extend(ctx))
+	require.NoError(t, l.Unlock(ctx))
+
+	// without a majority nothing is acquired and the servers that were locked are released
+	inst[2].Partition()
+	_, err = r.Lock(ctx, "resource")
+	require.ErrorIs(t, err, ErrNotAcquired)
+	requireReleased(t, h, "resource")
+
+	inst[0].Heal()
+	inst[1].Restart(t)
+	inst[2].Heal()
+	l, err = r.Lock(ctx, "resource")
+	require.NoError(t, err)
+
+	// losing the majority while holding the lock is reported by Extend and Unlock
+	inst[0].Stop()
+	inst[1].Stop()
+	inst[2].Stop()
+	require.ErrorIs(t, l.Extend(ctx), ErrLockLost)
+	require.ErrorIs(t, l.Unlock(ctx), ErrLockLost)
+}
+
+func TestRedlock_ClockDrift(t *testing.T) {
+	h, r := newTestRedlock(t, 5, Options{TTL: time.Second, DriftFactor: 0.1, Tries: 1})
+	ctx := context.Background()
+
+	start := time.Now()
+	l, err := r.Lock(ctx, "resource")
+	require.NoError(t, err)
+	// ttl minus 10% drift and 2ms for the ttl precision
+	require.WithinRange(t, l.Until(), start.Add(898*time.Millisecond), time.Now().Add(898*time.Millisecond))
+	require.NoError(t, l.Unlock(ctx))
+
+	// an acquisition that takes longer than the ttl never hands out the lock
+	clock := start
+	r.now = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+	_, err = r.Lock(ctx, "resource")
+	require.ErrorIs(t, err, ErrNotAcquired)
+	requireReleased(t, h, "resource")
+	r.now = time.Now
+
+	// servers whose clocks jump forward expire the lock early, a minority of them can't
+	// let a second holder in
+	l, err = r.Lock(ctx, "resource")
+	require.NoError(t, err)
+	expireEarly := func(inst *harness.Instance) {
+		require.NoError(t, inst.Client(t, client.Options{}).Do(ctx, "DEL", "resource").Err())
+	}
+	expireEarly(h.Instances[0])
+	expireEarly(h.Instances[1])
+
+	other := New(h.Clients(t, client.Options{}), Options{TTL: time.Second, Tries: 1, InstanceTimeout: 250 * time.Millisecond})
+	_, err = other.Lock(ctx, "resource")
+	require.ErrorIs(t, err, ErrNotAcquired)
+	require.NoError(t, l.Extend(ctx), "the lock is still held by a majority")
+
+	expireEarly(h.Instances[2])
+	l2, err := other.Lock(ctx, "resource")
+	require.NoError(t, err, "a majority of drifted clocks breaks the lock")
+	require.Greater(t, l2.Fence(), l.Fence(), "the fencing token lets storage reject the old holder")
+	require.ErrorIs(t, l.Extend(ctx), ErrLockLost)
+	require.ErrorIs(t, l.Unlock(ctx), ErrLockLost)
+	for _, inst := range h.Instances[:3] {
+		token, err := inst.Client(t, client.Options{}).Get(ctx, "resource")
+		require.NoError(t, err)
+		require.Equal(t, l2.Token(), token, "the old holder never touches the new holder's lock")
+	}
+}
+
+func TestRedlock_FencingAcrossPartitions(t *testing.T) {
+	h, r := newTestRedlock(t, 5, Options{TTL: 5 * time.Second, Tries: 1})
+	ctx := context.Background()
+
+	var last int64
+	for i := range 10 {
+		// every acquisition sees a different majority
+		a, b := h.Instances[i%5], h.Instances[(i+2)%5]
+		a.Partition()
+		b.Partition()
+
+		l, err := r.Lock(ctx, "resource")
+		require.NoError(t, err)
+		require.Greater(t, l.Fence(), last)
+		last = l.Fence()
+		require.NoError(t, l.Unlock(ctx))
+
+		a.Heal()
+		b.Heal()
+	}
+}
+
+func TestRedlock_ContextCanceled(t *testing.T) {
+	_, r := newTestRedlock(t, 3, Options{TTL: 5 * time.Second, Tries: 100, RetryDelay: 10 * time.Millisecond})
+
+	l, err := r.Lock(context.Background(), "resource")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = r.Lock(ctx, "resource")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.NoError(t, l.Unlock(context.Background()))
+}
@@ -0,0 +1,278 @@
+package sentinel
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	redis "github.com/Kostaaa1/redis-clone/internal/resp"
+)
+
+var (
+	errInProgress    = errors.New("INPROG Failover already in progress")
+	errNoGoodReplica = errors.New("NOGOODSLAVE No suitable replica to promote")
+)
+
+// serveConn answers the commands of a client or another sentinel until it disconnects
+func (s *Sentinel) serveConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	r := redis.NewReader(conn)
+	w := redis.NewWriter(conn)
+	for {
+		v, err := r.Read()
+		if err != nil {
+			return
+		}
+		if v.Type != "array" || len(v.Array) == 0 {
+			w.Write(errorf("ERR Protocol error: expected a command"))
+			continue
+		}
+		args := make([]string, len(v.Array))
+		for i, arg := range v.Array {
+			args[i] = arg.Bulk
+		}
+		if _, err := w.Write(s.command(args)); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Sentinel) command(args []string) redis.Value {
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		return redis.Value{Type: "string", String: "PONG"}
+	case "INFO":
+		return bulk(s.info())
+	case "SENTINEL":
+		if len(args) < 2 {
+			return errorf("ERR wrong number of arguments for 'sentinel' command")
+		}
+		return s.sentinelCommand(strings.ToUpper(args[1]), args[2:])
+	default:
+		return errorf("ERR unknown command '%s', with args beginning with: %s", args[0], strings.Join(args[1:], " "))
+	}
+}
+
+// sentinelCommand runs SENTINEL sub [arg ...]
+func (s *Sentinel) sentinelCommand(sub string, args []string) redis.Value {
+	arity := map[string]int{
+		"MYID": 0, "MASTERS": 0, "MASTER": 1, "REPLICAS": 1, "SLAVES": 1, "SENTINELS": 1,
+		"GET-MASTER-ADDR-BY-NAME": 1, "IS-MASTER-DOWN-BY-ADDR": 4, "FAILOVER": 1, "CKQUORUM": 1,
+	}
+	n, ok := arity[sub]
+	if !ok {
+		return errorf("ERR Unknown sentinel subcommand '%s'", sub)
+	}
+	if len(args) != n {
+		return errorf("ERR wrong number of arguments for 'sentinel|%s' command", strings.ToLower(sub))
+	}
+
+	if sub == "IS-MASTER-DOWN-BY-ADDR" {
+		epoch, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return errorf("ERR value is not an integer or out of range")
+		}
+		down, leader, leaderEpoch := s.isMasterDown(net.JoinHostPort(args[0], args[1]), epoch, args[3])
+		return array(integer(btoi(down)), bulk(leader), integer(int(leaderEpoch)))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sub == "MYID" {
+		return bulk(s.runID)
+	}
+	if sub == "MASTERS" {
+		var masters []redis.Value
+		for _, name := range slices.Sorted(maps.Keys(s.masters)) {
+			masters = append(masters, s.masterFields(s.masters[name]))
+		}
+		return array(masters...)
+	}
+
+	m := s.masters[args[0]]
+	if m == nil {
+		if sub == "GET-MASTER-ADDR-BY-NAME" {
+			return redis.Value{Type: "null"}
+		}
+		return errorf("ERR No such master with that name")
+	}
+	switch sub {
+	case "MASTER":
+		return s.masterFields(m)
+	case "REPLICAS", "SLAVES":
+		var replicas []redis.Value
+		for _, r := range m.instances()[1:] {
+			replicas = append(replicas, s.replicaFields(m, r))
+		}
+		return array(replicas...)
+	case "SENTINELS":
+		var peers []redis.Value
+		for _, id := range slices.Sorted(maps.Keys(m.sentinels)) {
+			peers = append(peers, peerFields(m.sentinels[id]))
+		}
+		return array(peers...)
+	case "GET-MASTER-ADDR-BY-NAME":
+		host, port, _ := net.SplitHostPort(m.addr())
+		return array(bulk(host), bulk(port))
+	case "FAILOVER":
+		if err := s.forceFailover(m); err != nil {
+			return errorf("%s", err.Error())
+		}
+		return redis.Value{Type: "string", String: "OK"}
+	default: // CKQUORUM
+		usable := 1
+		for _, p := range m.sentinels {
+			if time.Since(p.lastHello) < 5*s.helloPeriod {
+				usable++
+			}
+		}
+		voters := len(m.sentinels) + 1
+		switch {
+		case usable < m.quorum:
+			return errorf("NOQUORUM %d usable Sentinels. Not enough available Sentinels to reach the specified quorum for this master", usable)
+		case usable < voters/2+1:
+			return errorf("NOQUORUM %d usable Sentinels. Not enough available Sentinels to reach the majority and authorize a failover", usable)
+		}
+		return redis.Value{Type: "string", String: fmt.Sprintf("OK %d usable Sentinels. Quorum and failover authorization can be reached", usable)}
+	}
+}
+
+// masterFields describes m for SENTINEL MASTER, called with s.mu held
+func (s *Sentinel) masterFields(m *master) redis.Value {
+	inst := m.inst
+	flags := []string{"master"}
+	if inst.sdown() {
+		flags = append(flags, "s_down")
+	}
+	if m.odown {
+		flags = append(flags, "o_down")
+	}
+	if m.failover != failoverNone {
+		flags = append(flags, "failover_in_progress")
+	}
+	host, port, _ := net.SplitHostPort(inst.addr)
+	fields := []string{
+		"name", m.name,
+		"ip", host,
+		"port", port,
+		"runid", inst.runID,
+		"flags", strings.Join(flags, ","),
+		"last-ok-ping-reply", formatMs(time.Since(inst.lastOK)),
+		"down-after-milliseconds", formatMs(m.downAfter),
+		"info-refresh", formatMs(time.Since(inst.infoAt)),
+		"role-reported", inst.role,
+		"config-epoch", strconv.FormatInt(m.configEpoch, 10),
+		"num-slaves", strconv.Itoa(len(m.replicas)),
+		"num-other-sentinels", strconv.Itoa(len(m.sentinels)),
+		"quorum", strconv.Itoa(m.quorum),
+		"failover-timeout", formatMs(m.failoverTimeout),
+	}
+	if m.failover != failoverNone {
+		fields = append(fields, "failover-state", m.failover.String())
+	}
+	return array(bulks(fields...)...)
+}
+
+// replicaFields describes the replica r of m for SENTINEL REPLICAS, called with s.mu held
+func (s *Sentinel) replicaFields(m *master, r *instance) redis.Value {
+	flags := []string{"slave"}
+	if r.sdown() {
+		flags = append(flags, "s_down")
+	}
+	if r == m.promoted {
+		flags = append(flags, "promoted")
+	}
+	host, port, _ := net.SplitHostPort(r.addr)
+	masterHost, masterPort, _ := net.SplitHostPort(r.masterAddr)
+	status := "err"
+	if r.linkUp {
+		status = "ok"
+	}
+	return array(bulks(
+		"name", r.addr,
+		"ip", host,
+		"port", port,
+		"runid", r.runID,
+		"flags", strings.Join(flags, ","),
+		"last-ok-ping-reply", formatMs(time.Since(r.lastOK)),
+		"info-refresh", formatMs(time.Since(r.infoAt)),
+		"role-reported", r.role,
+		"master-link-down-time", formatMs(r.linkDown),
+		"master-link-status", status,
+		"master-host", masterHost,
+		"master-port", masterPort,
+		"slave-priority", strconv.Itoa(r.priority),
+		"slave-repl-offset", strconv.FormatInt(r.offset, 10),
+	)...)
+}
+
+func peerFields(p *peer) redis.Value {
+	host, port, _ := net.SplitHostPort(p.addr)
+	return array(bulks(
+		"name", p.runID,
+		"ip", host,
+		"port", port,
+		"runid", p.runID,
+		"flags", "sentinel",
+		"last-hello-message", formatMs(time.Since(p.lastHello)),
+		"voted-leader", cmp.Or(p.leader, "?"),
+		"voted-leader-epoch", strconv.FormatInt(p.leaderEpoch, 10),
+	)...)
+}
+
+// info formats INFO, the sentinel section only
+func (s *Sentinel) info() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Sentinel\r\nsentinel_masters:%d\r\nsentinel_tilt:0\r\n", len(s.masters))
+	for i, name := range slices.Sorted(maps.Keys(s.masters)) {
+		m := s.masters[name]
+		status := "ok"
+		if m.odown {
+			status = "odown"
+		} else if m.inst.sdown() {
+			status = "sdown"
+		}
+		fmt.Fprintf(&b, "master%d:name=%s,status=%s,address=%s,slaves=%d,sentinels=%d\r\n",
+			i, m.name, status, m.inst.addr, len(m.replicas), len(m.sentinels)+1)
+	}
+	return b.String()
+}
+
+func formatMs(d time.Duration) string {
+	return strconv.FormatInt(d.Milliseconds(), 10)
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func bulk(s string) redis.Value { return redis.Value{Type: "bulk", Bulk: s} }
+func integer(n int) redis.Value { return redis.Value{Type: "integer", Int: n} }
+func array(v ...redis.Value) redis.Value {
+	if v == nil {
+		v = []redis.Value{}
+	}
+	return redis.Value{Type: "array", Array: v}
+}
+
+func errorf(format string, args ...any) redis.Value {
+	return redis.Value{Type: "error", String: fmt.Sprintf(format, args...)}
+}
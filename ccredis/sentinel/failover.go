@@ -0,0 +1,368 @@
+package sentinel
+
+import (
+	"math/rand/v2"
+	"net"
+	"time"
+)
+
+type failoverState int
+
+const (
+	failoverNone failoverState = iota
+	// waiting to be elected the leader of the failover epoch
+	failoverWaitStart
+	failoverSelectReplica
+	// REPLICAOF NO ONE was sent, waiting for INFO of the replica to report it as a primary
+	failoverWaitPromotion
+	// pointing the other replicas at the promoted one
+	failoverReconfReplicas
+)
+
+var failoverStates = []string{"none", "wait_start", "select_slave", "wait_promotion", "reconf_slaves"}
+
+func (st failoverState) String() string { return failoverStates[st] }
+
+func (s *Sentinel) setFailoverState(m *master, st failoverState) {
+	m.failover = st
+	m.stateChanged = time.Now()
+}
+
+// run checks the state of m and advances its failover every tickPeriod until the sentinel closes
+func (s *Sentinel) run(m *master) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(tickPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		s.mu.Lock()
+		s.checkDown(m)
+		asks := s.peersToAsk(m)
+		s.startFailoverIfNeeded(m)
+		s.mu.Unlock()
+
+		for _, a := range asks {
+			s.wg.Add(1)
+			go s.ask(m, a)
+		}
+		s.failoverStep(m)
+	}
+}
+
+// checkDown updates the subjective down state of the instances of m and the objective down
+// state of the primary, called with s.mu held
+func (s *Sentinel) checkDown(m *master) {
+	now := time.Now()
+	for _, inst := range m.instances() {
+		down := now.Sub(inst.lastOK) > m.downAfter
+		switch {
+		case down && !inst.sdown():
+			inst.sdownSince = now
+			s.event("+sdown", "%s %s %s", m.role(inst), m.name, inst.addr)
+		case !down && inst.sdown():
+			inst.sdownSince = time.Time{}
+			s.event("-sdown", "%s %s %s", m.role(inst), m.name, inst.addr)
+		}
+	}
+
+	votes := 0
+	if m.inst.sdown() {
+		votes++
+		for _, p := range m.sentinels {
+			// an answer that got old no longer counts
+			if p.masterDown && now.Sub(p.downReplyAt) > 5*askPeriod {
+				p.masterDown = false
+			}
+			if p.masterDown {
+				votes++
+			}
+		}
+	}
+	odown := m.inst.sdown() && votes >= m.quorum
+	switch {
+	case odown && !m.odown:
+		m.odown, m.odownSince = true, now
+		m.startDelay = rand.N(maxDesync)
+		s.event("+odown", "master %s %s #quorum %d/%d", m.name, m.inst.addr, votes, m.quorum)
+	case !odown && m.odown:
+		m.odown = false
+		s.event("-odown", "master %s %s", m.name, m.inst.addr)
+	}
+}
+
+// askRequest is an IS-MASTER-DOWN-BY-ADDR request to a peer
+type askRequest struct {
+	p     *peer
+	addr  string
+	epoch int64
+	// this sentinel's run id to ask for a vote, * to only ask whether the primary is down
+	runID string
+}
+
+// peersToAsk returns the requests to send to the other sentinels while the primary is down,
+// called with s.mu held
+func (s *Sentinel) peersToAsk(m *master) []askRequest {
+	if !m.inst.sdown() {
+		return nil
+	}
+	runID, epoch := "*", s.currentEpoch
+	if m.failover != failoverNone {
+		runID, epoch = s.runID, m.failoverEpoch
+	}
+	now := time.Now()
+	var asks []askRequest
+	for _, p := range m.sentinels {
+		if p.asking || (!m.askNow && now.Sub(p.lastAsk) < askPeriod) {
+			continue
+		}
+		p.asking, p.lastAsk = true, now
+		asks = append(asks, askRequest{p: p, addr: m.inst.addr, epoch: epoch, runID: runID})
+	}
+	m.askNow = false
+	return asks
+}
+
+func (s *Sentinel) ask(m *master, a askRequest) {
+	defer s.wg.Done()
+	host, port, _ := net.SplitHostPort(a.addr)
+	v, err := a.p.client.Do(s.ctx, "SENTINEL", "IS-MASTER-DOWN-BY-ADDR", host, port, a.epoch, a.runID).Result()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a.p.asking = false
+	if err != nil || v.Type != "array" || len(v.Array) != 3 || m.inst.addr != a.addr {
+		return
+	}
+	a.p.masterDown = v.Array[0].Int == 1
+	a.p.downReplyAt = time.Now()
+	if leader := v.Array[1].Bulk; leader != "*" {
+		a.p.leader, a.p.leaderEpoch = leader, int64(v.Array[2].Int)
+	}
+}
+
+// voteLeader votes for runID as the leader of epoch, unless this sentinel already voted in
+// that epoch, and returns the current vote. Called with s.mu held.
+func (s *Sentinel) voteLeader(m *master, epoch int64, runID string) (string, int64) {
+	if epoch > s.currentEpoch {
+		s.currentEpoch = epoch
+		s.event("+new-epoch", "%d", epoch)
+	}
+	if m.leaderEpoch < epoch && s.currentEpoch <= epoch {
+		m.leader, m.leaderEpoch = runID, s.currentEpoch
+		s.event("+vote-for-leader", "%s %d", runID, epoch)
+		// don't start a failover of our own while the leader we voted for does its job
+		if runID != s.runID {
+			m.failoverStart = time.Now().Add(rand.N(maxDesync))
+		}
+	}
+	return m.leader, m.leaderEpoch
+}
+
+// startFailoverIfNeeded starts a failover of a primary that is objectively down, unless one was
+// attempted recently. Called with s.mu held.
+func (s *Sentinel) startFailoverIfNeeded(m *master) {
+	if !m.odown || m.failover != failoverNone || time.Since(m.failoverStart) < 2*m.failoverTimeout {
+		return
+	}
+	// sentinels see the primary down at about the same time, the random delay lets the first
+	// one to try collect the votes of the others instead of everyone voting for itself
+	if time.Since(m.odownSince) < m.startDelay {
+		return
+	}
+	s.currentEpoch++
+	m.failoverEpoch = s.currentEpoch
+	s.event("+new-epoch", "%d", s.currentEpoch)
+	s.event("+try-failover", "master %s %s", m.name, m.inst.addr)
+	s.setFailoverState(m, failoverWaitStart)
+	m.failoverStart = time.Now().Add(rand.N(maxDesync))
+	m.askNow = true
+}
+
+// leaderOf returns the sentinel that won the election of epoch, if any. This sentinel votes
+// for the one most voted by the others, or for itself. Called with s.mu held.
+func (s *Sentinel) leaderOf(m *master, epoch int64) string {
+	votes := make(map[string]int)
+	for _, p := range m.sentinels {
+		if p.leader != "" && p.leaderEpoch == epoch {
+			votes[p.leader]++
+		}
+	}
+	candidate := s.runID
+	if winner, _ := mostVoted(votes); winner != "" {
+		candidate = winner
+	}
+	if vote, voteEpoch := s.voteLeader(m, epoch, candidate); voteEpoch == epoch {
+		votes[vote]++
+	}
+
+	winner, n := mostVoted(votes)
+	voters := len(m.sentinels) + 1
+	if n < voters/2+1 || n < m.quorum {
+		return ""
+	}
+	return winner
+}
+
+func mostVoted(votes map[string]int) (string, int) {
+	var winner string
+	best := 0
+	for id, n := range votes {
+		if n > best || n == best && id > winner {
+			winner, best = id, n
+		}
+	}
+	return winner, best
+}
+
+func (s *Sentinel) abortFailover(m *master) {
+	s.setFailoverState(m, failoverNone)
+	m.promoted = nil
+	m.forced = false
+}
+
+// selectReplica returns the replica to promote: among the healthy replicas with a recent INFO
+// and a link to the primary that wasn't down for too long, the one with the lowest priority,
+// then the greatest offset, then the smallest run id. Priority 0 is never promoted. Called
+// with s.mu held.
+func (s *Sentinel) selectReplica(m *master) *instance {
+	now := time.Now()
+	maxLinkDown, maxInfoAge := 10*m.downAfter, 3*s.infoPeriod
+	if m.inst.sdown() {
+		maxLinkDown += now.Sub(m.inst.sdownSince)
+		maxInfoAge = 5 * s.failoverInfoPeriod
+	}
+	var best *instance
+	for _, r := range m.instances()[1:] {
+		if r.sdown() || r.role != "slave" || r.priority == 0 ||
+			now.Sub(r.lastOK) > 5*m.pingPeriod() ||
+			now.Sub(r.infoAt) > maxInfoAge ||
+			r.linkDown > maxLinkDown {
+			continue
+		}
+		if best == nil || r.priority < best.priority ||
+			r.priority == best.priority && (r.offset > best.offset || r.offset == best.offset && r.runID < best.runID) {
+			best = r
+		}
+	}
+	return best
+}
+
+// failoverStep advances the failover of m by at most one state
+func (s *Sentinel) failoverStep(m *master) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch m.failover {
+	case failoverWaitStart:
+		if !m.forced && s.leaderOf(m, m.failoverEpoch) != s.runID {
+			if time.Since(m.stateChanged) > min(electionTimeout, m.failoverTimeout) {
+				s.event("-failover-abort-not-elected", "master %s %s", m.name, m.inst.addr)
+				s.abortFailover(m)
+			}
+			return
+		}
+		s.event("+elected-leader", "master %s %s", m.name, m.inst.addr)
+		s.setFailoverState(m, failoverSelectReplica)
+
+	case failoverSelectReplica:
+		r := s.selectReplica(m)
+		if r == nil {
+			s.event("-failover-abort-no-good-slave", "master %s %s", m.name, m.inst.addr)
+			s.abortFailover(m)
+			return
+		}
+		s.event("+selected-slave", "slave %s %s @ %s %s", r.addr, m.name, m.name, m.inst.addr)
+		m.promoted = r
+		s.setFailoverState(m, failoverWaitPromotion)
+
+		s.mu.Unlock()
+		err := r.client.Do(s.ctx, "REPLICAOF", "NO", "ONE").Err()
+		s.mu.Lock()
+		if err != nil {
+			// the promotion times out unless INFO shows the command made it anyway
+			s.event("-failover-send-slaveof-noone", "slave %s %s: %v", r.addr, m.name, err)
+		}
+
+	case failoverWaitPromotion:
+		if time.Since(m.stateChanged) > m.failoverTimeout {
+			s.event("-failover-abort-slave-timeout", "master %s %s", m.name, m.inst.addr)
+			s.abortFailover(m)
+		}
+
+	case failoverReconfReplicas:
+		promoted := m.promoted
+		var send []*instance
+		done := true
+		for _, r := range m.replicas {
+			if r == promoted || r.sdown() {
+				continue
+			}
+			if r.reconfSent.Before(m.stateChanged) {
+				r.reconfSent = time.Now()
+				send = append(send, r)
+			}
+			if r.role != "slave" || r.masterAddr != promoted.addr || !r.linkUp {
+				done = false
+			}
+		}
+		if timedOut := time.Since(m.stateChanged) > m.failoverTimeout; done || timedOut {
+			if timedOut {
+				s.event("-failover-end-for-timeout", "master %s %s", m.name, m.inst.addr)
+			}
+			s.event("+failover-end", "master %s %s", m.name, m.inst.addr)
+			s.switchMaster(m, promoted.addr)
+			return
+		}
+
+		host, port, _ := net.SplitHostPort(promoted.addr)
+		s.mu.Unlock()
+		for _, r := range send {
+			if err := r.client.Do(s.ctx, "REPLICAOF", host, port).Err(); err == nil {
+				s.event("+slave-reconf-sent", "slave %s %s @ %s %s", r.addr, m.name, m.name, promoted.addr)
+			}
+		}
+		s.mu.Lock()
+	}
+}
+
+// isMasterDown answers IS-MASTER-DOWN-BY-ADDR: whether the primary at addr is down for this
+// sentinel and, when runID isn't *, the vote for the leader of epoch
+func (s *Sentinel) isMasterDown(addr string, epoch int64, runID string) (down bool, leader string, leaderEpoch int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	leader = "*"
+	for _, m := range s.masters {
+		if m.inst.addr != addr {
+			continue
+		}
+		down = m.inst.sdown()
+		if runID != "*" {
+			leader, leaderEpoch = s.voteLeader(m, epoch, runID)
+		}
+		break
+	}
+	return down, leader, leaderEpoch
+}
+
+// forceFailover starts a failover without the agreement of the other sentinels, like SENTINEL FAILOVER
+func (s *Sentinel) forceFailover(m *master) error {
+	if m.failover != failoverNone {
+		return errInProgress
+	}
+	if s.selectReplica(m) == nil {
+		return errNoGoodReplica
+	}
+	s.currentEpoch++
+	m.failoverEpoch = s.currentEpoch
+	m.failoverStart = time.Now()
+	m.forced = true
+	s.event("+new-epoch", "%d", s.currentEpoch)
+	s.event("+try-failover", "master %s %s", m.name, m.inst.addr)
+	s.setFailoverState(m, failoverWaitStart)
+	return nil
+}
@@ -0,0 +1,292 @@
+package sentinel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Kostaaa1/redis-clone/client"
+	redis "github.com/Kostaaa1/redis-clone/internal/resp"
+)
+
+const helloChannel = "__sentinel__:hello"
+
+// pingPeriod is how often the instances of m are pinged
+func (m *master) pingPeriod() time.Duration {
+	return min(time.Second, m.downAfter)
+}
+
+// monitor pings inst, reads its INFO and publishes hello messages on it until the sentinel closes
+func (s *Sentinel) monitor(m *master, inst *instance) {
+	defer s.wg.Done()
+	var lastInfo, lastHello time.Time
+	for {
+		s.ping(inst)
+		if time.Since(lastInfo) >= s.infoEvery(m) {
+			lastInfo = time.Now()
+			s.refreshInfo(m, inst)
+		}
+		if time.Since(lastHello) >= s.helloPeriod {
+			lastHello = time.Now()
+			s.sendHello(m, inst)
+		}
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-time.After(m.pingPeriod()):
+		}
+	}
+}
+
+// infoEvery is how often INFO is read, more often while the primary is down or failed over
+func (s *Sentinel) infoEvery(m *master) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m.inst.sdown() || m.failover != failoverNone {
+		return s.failoverInfoPeriod
+	}
+	return s.infoPeriod
+}
+
+func (s *Sentinel) ping(inst *instance) {
+	err := inst.client.Do(s.ctx, "PING").Err()
+	// an instance loading its dataset or without a link to its primary is alive
+	var rerr client.Error
+	valid := err == nil || errors.As(err, &rerr) &&
+		(strings.HasPrefix(string(rerr), "LOADING") || strings.HasPrefix(string(rerr), "MASTERDOWN"))
+	if valid {
+		s.mu.Lock()
+		inst.lastOK = time.Now()
+		s.mu.Unlock()
+	}
+}
+
+func (s *Sentinel) refreshInfo(m *master, inst *instance) {
+	info, err := inst.client.Do(s.ctx, "INFO").Text()
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	target := s.applyInfo(m, inst, parseInfo(info))
+	s.mu.Unlock()
+	if target == "" {
+		return
+	}
+	host, port, _ := net.SplitHostPort(target)
+	inst.client.Do(s.ctx, "REPLICAOF", host, port)
+}
+
+// parseInfo splits an INFO reply into its fields
+func parseInfo(info string) map[string]string {
+	fields := make(map[string]string)
+	for _, line := range strings.Split(info, "\r\n") {
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if k, v, ok := strings.Cut(line, ":"); ok {
+			fields[k] = v
+		}
+	}
+	return fields
+}
+
+// replicaAddr returns the address in a slaveN line of INFO replication, e.g.
+// ip=127.0.0.1,port=6381,state=online,offset=0,lag=0
+func replicaAddr(line string) string {
+	var ip, port string
+	for _, kv := range strings.Split(line, ",") {
+		k, v, _ := strings.Cut(kv, "=")
+		switch k {
+		case "ip":
+			ip = v
+		case "port":
+			port = v
+		}
+	}
+	if ip == "" || port == "" {
+		return ""
+	}
+	return net.JoinHostPort(ip, port)
+}
+
+// applyInfo updates inst from its INFO fields and returns the address of the primary it must be
+// pointed at with REPLICAOF, if it reports the wrong role or primary. Called with s.mu held.
+func (s *Sentinel) applyInfo(m *master, inst *instance, f map[string]string) string {
+	now := time.Now()
+	inst.infoAt = now
+	inst.runID = f["run_id"]
+	if role := f["role"]; role != inst.role {
+		inst.role = role
+		inst.roleSince = now
+	}
+
+	switch inst.role {
+	case "master":
+		if inst == m.inst {
+			for k, v := range f {
+				if _, err := strconv.Atoi(strings.TrimPrefix(k, "slave")); err == nil && strings.HasPrefix(k, "slave") {
+					if addr := replicaAddr(v); addr != "" {
+						s.addReplica(m, addr)
+					}
+				}
+			}
+		}
+	case "slave":
+		inst.masterAddr = net.JoinHostPort(f["master_host"], f["master_port"])
+		inst.linkUp = f["master_link_status"] == "up"
+		down, _ := strconv.Atoi(f["master_link_down_since_seconds"])
+		inst.linkDown = time.Duration(down) * time.Second
+		inst.priority = 100
+		if p, err := strconv.Atoi(f["slave_priority"]); err == nil {
+			inst.priority = p
+		}
+		inst.offset, _ = strconv.ParseInt(f["slave_repl_offset"], 10, 64)
+	}
+
+	if inst == m.promoted && m.failover == failoverWaitPromotion && inst.role == "master" {
+		m.configEpoch = m.failoverEpoch
+		s.event("+promoted-slave", "slave %s %s @ %s %s", inst.addr, m.name, m.name, m.inst.addr)
+		s.setFailoverState(m, failoverReconfReplicas)
+		return ""
+	}
+
+	// A replica that reports the wrong role or primary, e.g. an old primary back after a
+	// failover, is fixed once the configuration had time to reach every sentinel. Never while
+	// the primary is down or failed over, a newer configuration may not have arrived yet.
+	if inst == m.inst || m.inst.sdown() || m.failover != failoverNone {
+		return ""
+	}
+	wait := 4 * s.helloPeriod
+	if now.Sub(inst.roleSince) < wait || now.Sub(inst.reconfSent) < wait {
+		return ""
+	}
+	switch {
+	case inst.role == "master":
+		s.event("+convert-to-slave", "slave %s %s @ %s %s", inst.addr, m.name, m.name, m.inst.addr)
+	case inst.role == "slave" && inst.masterAddr != m.inst.addr:
+		s.event("+fix-slave-config", "slave %s %s @ %s %s", inst.addr, m.name, m.name, m.inst.addr)
+	default:
+		return ""
+	}
+	inst.reconfSent = now
+	return m.inst.addr
+}
+
+// sendHello publishes the address of this sentinel and its configuration of m on inst
+func (s *Sentinel) sendHello(m *master, inst *instance) {
+	s.mu.Lock()
+	host, port, _ := net.SplitHostPort(s.addr)
+	mhost, mport, _ := net.SplitHostPort(m.addr())
+	msg := fmt.Sprintf("%s,%s,%s,%d,%s,%s,%s,%d", host, port, s.runID, s.currentEpoch, m.name, mhost, mport, m.configEpoch)
+	s.mu.Unlock()
+	inst.client.Publish(s.ctx, helloChannel, msg)
+}
+
+// subscribe reads the hello messages published on inst until the sentinel closes
+func (s *Sentinel) subscribe(m *master, inst *instance) {
+	defer s.wg.Done()
+	for {
+		s.readHellos(m, inst)
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-time.After(m.pingPeriod()):
+		}
+	}
+}
+
+// readHellos subscribes to the hello channel of inst and processes its messages until the
+// connection breaks
+func (s *Sentinel) readHellos(m *master, inst *instance) {
+	d := net.Dialer{Timeout: m.downAfter}
+	conn, err := d.DialContext(s.ctx, "tcp", inst.addr)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(s.ctx, func() { conn.Close() })
+	defer stop()
+
+	w := redis.NewWriter(conn)
+	r := redis.NewReader(conn)
+	if _, err := w.Write(redis.Value{Type: "array", Array: bulks("SUBSCRIBE", helloChannel)}); err != nil {
+		return
+	}
+	for {
+		// this sentinel publishes on inst too, a silent connection is a dead one
+		conn.SetReadDeadline(time.Now().Add(3*s.helloPeriod + m.downAfter))
+		v, err := r.Read()
+		if err != nil {
+			return
+		}
+		if (v.Type == "array" || v.Type == "push") && len(v.Array) == 3 && v.Array[0].Bulk == "message" {
+			s.processHello(v.Array[2].Bulk)
+		}
+	}
+}
+
+// processHello learns about another sentinel and adopts its configuration of the primary when
+// its epoch is newer, e.g. after that sentinel failed the primary over. A hello is
+// ip,port,runid,current_epoch,master_name,master_ip,master_port,master_config_epoch.
+func (s *Sentinel) processHello(msg string) {
+	f := strings.Split(msg, ",")
+	if len(f) != 8 {
+		return
+	}
+	epoch, err1 := strconv.ParseInt(f[3], 10, 64)
+	configEpoch, err2 := strconv.ParseInt(f[7], 10, 64)
+	if err1 != nil || err2 != nil {
+		return
+	}
+	runID, addr, masterAddr := f[2], net.JoinHostPort(f[0], f[1]), net.JoinHostPort(f[5], f[6])
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.masters[f[4]]
+	if m == nil || runID == s.runID {
+		return
+	}
+
+	p := m.sentinels[runID]
+	if p == nil {
+		// a sentinel restarted with a new run id at the same address replaces its old entry
+		for id, old := range m.sentinels {
+			if old.addr == addr {
+				old.client.Close()
+				delete(m.sentinels, id)
+			}
+		}
+		p = s.newPeer(runID, addr)
+		m.sentinels[runID] = p
+		s.event("+sentinel", "sentinel %s %s @ %s %s", runID, addr, m.name, m.inst.addr)
+	} else if p.addr != addr {
+		p.client.Close()
+		np := s.newPeer(runID, addr)
+		p.addr, p.client = np.addr, np.client
+	}
+	p.lastHello = time.Now()
+
+	if epoch > s.currentEpoch {
+		s.currentEpoch = epoch
+		s.event("+new-epoch", "%d", epoch)
+	}
+	if configEpoch > m.configEpoch {
+		m.configEpoch = configEpoch
+		if masterAddr != m.inst.addr {
+			s.event("+config-update-from", "sentinel %s %s @ %s %s", runID, addr, m.name, m.inst.addr)
+			s.switchMaster(m, masterAddr)
+		}
+	}
+}
+
+func bulks(args ...string) []redis.Value {
+	vals := make([]redis.Value, len(args))
+	for i, arg := range args {
+		vals[i] = redis.Value{Type: "bulk", Bulk: arg}
+	}
+	return vals
+}
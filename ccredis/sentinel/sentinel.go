@@ -0,0 +1,419 @@
+// Package sentinel monitors primaries and their replicas and fails a primary over to one of
+// its replicas when it goes down, like Redis Sentinel.
+//
+// Every sentinel pings the instances it monitors and reads INFO to discover the replicas of a
+// primary. A primary that doesn't answer for DownAfter is subjectively down for that sentinel,
+// it becomes objectively down once Quorum sentinels agree, which a sentinel learns by asking
+// the others with SENTINEL IS-MASTER-DOWN-BY-ADDR. The sentinels then elect a leader for a new
+// epoch with the same command, the leader promotes the best replica with REPLICAOF NO ONE and
+// points the other replicas at it. Sentinels find each other, and learn about the outcome of a
+// failover, through hello messages they publish on the __sentinel__:hello channel of every
+// monitored instance. The configuration with the greatest epoch wins.
+//
+// Clients ask a sentinel for the current primary with SENTINEL GET-MASTER-ADDR-BY-NAME.
+package sentinel
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Kostaaa1/redis-clone/client"
+)
+
+const (
+	// how often the master goroutine checks the state of its instances and advances a failover
+	tickPeriod = 100 * time.Millisecond
+	// how often other sentinels are asked whether they see the primary down, their answer counts
+	// for five periods
+	askPeriod = time.Second
+	// a sentinel that loses an election gives up after this long, or the failover timeout if shorter
+	electionTimeout = 10 * time.Second
+	// upper bound of the random delays that keep sentinels from trying to fail over the same
+	// primary at the same time
+	maxDesync = time.Second
+)
+
+type MasterConfig struct {
+	// Name is what clients ask for with SENTINEL GET-MASTER-ADDR-BY-NAME
+	Name string
+	// host:port of the primary
+	Addr string
+	// number of sentinels that must agree the primary is down before it is failed over
+	Quorum int
+	// how long the primary may not reply to PING before this sentinel considers it down,
+	// defaults to 30s
+	DownAfter time.Duration
+	// how long a failover may take before it is aborted, another failover of the same primary
+	// can start twice as long after the previous one. Defaults to 3m.
+	FailoverTimeout time.Duration
+}
+
+type Config struct {
+	// host:port the sentinel serves on, defaults to 127.0.0.1:26380
+	Addr    string
+	Masters []MasterConfig
+}
+
+func (cfg *Config) init() error {
+	if cfg.Addr == "" {
+		cfg.Addr = "127.0.0.1:26380"
+	}
+	seen := make(map[string]bool, len(cfg.Masters))
+	for i := range cfg.Masters {
+		m := &cfg.Masters[i]
+		if m.Name == "" || seen[m.Name] {
+			return fmt.Errorf("sentinel: missing or duplicate master name %q", m.Name)
+		}
+		seen[m.Name] = true
+		if _, _, err := net.SplitHostPort(m.Addr); err != nil {
+			return fmt.Errorf("sentinel: master %s: %w", m.Name, err)
+		}
+		if m.Quorum <= 0 {
+			return fmt.Errorf("sentinel: master %s: quorum must be positive", m.Name)
+		}
+		if m.DownAfter == 0 {
+			m.DownAfter = 30 * time.Second
+		}
+		if m.FailoverTimeout == 0 {
+			m.FailoverTimeout = 3 * time.Minute
+		}
+	}
+	return nil
+}
+
+// Sentinel monitors the primaries of its Config once it serves
+type Sentinel struct {
+	cfg   Config
+	runID string
+
+	// how often INFO is read from an instance, normally and while its primary is down or
+	// being failed over, and how often hello messages are published. Shortened in tests.
+	infoPeriod         time.Duration
+	failoverInfoPeriod time.Duration
+	helloPeriod        time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu sync.Mutex
+	ln net.Listener
+	// address announced to the other sentinels
+	addr         string
+	currentEpoch int64
+	masters      map[string]*master
+	conns        map[net.Conn]struct{}
+	closed       bool
+}
+
+// master is a monitored primary, its replicas and the other sentinels that monitor it.
+// Fields are guarded by Sentinel.mu.
+type master struct {
+	name            string
+	quorum          int
+	downAfter       time.Duration
+	failoverTimeout time.Duration
+
+	inst        *instance
+	replicas    map[string]*instance
+	sentinels   map[string]*peer
+	configEpoch int64
+
+	odown      bool
+	odownSince time.Time
+	// how long after odownSince this sentinel tries to fail the primary over
+	startDelay time.Duration
+	// the sentinel this one voted for as the leader of leaderEpoch
+	leader      string
+	leaderEpoch int64
+
+	failover      failoverState
+	failoverEpoch int64
+	// when the last failover started, or when this sentinel voted for another leader
+	failoverStart time.Time
+	stateChanged  time.Time
+	promoted      *instance
+	// SENTINEL FAILOVER skips the agreement of the other sentinels
+	forced bool
+	// ask the other sentinels for their vote right away
+	askNow bool
+}
+
+// instance is a primary or replica as seen by the sentinel
+type instance struct {
+	addr   string
+	client *client.Client
+
+	// last valid reply to PING, and since when the instance is subjectively down
+	lastOK     time.Time
+	sdownSince time.Time
+
+	// from the last INFO
+	infoAt     time.Time
+	runID      string
+	role       string
+	roleSince  time.Time
+	masterAddr string
+	linkUp     bool
+	linkDown   time.Duration
+	priority   int
+	offset     int64
+	// when REPLICAOF was last sent to fix the role or primary of the instance
+	reconfSent time.Time
+}
+
+// peer is another sentinel monitoring the same primary
+type peer struct {
+	runID  string
+	addr   string
+	client *client.Client
+
+	lastHello time.Time
+	// its last answer to IS-MASTER-DOWN-BY-ADDR
+	masterDown  bool
+	downReplyAt time.Time
+	leader      string
+	leaderEpoch int64
+	lastAsk     time.Time
+	asking      bool
+}
+
+func (inst *instance) sdown() bool { return !inst.sdownSince.IsZero() }
+
+// New returns a sentinel for cfg, it starts monitoring with Serve or ListenAndServe
+func New(cfg Config) (*Sentinel, error) {
+	if err := cfg.init(); err != nil {
+		return nil, err
+	}
+	id := make([]byte, 20)
+	rand.Read(id)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Sentinel{
+		cfg:                cfg,
+		runID:              hex.EncodeToString(id),
+		infoPeriod:         10 * time.Second,
+		failoverInfoPeriod: time.Second,
+		helloPeriod:        2 * time.Second,
+		ctx:                ctx,
+		cancel:             cancel,
+		masters:            make(map[string]*master, len(cfg.Masters)),
+		conns:              make(map[net.Conn]struct{}),
+	}
+	for _, mc := range cfg.Masters {
+		m := &master{
+			name:            mc.Name,
+			quorum:          mc.Quorum,
+			downAfter:       mc.DownAfter,
+			failoverTimeout: mc.FailoverTimeout,
+			replicas:        make(map[string]*instance),
+			sentinels:       make(map[string]*peer),
+		}
+		m.inst = s.newInstance(m, mc.Addr)
+		s.masters[m.name] = m
+	}
+	return s, nil
+}
+
+// RunID identifies the sentinel to the other sentinels
+func (s *Sentinel) RunID() string { return s.runID }
+
+// Addr is the address the sentinel serves on, empty until it serves
+func (s *Sentinel) Addr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addr
+}
+
+// ListenAndServe listens on Config.Addr and serves until Close
+func (s *Sentinel) ListenAndServe() error {
+	ln, err := net.Listen("tcp", s.cfg.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve starts monitoring and answers the commands of clients and other sentinels on ln until Close
+func (s *Sentinel) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		ln.Close()
+		return errors.New("sentinel: closed")
+	}
+	s.ln = ln
+	s.addr = ln.Addr().String()
+	for _, m := range s.masters {
+		log.Printf("+monitor master %s %s quorum %d", m.name, m.inst.addr, m.quorum)
+		s.watch(m, m.inst)
+		s.wg.Add(1)
+		go s.run(m)
+	}
+	s.mu.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if s.ctx.Err() != nil {
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			continue
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops monitoring and serving and waits for every goroutine of the sentinel
+func (s *Sentinel) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.cancel()
+	if s.ln != nil {
+		s.ln.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	for _, m := range s.masters {
+		for _, inst := range m.instances() {
+			inst.client.Close()
+		}
+		for _, p := range m.sentinels {
+			p.client.Close()
+		}
+	}
+	return nil
+}
+
+func (s *Sentinel) newInstance(m *master, addr string) *instance {
+	return &instance{
+		addr: addr,
+		client: client.New(client.Options{
+			Addr:        addr,
+			ClientName:  "sentinel-" + s.runID[:8] + "-cmd",
+			PoolSize:    2,
+			DialTimeout: m.downAfter,
+			ReadTimeout: m.downAfter,
+		}),
+		lastOK:   time.Now(),
+		priority: 100,
+	}
+}
+
+func (s *Sentinel) newPeer(runID, addr string) *peer {
+	return &peer{
+		runID: runID,
+		addr:  addr,
+		client: client.New(client.Options{
+			Addr:        addr,
+			PoolSize:    2,
+			DialTimeout: askPeriod,
+			ReadTimeout: askPeriod,
+		}),
+	}
+}
+
+// instances returns the primary followed by its replicas sorted by address
+func (m *master) instances() []*instance {
+	all := []*instance{m.inst}
+	for _, addr := range slices.Sorted(maps.Keys(m.replicas)) {
+		all = append(all, m.replicas[addr])
+	}
+	return all
+}
+
+// addr is the address of the primary given to clients and other sentinels, the promoted replica
+// once it took over
+func (m *master) addr() string {
+	if m.failover == failoverReconfReplicas {
+		return m.promoted.addr
+	}
+	return m.inst.addr
+}
+
+// role names inst in events and replies
+func (m *master) role(inst *instance) string {
+	if inst == m.inst {
+		return "master"
+	}
+	return "slave"
+}
+
+// addReplica starts monitoring a replica reported by the primary
+func (s *Sentinel) addReplica(m *master, addr string) {
+	if addr == m.inst.addr || m.replicas[addr] != nil {
+		return
+	}
+	inst := s.newInstance(m, addr)
+	m.replicas[addr] = inst
+	s.event("+slave", "slave %s %s @ %s %s", addr, m.name, m.name, m.inst.addr)
+	s.watch(m, inst)
+}
+
+// switchMaster makes the instance at addr the primary of m, the previous primary becomes one
+// of its replicas. Called when a failover ends and for a newer configuration of another sentinel.
+func (s *Sentinel) switchMaster(m *master, addr string) {
+	old := m.inst
+	next := m.replicas[addr]
+	if next == nil {
+		next = s.newInstance(m, addr)
+		s.watch(m, next)
+	}
+	delete(m.replicas, addr)
+	m.inst = next
+	m.replicas[old.addr] = old
+
+	m.odown = false
+	m.failover = failoverNone
+	m.promoted = nil
+	m.forced = false
+	for _, p := range m.sentinels {
+		p.masterDown = false
+	}
+	s.event("+switch-master", "%s %s %s", m.name, old.addr, addr)
+}
+
+// watch starts the goroutines that monitor inst, called with s.mu held
+func (s *Sentinel) watch(m *master, inst *instance) {
+	if s.closed || s.ln == nil {
+		return
+	}
+	s.wg.Add(2)
+	go s.monitor(m, inst)
+	go s.subscribe(m, inst)
+}
+
+// event logs a state change, in the format of the events of Redis Sentinel
+func (s *Sentinel) event(typ, format string, args ...any) {
+	log.Printf("%s "+format, append([]any{typ}, args...)...)
+}
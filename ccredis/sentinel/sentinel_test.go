@@ -0,0 +1,193 @@
+package sentinel
+
+import (
+	"context"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kostaaa1/redis-clone/client"
+	"github.com/Kostaaa1/redis-clone/internal/harness"
+	redis "github.com/Kostaaa1/redis-clone/internal/resp"
+	"github.com/stretchr/testify/require"
+)
+
+// startReplicated serves a primary with n-1 replicas, the primary is the first instance
+func startReplicated(t *testing.T, n int) (*harness.Harness, []*client.Client) {
+	h := harness.Start(t, n, redis.Config{})
+	clients := h.Clients(t, client.Options{})
+	host, port, _ := net.SplitHostPort(h.Instances[0].Addr())
+	for _, c := range clients[1:] {
+		require.NoError(t, c.Do(context.Background(), "REPLICAOF", host, port).Err())
+		waitInfo(t, c, "master_link_status:up")
+	}
+	return h, clients
+}
+
+// waitInfo waits until INFO of c contains want
+func waitInfo(t *testing.T, c *client.Client, want string) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		info, err := c.Do(context.Background(), "INFO").Text()
+		return err == nil && strings.Contains(info, want)
+	}, 10*time.Second, 20*time.Millisecond, "INFO never reported %q", want)
+}
+
+// startSentinels serves n sentinels monitoring the primary of mc with short periods
+func startSentinels(t *testing.T, n int, mc MasterConfig) ([]*Sentinel, []*client.Client) {
+	sentinels := make([]*Sentinel, n)
+	clients := make([]*client.Client, n)
+	for i := range sentinels {
+		s, err := New(Config{Masters: []MasterConfig{mc}})
+		require.NoError(t, err)
+		s.infoPeriod, s.failoverInfoPeriod, s.helloPeriod = 100*time.Millisecond, 100*time.Millisecond, 100*time.Millisecond
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		go s.Serve(ln)
+		t.Cleanup(func() { s.Close() })
+
+		sentinels[i] = s
+		clients[i] = client.New(client.Options{Addr: ln.Addr().String()})
+		t.Cleanup(func() { clients[i].Close() })
+	}
+	return sentinels, clients
+}
+
+func masterAddr(t *testing.T, c *client.Client) string {
+	addr, err := c.Do(context.Background(), "SENTINEL", "GET-MASTER-ADDR-BY-NAME", "mymaster").Strings()
+	require.NoError(t, err)
+	return net.JoinHostPort(addr[0], addr[1])
+}
+
+func masterField(t *testing.T, c *client.Client, field string) string {
+	fields, err := c.Do(context.Background(), "SENTINEL", "MASTER", "mymaster").StringMap()
+	require.NoError(t, err)
+	return fields[field]
+}
+
+func TestSentinel_Failover(t *testing.T) {
+	ctx := context.Background()
+	h, nodes := startReplicated(t, 3)
+	primary := h.Instances[0].Addr()
+	_, sentinels := startSentinels(t, 3, MasterConfig{
+		Name: "mymaster", Addr: primary, Quorum: 2,
+		DownAfter: 300 * time.Millisecond, FailoverTimeout: 2 * time.Second,
+	})
+
+	// the sentinels discover the replicas and each other
+	for _, c := range sentinels {
+		require.Eventually(t, func() bool {
+			return masterField(t, c, "num-slaves") == "2" && masterField(t, c, "num-other-sentinels") == "2"
+		}, 10*time.Second, 20*time.Millisecond)
+		require.Equal(t, primary, masterAddr(t, c))
+	}
+	require.Equal(t, "OK 3 usable Sentinels. Quorum and failover authorization can be reached",
+		do(t, sentinels[0], "SENTINEL", "CKQUORUM", "mymaster").String)
+	require.NoError(t, nodes[0].Do(ctx, "SET", "k", "v").Err())
+	require.NoError(t, nodes[0].Do(ctx, "WAIT", 2, 5000).Err())
+
+	h.Instances[0].Stop()
+
+	// every sentinel agrees on one of the replicas
+	replicas := []string{h.Instances[1].Server().Addr(), h.Instances[2].Server().Addr()}
+	var promoted string
+	require.Eventually(t, func() bool {
+		promoted = masterAddr(t, sentinels[0])
+		for _, c := range sentinels {
+			if addr := masterAddr(t, c); addr != promoted || !slices.Contains(replicas, addr) {
+				return false
+			}
+		}
+		return true
+	}, 20*time.Second, 50*time.Millisecond)
+	require.NotEqual(t, "0", masterField(t, sentinels[0], "config-epoch"))
+
+	newPrimary := nodes[1+slices.Index(replicas, promoted)]
+	other := nodes[2-slices.Index(replicas, promoted)]
+	require.Equal(t, "v", mustText(t, newPrimary.Do(ctx, "GET", "k")))
+	require.NoError(t, newPrimary.Do(ctx, "SET", "k", "after-failover").Err())
+	_, port, _ := net.SplitHostPort(promoted)
+	waitInfo(t, other, "master_port:"+port+"\r\nmaster_link_status:up")
+	require.Eventually(t, func() bool {
+		v, err := other.Do(ctx, "GET", "k").Text()
+		return err == nil && v == "after-failover"
+	}, 5*time.Second, 20*time.Millisecond)
+
+	// the old primary comes back empty and is turned into a replica of the new one
+	h.Instances[0].Restart(t)
+	waitInfo(t, nodes[0], "role:slave\r\nmaster_host:127.0.0.1\r\nmaster_port:"+port+"\r\nmaster_link_status:up")
+	require.Equal(t, "after-failover", mustText(t, nodes[0].Do(ctx, "GET", "k")))
+}
+
+func TestSentinel_NoQuorum(t *testing.T) {
+	ctx := context.Background()
+	h, nodes := startReplicated(t, 2)
+	primary := h.Instances[0].Addr()
+	_, sentinels := startSentinels(t, 1, MasterConfig{
+		Name: "mymaster", Addr: primary, Quorum: 2,
+		DownAfter: 200 * time.Millisecond, FailoverTimeout: 2 * time.Second,
+	})
+	s := sentinels[0]
+	require.Eventually(t, func() bool { return masterField(t, s, "num-slaves") == "1" }, 10*time.Second, 20*time.Millisecond)
+	require.Contains(t, s.Do(ctx, "SENTINEL", "CKQUORUM", "mymaster").Err().Error(), "NOQUORUM 1 usable Sentinels")
+
+	// a single sentinel sees the primary down but can't fail it over on its own
+	h.Instances[0].Stop()
+	require.Eventually(t, func() bool { return masterField(t, s, "flags") == "master,s_down" }, 5*time.Second, 20*time.Millisecond)
+	time.Sleep(time.Second)
+	require.Equal(t, "master,s_down", masterField(t, s, "flags"))
+	require.Equal(t, primary, masterAddr(t, s))
+
+	// SENTINEL FAILOVER doesn't need the agreement of other sentinels
+	require.Equal(t, "OK", do(t, s, "SENTINEL", "FAILOVER", "mymaster").String)
+	require.Equal(t, "INPROG Failover already in progress", s.Do(ctx, "SENTINEL", "FAILOVER", "mymaster").Err().Error())
+	replica := h.Instances[1].Server().Addr()
+	require.Eventually(t, func() bool {
+		return masterAddr(t, s) == replica && masterField(t, s, "flags") == "master"
+	}, 10*time.Second, 20*time.Millisecond)
+	waitInfo(t, nodes[1], "role:master")
+	require.Equal(t, "NOGOODSLAVE No suitable replica to promote", s.Do(ctx, "SENTINEL", "FAILOVER", "mymaster").Err().Error())
+}
+
+func TestSentinel_Commands(t *testing.T) {
+	ctx := context.Background()
+	h, _ := startReplicated(t, 1)
+	_, sentinels := startSentinels(t, 1, MasterConfig{Name: "mymaster", Addr: h.Instances[0].Addr(), Quorum: 1})
+	s := sentinels[0]
+
+	require.ErrorIs(t, s.Do(ctx, "SENTINEL", "GET-MASTER-ADDR-BY-NAME", "unknown").Err(), client.Nil)
+	require.Equal(t, "ERR No such master with that name", s.Do(ctx, "SENTINEL", "MASTER", "unknown").Err().Error())
+	require.Equal(t, "ERR Unknown sentinel subcommand 'NOPE'", s.Do(ctx, "SENTINEL", "nope").Err().Error())
+	require.Equal(t, "ERR wrong number of arguments for 'sentinel|master' command", s.Do(ctx, "SENTINEL", "MASTER").Err().Error())
+
+	// the primary is up, it isn't down for this sentinel and a vote request gets its vote
+	host, port, _ := net.SplitHostPort(h.Instances[0].Addr())
+	reply := do(t, s, "SENTINEL", "IS-MASTER-DOWN-BY-ADDR", host, port, 5, "abc")
+	require.Equal(t, []redis.Value{{Type: "integer", Int: 0}, {Type: "bulk", Bulk: "abc"}, {Type: "integer", Int: 5}}, reply.Array)
+	reply = do(t, s, "SENTINEL", "IS-MASTER-DOWN-BY-ADDR", host, port, 5, "def")
+	require.Equal(t, "abc", reply.Array[1].Bulk, "one vote per epoch")
+
+	masters, err := s.Do(ctx, "SENTINEL", "MASTERS").Result()
+	require.NoError(t, err)
+	require.Len(t, masters.Array, 1)
+	info, err := s.Do(ctx, "INFO").Text()
+	require.NoError(t, err)
+	require.Contains(t, info, "master0:name=mymaster,status=ok,address="+h.Instances[0].Addr()+",slaves=0,sentinels=1\r\n")
+	require.Equal(t, "PONG", do(t, s, "PING").String)
+}
+
+func mustText(t *testing.T, cmd *client.Cmd) string {
+	t.Helper()
+	s, err := cmd.Text()
+	require.NoError(t, err)
+	return s
+}
+
+func do(t *testing.T, c *client.Client, args ...any) redis.Value {
+	t.Helper()
+	v, err := c.Do(context.Background(), args...).Result()
+	require.NoError(t, err)
+	return v
+}